@@ -0,0 +1,70 @@
+package api
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rs/zerolog"
+)
+
+// SetupAccessLog logs the requests the proxy handles. It must be set up
+// after SetupRequestID and before every other proxy route, so requests
+// answered by the maintenance page are logged too.
+func SetupAccessLog(app *fiber.App, cfg *config.Config, domains core.DomainResolver) {
+	app.Use(logAccess(cfg, domains))
+}
+
+// logAccess logs the requests the proxy handles as the hub's settings, or
+// those of the notebook requested, say. WebSocket sessions are logged once
+// upgraded, with status 101.
+func logAccess(cfg *config.Config, domains core.DomainResolver) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			// The error handler has not written the response yet.
+			status = errorStatus(err)
+		}
+
+		enabled, rate := cfg.Log.Access, cfg.Log.AccessSample
+		nb, found := domains.Route(c.Hostname(), c.Path())
+		if found && nb.AccessLog != nil {
+			if nb.AccessLog.Enabled != nil {
+				enabled = *nb.AccessLog.Enabled
+			}
+			if nb.AccessLog.SampleRate > 0 {
+				rate = nb.AccessLog.SampleRate
+			}
+		}
+		if !enabled {
+			return err
+		}
+		lvl := zerolog.InfoLevel
+		if status >= fiber.StatusInternalServerError {
+			lvl = zerolog.ErrorLevel
+		} else if rate < 1 && rand.Float64() >= rate {
+			return err
+		}
+
+		event := requestLogger(c).WithLevel(lvl).
+			Str("method", c.Method()).
+			Str("host", c.Hostname()).
+			Str("path", c.Path()).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("IP", c.IP())
+		if found {
+			event = event.Str("notebook", nb.ID)
+		}
+		if err != nil {
+			event = event.Err(err)
+		}
+		event.Msg("Request proxied")
+		return err
+	}
+}
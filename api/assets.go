@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+const assetsPrefix = "/assets/"
+
+// serveAsset answers a request for one of the notebook's static assets, under
+// its path prefix, and reports whether it did. marimo serves its own frontend under the same
+// prefix, so anything that isn't one of the notebook's assets is left to it.
+func serveAsset(c fiber.Ctx, nb core.Notebook, notebooksDir string, maxAge time.Duration) (bool, error) {
+	if nb.Assets == "" || (c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead) {
+		return false, nil
+	}
+	name, ok := strings.CutPrefix(strings.TrimPrefix(c.Path(), nb.PathPrefix), assetsPrefix)
+	if !ok {
+		return false, nil
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	file, ok := nb.AssetPath(notebooksDir, name)
+	if !ok {
+		return false, nil
+	}
+	return true, c.SendFile(file, fiber.SendFile{
+		Compress:  true,
+		ByteRange: true,
+		MaxAge:    int(maxAge.Seconds()),
+	})
+}
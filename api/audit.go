@@ -0,0 +1,45 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/audit"
+)
+
+// SetupAuditLog records every API call that changes state or is denied. It
+// must be set up before the API routes so it wraps authentication.
+func SetupAuditLog(app *fiber.App, logger *audit.Logger) {
+	app.Use("/api/v1", auditRequests(logger))
+}
+
+func auditRequests(logger *audit.Logger) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if c.Method() == fiber.MethodGet && status != fiber.StatusUnauthorized && status != fiber.StatusForbidden {
+			return err
+		}
+
+		e := audit.Event{
+			Time:      start,
+			RequestID: requestID(c),
+			IP:        c.IP(),
+			Method:    c.Method(),
+			Path:      c.Path(),
+			Status:    status,
+			Duration:  time.Since(start).Milliseconds(),
+		}
+		if user := currentUser(c); user != nil {
+			e.Actor = user.Username
+			e.UserID = user.ID
+		}
+		if token := currentToken(c); token != nil {
+			e.TokenID = token.ID
+		}
+		logger.Record(e)
+		return err
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	userLocal  = "user"
+	tokenLocal = "token"
+)
+
+// authenticate resolves the caller from the headers of a trusted auth
+// proxy, an API token, HTTP Basic credentials or, without any, a verified
+// client certificate against the local user accounts. With auth disabled every caller is anonymous and is
+// allowed everything. The configured Basic account is let in like such a
+// caller, and with auth disabled is the only one let in.
+func authenticate(reg core.Registry, cfg *config.Config) fiber.Handler {
+	enabled := cfg.Auth.Enabled
+	basic := newBasicAccount(cfg.Auth.BasicUsername, cfg.Auth.BasicPasswordHash)
+	trusted := newTrustedHeaders(cfg)
+	return func(c fiber.Ctx) error {
+		if !enabled && basic == nil {
+			return c.Next()
+		}
+
+		header := c.Get(fiber.HeaderAuthorization)
+		if username, password, ok := parseBasicAuth(header); ok && basic.check(username, password) {
+			return c.Next()
+		}
+		if !enabled {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="marimo-hub"`)
+			if header == "" {
+				return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Authentication required"})
+			}
+			requestLogger(c).Debug().Str("IP", c.IP()).Msg("Authentication failed")
+			return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Invalid credentials"})
+		}
+
+		if username, groups, ok := trusted.identity(c); ok {
+			user, err := trusted.user(reg, username, groups)
+			if err != nil {
+				return err
+			}
+			c.Locals(userLocal, &user)
+			return c.Next()
+		}
+
+		if secret, ok := parseBearer(header); ok {
+			token, valid := core.VerifyToken(reg, secret)
+			if !valid {
+				requestLogger(c).Debug().Str("IP", c.IP()).Msg("Token authentication failed")
+				return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Invalid token"})
+			}
+			user, exists := reg.GetUser(token.UserID)
+			if !exists {
+				return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Invalid token"})
+			}
+			c.Locals(userLocal, &user)
+			c.Locals(tokenLocal, &token)
+			return c.Next()
+		}
+
+		username, password, ok := parseBasicAuth(header)
+		if !ok && header == "" {
+			if name, verified := clientCertName(c); verified {
+				user, exists := reg.GetUserByName(name)
+				if !exists {
+					requestLogger(c).Debug().Str("IP", c.IP()).Str("username", name).Msg("Client certificate authentication failed")
+					return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Unknown client certificate"})
+				}
+				c.Locals(userLocal, &user)
+				return c.Next()
+			}
+		}
+		if !ok {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="marimo-hub"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Authentication required"})
+		}
+
+		user, exists := reg.GetUserByName(username)
+		if !exists || !user.CheckPassword(password) {
+			requestLogger(c).Debug().Str("IP", c.IP()).Str("username", username).Msg("Authentication failed")
+			return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Invalid credentials"})
+		}
+
+		c.Locals(userLocal, &user)
+		return c.Next()
+	}
+}
+
+// authRequired reports whether the API asks callers for credentials.
+func authRequired(cfg *config.Config) bool {
+	return cfg.Auth.Enabled || cfg.Auth.BasicUsername != ""
+}
+
+// basicAccount is the single account configured for HTTP Basic auth.
+// Checking a bcrypt hash takes tens of milliseconds, so the password last
+// found to match is remembered by its SHA-256.
+type basicAccount struct {
+	username string
+	hash     []byte
+	verified atomic.Pointer[[sha256.Size]byte]
+}
+
+// newBasicAccount returns the account, or nil without a username.
+func newBasicAccount(username, hash string) *basicAccount {
+	if username == "" {
+		return nil
+	}
+	return &basicAccount{username: username, hash: []byte(hash)}
+}
+
+func (a *basicAccount) check(username, password string) bool {
+	if a == nil || subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) != 1 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(password))
+	if last := a.verified.Load(); last != nil && subtle.ConstantTimeCompare(last[:], sum[:]) == 1 {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(a.hash, []byte(password)) != nil {
+		return false
+	}
+	a.verified.Store(&sum)
+	return true
+}
+
+// requireAdmin rejects callers that are authenticated but not admins. API
+// tokens never carry admin privileges.
+func requireAdmin() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if user := currentUser(c); (user != nil && !user.Admin) || currentToken(c) != nil {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Admin privileges required"})
+		}
+		return c.Next()
+	}
+}
+
+// requireUser rejects callers using an API token, for routes that manage
+// accounts and credentials.
+func requireUser() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if currentToken(c) != nil {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not available to API tokens"})
+		}
+		return c.Next()
+	}
+}
+
+// authorize rejects API tokens that were not granted scope. Other callers
+// are unaffected.
+func authorize(scope core.TokenScope) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if token := currentToken(c); token != nil && !token.Allows(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Token lacks the " + string(scope) + " scope"})
+		}
+		return c.Next()
+	}
+}
+
+// currentUser returns the authenticated caller, or nil when auth is disabled.
+func currentUser(c fiber.Ctx) *core.User {
+	user, _ := c.Locals(userLocal).(*core.User)
+	return user
+}
+
+// currentToken returns the API token used by the caller, if any.
+func currentToken(c fiber.Ctx) *core.Token {
+	token, _ := c.Locals(tokenLocal).(*core.Token)
+	return token
+}
+
+// tokenCovers reports whether the caller's token, if any, extends to nb.
+func tokenCovers(c fiber.Ctx, nb core.Notebook) bool {
+	token := currentToken(c)
+	return token == nil || token.Covers(nb)
+}
+
+// clientCertName returns the common name of the certificate the client
+// presented, if the server verified it against its client CA.
+func clientCertName(c fiber.Ctx) (string, bool) {
+	cert := verifiedClientCert(c)
+	if cert == nil || cert.Subject.CommonName == "" {
+		return "", false
+	}
+	return cert.Subject.CommonName, true
+}
+
+// verifiedClientCert returns the certificate the client presented, or nil
+// without one the server verified.
+func verifiedClientCert(c fiber.Ctx) *x509.Certificate {
+	state := c.RequestCtx().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+func parseBearer(header string) (string, bool) {
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return header[len(prefix):], true
+}
+
+func parseBasicAuth(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
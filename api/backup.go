@@ -0,0 +1,52 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/backup"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+type BackupsResponse struct {
+	Backups   []backup.Object `json:"backups"`
+	LastRun   time.Time       `json:"last_run"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+type BackupResponse struct {
+	Backup backup.Object `json:"backup"`
+}
+
+func SetupBackupRoutes(app *fiber.App, scheduler *backup.Scheduler) {
+	api := app.Group("/api/v1/system", requireAdmin())
+	api.Get("/backups", getBackups(scheduler))
+	api.Post("/backups", postBackup(scheduler))
+}
+
+func getBackups(scheduler *backup.Scheduler) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		objects, err := scheduler.List(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		resp := BackupsResponse{Backups: objects}
+		lastRun, lastErr := scheduler.LastRun()
+		resp.LastRun = lastRun
+		if lastErr != nil {
+			resp.LastError = lastErr.Error()
+		}
+		return c.JSON(resp)
+	}
+}
+
+func postBackup(scheduler *backup.Scheduler) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		obj, err := scheduler.BackupNow(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+		return c.Status(fiber.StatusCreated).JSON(BackupResponse{Backup: obj})
+	}
+}
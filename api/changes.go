@@ -0,0 +1,52 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+func SetupChangeRoutes(app *fiber.App, log core.ChangeLog) {
+	api := app.Group("/api/v1")
+	api.Get("/changes", getChanges(log), authorize(core.ScopeRead))
+}
+
+// getChanges lists the registry changes after since, for subscribers to
+// catch up on what they missed. Tokens only see the changes to notebooks
+// they cover.
+func getChanges(log core.ChangeLog) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var since uint64
+		if q := c.Query("since"); q != "" {
+			n, err := strconv.ParseUint(q, 10, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "since must be a sequence number"})
+			}
+			since = n
+		}
+		limit := core.MaxChanges
+		if q := c.Query("limit"); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 1 || n > core.MaxChanges {
+				return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "limit must be between 1 and " + strconv.Itoa(core.MaxChanges)})
+			}
+			limit = n
+		}
+
+		resp, err := log.Changes(since, limit)
+		if err != nil {
+			return err
+		}
+		if token := currentToken(c); token != nil {
+			visible := resp.Changes[:0]
+			for _, e := range resp.Changes {
+				if token.Covers(e.Notebook) {
+					visible = append(visible, e)
+				}
+			}
+			resp.Changes = visible
+		}
+		return c.JSON(resp)
+	}
+}
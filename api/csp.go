@@ -0,0 +1,57 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// setCSP sets the Content-Security-Policy of the HTML pages of notebooks,
+// from the notebook's policy or the hub's, replacing any the notebook sent.
+func setCSP(cfg *config.Config, domains core.DomainResolver) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		err := c.Next()
+
+		if !strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMETextHTML) {
+			return err
+		}
+		nb, found := domains.Route(c.Hostname(), c.Path())
+		if !found {
+			return err
+		}
+		policy, ancestors := cfg.Proxy.CSP, "'self'"
+		if nb.CSP != nil {
+			if nb.CSP.Policy != "" {
+				policy = nb.CSP.Policy
+			}
+			if len(nb.CSP.FrameAncestors) > 0 {
+				ancestors = strings.Join(nb.CSP.FrameAncestors, " ")
+				if !strings.Contains(policy, ".FrameAncestors") {
+					policy = addDirective(policy, "frame-ancestors {{.FrameAncestors}}")
+				}
+			}
+		}
+		if policy == "" {
+			return err
+		}
+
+		value, renderErr := core.RenderCSP(policy, core.CSPData{Domain: c.Hostname(), FrameAncestors: ancestors})
+		if renderErr != nil {
+			requestLogger(c).Warn().Str("notebook", nb.ID).Err(renderErr).Msg("Invalid CSP")
+			return err
+		}
+		c.Set(fiber.HeaderContentSecurityPolicy, value)
+		return err
+	}
+}
+
+// addDirective appends a directive to a policy.
+func addDirective(policy, directive string) string {
+	policy = strings.TrimRight(policy, "; ")
+	if policy == "" {
+		return directive
+	}
+	return policy + "; " + directive
+}
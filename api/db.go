@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+type DBResponse struct {
+	DB core.DBStats `json:"db"`
+}
+
+func SetupDBRoutes(app *fiber.App, reg *core.BadgerRegistry) {
+	api := app.Group("/api/v1/system", requireAdmin())
+	api.Get("/db", getDB(reg))
+}
+
+func getDB(reg *core.BadgerRegistry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.JSON(DBResponse{DB: reg.Stats()})
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/expvar"
+	"github.com/gofiber/fiber/v3/middleware/pprof"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// SetupDebugRoutes serves net/http/pprof under /debug/pprof (a full goroutine
+// dump is /debug/pprof/goroutine?debug=2) and expvar under /debug/vars, for
+// admins only.
+func SetupDebugRoutes(app *fiber.App, cfg *config.Config, reg core.Registry) {
+	if !authRequired(cfg) {
+		logging.API.Warn().Msg("Debug endpoints are enabled without authentication")
+	}
+	app.Use("/debug", authenticate(reg, cfg), requireAdmin(), pprof.New(), expvar.New())
+}
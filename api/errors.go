@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// ErrorHandler turns errors returned by handlers into JSON error responses,
+// choosing the status from the typed errors of the core package. Install it
+// on every fiber app. Internal errors may tell about the host, such as
+// paths and database details, so those are logged instead of returned.
+func ErrorHandler(c fiber.Ctx, err error) error {
+	status := errorStatus(err)
+	if status == fiber.StatusInternalServerError {
+		requestLogger(c).Error().
+			Err(err).
+			Str("method", c.Method()).
+			Str("path", c.Path()).
+			Msg("Request failed")
+		return c.Status(status).JSON(core.ErrorResponse{Error: "Internal server error"})
+	}
+	return c.Status(status).JSON(core.ErrorResponse{Error: err.Error()})
+}
+
+func errorStatus(err error) int {
+	var (
+		fiberErr       *fiber.Error
+		notFound       *core.NotFoundError
+		invalid        *core.InvalidRequestError
+		inUse          *core.InUseError
+		notEmpty       *core.WorkspaceNotEmptyError
+		dependedOn     *core.DependedOnError
+		alreadyRunning *core.AlreadyRunningError
+		quota          *core.QuotaExceededError
+		notRunning     *core.NotRunningError
+		portsExhausted *core.PortsExhaustedError
+	)
+	switch {
+	case errors.As(err, &fiberErr):
+		return fiberErr.Code
+	case errors.As(err, &notFound):
+		return fiber.StatusNotFound
+	case errors.As(err, &invalid):
+		return fiber.StatusBadRequest
+	case errors.As(err, &inUse), errors.As(err, &notEmpty), errors.As(err, &dependedOn), errors.As(err, &alreadyRunning), errors.As(err, &quota):
+		return fiber.StatusConflict
+	case errors.As(err, &notRunning), errors.As(err, &portsExhausted):
+		return fiber.StatusServiceUnavailable
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+const (
+	// eventsKeepAlive is how often an idle event stream gets a comment,
+	// which also finds out that the client has gone.
+	eventsKeepAlive = 30 * time.Second
+	// eventsBuffer is how far a client may fall behind before its stream
+	// is ended, to catch up from the change log when it reconnects.
+	eventsBuffer = 256
+	// eventsRetry is how long clients wait before reconnecting.
+	eventsRetry = 3 * time.Second
+)
+
+// SetupEventRoutes streams the registry's changes. log, if not nil, lets
+// clients catch up on the changes they missed. Streams end when the server
+// shuts down.
+func SetupEventRoutes(app *fiber.App, source core.EventSource, log core.ChangeLog) {
+	stopping := make(chan struct{})
+	var once sync.Once
+	app.Hooks().OnShutdown(func() error {
+		once.Do(func() { close(stopping) })
+		return nil
+	})
+	api := app.Group("/api/v1")
+	api.Get("/events", streamEvents(source, log, stopping), authorize(core.ScopeRead))
+}
+
+// streamEvents streams registry changes as server-sent events, with their
+// sequence numbers as IDs. A client reconnecting with Last-Event-ID, or
+// asking for the changes after since, first gets those it missed; if they
+// are gone, a truncated event tells it to load everything afresh. Tokens
+// only see the changes to notebooks they cover.
+func streamEvents(source core.EventSource, log core.ChangeLog, stopping <-chan struct{}) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		from := c.Get("Last-Event-ID")
+		if from == "" {
+			from = c.Query("since")
+		}
+		var since uint64
+		if from != "" {
+			n, err := strconv.ParseUint(from, 10, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "since must be a sequence number"})
+			}
+			since = n
+		}
+		token := currentToken(c)
+		visible := func(e core.Event) bool {
+			return token == nil || token.Covers(e.Notebook)
+		}
+
+		// The sink never blocks, so unsubscribing can't hang on a stream
+		// that stopped reading.
+		events := make(chan core.Event, eventsBuffer)
+		behind := make(chan struct{})
+		var behindOnce sync.Once
+		sub := source.Subscribe(core.EventSinkFunc(func(e core.Event) error {
+			select {
+			case events <- e:
+			default:
+				behindOnce.Do(func() { close(behind) })
+			}
+			return nil
+		}))
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		// Proxies in front of the hub mustn't hold events back.
+		c.Set("X-Accel-Buffering", "no")
+		logger := requestLogger(c)
+		return c.SendStreamWriter(func(w *bufio.Writer) {
+			defer source.Unsubscribe(sub)
+			// The response only starts with its first bytes, so the
+			// stream opens with how long clients wait to reconnect.
+			fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+			if from != "" {
+				if err := writeMissedEvents(w, log, since, sub.Seq(), visible); err != nil {
+					logger.Error().Str("method", "streamEvents").
+						Uint64("since", since).
+						Err(err).
+						Msg("Failed to read missed changes")
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			ticker := time.NewTicker(eventsKeepAlive)
+			defer ticker.Stop()
+			for {
+				select {
+				case e := <-events:
+					if !visible(e) {
+						continue
+					}
+					if err := writeEvent(w, e); err != nil {
+						return
+					}
+				case <-ticker.C:
+					w.WriteString(": keep-alive\n\n")
+				case <-behind:
+					return
+				case <-stopping:
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+	}
+}
+
+// writeMissedEvents writes the changes after since, up to until, from log.
+func writeMissedEvents(w *bufio.Writer, log core.ChangeLog, since, until uint64, visible func(core.Event) bool) error {
+	// Without a log, or for a client ahead of it, as after restoring a
+	// backup, there is nothing to catch up from.
+	if log == nil || since > until {
+		return writeTruncated(w, since)
+	}
+	for first := true; since < until; first = false {
+		resp, err := log.Changes(since, core.MaxChanges)
+		if err != nil {
+			return err
+		}
+		if first && resp.Truncated {
+			if err := writeTruncated(w, since); err != nil {
+				return err
+			}
+		}
+		for _, e := range resp.Changes {
+			if e.Seq > until {
+				return nil
+			}
+			if !visible(e) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return err
+			}
+		}
+		if !resp.More {
+			return nil
+		}
+		since = resp.Seq
+	}
+	return nil
+}
+
+func writeEvent(w *bufio.Writer, e core.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.Seq, data)
+	return err
+}
+
+// writeTruncated tells the client that changes after since are gone.
+func writeTruncated(w *bufio.Writer, since uint64) error {
+	_, err := fmt.Fprintf(w, "event: truncated\ndata: {\"since\":%d}\n\n", since)
+	return err
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// getFiles lists a directory of the notebooks directory, or describes a
+// single file, so callers can see what is deployable.
+func getFiles(notebooksDir string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		name, err := url.PathUnescape(c.Params("*"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid path"})
+		}
+		path, rel, err := core.ResolvePath(notebooksDir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "File not found"})
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return c.JSON(core.FilesResponse{Path: rel, Files: []core.FileEntry{fileEntry(rel, info)}})
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		files := make([]core.FileEntry, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				// Removed while listing.
+				continue
+			}
+			files = append(files, fileEntry(filepath.ToSlash(filepath.Join(rel, entry.Name())), info))
+		}
+		return c.JSON(core.FilesResponse{Path: rel, Files: files})
+	}
+}
+
+func fileEntry(rel string, info fs.FileInfo) core.FileEntry {
+	return core.FileEntry{
+		Name:    info.Name(),
+		Path:    rel,
+		Dir:     info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/proxy"
+)
+
+const (
+	defaultForwardAuthTimeout = 10 * time.Second
+	// maxForwardAuthBody bounds the answer of an auth service relayed to
+	// a visitor it turned away.
+	maxForwardAuthBody = 1 << 20
+)
+
+// forwardAuthClient doesn't follow redirects, which are answers the
+// visitor is meant to follow, such as to a login page.
+var forwardAuthClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// checkForwardAuth asks the auth services of notebooks that have one
+// whether to let each request through, before any proxying, WebSocket
+// upgrades included. A request let through carries the headers the service
+// answered with, as the notebook configures. Visitors holding a share link
+// aren't asked about.
+func checkForwardAuth(domains core.DomainResolver) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		nb, ok := domains.Route(c.Hostname(), c.Path())
+		if !ok || nb.ForwardAuth == nil || nb.Disabled || sharedVisit(c) {
+			return c.Next()
+		}
+		auth := nb.ForwardAuth
+
+		timeout := defaultForwardAuthTimeout
+		if auth.Timeout > 0 {
+			timeout = time.Duration(auth.Timeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(c.Context(), timeout)
+		defer cancel()
+		resp, err := askForwardAuth(ctx, c, auth.URL)
+		if err != nil {
+			requestLogger(c).Warn().Str("notebook", nb.ID).
+				Str("url", auth.URL).
+				Err(err).
+				Msg("Forward auth failed")
+			return c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: "Failed to authenticate request"})
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			resp.Body.Close()
+			for _, name := range auth.ResponseHeaders {
+				c.Request().Header.Del(name)
+				for _, v := range resp.Header.Values(name) {
+					c.Request().Header.Add(name, v)
+				}
+			}
+			return c.Next()
+		}
+		defer resp.Body.Close()
+
+		proxy.RemoveHopHeaders(resp.Header)
+		resp.Header.Del(fiber.HeaderContentLength)
+		for k, values := range resp.Header {
+			for _, v := range values {
+				c.Response().Header.Add(k, v)
+			}
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxForwardAuthBody))
+		if err != nil {
+			return err
+		}
+		return c.Status(resp.StatusCode).Send(body)
+	}
+}
+
+// askForwardAuth sends the auth service the headers of the request being
+// proxied along with where it was going.
+func askForwardAuth(ctx context.Context, c fiber.Ctx, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range c.GetReqHeaders() {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	proxy.RemoveHopHeaders(req.Header)
+	req.Header.Del(fiber.HeaderContentLength)
+	req.Header.Set("X-Forwarded-Method", c.Method())
+	req.Header.Set("X-Forwarded-Proto", c.Scheme())
+	req.Header.Set("X-Forwarded-Host", c.Hostname())
+	req.Header.Set("X-Forwarded-Uri", c.OriginalURL())
+	req.Header.Set("X-Forwarded-For", c.IP())
+
+	return forwardAuthClient.Do(req)
+}
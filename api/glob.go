@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// maxGlobMatches bounds how many notebooks one glob request may register.
+const maxGlobMatches = 500
+
+var slugUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// globFile is what the name and domain templates of a glob request are
+// rendered with.
+type globFile struct {
+	// Name is the file name without its extension, or the name of the
+	// directory of a directory-backed app.
+	Name string
+	// Slug is Name lowercased with everything but letters and digits
+	// collapsed to dashes, so it can be used as a domain label.
+	Slug string
+	// Dir is the directory of the file relative to the notebooks directory.
+	Dir string
+}
+
+func newGlobFile(rel string, isDir bool) globFile {
+	name := filepath.Base(rel)
+	if !isDir {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return globFile{
+		Name: name,
+		Slug: strings.Trim(slugUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-"),
+		Dir:  filepath.Dir(rel),
+	}
+}
+
+func postNotebookGlob(reg core.Registry, notebooksDir string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.GlobNotebooksRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		base := globBase(req)
+		if err := admitNotebook(c, reg, &base); err != nil {
+			return err
+		}
+
+		results, err := registerGlob(reg, notebooksDir, req, base)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				requestLogger(c).Debug().Str("path", result.Path).Str("error", result.Error).Msg("Skipped glob match")
+			}
+		}
+		return c.JSON(core.GlobNotebooksResponse{Results: results})
+	}
+}
+
+// globBase is the part of a glob request shared by every notebook it
+// registers.
+func globBase(req core.GlobNotebooksRequest) core.CreateUpdateNotebookRequest {
+	return core.CreateUpdateNotebookRequest{
+		WorkspaceID:   req.WorkspaceID,
+		Runtime:       req.Runtime,
+		Env:           req.Env,
+		ShowCode:      req.ShowCode,
+		Watch:         req.Watch,
+		Pinned:        req.Pinned,
+		Desired:       req.Desired,
+		Owner:         req.Owner,
+		Collaborators: req.Collaborators,
+		Assets:        req.Assets,
+		ErrorPage:     req.ErrorPage,
+		Public:        req.Public,
+		Disabled:      req.Disabled,
+		ClientCert:    req.ClientCert,
+		StartTimeout:  req.StartTimeout,
+		DependsOn:     req.DependsOn,
+		Hooks:         req.Hooks,
+		Probe:         req.Probe,
+		Upstream:      req.Upstream,
+		ForwardAuth:   req.ForwardAuth,
+		AccessLog:     req.AccessLog,
+		Limits:        req.Limits,
+		Timeouts:      req.Timeouts,
+		Security:      req.Security,
+		CSP:           req.CSP,
+	}
+}
+
+// registerGlob registers every file matching req on top of base. Errors
+// concern the request as a whole; files that fail are reported in the
+// results.
+func registerGlob(reg core.Registry, notebooksDir string, req core.GlobNotebooksRequest, base core.CreateUpdateNotebookRequest) ([]core.GlobResult, error) {
+	if req.Name == "" {
+		req.Name = "{{.Name}}"
+	}
+	nameTmpl, err := template.New("name").Option("missingkey=error").Parse(req.Name)
+	if err != nil {
+		return nil, &core.InvalidRequestError{Reason: "invalid name template: " + err.Error()}
+	}
+	domainTmpl, err := template.New("domain").Option("missingkey=error").Parse(req.Domain)
+	if err != nil {
+		return nil, &core.InvalidRequestError{Reason: "invalid domain template: " + err.Error()}
+	}
+	prefixTmpl, err := template.New("prefix").Option("missingkey=error").Parse(req.PathPrefix)
+	if err != nil {
+		return nil, &core.InvalidRequestError{Reason: "invalid path prefix template: " + err.Error()}
+	}
+
+	// Each match is confined on its own; checking the pattern as well
+	// keeps the listing of files outside the directory private.
+	pattern, _, err := core.ResolvePath(notebooksDir, req.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, &core.InvalidRequestError{Reason: "invalid pattern: " + err.Error()}
+	}
+	if len(matches) > maxGlobMatches {
+		return nil, &core.InvalidRequestError{Reason: "pattern matches too many files"}
+	}
+
+	results := make([]core.GlobResult, 0, len(matches))
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			// Directories only count when they hold an app.
+			if core.CheckNotebookPath(match) != nil {
+				continue
+			}
+		} else if !info.Mode().IsRegular() {
+			continue
+		}
+		nb, err := registerGlobMatch(reg, notebooksDir, base, match, info.IsDir(), nameTmpl, domainTmpl, prefixTmpl)
+		result := core.GlobResult{Path: match}
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Notebook = &nb
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// registerGlobMatch registers one file matched by a glob request, going
+// through the same checks as a single registration.
+func registerGlobMatch(reg core.Registry, notebooksDir string, req core.CreateUpdateNotebookRequest, match string, isDir bool, nameTmpl, domainTmpl, prefixTmpl *template.Template) (core.Notebook, error) {
+	path, rel, err := core.ResolvePath(notebooksDir, match)
+	if err != nil {
+		return core.Notebook{}, err
+	}
+	req.Path, req.RelativePath = path, rel
+
+	file := newGlobFile(rel, isDir)
+	var name, domain, prefix strings.Builder
+	if err := nameTmpl.Execute(&name, file); err != nil {
+		return core.Notebook{}, err
+	}
+	if err := domainTmpl.Execute(&domain, file); err != nil {
+		return core.Notebook{}, err
+	}
+	if err := prefixTmpl.Execute(&prefix, file); err != nil {
+		return core.Notebook{}, err
+	}
+	req.Name, req.Domain, req.PathPrefix = name.String(), domain.String(), prefix.String()
+
+	if err := normalizeDomain(&req); err != nil {
+		return core.Notebook{}, err
+	}
+	if err := validateRequest(req); err != nil {
+		return core.Notebook{}, err
+	}
+	return reg.Add(req)
+}
@@ -2,22 +2,35 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
 	"github.com/rekk30/marimo-hub/pkg/core"
-	"github.com/rs/zerolog/log"
 )
 
 var validate = validator.New()
 
+var (
+	envNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+)
+
 func init() {
 	validate.RegisterValidation("filepath", func(fl validator.FieldLevel) bool {
 		path := fl.Field().String()
 		return path != "" && !strings.Contains(path, "..")
 	})
+	validate.RegisterValidation("envname", func(fl validator.FieldLevel) bool {
+		return envNamePattern.MatchString(fl.Field().String())
+	})
+	validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
 }
 
 func validateRequest(req interface{}) error {
@@ -35,54 +48,151 @@ func validateRequest(req interface{}) error {
 	return nil
 }
 
-func SetupAPIRoutes(app *fiber.App, reg core.Registry, runner *core.Runner) {
-	api := app.Group("/api/v1")
-	api.Get("/notebooks/:id", getNotebook(reg))
-	api.Get("/notebooks/:id/status", getNotebookStatus(runner))
-	api.Get("/notebooks", getNotebooks(reg))
-	api.Post("/notebooks", postNotebook(reg))
-	api.Put("/notebooks/:id", putNotebook(reg))
-	api.Delete("/notebooks/:id", deleteNotebook(reg))
-	api.Post("/notebooks/:id/reload", reloadNotebook(reg, runner))
+// normalizeDomain canonicalizes the requested domain before validation, so
+// that a trailing dot or an internationalized name is accepted.
+func normalizeDomain(req *core.CreateUpdateNotebookRequest) error {
+	domain, err := core.NormalizeDomain(req.Domain)
+	if err != nil {
+		return err
+	}
+	req.Domain = domain
+	if req.PathPrefix != "" {
+		if req.PathPrefix, err = core.NormalizePathPrefix(req.PathPrefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveNotebookRequest validates a request to create or update a notebook
+// and resolves its domain and path the way the API does before storing it.
+// Validation failures are InvalidRequestErrors.
+func ResolveNotebookRequest(req *core.CreateUpdateNotebookRequest, notebooksDir string) error {
+	if err := normalizeDomain(req); err != nil {
+		return err
+	}
+	if err := validateRequest(*req); err != nil {
+		return &core.InvalidRequestError{Reason: err.Error()}
+	}
+	if req.Path == "" {
+		return nil
+	}
+	var err error
+	if req.Path, req.RelativePath, err = core.ResolvePath(notebooksDir, req.Path); err != nil {
+		return err
+	}
+	return core.CheckNotebookPath(req.Path)
+}
+
+func SetupAPIRoutes(app *fiber.App, cfg *config.Config, reg core.Registry, runner *core.Runner) {
+	app.Get("/healthz", getHealthz())
+	app.Get("/readyz", getReadyz(reg, runner, cfg.Health.MinPinnedRunning))
+
+	api := app.Group("/api/v1", authenticate(reg, cfg))
+	api.Get("/version", getVersion(), authorize(core.ScopeRead))
+	api.Get("/system/config", getSystemConfig(cfg), requireAdmin())
+	api.Get("/system/doctor", getSystemDoctor(cfg, reg, runner), requireAdmin())
+	api.Get("/overview", getOverview(reg, runner), authorize(core.ScopeRead))
+	api.Get("/notebooks/:id", getNotebook(reg), authorize(core.ScopeRead))
+	api.Get("/notebooks/:id/status", getNotebookStatus(reg, runner), authorize(core.ScopeRead))
+	api.Get("/notebooks", getNotebooks(reg), authorize(core.ScopeRead))
+	api.Post("/notebooks", postNotebook(reg, cfg.Notebooks.Path), authorize(core.ScopeWrite))
+	api.Post("/notebooks/glob", postNotebookGlob(reg, cfg.Notebooks.Path), authorize(core.ScopeWrite))
+	api.Get("/files", getFiles(cfg.Notebooks.Path), authorize(core.ScopeWrite))
+	api.Get("/files/*", getFiles(cfg.Notebooks.Path), authorize(core.ScopeWrite))
+	api.Post("/notebooks/upload", postNotebookUpload(reg, cfg.Notebooks.Path, core.BundleLimits{
+		MaxSize:  int64(cfg.Notebooks.Upload.MaxDiskMB) << 20,
+		MaxFiles: cfg.Notebooks.Upload.MaxFiles,
+	}), authorize(core.ScopeWrite))
+	api.Put("/notebooks/:id", putNotebook(reg, cfg.Notebooks.Path), authorize(core.ScopeDeploy))
+	api.Delete("/notebooks/:id", deleteNotebook(reg), authorize(core.ScopeWrite))
+	api.Post("/notebooks/:id/reload", reloadNotebook(reg, runner), authorize(core.ScopeDeploy))
+	api.Post("/notebooks/:id/kill", killNotebook(reg, runner), requireAdmin())
+	api.Put("/notebooks/:id/state", putNotebookState(reg, runner), authorize(core.ScopeDeploy))
+	api.Get("/notebooks/:id/previews", getPreviews(reg), authorize(core.ScopeRead))
+	api.Put("/notebooks/:id/previews/:name", putPreview(reg, runner, cfg.Notebooks.Path), authorize(core.ScopeDeploy))
+	api.Delete("/notebooks/:id/previews/:name", deletePreview(reg, cfg.Notebooks.Path), authorize(core.ScopeDeploy))
+	api.Post("/notebooks/:id/suspend", suspendNotebook(reg, runner), authorize(core.ScopeDeploy))
+	api.Post("/notebooks/:id/resume", resumeNotebook(reg, runner), authorize(core.ScopeDeploy))
+
+	api.Get("/workspaces/:id", getWorkspace(reg), authorize(core.ScopeRead))
+	api.Get("/workspaces/:id/notebooks", getWorkspaceNotebooks(reg), authorize(core.ScopeRead))
+	api.Get("/workspaces/:id/quota", getWorkspaceQuota(reg, runner), authorize(core.ScopeRead))
+	api.Get("/workspaces", getWorkspaces(reg), authorize(core.ScopeRead))
+	api.Post("/workspaces", postWorkspace(reg), requireAdmin())
+	api.Put("/workspaces/:id", putWorkspace(reg), requireAdmin())
+	api.Delete("/workspaces/:id", deleteWorkspace(reg), requireAdmin())
+
+	api.Get("/me", getMe(), requireUser())
+	api.Get("/users/:id", getUser(reg), requireUser())
+	api.Get("/users", getUsers(reg), requireAdmin())
+	api.Post("/users", postUser(reg), requireAdmin())
+	api.Put("/users/:id", putUser(reg), requireUser())
+	api.Delete("/users/:id", deleteUser(reg), requireAdmin())
+
+	api.Get("/tokens/:id", getToken(reg), requireUser())
+	api.Get("/tokens", getTokens(reg), requireUser())
+	api.Post("/tokens", postToken(reg), requireUser())
+	api.Delete("/tokens/:id", deleteToken(reg), requireUser())
 }
 
 //--- Handlers ---//
 
 func getNotebook(reg core.Registry) fiber.Handler {
 	return func(c fiber.Ctx) error {
-		log.Debug().Str("IP", c.IP()).Str("method", "GET /notebooks/:id").Msg("Request received")
 		id := c.Params("id")
 		nb, exists := reg.Get(id)
-		if !exists {
+		if !exists || !tokenCovers(c, nb) {
 			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
 		}
 		return c.JSON(core.NotebookResponse{Notebook: nb})
 	}
 }
 
-func getNotebookStatus(runner *core.Runner) fiber.Handler {
+func getNotebookStatus(reg core.Registry, runner *core.Runner) fiber.Handler {
 	return func(c fiber.Ctx) error {
-		log.Debug().Str("IP", c.IP()).Msg("GET /notebooks/:id/status")
 		id := c.Params("id")
+		nb, exists := reg.Get(id)
+		if exists && !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if exists && nb.Disabled {
+			return c.JSON(core.StatusResponse{Status: core.StatusDisabled})
+		}
+		if exists && !nb.WantsProcess() {
+			return c.JSON(core.StatusResponse{Status: core.StatusStopped})
+		}
 		status, err := runner.GetStatus(id)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: err.Error()})
+			return err
+		}
+		conns := runner.Connections(id)
+		resp := core.StatusResponse{Status: status, Reason: runner.GetReason(id), Connections: &conns}
+		if health, ok := runner.Health(id); ok {
+			resp.Health = &health
 		}
-		return c.JSON(core.StatusResponse{Status: status})
+		return c.JSON(resp)
 	}
 }
 
 func getNotebooks(reg core.Registry) fiber.Handler {
 	return func(c fiber.Ctx) error {
-		log.Debug().Str("IP", c.IP()).Msg("GET /notebooks")
 		nbs := reg.List()
+		if workspace := c.Query("workspace"); workspace != "" {
+			nbs = filterByWorkspace(nbs, workspace)
+		}
+		if user := currentUser(c); user != nil && c.Query("mine") == "true" {
+			nbs = filterByMember(nbs, user)
+		}
+		if token := currentToken(c); token != nil {
+			nbs = filterByToken(nbs, token)
+		}
 		return c.JSON(core.NotebooksResponse{Notebooks: nbs})
 	}
 }
 
-func postNotebook(reg core.Registry) fiber.Handler {
+func postNotebook(reg core.Registry, notebooksDir string) fiber.Handler {
 	return func(c fiber.Ctx) error {
-		log.Debug().Str("IP", c.IP()).Msg("POST /notebooks")
 		var req core.CreateUpdateNotebookRequest
 		if err := json.Unmarshal(c.Body(), &req); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
@@ -91,36 +201,81 @@ func postNotebook(reg core.Registry) fiber.Handler {
 		if req.Name == "" || req.Path == "" || req.Domain == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Missing required fields"})
 		}
+		if err := ResolveNotebookRequest(&req, notebooksDir); err != nil {
+			return err
+		}
 
-		if err := validateRequest(req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		if err := admitNotebook(c, reg, &req); err != nil {
+			return err
 		}
 
 		nb, err := reg.Add(req)
 		if err != nil {
-			return c.Status(fiber.StatusConflict).JSON(core.ErrorResponse{Error: err.Error()})
+			return err
 		}
 
 		return c.Status(fiber.StatusCreated).JSON(core.NotebookResponse{Notebook: nb})
 	}
 }
 
-func putNotebook(reg core.Registry) fiber.Handler {
+// admitNotebook fills in the owner of a notebook about to be created and
+// checks that the caller's token allows creating it.
+func admitNotebook(c fiber.Ctx, reg core.Registry, req *core.CreateUpdateNotebookRequest) error {
+	// Only admins may create notebooks on behalf of someone else.
+	if user := currentUser(c); user != nil && (req.Owner == "" || !user.Admin) {
+		req.Owner = user.ID
+	}
+	if err := checkMembers(reg, *req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if token := currentToken(c); token != nil {
+		if len(token.NotebookIDs) > 0 {
+			return fiber.NewError(fiber.StatusForbidden, "Token is limited to existing notebooks")
+		}
+		if token.WorkspaceID != "" && req.WorkspaceID == "" {
+			req.WorkspaceID = token.WorkspaceID
+		}
+		if token.WorkspaceID != "" && req.WorkspaceID != token.WorkspaceID {
+			return fiber.NewError(fiber.StatusForbidden, "Token is limited to another workspace")
+		}
+	}
+	return nil
+}
+
+func putNotebook(reg core.Registry, notebooksDir string) fiber.Handler {
 	return func(c fiber.Ctx) error {
-		log.Debug().Str("IP", c.IP()).Msg("PUT /notebooks/:id")
 		id := c.Params("id")
 		var req core.CreateUpdateNotebookRequest
 		if err := json.Unmarshal(c.Body(), &req); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
 		}
 
-		if err := validateRequest(req); err != nil {
+		if err := ResolveNotebookRequest(&req, notebooksDir); err != nil {
+			return err
+		}
+
+		current, exists := reg.Get(id)
+		if !exists || !tokenCovers(c, current) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		user := currentUser(c)
+		if !current.CanEdit(user) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+		if (req.Owner != "" || req.Collaborators != nil) && !current.CanManage(user) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Only the owner can change who has access"})
+		}
+		if token := currentToken(c); token != nil && token.WorkspaceID != "" && req.WorkspaceID != "" && req.WorkspaceID != token.WorkspaceID {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Token is limited to another workspace"})
+		}
+		if err := checkMembers(reg, req); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
 		}
 
 		nb, err := reg.Update(id, req)
 		if err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: err.Error()})
+			return err
 		}
 
 		return c.JSON(core.NotebookResponse{Notebook: nb})
@@ -129,10 +284,16 @@ func putNotebook(reg core.Registry) fiber.Handler {
 
 func deleteNotebook(reg core.Registry) fiber.Handler {
 	return func(c fiber.Ctx) error {
-		log.Debug().Str("IP", c.IP()).Msg("DELETE /notebooks/:id")
 		id := c.Params("id")
+		nb, exists := reg.Get(id)
+		if !exists || !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !nb.CanManage(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Only the owner can delete this notebook"})
+		}
 		if err := reg.Delete(id); err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: err.Error()})
+			return err
 		}
 		return c.SendStatus(fiber.StatusNoContent)
 	}
@@ -140,16 +301,135 @@ func deleteNotebook(reg core.Registry) fiber.Handler {
 
 func reloadNotebook(reg core.Registry, runner *core.Runner) fiber.Handler {
 	return func(c fiber.Ctx) error {
-		log.Debug().Str("IP", c.IP()).Msg("POST /notebooks/:id/reload")
 		id := c.Params("id")
 
 		nb, exists := reg.Get(id)
-		if !exists {
+		if !exists || !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !nb.CanEdit(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+
+		if err := restartNotebook(runner, nb); err != nil {
+			return err
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// killNotebook kills the process group of a notebook stuck where stopping
+// or reloading it hangs. A notebook that should run is restarted afterwards
+// by the reconciler, as after a crash. Killing bypasses the notebook's lock
+// and any graceful stop, so only admins may do it.
+func killNotebook(reg core.Registry, runner *core.Runner) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		nb, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, nb) {
 			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
 		}
 
-		runner.HandleRegistryEvent(nb, core.ActionUpdate)
+		if _, err := runner.Kill(nb.ID); err != nil {
+			return err
+		}
 
 		return c.SendStatus(fiber.StatusNoContent)
 	}
 }
+
+// restartNotebook restarts the process of nb, or starts it if it has none.
+// A reload doesn't change the definition, so going through the registry
+// wouldn't restart anything.
+func restartNotebook(runner *core.Runner, nb core.Notebook) error {
+	var notRunning *core.NotRunningError
+	if err := runner.Restart(nb.ID); errors.As(err, &notRunning) {
+		runner.HandleRegistryEvent(nb, core.ActionUpdate)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func putNotebookState(reg core.Registry, runner *core.Runner) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.SetDesiredStateRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		nb, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !nb.CanEdit(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+
+		return setDesiredState(c, reg, runner, nb, req.Desired)
+	}
+}
+
+func suspendNotebook(reg core.Registry, runner *core.Runner) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		nb, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !nb.CanEdit(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+		if nb.Disabled {
+			return c.Status(fiber.StatusConflict).JSON(core.ErrorResponse{Error: "Notebook is disabled"})
+		}
+		if !nb.WantsProcess() {
+			return c.Status(fiber.StatusConflict).JSON(core.ErrorResponse{Error: "Notebook is stopped"})
+		}
+
+		return setDesiredState(c, reg, runner, nb, core.DesiredSuspended)
+	}
+}
+
+func resumeNotebook(reg core.Registry, runner *core.Runner) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		nb, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !nb.CanEdit(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+		if nb.Disabled {
+			return c.Status(fiber.StatusConflict).JSON(core.ErrorResponse{Error: "Notebook is disabled"})
+		}
+		if !nb.WantsProcess() {
+			return c.Status(fiber.StatusConflict).JSON(core.ErrorResponse{Error: "Notebook is stopped"})
+		}
+
+		return setDesiredState(c, reg, runner, nb, core.DesiredRunning)
+	}
+}
+
+// setDesiredState persists desired for nb and reports it with the state the
+// runner has converged to so far. Declaring the state a notebook is already
+// in is a no-op, so callers can converge without checking first.
+func setDesiredState(c fiber.Ctx, reg core.Registry, runner *core.Runner, nb core.Notebook, desired core.DesiredState) error {
+	if nb.Desired != desired {
+		var err error
+		nb, err = reg.Update(nb.ID, core.CreateUpdateNotebookRequest{Desired: desired})
+		if err != nil {
+			return err
+		}
+	}
+
+	// A stopped notebook reports an error alongside StatusStopped.
+	status, _ := runner.GetStatus(nb.ID)
+	if !nb.WantsProcess() {
+		status = core.StatusStopped
+	}
+	return c.JSON(core.NotebookStateResponse{Desired: desired, Current: status})
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+func getHealthz() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	}
+}
+
+// getReadyz reports the hub as ready once at least minPinnedRunning of the
+// pinned notebooks are Running. With no pinned notebooks the hub is ready.
+func getReadyz(reg core.Registry, runner *core.Runner, minPinnedRunning float64) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var resp core.ReadinessResponse
+		for _, nb := range reg.List() {
+			if !nb.Pinned {
+				continue
+			}
+			resp.PinnedTotal++
+			if status, err := runner.GetStatus(nb.ID); err == nil && status == core.StatusRunning {
+				resp.PinnedRunning++
+			}
+		}
+
+		resp.Ready = true
+		if minPinnedRunning > 0 && resp.PinnedTotal > 0 {
+			resp.Ready = float64(resp.PinnedRunning)/float64(resp.PinnedTotal) >= minPinnedRunning
+		}
+
+		if !resp.Ready {
+			requestLogger(c).Warn().Int("pinned_total", resp.PinnedTotal).
+				Int("pinned_running", resp.PinnedRunning).
+				Msg("Hub not ready")
+			return c.Status(fiber.StatusServiceUnavailable).JSON(resp)
+		}
+		return c.JSON(resp)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/hooks"
+	"github.com/rs/zerolog"
+)
+
+// hookTimeout bounds how long the actions of one delivery may take.
+const hookTimeout = 5 * time.Minute
+
+// SetupHookRoutes serves POST /api/v1/hooks/:name for webhook senders such as
+// GitHub or GitLab, which authenticate with the hook's secret rather than a
+// hub account. It must be set up before SetupAPIRoutes so that API
+// authentication doesn't apply. Actions run in the background; deliveries to
+// the same hook run one at a time.
+func SetupHookRoutes(app *fiber.App, cfg *config.Config, reg core.Registry, runner *core.Runner, defs map[string]hooks.Hook) {
+	locks := make(map[string]*sync.Mutex, len(defs))
+	for name := range defs {
+		locks[name] = &sync.Mutex{}
+	}
+	app.Post("/api/v1/hooks/:name", postHook(cfg.Notebooks.Path, reg, runner, defs, locks))
+}
+
+func postHook(notebooksDir string, reg core.Registry, runner *core.Runner, defs map[string]hooks.Hook, locks map[string]*sync.Mutex) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		hook, exists := defs[c.Params("name")]
+		// Unknown hooks and wrong secrets look the same to the sender.
+		if !exists || !hook.Verify(c.Body(), func(key string) string { return c.Get(key) }) {
+			requestLogger(c).Debug().Str("hook", c.Params("name")).Msg("Webhook rejected")
+			return c.Status(fiber.StatusUnauthorized).JSON(core.ErrorResponse{Error: "Invalid hook or secret"})
+		}
+
+		logger := requestLogger(c).With().Str("hook", hook.Name).Logger()
+		// The body is only valid until the handler returns.
+		body := bytes.Clone(c.Body())
+		go func() {
+			lock := locks[hook.Name]
+			lock.Lock()
+			defer lock.Unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+			defer cancel()
+			runHook(ctx, &logger, notebooksDir, reg, runner, hook, body)
+		}()
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+// runHook runs the actions of a hook in order and stops at the first that
+// fails, since later ones usually depend on it.
+func runHook(ctx context.Context, logger *zerolog.Logger, notebooksDir string, reg core.Registry, runner *core.Runner, hook hooks.Hook, body []byte) {
+	for i, action := range hook.Actions {
+		if err := runHookAction(ctx, logger, notebooksDir, reg, runner, action, body); err != nil {
+			logger.Error().Err(err).Int("action", i).Str("type", action.Type).Msg("Webhook action failed")
+			return
+		}
+	}
+	logger.Info().Int("actions", len(hook.Actions)).Msg("Webhook handled")
+}
+
+func runHookAction(ctx context.Context, logger *zerolog.Logger, notebooksDir string, reg core.Registry, runner *core.Runner, action hooks.Action, body []byte) error {
+	switch action.Type {
+	case hooks.ActionGitPull:
+		dir, _, err := core.ResolvePath(notebooksDir, action.Dir)
+		if err != nil {
+			return err
+		}
+		return hooks.GitPull(ctx, dir)
+
+	case hooks.ActionReload:
+		nb, exists := reg.Get(action.Notebook)
+		if !exists {
+			return &core.NotFoundError{ID: action.Notebook}
+		}
+		return restartNotebook(runner, nb)
+
+	case hooks.ActionDiscover:
+		results, err := registerGlob(reg, notebooksDir, *action.Discover, globBase(*action.Discover))
+		if err != nil {
+			return err
+		}
+		added := 0
+		for _, result := range results {
+			if result.Notebook != nil {
+				added++
+			}
+		}
+		logger.Info().Int("matches", len(results)).Int("added", added).Msg("Discovered notebooks")
+		return nil
+
+	case hooks.ActionPreview:
+		pr, ok := hooks.ParsePullRequest(body)
+		if !ok {
+			logger.Debug().Msg("Delivery is not about an open pull request, skipping preview")
+			return nil
+		}
+		base, exists := reg.Get(action.Notebook)
+		if !exists {
+			return &core.NotFoundError{ID: action.Notebook}
+		}
+		if pr.Fork && !pr.Closed && !action.Forks {
+			logger.Info().Int("number", pr.Number).Msg("Pull request is from a fork, skipping preview")
+			return nil
+		}
+		if pr.Closed {
+			err := core.DeletePreview(ctx, reg, notebooksDir, base, pr.PreviewName())
+			var notFound *core.NotFoundError
+			if errors.As(err, &notFound) {
+				return nil
+			}
+			return err
+		}
+		_, err := deployPreview(ctx, reg, runner, notebooksDir, base, pr.PreviewName(), pr.Ref, action.Secrets)
+		return err
+	}
+	return errors.New("unknown action type " + action.Type)
+}
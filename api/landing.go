@@ -0,0 +1,80 @@
+package api
+
+import (
+	"html/template"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 3rem auto; padding: 0 1rem; color: #222; }
+ul { list-style: none; padding: 0; }
+li { padding: .75rem 0; border-bottom: 1px solid #eee; }
+a { color: #0b57d0; text-decoration: none; font-weight: 600; }
+span { color: #666; font-size: .9rem; margin-left: .5rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Notebooks}}<ul>
+{{range .Notebooks}}<li><a href="{{.URL}}">{{.Name}}</a><span>{{.Domain}}</span></li>
+{{end}}</ul>
+{{else}}<p>No notebooks are published yet.</p>
+{{end}}</body>
+</html>
+`))
+
+type landingEntry struct {
+	Name   string
+	Domain string
+	URL    string
+}
+
+// serveLanding answers a request for a host without a notebook with an index
+// of the public notebooks. Only the hub's own domain gets a 200; any other
+// unknown host is still a 404, just a browsable one. Clients that don't
+// want HTML keep getting the JSON error.
+func serveLanding(c fiber.Ctx, cfg *config.Config, domains core.DomainResolver) error {
+	host := strings.TrimSuffix(c.Hostname(), ".")
+	isBase := cfg.Landing.Domain != "" && strings.EqualFold(host, cfg.Landing.Domain)
+	if !isBase && c.Accepts(fiber.MIMETextHTML) == "" {
+		return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found for this domain"})
+	}
+
+	// Links keep the port the proxy was reached on.
+	port := ""
+	if _, p, err := net.SplitHostPort(c.Host()); err == nil {
+		port = ":" + p
+	}
+	var entries []landingEntry
+	for _, nb := range domains.List() {
+		if !nb.Public || !nb.WantsProcess() {
+			continue
+		}
+		entries = append(entries, landingEntry{
+			Name:   nb.Name,
+			Domain: nb.Domain + nb.PathPrefix,
+			URL:    c.Scheme() + "://" + nb.Domain + port + nb.PathPrefix + "/",
+		})
+	}
+
+	status := fiber.StatusOK
+	if !isBase {
+		status = fiber.StatusNotFound
+	}
+	c.Status(status).Type("html", "utf-8")
+	return landingTemplate.Execute(c.Response().BodyWriter(), struct {
+		Title     string
+		Notebooks []landingEntry
+	}{cfg.Landing.Title, entries})
+}
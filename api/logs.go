@@ -0,0 +1,137 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/proclog"
+)
+
+// maxBundleLogSize caps the log data in a bundle; the newest files are kept.
+const maxBundleLogSize = 32 << 20
+
+// LogBundleStatus is the status.json entry of a log bundle.
+type LogBundleStatus struct {
+	Status      core.Status       `json:"status"`
+	Desired     core.DesiredState `json:"desired_state,omitempty"`
+	GeneratedAt time.Time         `json:"generated_at"`
+}
+
+func SetupLogRoutes(app *fiber.App, reg core.Registry, runner *core.Runner, logs *proclog.Store) {
+	api := app.Group("/api/v1")
+	api.Get("/notebooks/:id/logs/download", downloadNotebookLogs(reg, runner, logs), authorize(core.ScopeRead))
+}
+
+func downloadNotebookLogs(reg core.Registry, runner *core.Runner, logs *proclog.Store) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		nb, exists := reg.Get(id)
+		if !exists || !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+
+		files, err := logs.Files(id)
+		if err != nil {
+			return err
+		}
+		files = newestWithin(files, maxBundleLogSize)
+
+		status, _ := runner.GetStatus(id)
+		if !nb.WantsProcess() {
+			status = core.StatusStopped
+		}
+		bundleStatus := LogBundleStatus{Status: status, Desired: nb.Desired, GeneratedAt: time.Now()}
+
+		// Bundles end up attached to bug reports, so environment values are
+		// left out.
+		env := make(map[string]string, len(nb.Env))
+		for k := range nb.Env {
+			env[k] = "REDACTED"
+		}
+		nb.Env = env
+
+		c.Attachment(nb.ID + "-logs.zip")
+		c.Set(fiber.HeaderContentType, "application/zip")
+		logger := requestLogger(c)
+		return c.SendStreamWriter(func(w *bufio.Writer) {
+			if err := writeLogBundle(w, nb, bundleStatus, files); err != nil {
+				logger.Error().Str("method", "downloadNotebookLogs").
+					Str("notebook", id).
+					Err(err).
+					Msg("Failed to write log bundle")
+			}
+		})
+	}
+}
+
+func writeLogBundle(w *bufio.Writer, nb core.Notebook, status LogBundleStatus, files []string) error {
+	zw := zip.NewWriter(w)
+	if err := writeJSONEntry(zw, "notebook.json", nb); err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "status.json", status); err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := writeFileEntry(zw, "logs/"+filepath.Base(path), path); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func writeJSONEntry(zw *zip.Writer, name string, v any) error {
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeFileEntry(zw *zip.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Removed by retention since it was listed.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// newestWithin returns the newest of files, which are ordered oldest first,
+// that together fit in limit bytes. The newest file is always included.
+func newestWithin(files []string, limit int64) []string {
+	var total int64
+	for i := len(files) - 1; i >= 0; i-- {
+		info, err := os.Stat(files[i])
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		if total > limit && i < len(files)-1 {
+			return files[i+1:]
+		}
+	}
+	return files
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+type MaintenanceResponse struct {
+	Maintenance core.MaintenanceState `json:"maintenance"`
+}
+
+// Maintenance switches the proxy between serving notebooks and answering
+// every domain with a maintenance page, so planned downtime reads as such
+// rather than as every notebook failing at once. Operators on the allowed
+// addresses still reach the notebooks. The switch is kept by each hub, so
+// in a cluster it is set on every node.
+type Maintenance struct {
+	allow   []netip.Prefix
+	page    []byte
+	message string
+
+	mu    sync.RWMutex
+	state core.MaintenanceState
+}
+
+// NewMaintenance sets maintenance mode up as cfg says, reading the custom
+// page if there is one.
+func NewMaintenance(cfg *config.Config) (*Maintenance, error) {
+	allow, err := config.ParseIPList(cfg.Maintenance.AllowIPs)
+	if err != nil {
+		return nil, err
+	}
+	m := &Maintenance{allow: allow, message: cfg.Maintenance.Message}
+	if cfg.Maintenance.Page != "" {
+		if m.page, err = os.ReadFile(cfg.Maintenance.Page); err != nil {
+			return nil, fmt.Errorf("failed to read maintenance page: %w", err)
+		}
+	}
+	if cfg.Maintenance.Enabled {
+		m.Set(true, "")
+	}
+	return m, nil
+}
+
+func (m *Maintenance) State() core.MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Set switches maintenance mode on or off. An empty message falls back to
+// the configured one.
+func (m *Maintenance) Set(enabled bool, message string) core.MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !enabled {
+		m.state = core.MaintenanceState{}
+		return m.state
+	}
+	if message == "" {
+		message = m.message
+	}
+	since := m.state.Since
+	if since == nil {
+		now := time.Now()
+		since = &now
+	}
+	m.state = core.MaintenanceState{Enabled: true, Message: message, Since: since}
+	return m.state
+}
+
+// allowed reports whether ip belongs to an operator.
+func (m *Maintenance) allowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(m.allow, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// SetupMaintenance serves the maintenance page on the proxy while
+// maintenance mode is on. It must be set up before every other proxy
+// route, WebSockets included.
+func SetupMaintenance(app *fiber.App, m *Maintenance, pages *ErrorPages, domains core.DomainResolver) {
+	app.Use(serveMaintenance(m, pages, domains))
+}
+
+// SetupMaintenanceRoutes lets admins see and switch maintenance mode.
+func SetupMaintenanceRoutes(app *fiber.App, m *Maintenance) {
+	api := app.Group("/api/v1/system", requireAdmin())
+	api.Get("/maintenance", getMaintenance(m))
+	api.Put("/maintenance", putMaintenance(m))
+}
+
+// serveMaintenance answers with the error page of the notebook requested,
+// if it has one, so branded notebooks stay branded, and otherwise with the
+// maintenance page.
+func serveMaintenance(m *Maintenance, pages *ErrorPages, domains core.DomainResolver) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		state := m.State()
+		if !state.Enabled || m.allowed(c.IP()) {
+			return c.Next()
+		}
+
+		var nb *core.Notebook
+		if routed, ok := domains.Route(c.Hostname(), c.Path()); ok {
+			nb = &routed
+		}
+		if m.page != nil && (nb == nil || nb.ErrorPage == "") && c.Accepts(fiber.MIMETextHTML) != "" {
+			c.Set(fiber.HeaderCacheControl, "no-store")
+			c.Type("html", "utf-8")
+			return c.Status(fiber.StatusServiceUnavailable).Send(m.page)
+		}
+		return pages.Serve(c, nb, Notice{
+			Code:    fiber.StatusServiceUnavailable,
+			Reason:  ReasonMaintenance,
+			Title:   "Down for maintenance",
+			Message: state.Message,
+		})
+	}
+}
+
+func getMaintenance(m *Maintenance) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.JSON(MaintenanceResponse{Maintenance: m.State()})
+	}
+}
+
+func putMaintenance(m *Maintenance) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.SetMaintenanceRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		state := m.Set(*req.Enabled, req.Message)
+		requestLogger(c).Warn().Bool("enabled", state.Enabled).
+			Str("message", state.Message).
+			Msg("Maintenance mode switched")
+		return c.JSON(MaintenanceResponse{Maintenance: state})
+	}
+}
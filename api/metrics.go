@@ -0,0 +1,17 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/metrics"
+)
+
+func SetupMetricsRoutes(app *fiber.App) {
+	app.Get("/metrics", getMetrics())
+}
+
+func getMetrics() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		return metrics.Default.WriteText(c)
+	}
+}
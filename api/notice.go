@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+var noticeTemplate = template.Must(template.New("notice").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 3rem auto; padding: 0 1rem; color: #222; }
+p { color: #444; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+// Reasons the proxy can't serve a notebook, which error page templates may
+// tell apart.
+const (
+	ReasonNotFound    = "not_found"
+	ReasonDisabled    = "disabled"
+	ReasonClientCert  = "client_cert"
+	ReasonShareLink   = "share_link"
+	ReasonMaintenance = "maintenance"
+	ReasonStopped     = "stopped"
+	ReasonStarting    = "starting"
+	ReasonSuspended   = "suspended"
+	ReasonBusy        = "busy"
+	ReasonUnavailable = "unavailable"
+)
+
+// Notice is what error page templates are given.
+type Notice struct {
+	// Code is the HTTP status of the response.
+	Code    int
+	Reason  string
+	Title   string
+	Message string
+	// Name and Status are the notebook's, and Domain the one requested.
+	// Name and Status are empty when no notebook is found.
+	Name   string
+	Status core.Status
+	Domain string
+}
+
+// ErrorPages renders the pages the proxy answers visitors with when it
+// can't serve them a notebook: the notebook's own template, the hub's, or
+// the built-in page, in that order. A notebook's template is read again
+// once it changes; one that fails gives way to the next.
+type ErrorPages struct {
+	notebooksDir string
+	fallback     *template.Template
+
+	mu    sync.Mutex
+	cache map[string]cachedPage
+}
+
+type cachedPage struct {
+	modTime time.Time
+	tmpl    *template.Template
+}
+
+// NewErrorPages loads the hub's error page, if cfg sets one.
+func NewErrorPages(cfg *config.Config) (*ErrorPages, error) {
+	p := &ErrorPages{notebooksDir: cfg.Notebooks.Path, fallback: noticeTemplate, cache: map[string]cachedPage{}}
+	if cfg.Proxy.ErrorPage != "" {
+		tmpl, err := template.ParseFiles(cfg.Proxy.ErrorPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load error page: %w", err)
+		}
+		p.fallback = tmpl
+	}
+	return p, nil
+}
+
+// notebookTemplate returns the error page of nb, or nil if it has none or
+// it doesn't parse.
+func (p *ErrorPages) notebookTemplate(nb *core.Notebook) (*template.Template, error) {
+	if nb == nil {
+		return nil, nil
+	}
+	path, ok := nb.ErrorPagePath(p.notebooksDir)
+	if !ok {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cached, ok := p.cache[path]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.tmpl, nil
+	}
+	// A template that fails to parse is remembered as nil, so it is only
+	// reported once per change.
+	tmpl, err := template.New(filepath.Base(path)).ParseFiles(path)
+	p.cache[path] = cachedPage{modTime: info.ModTime(), tmpl: tmpl}
+	return tmpl, err
+}
+
+// Serve answers with n, rendered for visitors and as a JSON error for
+// clients that don't want HTML. nb is the notebook requested, if any.
+func (p *ErrorPages) Serve(c fiber.Ctx, nb *core.Notebook, n Notice) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Status(n.Code)
+	if c.Accepts(fiber.MIMETextHTML) == "" {
+		return c.JSON(core.ErrorResponse{Error: n.Message})
+	}
+	if n.Domain == "" {
+		n.Domain = c.Hostname()
+	}
+	if nb != nil && n.Name == "" {
+		n.Name = nb.Name
+	}
+	if n.Title == "" {
+		n.Title = n.Name
+	}
+
+	var page bytes.Buffer
+	tmpl, err := p.notebookTemplate(nb)
+	if err != nil {
+		requestLogger(c).Warn().Str("notebook", nb.ID).Err(err).Msg("Invalid notebook error page")
+	}
+	if tmpl == nil {
+		tmpl = p.fallback
+	}
+	if err := tmpl.Execute(&page, n); err != nil {
+		requestLogger(c).Warn().Err(err).Msg("Failed to render error page")
+		page.Reset()
+		if err := noticeTemplate.Execute(&page, n); err != nil {
+			return err
+		}
+	}
+	c.Type("html", "utf-8")
+	return c.Send(page.Bytes())
+}
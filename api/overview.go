@@ -0,0 +1,66 @@
+package api
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/proxy"
+)
+
+const (
+	defaultOverviewTop = 10
+	maxOverviewTop     = 100
+)
+
+// getOverview sums up the notebooks the caller can see in one response, for
+// a status wallboard. top sets how many of the busiest notebooks are listed.
+func getOverview(reg core.Registry, runner *core.Runner) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		top := defaultOverviewTop
+		if q := c.Query("top"); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 0 || n > maxOverviewTop {
+				return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "top must be between 0 and " + strconv.Itoa(maxOverviewTop)})
+			}
+			top = n
+		}
+
+		nbs := reg.List()
+		if token := currentToken(c); token != nil {
+			nbs = filterByToken(nbs, token)
+		}
+		visible := make(map[string]bool, len(nbs))
+
+		resp := core.OverviewResponse{
+			Notebooks:     len(nbs),
+			Statuses:      make(map[core.Status]int),
+			RecentCrashes: []core.Crash{},
+			TopTraffic:    []core.NotebookTraffic{},
+		}
+		for _, nb := range nbs {
+			visible[nb.ID] = true
+			status, _ := runner.GetStatus(nb.ID)
+			resp.Statuses[status]++
+			if requests := proxy.RequestCount(nb.ID); requests > 0 {
+				resp.TopTraffic = append(resp.TopTraffic, core.NotebookTraffic{ID: nb.ID, Name: nb.Name, Requests: requests})
+			}
+		}
+		slices.SortFunc(resp.TopTraffic, func(a, b core.NotebookTraffic) int {
+			return cmp.Compare(b.Requests, a.Requests)
+		})
+		resp.TopTraffic = resp.TopTraffic[:min(top, len(resp.TopTraffic))]
+
+		resp.Usage = runner.Usage(func(nb core.Notebook) bool { return visible[nb.ID] })
+		resp.Usage.Notebooks = len(nbs)
+		resp.StartQueue = runner.StartQueueLength()
+		for _, crash := range runner.RecentCrashes() {
+			if visible[crash.NotebookID] {
+				resp.RecentCrashes = append(resp.RecentCrashes, crash)
+			}
+		}
+		return c.JSON(resp)
+	}
+}
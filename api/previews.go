@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// previewTimeout bounds the git operations of deploying a preview.
+const previewTimeout = 2 * time.Minute
+
+func getPreviews(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		base, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, base) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		previews := core.ListPreviews(reg, base.ID)
+		if previews == nil {
+			previews = []core.Notebook{}
+		}
+		return c.JSON(core.NotebooksResponse{Notebooks: previews})
+	}
+}
+
+func putPreview(reg core.Registry, runner *core.Runner, notebooksDir string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.DeployPreviewRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		base, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, base) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !base.CanEdit(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+
+		ctx, cancel := context.WithTimeout(c.Context(), previewTimeout)
+		defer cancel()
+		nb, err := deployPreview(ctx, reg, runner, notebooksDir, base, c.Params("name"), req.Ref, req.Secrets)
+		if err != nil {
+			return err
+		}
+		return c.JSON(core.NotebookResponse{Notebook: nb})
+	}
+}
+
+func deletePreview(reg core.Registry, notebooksDir string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		base, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, base) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !base.CanEdit(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+
+		ctx, cancel := context.WithTimeout(c.Context(), previewTimeout)
+		defer cancel()
+		if err := core.DeletePreview(ctx, reg, notebooksDir, base, c.Params("name")); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// deployPreview deploys a preview and restarts it if that didn't already.
+func deployPreview(ctx context.Context, reg core.Registry, runner *core.Runner, notebooksDir string, base core.Notebook, name, ref string, secrets bool) (core.Notebook, error) {
+	nb, changed, err := core.DeployPreview(ctx, reg, notebooksDir, base, name, ref, secrets)
+	if err != nil || changed {
+		return nb, err
+	}
+	return nb, restartNotebook(runner, nb)
+}
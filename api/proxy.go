@@ -2,122 +2,241 @@ package api
 
 import (
 	"bytes"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gorilla/websocket"
+	"github.com/rekk30/marimo-hub/pkg/config"
 	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"github.com/rekk30/marimo-hub/pkg/proxy"
 	wsproxy "github.com/rekk30/marimo-hub/pkg/websocket"
 )
 
-func SetupProxyRoutes(app *fiber.App, reg core.Registry, runner *core.Runner) {
-	app.Get("/ws", wsproxy.New(func(conn *wsproxy.Conn) {
-		host := conn.Hostname
-		nb, ok := reg.GetByDomain(host)
-		if !ok {
-			conn.WriteMessage(websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "no such notebook"))
+// clientCertHeader passes the subject of the visitor's verified client
+// certificate to notebooks. Visitors can't set it themselves.
+const clientCertHeader = "X-Client-Cert-Subject"
+
+func SetupProxyRoutes(app *fiber.App, cfg *config.Config, domains core.DomainResolver, runner *core.Runner, links *core.ShareLinks, pages *ErrorPages) {
+	router := proxy.NewRouter(domains, runner)
+	trusted := newTrustedHeaders(cfg)
+	app.Use(forwardIdentity(trusted))
+	app.Use(checkShareLink(links, domains, pages))
+	app.Use(checkForwardAuth(domains))
+	app.Use(addSecurityHeaders(cfg, domains))
+	app.Use(setCSP(cfg, domains))
+	app.Use(rewritePrefix(cfg, trusted))
+
+	// marimo serves its WebSockets under the notebook's path prefix, so
+	// every upgrade is proxied; other requests pass through.
+	app.Use(wsproxy.New(func(conn *wsproxy.Conn) {
+		subject, hasCert := conn.GetHeader(clientCertHeader)
+		shareLink, shared := conn.GetHeader(shareLinkHeader)
+		nb, err := router.Notebook(conn.Hostname, conn.Path, hasCert || shared)
+		if err != nil {
+			closeSession(conn, err)
 			return
 		}
-		port, ok := runner.GetPort(nb.ID)
-		if !ok {
-			conn.WriteMessage(websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "port unavailable"))
+		backend, err := router.Backend(nb)
+		if err != nil {
+			closeSession(conn, err)
 			return
 		}
-
-		path := conn.Path
-		rawQS := conn.RawQuery
-		targetUrl := fmt.Sprintf("ws://127.0.0.1:%d%s", port, path)
-		if rawQS != "" {
-			targetUrl += "?" + rawQS
-		}
-
-		backend, _, err := websocket.DefaultDialer.Dial(targetUrl, nil)
+		done, err := backend.BeginSession()
 		if err != nil {
-			conn.WriteMessage(websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()))
+			closeSession(conn, err)
 			return
 		}
-		defer backend.Close()
-
-		go func() {
-			for {
-				t, msg, err := backend.ReadMessage()
-				if err != nil {
-					conn.WriteMessage(websocket.CloseMessage,
-						websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-					return
-				}
-				if err := conn.WriteMessage(t, msg); err != nil {
-					return
-				}
-			}
-		}()
-		for {
-			t, msg, err := conn.ReadMessage()
-			if err != nil {
-				backend.WriteMessage(websocket.CloseMessage,
-					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-				return
+		defer done()
+
+		header := http.Header{}
+		if id, ok := conn.GetHeader(http.CanonicalHeaderKey(fiber.HeaderXRequestID)); ok {
+			header.Set(fiber.HeaderXRequestID, id)
+		}
+		if hasCert {
+			header.Set(clientCertHeader, subject)
+		}
+		if shared {
+			header.Set(shareLinkHeader, shareLink)
+		}
+		// Only the identity headers the proxy vouches for are passed on.
+		var passed []string
+		if trusted != nil {
+			passed = append(passed, trusted.userHeader, trusted.groupsHeader)
+		}
+		if nb.ForwardAuth != nil {
+			passed = append(passed, nb.ForwardAuth.ResponseHeaders...)
+		}
+		for _, name := range passed {
+			if v, ok := conn.GetHeader(http.CanonicalHeaderKey(name)); ok {
+				header.Set(name, v)
 			}
-			if err := backend.WriteMessage(t, msg); err != nil {
+		}
+
+		path := conn.Path
+		session := proxy.NewSession(conn)
+		defer session.Close()
+		notebook, err := backend.Dial(session.Context(), path, conn.RawQuery, header)
+		if err != nil {
+			if session.Context().Err() != nil {
+				// The visitor is gone.
 				return
 			}
+			logging.WebSocket.Warn().Str("notebook", nb.ID).
+				Str("path", path).
+				Err(err).
+				Msg("Failed to connect to notebook WebSocket")
+			closeSession(conn, err)
+			return
 		}
+		defer notebook.Close()
+		logging.WebSocket.Debug().Str("notebook", nb.ID).Str("path", path).Msg("WebSocket session opened")
+		defer func() {
+			logging.WebSocket.Debug().Str("notebook", nb.ID).Str("path", path).Msg("WebSocket session closed")
+		}()
+		session.Relay(notebook, backend.Throttle())
 	}))
 
 	app.Use(func(c fiber.Ctx) error {
-		host := c.Hostname()
+		nb, err := router.Notebook(c.Hostname(), c.Path(), verifiedClientCert(c) != nil || sharedVisit(c))
+		switch {
+		case errors.Is(err, proxy.ErrNotFound) && cfg.Landing.Enabled:
+			return serveLanding(c, cfg, domains)
+		case errors.Is(err, proxy.ErrNotFound):
+			return pages.Serve(c, nil, Notice{Code: fiber.StatusNotFound, Reason: ReasonNotFound, Title: "Not found", Message: "Notebook not found for this domain"})
+		case errors.Is(err, proxy.ErrDisabled):
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusServiceUnavailable, Reason: ReasonDisabled, Status: core.StatusDisabled, Message: "This notebook is disabled."})
+		case errors.Is(err, proxy.ErrClientCert):
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusForbidden, Reason: ReasonClientCert, Status: notebookStatus(runner, nb), Message: "This notebook requires a client certificate."})
+		}
 
-		nb, exists := reg.GetByDomain(host)
-		if !exists {
-			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found for this domain"})
+		// Assets don't need the backend, so they are served even while
+		// the notebook is stopped or suspended.
+		if served, err := serveAsset(c, nb, cfg.Notebooks.Path, cfg.Notebooks.AssetsMaxAge); served {
+			return err
 		}
 
-		port, ok := runner.GetPort(nb.ID)
-		if !ok {
-			return c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: "Notebook found but port not available"})
+		backend, err := router.Backend(nb)
+		switch {
+		case errors.Is(err, proxy.ErrNoAddress):
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusInternalServerError, Reason: ReasonStopped, Status: notebookStatus(runner, nb), Message: "Notebook found but port not available"})
+		case errors.Is(err, proxy.ErrSuspended):
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusServiceUnavailable, Reason: ReasonSuspended, Status: core.StatusSuspended, Message: "Notebook is suspended"})
+		case errors.Is(err, proxy.ErrStarting):
+			c.Set(fiber.HeaderRetryAfter, "5")
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusServiceUnavailable, Reason: ReasonStarting, Status: core.StatusStarting, Message: "Notebook is starting"})
+		case err != nil:
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusInternalServerError, Reason: ReasonStopped, Status: notebookStatus(runner, nb), Message: "Notebook not running"})
 		}
 
-		status, err := runner.GetStatus(nb.ID)
-		if err != nil || status != core.StatusRunning {
-			return c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: "Notebook not running"})
+		maxBody := int64(cfg.Server.ProxyMaxBodyMB) << 20
+		if int64(c.Request().Header.ContentLength()) > maxBody {
+			return fiber.ErrRequestEntityTooLarge
 		}
 
-		client := &http.Client{}
-		req, err := http.NewRequest(c.Method(), fmt.Sprintf("http://localhost:%d%s", port, c.Path()), bytes.NewReader(c.Body()))
+		url := backend.URL(c.Path(), string(c.Request().URI().QueryString()))
+		req, err := http.NewRequest(c.Method(), url, requestBody(c, maxBody))
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: "Failed to proxy request"})
 		}
+		// A chunked upload has no length and stays chunked.
+		req.ContentLength = int64(c.Request().Header.ContentLength())
 
-		for k, v := range c.GetReqHeaders() {
-			if len(v) > 0 {
-				req.Header.Set(k, v[0])
+		for k, values := range c.GetReqHeaders() {
+			for _, v := range values {
+				req.Header.Add(k, v)
 			}
 		}
-
-		resp, err := client.Do(req)
-		if err != nil {
+		// The tokens of share links are for the hub alone; notebook code
+		// could hand them out further.
+		proxy.RemoveCookies(req.Header, shareParam+"_")
+
+		resp, err := backend.Forward(req)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, proxy.ErrTooManyRequests):
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusTooManyRequests, Reason: ReasonBusy, Status: core.StatusRunning, Message: "Too many requests to this notebook"})
+		case errors.As(err, &tooLarge):
+			return fiber.ErrRequestEntityTooLarge
+		case errors.Is(err, proxy.ErrInvalidUpstream):
+			requestLogger(c).Error().Str("notebook", nb.ID).Err(err).Msg("Invalid upstream")
 			return c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: "Failed to proxy request"})
+		case err != nil:
+			requestLogger(c).Warn().Str("notebook", nb.ID).
+				Str("path", c.Path()).
+				Err(err).
+				Msg("Failed to proxy request")
+			return pages.Serve(c, &nb, Notice{Code: fiber.StatusInternalServerError, Reason: ReasonUnavailable, Status: notebookStatus(runner, nb), Message: "Failed to proxy request"})
 		}
-		defer resp.Body.Close()
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: "Failed to read response"})
-		}
-		resp.Body = io.NopCloser(bytes.NewReader(body))
-
-		for k, v := range resp.Header {
-			c.Set(k, v[0])
+		for k, values := range resp.Header {
+			for _, v := range values {
+				c.Response().Header.Add(k, v)
+			}
 		}
 		if _, exists := resp.Header["Content-Type"]; !exists {
 			c.Set("Content-Type", "application/json")
 		}
 
-		return c.Status(resp.StatusCode).SendStream(resp.Body)
+		// The body is streamed with its length, so partial content keeps
+		// the Content-Length matching its Content-Range and downloads can
+		// be resumed. The server closes it once sent.
+		return c.Status(resp.StatusCode).SendStream(resp.Body, int(resp.ContentLength))
 	})
 }
+
+// notebookStatus is the status of nb for error pages, which don't mind an
+// error finding it out.
+func notebookStatus(runner *core.Runner, nb core.Notebook) core.Status {
+	status, _ := runner.GetStatus(nb.ID)
+	return status
+}
+
+// closeSession turns a visitor away from a WebSocket session with the
+// close code err calls for. marimo reconnects after CloseTryAgainLater,
+// which a notebook that isn't running, as while it restarts, is closed
+// with.
+func closeSession(conn *wsproxy.Conn, err error) {
+	code := websocket.CloseTryAgainLater
+	switch {
+	case errors.Is(err, proxy.ErrNotFound), errors.Is(err, proxy.ErrDisabled):
+		code = websocket.CloseNormalClosure
+	case errors.Is(err, proxy.ErrClientCert):
+		code = websocket.ClosePolicyViolation
+	case errors.Is(err, proxy.ErrNoAddress), errors.Is(err, proxy.ErrInvalidUpstream):
+		code = websocket.CloseInternalServerErr
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()))
+}
+
+// forwardIdentity replaces whatever the visitor sent as clientCertHeader
+// with the subject of the client certificate the proxy verified, if any,
+// and drops identity headers that don't come from a trusted auth proxy.
+func forwardIdentity(trusted *trustedHeaders) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		trusted.forward(c)
+		c.Request().Header.Del(clientCertHeader)
+		if cert := verifiedClientCert(c); cert != nil {
+			c.Request().Header.Set(clientCertHeader, cert.Subject.String())
+		}
+		return c.Next()
+	}
+}
+
+// requestBody reads the body of the request being proxied as it arrives,
+// failing once it exceeds limit bytes.
+func requestBody(c fiber.Ctx, limit int64) io.Reader {
+	if c.Request().Header.ContentLength() == 0 {
+		return http.NoBody
+	}
+	var body io.Reader
+	if c.Request().IsBodyStream() {
+		body = c.Request().BodyStream()
+	} else {
+		body = bytes.NewReader(c.Request().Body())
+	}
+	return http.MaxBytesReader(nil, io.NopCloser(body), limit)
+}
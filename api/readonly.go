@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+const readOnlyPath = "/api/v1/system/read-only"
+
+// readOnlyExempt are the calls allowed in read-only mode although they
+// aren't reads: switching it or maintenance mode, neither of which is
+// stored, and taking a backup, which is what the mode is often for.
+var readOnlyExempt = map[string]string{
+	readOnlyPath:                 fiber.MethodPut,
+	"/api/v1/system/maintenance": fiber.MethodPut,
+	"/api/v1/system/backups":     fiber.MethodPost,
+}
+
+type ReadOnlyResponse struct {
+	ReadOnly core.ReadOnlyState `json:"read_only"`
+}
+
+// ReadOnly switches the API between serving every call and rejecting those
+// that change state, for migrations, backups and incident freezes. The
+// proxy keeps serving notebooks either way. The switch is kept by each hub,
+// so in a cluster it is set on every node.
+type ReadOnly struct {
+	mu    sync.RWMutex
+	state core.ReadOnlyState
+}
+
+func NewReadOnly(enabled bool) *ReadOnly {
+	r := &ReadOnly{}
+	if enabled {
+		r.Set(true, "")
+	}
+	return r
+}
+
+func (r *ReadOnly) State() core.ReadOnlyState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
+
+// Set switches read-only mode on or off. Switching it on again only
+// updates the reason.
+func (r *ReadOnly) Set(enabled bool, reason string) core.ReadOnlyState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !enabled {
+		r.state = core.ReadOnlyState{}
+		return r.state
+	}
+	since := r.state.Since
+	if since == nil {
+		now := time.Now()
+		since = &now
+	}
+	r.state = core.ReadOnlyState{Enabled: true, Reason: reason, Since: since}
+	return r.state
+}
+
+// SetupReadOnly rejects the API calls that change state with 503 while
+// read-only mode is on. It must be set up before the routes it guards,
+// WebDAV and webhooks included.
+func SetupReadOnly(app *fiber.App, ro *ReadOnly) {
+	app.Use(rejectWhenReadOnly(ro))
+}
+
+// SetupReadOnlyRoutes lets admins see and switch read-only mode.
+func SetupReadOnlyRoutes(app *fiber.App, ro *ReadOnly) {
+	api := app.Group("/api/v1/system", requireAdmin())
+	api.Get("/read-only", getReadOnly(ro))
+	api.Put("/read-only", putReadOnly(ro))
+}
+
+func rejectWhenReadOnly(ro *ReadOnly) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, "PROPFIND":
+			return c.Next()
+		}
+		if readOnlyExempt[c.Path()] == c.Method() {
+			return c.Next()
+		}
+		state := ro.State()
+		if !state.Enabled {
+			return c.Next()
+		}
+		msg := "The hub is read-only"
+		if state.Reason != "" {
+			msg += ": " + state.Reason
+		}
+		return c.Status(fiber.StatusServiceUnavailable).JSON(core.ErrorResponse{Error: msg})
+	}
+}
+
+func getReadOnly(ro *ReadOnly) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.JSON(ReadOnlyResponse{ReadOnly: ro.State()})
+	}
+}
+
+func putReadOnly(ro *ReadOnly) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.SetReadOnlyRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		state := ro.Set(*req.Enabled, req.Reason)
+		requestLogger(c).Warn().Bool("enabled", state.Enabled).
+			Str("reason", state.Reason).
+			Msg("Read-only mode switched")
+		return c.JSON(ReadOnlyResponse{ReadOnly: state})
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/metrics"
+)
+
+var panicsTotal = metrics.NewCounter("marimo_hub_http_panics_total", "Panics recovered while handling HTTP requests.")
+
+// SetupRecover turns a panic in any handler into a 500 response instead of
+// crashing the hub. It must be set up before anything else so it covers the
+// other middleware too.
+func SetupRecover(app *fiber.App) {
+	app.Use(recoverPanics())
+}
+
+func recoverPanics() fiber.Handler {
+	return func(c fiber.Ctx) (err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			panicsTotal.Inc()
+			requestLogger(c).Error().
+				Interface("panic", r).
+				Str("method", c.Method()).
+				Str("path", c.Path()).
+				Str("stack", string(debug.Stack())).
+				Msg("Recovered from panic")
+			err = c.Status(fiber.StatusInternalServerError).JSON(core.ErrorResponse{Error: "Internal server error"})
+		}()
+		return c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"regexp"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"github.com/rs/zerolog"
+)
+
+const (
+	requestIDLocal = "request_id"
+	loggerLocal    = "logger"
+)
+
+// requestIDPattern bounds the inbound IDs that are trusted enough to end up
+// in logs and backend requests.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// SetupRequestID gives every request an X-Request-ID, keeping the caller's if
+// it has one, and a logger of module that carries it. The ID is returned to
+// the caller and forwarded to notebook backends. It must be set up before any
+// other route or middleware.
+func SetupRequestID(app *fiber.App, module *logging.Module) {
+	app.Use(func(c fiber.Ctx) error {
+		id := c.Get(fiber.HeaderXRequestID)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Set(fiber.HeaderXRequestID, id)
+		// The proxy copies request headers to the backend.
+		c.Request().Header.Set(fiber.HeaderXRequestID, id)
+
+		logger := module.Logger().With().Str("request_id", id).Logger()
+		c.Locals(requestIDLocal, id)
+		c.Locals(loggerLocal, &logger)
+		return c.Next()
+	})
+}
+
+// requestID returns the ID of the current request.
+func requestID(c fiber.Ctx) string {
+	id, _ := c.Locals(requestIDLocal).(string)
+	return id
+}
+
+// requestLogger returns the logger of the current request, or the API's
+// logger if request IDs are not set up.
+func requestLogger(c fiber.Ctx) *zerolog.Logger {
+	if logger, ok := c.Locals(loggerLocal).(*zerolog.Logger); ok {
+		return logger
+	}
+	return logging.API.Logger()
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rs/zerolog"
+)
+
+// SetupRequestLog logs every API request once it has been handled, at level.
+// Server errors are always logged as errors. Health probes are left out to
+// keep the log readable. It must be set up after SetupRequestID and before
+// the API routes so it sees the caller's identity.
+func SetupRequestLog(app *fiber.App, level zerolog.Level) {
+	app.Use(logRequests(level))
+}
+
+func logRequests(level zerolog.Level) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if path := c.Path(); path == "/healthz" || path == "/readyz" {
+			return c.Next()
+		}
+
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			// The error handler has not written the response yet.
+			status = errorStatus(err)
+		}
+
+		lvl := level
+		if status >= fiber.StatusInternalServerError {
+			lvl = zerolog.ErrorLevel
+		}
+		event := requestLogger(c).WithLevel(lvl).
+			Str("method", c.Method()).
+			Str("route", c.Route().Path).
+			Str("path", c.Path()).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("IP", c.IP())
+		if user := currentUser(c); user != nil {
+			event = event.Str("user", user.Username)
+		}
+		if token := currentToken(c); token != nil {
+			event = event.Str("token", token.ID)
+		}
+		if err != nil {
+			event = event.Err(err)
+		}
+		event.Msg("Request handled")
+		return err
+	}
+}
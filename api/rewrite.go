@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"golang.org/x/net/html"
+)
+
+// maxRewriteBody bounds the pages rewritten for an external prefix; larger
+// ones, and ones of unknown length, are sent as they are.
+const maxRewriteBody = 8 << 20
+
+// urlAttributes are the attributes whose URLs are rewritten.
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"poster":     true,
+	"data":       true,
+}
+
+// rewritePrefix keeps the prefix a proxy in front of the hub serves it
+// under in the links, asset URLs and redirects notebooks answer with, as
+// they are unaware of it. WebSocket and other URLs to the same host in
+// inline scripts get it too.
+func rewritePrefix(cfg *config.Config, trusted *trustedHeaders) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		prefix := cfg.Proxy.ExternalPrefix
+		if forwarded := c.Get("X-Forwarded-Prefix"); forwarded != "" && trusted.trusts(c) && config.ValidPathPrefix(forwarded) {
+			prefix = forwarded
+		}
+		prefix = strings.TrimRight(prefix, "/")
+		if prefix == "" {
+			return c.Next()
+		}
+		if strings.Contains(c.Get(fiber.HeaderAccept), fiber.MIMETextHTML) {
+			// Pages have to come uncompressed to be rewritten.
+			c.Request().Header.Del(fiber.HeaderAcceptEncoding)
+		}
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		resp := c.Response()
+		host := c.Host()
+		if location, ok := prefixURL(string(resp.Header.Peek(fiber.HeaderLocation)), prefix, host); ok {
+			resp.Header.Set(fiber.HeaderLocation, location)
+		}
+		if !strings.HasPrefix(string(resp.Header.ContentType()), fiber.MIMETextHTML) ||
+			len(resp.Header.ContentEncoding()) > 0 ||
+			resp.Header.ContentLength() < 0 || resp.Header.ContentLength() > maxRewriteBody {
+			return nil
+		}
+		if rewritten, ok := rewriteHTML(resp.Body(), prefix, host); ok {
+			resp.SetBodyRaw(rewritten)
+		}
+		return nil
+	}
+}
+
+// rewriteHTML adds prefix to the URLs of page, returning false if it
+// can't be parsed.
+func rewriteHTML(page []byte, prefix, host string) ([]byte, bool) {
+	z := html.NewTokenizer(bytes.NewReader(page))
+	var out bytes.Buffer
+	out.Grow(len(page))
+	sameHost := []byte("://" + host + "/")
+	prefixed := []byte("://" + host + prefix + "/")
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return out.Bytes(), z.Err() == io.EOF
+		case html.StartTagToken, html.SelfClosingTagToken:
+			raw := bytes.Clone(z.Raw())
+			token := z.Token()
+			changed := false
+			for i, attr := range token.Attr {
+				if !urlAttributes[attr.Key] {
+					continue
+				}
+				if url, ok := prefixURL(attr.Val, prefix, host); ok {
+					token.Attr[i].Val = url
+					changed = true
+				}
+			}
+			if changed {
+				out.WriteString(token.String())
+			} else {
+				out.Write(raw)
+			}
+		case html.TextToken:
+			out.Write(bytes.ReplaceAll(z.Raw(), sameHost, prefixed))
+		default:
+			out.Write(z.Raw())
+		}
+	}
+}
+
+// prefixURL adds prefix to url if it is a path or a URL to host without
+// it.
+func prefixURL(url, prefix, host string) (string, bool) {
+	if strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//") {
+		if url == prefix || strings.HasPrefix(url, prefix+"/") {
+			return url, false
+		}
+		return prefix + url, true
+	}
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return url, false
+	}
+	switch scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return url, false
+	}
+	path, ok := strings.CutPrefix(rest, host+"/")
+	if !ok || strings.HasPrefix("/"+path, prefix+"/") {
+		return url, false
+	}
+	return scheme + "://" + host + prefix + "/" + path, true
+}
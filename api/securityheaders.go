@@ -0,0 +1,55 @@
+package api
+
+import (
+	"maps"
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// defaultSecurityHeaders are added to proxied responses when security
+// headers are enabled. X-Frame-Options allows the same origin, as marimo
+// frames some of its outputs.
+var defaultSecurityHeaders = map[string]string{
+	fiber.HeaderStrictTransportSecurity: "max-age=31536000",
+	fiber.HeaderXContentTypeOptions:     "nosniff",
+	fiber.HeaderReferrerPolicy:          "strict-origin-when-cross-origin",
+	fiber.HeaderXFrameOptions:           "SAMEORIGIN",
+}
+
+// addSecurityHeaders adds security headers to the responses of notebooks,
+// as the hub's settings, or those of the notebook, say. Headers a notebook
+// sends itself are left alone.
+func addSecurityHeaders(cfg *config.Config, domains core.DomainResolver) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		err := c.Next()
+
+		enabled := cfg.Proxy.SecurityHeaders
+		nb, found := domains.Route(c.Hostname(), c.Path())
+		if !found {
+			return err
+		}
+		var overrides map[string]string
+		if nb.Security != nil {
+			if nb.Security.Enabled != nil {
+				enabled = *nb.Security.Enabled
+			}
+			overrides = nb.Security.Headers
+		}
+		if !enabled {
+			return err
+		}
+		headers := maps.Clone(defaultSecurityHeaders)
+		for name, value := range overrides {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+		for name, value := range headers {
+			if value != "" && len(c.Response().Header.Peek(name)) == 0 {
+				c.Set(name, value)
+			}
+		}
+		return err
+	}
+}
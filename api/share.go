@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+const (
+	// shareParam is the query parameter of share links carrying the token.
+	shareParam = "hub_share"
+	// shareLinkHeader passes the ID of the share link a visitor came in
+	// through to notebooks, and lets them past forward auth and client
+	// certificate requirements. Visitors can't set it themselves.
+	shareLinkHeader = "X-Share-Link"
+)
+
+func SetupShareRoutes(app *fiber.App, cfg *config.Config, reg core.Registry, links *core.ShareLinks) {
+	api := app.Group("/api/v1")
+	api.Post("/notebooks/:id/share", postShareLink(cfg, reg, links), authorize(core.ScopeDeploy))
+}
+
+// postShareLink creates a link letting anyone who holds it visit the
+// notebook until it expires, without an account.
+func postShareLink(cfg *config.Config, reg core.Registry, links *core.ShareLinks) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		nb, exists := reg.Get(c.Params("id"))
+		if !exists || !tokenCovers(c, nb) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Notebook not found"})
+		}
+		if !nb.CanEdit(currentUser(c)) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit this notebook"})
+		}
+
+		var req core.CreateShareLinkRequest
+		if len(c.Body()) > 0 {
+			if err := json.Unmarshal(c.Body(), &req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+			}
+		}
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+		ttl := core.DefaultShareTTL
+		if req.ExpiresAt != nil {
+			ttl = time.Until(*req.ExpiresAt)
+		}
+		if ttl <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Expiry must be in the future"})
+		}
+		if ttl > cfg.Proxy.ShareMaxTTL {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Share links may last at most " + cfg.Proxy.ShareMaxTTL.String()})
+		}
+
+		link, token, err := links.Create(nb.ID, ttl, req.MaxUses)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(core.ShareLinkResponse{
+			Link:  link,
+			URL:   shareURL(cfg, nb, token),
+			Token: token,
+		})
+	}
+}
+
+// shareURL is the address of nb through the share link with token, assuming
+// the proxy is reached on the default port of its scheme.
+func shareURL(cfg *config.Config, nb core.Notebook, token string) string {
+	scheme := "http"
+	if cfg.Server.Proxy.TLSCert != "" || cfg.ACME.Enabled {
+		scheme = "https"
+	}
+	return scheme + "://" + nb.Domain + cfg.Proxy.ExternalPrefix + nb.PathPrefix + "/?" + shareParam + "=" + url.QueryEscape(token)
+}
+
+// checkShareLink lets visitors holding a share link into the notebook it is
+// for. A visitor opening the link gets a cookie with its token, which later
+// requests and WebSockets carry, and is redirected to the page without the
+// token, so that it doesn't linger in the address bar, history or referrers.
+func checkShareLink(links *core.ShareLinks, domains core.DomainResolver, pages *ErrorPages) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		c.Request().Header.Del(shareLinkHeader)
+		nb, ok := domains.Route(c.Hostname(), c.Path())
+		if !ok || nb.Disabled {
+			return c.Next()
+		}
+		cookie := shareCookie(nb)
+
+		if token := c.Query(shareParam); token != "" {
+			link, err := links.Redeem(token, nb.ID)
+			if err != nil {
+				notice := Notice{Code: fiber.StatusForbidden, Reason: ReasonShareLink, Message: "This share link is invalid."}
+				switch {
+				case errors.Is(err, core.ErrShareLinkExpired):
+					notice.Code, notice.Message = fiber.StatusGone, "This share link has expired."
+				case errors.Is(err, core.ErrShareLinkUsedUp):
+					notice.Code, notice.Message = fiber.StatusGone, "This share link has been used up."
+				}
+				return pages.Serve(c, &nb, notice)
+			}
+			c.Cookie(&fiber.Cookie{
+				Name:     cookie,
+				Value:    token,
+				Path:     "/",
+				Expires:  link.ExpiresAt,
+				Secure:   c.Scheme() == "https",
+				HTTPOnly: true,
+				SameSite: fiber.CookieSameSiteLaxMode,
+			})
+			c.Request().URI().QueryArgs().Del(shareParam)
+			c.Set(fiber.HeaderCacheControl, "no-store")
+			return c.Redirect().Status(fiber.StatusSeeOther).To(samePage(c.Path(), string(c.Request().URI().QueryArgs().QueryString())))
+		}
+
+		if token := c.Cookies(cookie); token != "" {
+			if link, err := links.Verify(token, nb.ID); err == nil {
+				c.Request().Header.Set(shareLinkHeader, link.ID)
+			}
+		}
+		return c.Next()
+	}
+}
+
+// shareCookie names the cookie of the share link to nb, apart from those of
+// other notebooks on the same domain.
+func shareCookie(nb core.Notebook) string {
+	return shareParam + "_" + nb.ID
+}
+
+// samePage is a reference to path with rawQuery relative to path itself, so
+// that it holds below a prefix a proxy in front of the hub strips.
+func samePage(path, rawQuery string) string {
+	ref := "./" + path[strings.LastIndex(path, "/")+1:]
+	if rawQuery != "" {
+		ref += "?" + rawQuery
+	}
+	return ref
+}
+
+// sharedVisit reports whether the visitor came in through a share link.
+func sharedVisit(c fiber.Ctx) bool {
+	return c.Get(shareLinkHeader) != ""
+}
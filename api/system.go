@@ -0,0 +1,30 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/doctor"
+	"github.com/rekk30/marimo-hub/pkg/version"
+)
+
+func getVersion() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.JSON(version.Get())
+	}
+}
+
+func getSystemConfig(cfg *config.Config) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.JSON(core.SystemConfigResponse{Settings: cfg.Settings(), Sources: cfg.Sources()})
+	}
+}
+
+// getSystemDoctor diagnoses the setup of the hub. Problems are reported in
+// the body, not by the status, which is only an error if the checks
+// couldn't run.
+func getSystemDoctor(cfg *config.Config, reg core.Registry, runner *core.Runner) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.JSON(doctor.Run(c.Context(), doctor.Options{Config: cfg, Registry: reg, Runner: runner}))
+	}
+}
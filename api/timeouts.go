@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/valyala/fasthttp"
+)
+
+// SetupProxyTimeouts applies the timeouts of the notebook requested in
+// place of the proxy's own, once a request's headers have been read.
+func SetupProxyTimeouts(app *fiber.App, domains core.DomainResolver) {
+	app.Server().HeaderReceived = notebookTimeouts(domains)
+}
+
+func notebookTimeouts(domains core.DomainResolver) func(*fasthttp.RequestHeader) fasthttp.RequestConfig {
+	return func(h *fasthttp.RequestHeader) fasthttp.RequestConfig {
+		host := string(h.Host())
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		path, _, _ := strings.Cut(string(h.RequestURI()), "?")
+		nb, ok := domains.Route(host, path)
+		if !ok || nb.Timeouts == nil {
+			// Zero leaves the server's timeouts in place.
+			return fasthttp.RequestConfig{}
+		}
+		return fasthttp.RequestConfig{
+			ReadTimeout:  time.Duration(nb.Timeouts.Read) * time.Second,
+			WriteTimeout: time.Duration(nb.Timeouts.Write) * time.Second,
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+func getToken(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		token, exists := reg.GetToken(c.Params("id"))
+		if !exists || !ownsToken(c, token) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Token not found"})
+		}
+		return c.JSON(core.TokenResponse{Token: token})
+	}
+}
+
+func getTokens(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var tokens []core.Token
+		for _, token := range reg.ListTokens() {
+			if ownsToken(c, token) {
+				tokens = append(tokens, token)
+			}
+		}
+		return c.JSON(core.TokensResponse{Tokens: tokens})
+	}
+}
+
+func postToken(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.CreateTokenRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		if req.WorkspaceID != "" && len(req.NotebookIDs) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "A token is limited to either a workspace or notebooks"})
+		}
+		if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Expiry must be in the future"})
+		}
+		if req.WorkspaceID != "" {
+			if _, exists := reg.GetWorkspace(req.WorkspaceID); !exists {
+				return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Workspace not found"})
+			}
+		}
+
+		// A token never grants more than its creator has.
+		user := currentUser(c)
+		for _, id := range req.NotebookIDs {
+			nb, exists := reg.Get(id)
+			if !exists {
+				return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Notebook " + id + " not found"})
+			}
+			if !nb.CanEdit(user) {
+				return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Not allowed to edit notebook " + id})
+			}
+		}
+
+		userID := ""
+		if user != nil {
+			userID = user.ID
+		}
+		token, secret, err := reg.AddToken(userID, req)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(core.TokenResponse{Token: token, Secret: secret})
+	}
+}
+
+func deleteToken(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		token, exists := reg.GetToken(id)
+		if !exists || !ownsToken(c, token) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Token not found"})
+		}
+		if err := reg.DeleteToken(id); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// ownsToken reports whether the caller may see and revoke token. Admins
+// manage every token.
+func ownsToken(c fiber.Ctx, token core.Token) bool {
+	user := currentUser(c)
+	return user == nil || user.Admin || user.ID == token.UserID
+}
+
+func filterByToken(nbs []core.Notebook, token *core.Token) []core.Notebook {
+	var filtered []core.Notebook
+	for _, nb := range nbs {
+		if token.Covers(nb) {
+			filtered = append(filtered, nb)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// trustedHeaders reads who the caller is from the headers an auth proxy in
+// front of the hub, such as oauth2-proxy or Authelia, sets after logging
+// them in. Only requests coming from the proxy's own addresses are
+// believed.
+type trustedHeaders struct {
+	proxies      []netip.Prefix
+	userHeader   string
+	groupsHeader string
+	adminGroups  []string
+}
+
+// newTrustedHeaders returns nil unless trusted proxies are configured.
+func newTrustedHeaders(cfg *config.Config) *trustedHeaders {
+	proxies, _ := config.ParseIPList(cfg.Auth.TrustedProxies)
+	if len(proxies) == 0 {
+		return nil
+	}
+	return &trustedHeaders{
+		proxies:      proxies,
+		userHeader:   cfg.Auth.UserHeader,
+		groupsHeader: cfg.Auth.GroupsHeader,
+		adminGroups:  splitList(cfg.Auth.AdminGroups),
+	}
+}
+
+// trusts reports whether the request came straight from a trusted proxy.
+// The peer address is used, as the forwarding headers are the proxy's to
+// set too.
+func (t *trustedHeaders) trusts(c fiber.Ctx) bool {
+	if t == nil {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(c.RequestCtx().RemoteIP())
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(t.proxies, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// identity returns the user and groups the trusted proxy named, if the
+// request came from it with a user.
+func (t *trustedHeaders) identity(c fiber.Ctx) (string, []string, bool) {
+	if !t.trusts(c) {
+		return "", nil, false
+	}
+	username := strings.TrimSpace(c.Get(t.userHeader))
+	if username == "" {
+		return "", nil, false
+	}
+	return username, splitList(c.Get(t.groupsHeader)), true
+}
+
+// user returns the account of the user the proxy named, creating it the
+// first time they are seen. With admin groups configured, the account is an
+// admin exactly while the user is in one of them.
+func (t *trustedHeaders) user(reg core.UserRegistry, username string, groups []string) (core.User, error) {
+	admin := slices.ContainsFunc(groups, func(g string) bool { return slices.Contains(t.adminGroups, g) })
+	user, exists := reg.GetUserByName(username)
+	if !exists {
+		req := core.CreateUpdateUserRequest{Username: username}
+		if len(t.adminGroups) > 0 {
+			req.Admin = &admin
+		}
+		user, err := reg.AddUser(req)
+		if err != nil {
+			// Another request of theirs may have created it meanwhile.
+			if existing, exists := reg.GetUserByName(username); exists {
+				return existing, nil
+			}
+			return core.User{}, err
+		}
+		logging.API.Info().Str("username", username).
+			Bool("admin", user.Admin).
+			Msg("Created user for the auth proxy")
+		return user, nil
+	}
+	if len(t.adminGroups) > 0 && user.Admin != admin {
+		return reg.UpdateUser(user.ID, core.CreateUpdateUserRequest{Admin: &admin})
+	}
+	return user, nil
+}
+
+// forward leaves the identity headers of a request to the proxy for
+// notebooks to read if they came from a trusted proxy, and drops them
+// otherwise so visitors can't pose as someone else.
+func (t *trustedHeaders) forward(c fiber.Ctx) {
+	if t == nil || t.trusts(c) {
+		return
+	}
+	c.Request().Header.Del(t.userHeader)
+	c.Request().Header.Del(t.groupsHeader)
+}
+
+// splitList splits a list separated by commas, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
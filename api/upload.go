@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// uploadsDir is where uploaded bundles are extracted, relative to the
+// notebooks directory. Each bundle gets a directory of its own.
+const uploadsDir = "uploads"
+
+// defaultEntrypoint is run when an upload doesn't name its entrypoint.
+const defaultEntrypoint = "app.py"
+
+// postNotebookUpload registers a notebook from a zip bundle of its project.
+// The multipart form carries the archive as "bundle" and the notebook as
+// JSON in "notebook", whose path names the entrypoint inside the bundle.
+func postNotebookUpload(reg core.Registry, notebooksDir string, limits core.BundleLimits) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.CreateUpdateNotebookRequest
+		if err := json.Unmarshal([]byte(c.FormValue("notebook")), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+		if req.Name == "" || req.Domain == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Missing required fields"})
+		}
+		if req.Path == "" {
+			req.Path = defaultEntrypoint
+		}
+		if err := normalizeDomain(&req); err != nil {
+			return err
+		}
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+		if err := admitNotebook(c, reg, &req); err != nil {
+			return err
+		}
+
+		header, err := c.FormFile("bundle")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Missing bundle"})
+		}
+		bundle, err := header.Open()
+		if err != nil {
+			return err
+		}
+		defer bundle.Close()
+
+		dir := filepath.Join(notebooksDir, uploadsDir, uuid.New().String())
+		if err := core.ExtractBundle(bundle, header.Size, dir, limits); err != nil {
+			return err
+		}
+
+		nb, err := registerBundle(reg, notebooksDir, dir, req)
+		if err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+
+		requestLogger(c).Info().Str("id", nb.ID).Str("dir", dir).Msg("Notebook bundle extracted")
+		return c.Status(fiber.StatusCreated).JSON(core.NotebookResponse{Notebook: nb})
+	}
+}
+
+// registerBundle registers the entrypoint of a bundle extracted to dir. A
+// bundle run from its app.py is registered as a directory-backed app.
+func registerBundle(reg core.Registry, notebooksDir, dir string, req core.CreateUpdateNotebookRequest) (core.Notebook, error) {
+	entry, _, err := core.ResolvePath(dir, req.Path)
+	if err != nil {
+		return core.Notebook{}, err
+	}
+	if info, err := os.Stat(entry); err != nil || !info.Mode().IsRegular() {
+		return core.Notebook{}, &core.InvalidRequestError{Reason: "bundle has no entrypoint " + req.Path}
+	}
+	if entry == filepath.Join(dir, defaultEntrypoint) {
+		entry = dir
+	}
+
+	if req.Path, req.RelativePath, err = core.ResolvePath(notebooksDir, entry); err != nil {
+		return core.Notebook{}, err
+	}
+	return reg.Add(req)
+}
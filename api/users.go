@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+func getMe() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		user := currentUser(c)
+		if user == nil {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Authentication is disabled"})
+		}
+		return c.JSON(core.UserResponse{User: *user})
+	}
+}
+
+func getUser(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		if !isSelfOrAdmin(c, id) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Admin privileges required"})
+		}
+		user, exists := reg.GetUser(id)
+		if !exists {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "User not found"})
+		}
+		return c.JSON(core.UserResponse{User: user})
+	}
+}
+
+func getUsers(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return c.JSON(core.UsersResponse{Users: reg.ListUsers()})
+	}
+}
+
+func postUser(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.CreateUpdateUserRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if req.Username == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Missing required fields"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		user, err := reg.AddUser(req)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(core.UserResponse{User: user})
+	}
+}
+
+func putUser(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		if !isSelfOrAdmin(c, id) {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Admin privileges required"})
+		}
+
+		var req core.CreateUpdateUserRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		// Users may change their own profile and password, but not how
+		// they are identified or what they are allowed to do.
+		if caller := currentUser(c); caller != nil && !caller.Admin && (req.Admin != nil || req.OIDCSubject != "") {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Admin privileges required"})
+		}
+
+		if _, exists := reg.GetUser(id); !exists {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "User not found"})
+		}
+		user, err := reg.UpdateUser(id, req)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(core.UserResponse{User: user})
+	}
+}
+
+func deleteUser(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		if caller := currentUser(c); caller != nil && caller.ID == id {
+			return c.Status(fiber.StatusConflict).JSON(core.ErrorResponse{Error: "Cannot delete the current user"})
+		}
+		if err := reg.DeleteUser(id); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func isSelfOrAdmin(c fiber.Ctx, id string) bool {
+	user := currentUser(c)
+	return user == nil || user.Admin || user.ID == id
+}
+
+// checkMembers verifies that the owner and collaborators named in req exist.
+func checkMembers(reg core.UserRegistry, req core.CreateUpdateNotebookRequest) error {
+	ids := req.Collaborators
+	if req.Owner != "" {
+		ids = append([]string{req.Owner}, ids...)
+	}
+	for _, id := range ids {
+		if _, exists := reg.GetUser(id); !exists {
+			return fmt.Errorf("user %s not found", id)
+		}
+	}
+	return nil
+}
+
+func filterByMember(nbs []core.Notebook, user *core.User) []core.Notebook {
+	var filtered []core.Notebook
+	for _, nb := range nbs {
+		if nb.IsMember(user) {
+			filtered = append(filtered, nb)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/adaptor"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"golang.org/x/net/webdav"
+)
+
+const davPrefix = "/dav"
+
+// WebDAVMethods are the request methods WebDAV adds to HTTP. The API server
+// has to accept them for SetupWebDAVRoutes to work.
+var WebDAVMethods = []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+// SetupWebDAVRoutes serves the notebooks directory over WebDAV under /dav,
+// so it can be mounted as a network drive. Reading needs the read scope and
+// changing files the write scope and an admin, since the hub runs whatever
+// notebook code is written there.
+func SetupWebDAVRoutes(app *fiber.App, cfg *config.Config, reg core.Registry) {
+	if !authRequired(cfg) {
+		logging.API.Warn().Msg("WebDAV is enabled without authentication")
+	}
+	handler := &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: confinedFS{root: cfg.Notebooks.Path},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logging.API.Debug().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("WebDAV request failed")
+			}
+		},
+	}
+	app.Use(davPrefix, authenticate(reg, cfg), authorizeDAV(), adaptor.HTTPHandler(handler))
+}
+
+// authorizeDAV requires the write scope of tokens, as reading the files
+// does through GET /files, and lets only admins, or their tokens, change
+// files. Tokens limited to some notebooks or a workspace get no access,
+// since the directory is shared by all of them.
+func authorizeDAV() fiber.Handler {
+	write := authorize(core.ScopeWrite)
+	return func(c fiber.Ctx) error {
+		if token := currentToken(c); token != nil && (len(token.NotebookIDs) > 0 || token.WorkspaceID != "") {
+			return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Token is limited to some notebooks"})
+		}
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, "PROPFIND":
+			// Any user may read.
+		default:
+			if user := currentUser(c); user != nil && !user.Admin {
+				return c.Status(fiber.StatusForbidden).JSON(core.ErrorResponse{Error: "Admin privileges required"})
+			}
+		}
+		return write(c)
+	}
+}
+
+// confinedFS is a webdav.Dir that refuses names leading out of root through
+// symlinks.
+type confinedFS struct {
+	root string
+}
+
+func (fs confinedFS) check(name string) error {
+	_, _, err := core.ResolvePath(fs.root, filepath.Join(fs.root, filepath.FromSlash(name)))
+	if err != nil {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+func (fs confinedFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.check(name); err != nil {
+		return err
+	}
+	return webdav.Dir(fs.root).Mkdir(ctx, name, perm)
+}
+
+func (fs confinedFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if err := fs.check(name); err != nil {
+		return nil, err
+	}
+	return webdav.Dir(fs.root).OpenFile(ctx, name, flag, perm)
+}
+
+func (fs confinedFS) RemoveAll(ctx context.Context, name string) error {
+	if err := fs.check(name); err != nil {
+		return err
+	}
+	return webdav.Dir(fs.root).RemoveAll(ctx, name)
+}
+
+func (fs confinedFS) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fs.check(oldName); err != nil {
+		return err
+	}
+	if err := fs.check(newName); err != nil {
+		return err
+	}
+	return webdav.Dir(fs.root).Rename(ctx, oldName, newName)
+}
+
+func (fs confinedFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := fs.check(name); err != nil {
+		return nil, err
+	}
+	return webdav.Dir(fs.root).Stat(ctx, name)
+}
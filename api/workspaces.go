@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+func getWorkspace(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		ws, exists := reg.GetWorkspace(c.Params("id"))
+		if !exists || !tokenCoversWorkspace(c, ws.ID) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Workspace not found"})
+		}
+		return c.JSON(core.WorkspaceResponse{Workspace: ws})
+	}
+}
+
+func getWorkspaceNotebooks(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		if _, exists := reg.GetWorkspace(id); !exists || !tokenCoversWorkspace(c, id) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Workspace not found"})
+		}
+		nbs := filterByWorkspace(reg.List(), id)
+		if token := currentToken(c); token != nil {
+			nbs = filterByToken(nbs, token)
+		}
+		return c.JSON(core.NotebooksResponse{Notebooks: nbs})
+	}
+}
+
+func getWorkspaceQuota(reg core.Registry, runner *core.Runner) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		ws, exists := reg.GetWorkspace(id)
+		if !exists || !tokenCoversWorkspace(c, id) {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Workspace not found"})
+		}
+
+		usage := runner.WorkspaceUsage(id)
+		usage.Notebooks = len(filterByWorkspace(reg.List(), id))
+		return c.JSON(core.QuotaResponse{Quota: ws.Quota, Usage: usage})
+	}
+}
+
+func getWorkspaces(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		workspaces := reg.ListWorkspaces()
+		if token := currentToken(c); token != nil && token.WorkspaceID != "" {
+			var filtered []core.Workspace
+			for _, ws := range workspaces {
+				if ws.ID == token.WorkspaceID {
+					filtered = append(filtered, ws)
+				}
+			}
+			workspaces = filtered
+		}
+		return c.JSON(core.WorkspacesResponse{Workspaces: workspaces})
+	}
+}
+
+func postWorkspace(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.CreateUpdateWorkspaceRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if req.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Missing required fields"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		ws, err := reg.AddWorkspace(req)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(core.WorkspaceResponse{Workspace: ws})
+	}
+}
+
+func putWorkspace(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var req core.CreateUpdateWorkspaceRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: "Invalid request"})
+		}
+
+		if err := validateRequest(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(core.ErrorResponse{Error: err.Error()})
+		}
+
+		ws, err := reg.UpdateWorkspace(c.Params("id"), req)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(core.WorkspaceResponse{Workspace: ws})
+	}
+}
+
+func deleteWorkspace(reg core.Registry) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := c.Params("id")
+		if _, exists := reg.GetWorkspace(id); !exists {
+			return c.Status(fiber.StatusNotFound).JSON(core.ErrorResponse{Error: "Workspace not found"})
+		}
+		if err := reg.DeleteWorkspace(id); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func filterByWorkspace(nbs []core.Notebook, workspaceID string) []core.Notebook {
+	var filtered []core.Notebook
+	for _, nb := range nbs {
+		if nb.WorkspaceID == workspaceID {
+			filtered = append(filtered, nb)
+		}
+	}
+	return filtered
+}
+
+// tokenCoversWorkspace reports whether the caller's token, if any, extends to
+// the workspace.
+func tokenCoversWorkspace(c fiber.Ctx, id string) bool {
+	token := currentToken(c)
+	return token == nil || token.WorkspaceID == "" || token.WorkspaceID == id
+}
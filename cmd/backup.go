@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/rekk30/marimo-hub/pkg/backup"
+	"github.com/rekk30/marimo-hub/pkg/hub"
+	"github.com/rs/zerolog/log"
+)
+
+// backupNow uploads a full backup of the Badger registry to the configured
+// bucket. Badger allows one process at a time, so run it with the hub
+// stopped; a running hub takes backups through the API instead.
+func backupNow(args []string) {
+	cfg := loadConfig(flag.NewFlagSet("backup", flag.ExitOnError), args)
+	if cfg.Database.Driver == "postgres" {
+		log.Fatal().Msg("Backups cover the Badger registry only; back up Postgres with its own tools")
+	}
+
+	reg := openBadger(cfg)
+	defer reg.Close()
+
+	scheduler := backup.NewScheduler(reg, hub.S3Store(cfg.Backup.S3), backup.Config{
+		FullEvery: cfg.Backup.FullEvery,
+		Retention: cfg.Backup.Retention,
+		Prefix:    cfg.Backup.S3.Prefix,
+	})
+	obj, err := scheduler.BackupNow(context.Background())
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to back up registry")
+	}
+	log.Info().Str("key", obj.Key).Int64("bytes", obj.Size).Msg("Registry backed up")
+}
+
+// restore rebuilds the registry from the latest backup chain in the configured
+// bucket. Run it with the hub stopped and DB_PATH pointing at an empty
+// directory, then start the hub normally:
+//
+//	DB_PATH=/data/restored.db marimo-hub restore
+func restore(args []string) {
+	cfg := loadConfig(flag.NewFlagSet("restore", flag.ExitOnError), args)
+
+	chain, err := backup.Restore(context.Background(), hub.S3Store(cfg.Backup.S3), cfg.Backup.S3.Prefix, cfg.Database.Path)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to restore registry")
+	}
+	log.Info().Int("files", len(chain)).Str("path", cfg.Database.Path).Msg("Registry restored")
+}
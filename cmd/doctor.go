@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rekk30/marimo-hub/pkg/doctor"
+	"github.com/rekk30/marimo-hub/pkg/hub"
+)
+
+// runDoctor diagnoses the setup of the hub and prints a line per check, or
+// the report as JSON with --json. It exits non-zero if a check failed.
+// Badger allows one process at a time, so against a running hub the
+// database check fails; ask the hub instead, at GET /api/v1/system/doctor.
+func runDoctor(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	cfg := loadConfig(flags, args)
+
+	opts := doctor.Options{Config: cfg}
+	reg, err := hub.OpenRegistry(context.Background(), cfg)
+	if err != nil {
+		opts.RegistryError = err
+	} else {
+		defer reg.(registry).Close()
+		opts.Registry = reg
+	}
+
+	report := doctor.Run(context.Background(), opts)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, c := range report.Checks {
+			fmt.Printf("%-7s %-15s %s\n", strings.ToUpper(string(c.Status)), c.Name, c.Message)
+			for _, detail := range c.Details {
+				fmt.Printf("%-23s %s\n", "", detail)
+			}
+		}
+	}
+	if report.Status == doctor.StatusFail {
+		os.Exit(1)
+	}
+}
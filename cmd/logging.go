@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// setupLogging switches the global logger, which every package logs
+// through, to format. Colors are left out when stderr isn't a terminal or
+// NO_COLOR is set.
+func setupLogging(format string) {
+	if format != "console" {
+		return
+	}
+	log.Logger = log.Output(zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: time.TimeOnly,
+		NoColor:    os.Getenv("NO_COLOR") != "" || !isTerminal(os.Stderr),
+	})
+}
+
+// setupLevels sets the level of the hub's logs, and of its modules. The
+// levels were validated with the configuration.
+func setupLevels(level, levels string) {
+	global, _ := zerolog.ParseLevel(level)
+	modules, _ := logging.ParseLevels(levels)
+	logging.Setup(global, modules)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -1,66 +1,101 @@
 package main
 
 import (
-	"context"
+	"flag"
 	"fmt"
-	"os/exec"
-	"sync"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
-	"github.com/gofiber/fiber/v3"
-	"github.com/rekk30/marimo-hub/api"
 	"github.com/rekk30/marimo-hub/pkg/config"
-	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/hub"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/rs/zerolog/pkgerrors"
 )
 
+type command struct {
+	run   func(args []string)
+	usage string
+}
+
+var commands = map[string]command{
+	"serve":    {serve, "run the hub (the default)"},
+	"doctor":   {runDoctor, "diagnose the setup and report problems"},
+	"migrate":  {migrate, "create or upgrade the database schema and exit"},
+	"export":   {export, "write the registered notebooks as a manifest"},
+	"import":   {importManifest, "register the notebooks of a manifest"},
+	"backup":   {backupNow, "upload a full backup of the registry"},
+	"restore":  {restore, "rebuild the registry from the latest backup"},
+	"validate": {validate, "check a configuration and a manifest"},
+	"version":  {printVersion, "print the version"},
+}
+
 func main() {
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatal().Stack().Err(err).Msg("Failed to load configuration")
+	// Without a command the hub is served, as it always has been.
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		serve(os.Args[1:])
+		return
 	}
-	log.Info().Interface("config", cfg).Msgf("Configuration loaded")
+	cmd, exists := commands[os.Args[1]]
+	if !exists {
+		usage()
+		os.Exit(2)
+	}
+	cmd.run(os.Args[2:])
+}
 
-	cmd := exec.Command("marimo", "edit", "--headless", "--host", "0.0.0.0", "-p", fmt.Sprintf("%d", cfg.Server.MarimoPort), "--skip-update-check", "--watch", "--allow-origins", "*", "--no-token")
-	if err := cmd.Start(); err != nil {
-		log.Fatal().Stack().Err(err).Msg("Failed to start marimo")
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [--config file] [--env-file file] [--log-format json|console] [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].usage)
 	}
-	defer cmd.Process.Kill()
-	log.Info().Msgf("Marimo started on port %d", cfg.Server.MarimoPort)
+}
 
-	apiApp := fiber.New(fiber.Config{})
-	proxyApp := fiber.New(fiber.Config{})
+// loadConfig parses the flags of a command, which all take --config,
+// --env-file and --log-format, loads the configuration and sets up logging
+// as it says.
+func loadConfig(flags *flag.FlagSet, args []string) *config.Config {
+	configFile := flags.String("config", "", "configuration file; environment variables take precedence")
+	envFile := flags.String("env-file", "", "file of environment variables to load first, defaults to $ENV_FILE")
+	logFormat := flags.String("log-format", "", "json or console, overriding LOG_FORMAT")
+	flags.Parse(args)
 
-	runner := core.NewRunner(context.Background())
-	reg, err := core.NewBadgerRegistry(cfg.Database.Path, runner.HandleRegistryEvent)
+	// The flag takes precedence over the environment, and applies right
+	// away so problems loading the configuration are logged as asked.
+	if *logFormat != "" {
+		os.Setenv("LOG_FORMAT", *logFormat)
+		setupLogging(*logFormat)
+	}
+	if err := config.LoadEnvFile(*envFile); err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to load env file")
+	}
+	cfg, err := config.LoadFile(*configFile)
 	if err != nil {
-		log.Fatal().Stack().Err(err).Msg("Failed to create registry")
+		log.Fatal().Stack().Err(err).Msg("Failed to load configuration")
 	}
+	setupLogging(cfg.Log.Format)
+	setupLevels(cfg.Log.Level, cfg.Log.Levels)
+	for _, warning := range cfg.Warnings() {
+		log.Warn().Str("problem", warning).Msg("Configuration warning; set STRICT_CONFIG to fail on it")
+	}
+	return cfg
+}
 
-	api.SetupAPIRoutes(apiApp, reg, runner)
-	api.SetupProxyRoutes(proxyApp, reg, runner)
-
-	log.Info().Msgf("Starting API server on port %d and proxy server on port %d", cfg.Server.APIPort, cfg.Server.ProxyPort)
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		if err := apiApp.Listen(fmt.Sprintf(":%d", cfg.Server.APIPort)); err != nil {
-			log.Error().Stack().Err(err).Msg("API server error")
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		if err := proxyApp.Listen(fmt.Sprintf(":%d", cfg.Server.ProxyPort)); err != nil {
-			log.Error().Stack().Err(err).Msg("Proxy server error")
-		}
-	}()
+func serve(args []string) {
+	cfg := loadConfig(flag.NewFlagSet("serve", flag.ExitOnError), args)
+	log.Info().Interface("config", cfg.Settings()).Msgf("Configuration loaded")
 
-	wg.Wait()
+	h, err := hub.New(hub.WithConfig(cfg))
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to start the hub")
+	}
+	defer h.Close()
+	if err := h.Run(); err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to serve")
+	}
 }
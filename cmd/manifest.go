@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/rekk30/marimo-hub/api"
+	"github.com/rekk30/marimo-hub/pkg/manifest"
+	"github.com/rs/zerolog/log"
+)
+
+// export writes the registered notebooks as a manifest, to stdout unless
+// --output is given.
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("output", "", "file to write the manifest to")
+	cfg := loadConfig(flags, args)
+
+	reg := openRegistry(cfg)
+	defer reg.Close()
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal().Stack().Err(err).Msg("Failed to create manifest")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	m := manifest.FromNotebooks(reg.List())
+	if err := m.Write(out); err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to write manifest")
+	}
+	log.Info().Int("notebooks", len(m.Notebooks)).Msg("Notebooks exported")
+}
+
+// importManifest registers the notebooks of a manifest. A notebook whose
+// domain and path prefix are already registered is updated instead, so
+// importing the same manifest again is harmless.
+func importManifest(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	manifestFile := flags.String("manifest", "", "manifest to import")
+	cfg := loadConfig(flags, args)
+	if *manifestFile == "" {
+		log.Fatal().Msg("--manifest is required")
+	}
+
+	m, err := manifest.Load(*manifestFile)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to load manifest")
+	}
+	// Check everything before writing anything.
+	for i := range m.Notebooks {
+		req := &m.Notebooks[i]
+		if req.Name == "" || req.Path == "" || req.Domain == "" {
+			log.Fatal().Int("index", i).Msg("Notebook is missing its name, path or domain")
+		}
+		if err := api.ResolveNotebookRequest(req, cfg.Notebooks.Path); err != nil {
+			log.Fatal().Err(err).Int("index", i).Str("name", req.Name).Msg("Invalid notebook")
+		}
+	}
+
+	reg := openRegistry(cfg)
+	defer reg.Close()
+
+	added, updated := 0, 0
+	for _, req := range m.Notebooks {
+		if current, exists := reg.GetByRoute(req.Domain, req.PathPrefix); exists {
+			_, err = reg.Update(current.ID, req)
+			updated++
+		} else {
+			_, err = reg.Add(req)
+			added++
+		}
+		if err != nil {
+			log.Fatal().Stack().Err(err).Str("name", req.Name).Msg("Failed to import notebook")
+		}
+	}
+	log.Info().Int("added", added).Int("updated", updated).Msg("Manifest imported")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/hub"
+	"github.com/rs/zerolog/log"
+)
+
+// migrate brings the configured database up to the schema of this version
+// and exits, so an upgrade can run it once before rolling out hub nodes.
+// Badger needs no schema; opening it is enough to check that it is usable.
+func migrate(args []string) {
+	cfg := loadConfig(flag.NewFlagSet("migrate", flag.ExitOnError), args)
+
+	reg := openRegistry(cfg)
+	defer reg.Close()
+	if cfg.Cluster.Enabled {
+		directory, err := core.NewPostgresDirectory(context.Background(), cfg.Database.DSN, cfg.Cluster.NodeID)
+		if err != nil {
+			log.Fatal().Stack().Err(err).Msg("Failed to create backend directory")
+		}
+		directory.Close()
+	}
+	log.Info().Str("driver", cfg.Database.Driver).Msg("Database is up to date")
+}
+
+// registry is a registry opened by a command that runs without the hub.
+type registry interface {
+	core.Registry
+	io.Closer
+}
+
+// openRegistry opens the configured registry without anything subscribed to
+// it. Badger allows one process at a time, so commands using it need the hub
+// to be stopped.
+func openRegistry(cfg *config.Config) registry {
+	reg, err := hub.OpenRegistry(context.Background(), cfg)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to open registry")
+	}
+	reg.SetDomainPolicy(hub.DomainPolicy(cfg))
+	return reg.(registry)
+}
+
+func openBadger(cfg *config.Config) *core.BadgerRegistry {
+	reg, err := core.NewBadgerRegistry(cfg.Database.Path)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to open registry")
+	}
+	return reg
+}
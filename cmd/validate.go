@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rekk30/marimo-hub/api"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/manifest"
+)
+
+// validate checks a configuration and, optionally, a manifest without
+// starting anything, printing every problem found, unknown settings
+// included. It exits non-zero if
+// there are any, so it can gate CI:
+//
+//	marimo-hub validate --config hub.yaml --manifest notebooks.yaml
+//
+// Workspaces and existing notebooks live in the registry, which isn't
+// opened, so workspace references and collisions with notebooks registered
+// through the API are not checked.
+func validate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := flags.String("config", "", "configuration file to check")
+	envFile := flags.String("env-file", "", "file of environment variables to load first, defaults to $ENV_FILE")
+	manifestFile := flags.String("manifest", "", "manifest to check")
+	flags.Parse(args)
+
+	if err := config.LoadEnvFile(*envFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	problems := cfg.Warnings()
+	if *manifestFile != "" {
+		problems = append(problems, validateManifest(cfg, *manifestFile)...)
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+func validateManifest(cfg *config.Config, path string) []string {
+	m, err := manifest.Load(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	domains := make(map[string]int)
+	for i, req := range m.Notebooks {
+		prefix := fmt.Sprintf("notebooks[%d]", i)
+		if req.Name != "" {
+			prefix += " (" + req.Name + ")"
+		}
+
+		var missing []string
+		for _, field := range []struct{ name, value string }{{"name", req.Name}, {"path", req.Path}, {"domain", req.Domain}} {
+			if field.value == "" {
+				missing = append(missing, field.name)
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: missing %s", prefix, strings.Join(missing, ", ")))
+			continue
+		}
+
+		if err := api.ResolveNotebookRequest(&req, cfg.Notebooks.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			continue
+		}
+		if _, err := os.Stat(req.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s does not exist", prefix, req.Path))
+		}
+		if first, exists := domains[req.Domain]; exists {
+			problems = append(problems, fmt.Sprintf("%s: domain %s is also used by notebooks[%d]", prefix, req.Domain, first))
+		} else {
+			domains[req.Domain] = i
+		}
+	}
+	return problems
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rekk30/marimo-hub/pkg/version"
+)
+
+func printVersion(args []string) {
+	info := version.Get()
+	fmt.Printf("marimo-hub %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("commit:  %s\n", info.Commit)
+	}
+	if info.BuildDate != "" {
+		fmt.Printf("built:   %s\n", info.BuildDate)
+	}
+	fmt.Printf("go:      %s\n", info.GoVersion)
+	if info.Marimo != "" {
+		fmt.Printf("marimo:  %s\n", info.Marimo)
+	}
+}
@@ -0,0 +1,298 @@
+// Package acme obtains and renews certificates from an ACME CA such as
+// Let's Encrypt, answering DNS-01 challenges through a DNS provider's API.
+// Unlike HTTP-01, DNS-01 works for wildcard domains and for hubs the CA
+// can't reach.
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// checkInterval is how often the certificate is checked for renewal.
+	checkInterval = 12 * time.Hour
+	// retryInterval is how long to wait after failing to obtain one.
+	retryInterval = time.Hour
+	// orderTimeout bounds obtaining one certificate, propagation included.
+	orderTimeout = 30 * time.Minute
+)
+
+// Provider sets the TXT records that answer DNS-01 challenges. Names are
+// fully qualified, without the final dot.
+type Provider interface {
+	Present(ctx context.Context, name, value string) error
+	CleanUp(ctx context.Context, name, value string) error
+}
+
+// Config describes the certificate to keep and the CA to get it from.
+type Config struct {
+	// Directory is the URL of the CA's ACME directory.
+	Directory string
+	Email     string
+	// Domains are the names of the certificate, wildcards included.
+	Domains []string
+	// Dir keeps the account key and the certificate, as a name.crt file
+	// next to its name.key.
+	Dir string
+	// RenewBefore is how long before it expires the certificate is
+	// renewed.
+	RenewBefore time.Duration
+	// Propagation is how long to wait for challenge records to reach the
+	// provider's name servers before asking the CA to check them.
+	Propagation time.Duration
+}
+
+// Manager keeps a certificate for the configured domains in its directory.
+type Manager struct {
+	cfg      Config
+	provider Provider
+}
+
+func NewManager(cfg Config, provider Provider) *Manager {
+	return &Manager{cfg: cfg, provider: provider}
+}
+
+// Run obtains the certificate when it is missing, no longer covers the
+// configured domains or is about to expire, until ctx is done. onRenew is
+// called whenever a new certificate was saved.
+func (m *Manager) Run(ctx context.Context, onRenew func()) {
+	for {
+		wait := checkInterval
+		renewed, err := m.RenewIfDue(ctx)
+		if err != nil {
+			logging.ACME.Error().Err(err).Str("method", "Manager.Run").Strs("domains", m.cfg.Domains).Msg("Failed to obtain certificate")
+			wait = retryInterval
+		} else if renewed {
+			onRenew()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RenewIfDue obtains the certificate if it is due, reporting whether it
+// did.
+func (m *Manager) RenewIfDue(ctx context.Context) (bool, error) {
+	if !m.due() {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, orderTimeout)
+	defer cancel()
+	if err := m.obtain(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// certPath is where the certificate is saved, its key sitting next to it
+// with the .key extension.
+func (m *Manager) certPath() string {
+	name := strings.ReplaceAll(m.cfg.Domains[0], "*", "_")
+	return filepath.Join(m.cfg.Dir, name+".crt")
+}
+
+func (m *Manager) due() bool {
+	data, err := os.ReadFile(m.certPath())
+	if err != nil {
+		return true
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	if time.Until(cert.NotAfter) < m.cfg.RenewBefore {
+		return true
+	}
+	for _, domain := range m.cfg.Domains {
+		if !slices.Contains(cert.DNSNames, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// obtain orders a certificate, answers the challenges of its domains one
+// at a time, and saves it. One at a time, a domain and its wildcard, which
+// share a record name, don't need two values set at once.
+func (m *Manager) obtain(ctx context.Context) error {
+	client, err := m.client(ctx)
+	if err != nil {
+		return err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.cfg.Domains...))
+	if err != nil {
+		return fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, url); err != nil {
+			return err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("order failed: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.cfg.Domains}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize order: %w", err)
+	}
+	if err := m.save(chain, key); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	logging.ACME.Info().Strs("domains", m.cfg.Domains).Msg("Obtained certificate")
+	return nil
+}
+
+// authorize answers the DNS-01 challenge of an authorization, unless the
+// CA still remembers an earlier one.
+func (m *Manager) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	i := slices.IndexFunc(authz.Challenges, func(c *acme.Challenge) bool { return c.Type == "dns-01" })
+	if i < 0 {
+		return fmt.Errorf("CA offers no dns-01 challenge for %s", authz.Identifier.Value)
+	}
+	challenge := authz.Challenges[i]
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+
+	// Wildcard authorizations are for the domain they cover.
+	name := "_acme-challenge." + authz.Identifier.Value
+	if err := m.provider.Present(ctx, name, value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", name, err)
+	}
+	defer func() {
+		// Cleaning up has to happen even when the order timed out.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if err := m.provider.CleanUp(ctx, name, value); err != nil {
+			logging.ACME.Warn().Err(err).Str("method", "Manager.authorize").Str("name", name).Msg("Failed to remove challenge record")
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(m.cfg.Propagation):
+	}
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed to accept challenge: %w", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization of %s failed: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// client returns an ACME client registered with the account key in the
+// directory, creating the key the first time.
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account key: %w", err)
+	}
+	client := &acme.Client{Key: key, DirectoryURL: m.cfg.Directory}
+	account := &acme.Account{}
+	if m.cfg.Email != "" {
+		account.Contact = []string{"mailto:" + m.cfg.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register account: %w", err)
+	}
+	return client, nil
+}
+
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.cfg.Dir, "account.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s holds no PEM key", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(m.cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	return key, writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+// save writes the key before the certificate, so the pair matches by the
+// time the certificate's change is seen.
+func (m *Manager) save(chain [][]byte, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	var certPEM []byte
+	for _, cert := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+	certPath := m.certPath()
+	keyPath := strings.TrimSuffix(certPath, ".crt") + ".key"
+	if err := writeFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return err
+	}
+	return writeFile(certPath, certPEM)
+}
+
+// writeFile replaces path atomically with a file only its owner can read.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,102 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Cloudflare sets challenge records through the Cloudflare API, with a
+// token allowed to edit the DNS of the zone.
+type Cloudflare struct {
+	token  string
+	zoneID string
+	client *http.Client
+}
+
+func NewCloudflare(token, zoneID string) *Cloudflare {
+	return &Cloudflare{token: token, zoneID: zoneID, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (c *Cloudflare) Present(ctx context.Context, name, value string) error {
+	record := cloudflareRecord{Type: "TXT", Name: name, Content: value, TTL: 60}
+	return c.do(ctx, http.MethodPost, "/dns_records", record, nil)
+}
+
+func (c *Cloudflare) CleanUp(ctx context.Context, name, value string) error {
+	query := url.Values{"type": {"TXT"}, "name": {name}}
+	var records []cloudflareRecord
+	if err := c.do(ctx, http.MethodGet, "/dns_records?"+query.Encode(), nil, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		// Cloudflare may keep TXT contents quoted.
+		if strings.Trim(record.Content, `"`) != value {
+			continue
+		}
+		if err := c.do(ctx, http.MethodDelete, "/dns_records/"+record.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do calls path below the zone, decoding the result into result if set.
+func (c *Cloudflare) do(ctx context.Context, method, path string, body, result any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareAPI+"/zones/"+url.PathEscape(c.zoneID)+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var decoded cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return fmt.Errorf("cloudflare request failed with status %d", resp.StatusCode)
+	}
+	if !decoded.Success {
+		var messages []string
+		for _, e := range decoded.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("cloudflare request failed with status %d: %s", resp.StatusCode, strings.Join(messages, "; "))
+	}
+	if result != nil {
+		return json.Unmarshal(decoded.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package acme
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	opcodeUpdate = 5
+	typeTSIG     = 250
+	classNone    = 254
+	classAny     = 255
+	// tsigFudge is how far apart the clocks of the hub and the name server
+	// may be, in seconds.
+	tsigFudge = 300
+)
+
+// tsigAlgorithms maps the TSIG algorithms supported to their hashes.
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// RFC2136 sets challenge records with DNS UPDATE messages signed with a
+// TSIG key, as BIND, Knot and PowerDNS accept.
+type RFC2136 struct {
+	// server is the host:port of the primary name server of zone.
+	server    string
+	zone      string
+	keyName   string
+	secret    []byte
+	algorithm string
+}
+
+// NewRFC2136 checks the TSIG secret, which is base64, and the algorithm:
+// hmac-sha1, hmac-sha256 or hmac-sha512.
+func NewRFC2136(server, zone, keyName, secret, algorithm string) (*RFC2136, error) {
+	if _, ok := tsigAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q", algorithm)
+	}
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("TSIG secret is not base64: %w", err)
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &RFC2136{server: server, zone: zone, keyName: keyName, secret: key, algorithm: algorithm}, nil
+}
+
+func (r *RFC2136) Present(ctx context.Context, name, value string) error {
+	return r.update(ctx, name, value, dnsmessage.ClassINET, 60)
+}
+
+// CleanUp deletes the one record, leaving other challenges of the name.
+func (r *RFC2136) CleanUp(ctx context.Context, name, value string) error {
+	return r.update(ctx, name, value, classNone, 0)
+}
+
+// update sends an update of the TXT record name with value in class, which
+// adds it for IN and deletes it for NONE.
+func (r *RFC2136) update(ctx context.Context, name, value string, class dnsmessage.Class, ttl uint32) error {
+	msg, err := r.message(name, value, class, ttl)
+	if err != nil {
+		return err
+	}
+	if r.keyName != "" {
+		msg = r.sign(msg, time.Now())
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", r.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Over TCP, messages are prefixed with their length.
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(msg)))); err != nil {
+		return err
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(resp)
+	if err != nil {
+		return fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("DNS update refused: %s", rcodeName(header.RCode))
+	}
+	return nil
+}
+
+// message builds the update: the zone in the question section and the
+// change in the authority section.
+func (r *RFC2136) message(name, value string, class dnsmessage.Class, ttl uint32) ([]byte, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	zone, err := dnsmessage.NewName(fqdn(r.zone))
+	if err != nil {
+		return nil, err
+	}
+	rrName, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), OpCode: opcodeUpdate})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	hdr := dnsmessage.ResourceHeader{Name: rrName, Class: class, TTL: ttl}
+	if err := b.TXTResource(hdr, dnsmessage.TXTResource{TXT: []string{value}}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// sign appends a TSIG record to msg, as RFC 8945 describes.
+func (r *RFC2136) sign(msg []byte, now time.Time) []byte {
+	keyName := wireName(r.keyName)
+	algorithm := wireName(r.algorithm)
+	var timeSigned [6]byte
+	binary.BigEndian.PutUint16(timeSigned[:2], uint16(now.Unix()>>32))
+	binary.BigEndian.PutUint32(timeSigned[2:], uint32(now.Unix()))
+
+	mac := hmac.New(tsigAlgorithms[r.algorithm], r.secret)
+	mac.Write(msg)
+	mac.Write(keyName)
+	mac.Write(binary.BigEndian.AppendUint16(nil, classAny))
+	mac.Write(binary.BigEndian.AppendUint32(nil, 0)) // TTL
+	mac.Write(algorithm)
+	mac.Write(timeSigned[:])
+	mac.Write(binary.BigEndian.AppendUint16(nil, tsigFudge))
+	mac.Write(binary.BigEndian.AppendUint16(nil, 0)) // error
+	mac.Write(binary.BigEndian.AppendUint16(nil, 0)) // other length
+	sum := mac.Sum(nil)
+
+	var rdata []byte
+	rdata = append(rdata, algorithm...)
+	rdata = append(rdata, timeSigned[:]...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[:2]...)               // original ID
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // error
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // other length
+
+	signed := append([]byte{}, msg...)
+	signed = append(signed, keyName...)
+	signed = binary.BigEndian.AppendUint16(signed, typeTSIG)
+	signed = binary.BigEndian.AppendUint16(signed, classAny)
+	signed = binary.BigEndian.AppendUint32(signed, 0)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+	// The record counts among the additional ones.
+	binary.BigEndian.PutUint16(signed[10:12], binary.BigEndian.Uint16(signed[10:12])+1)
+	return signed
+}
+
+// wireName encodes name uncompressed and lowercased, as TSIG wants.
+func wireName(name string) []byte {
+	var wire []byte
+	for _, label := range strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".") {
+		if label == "" {
+			continue
+		}
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	return append(wire, 0)
+}
+
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// rcodeName names the response codes an update is refused with.
+func rcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case 5:
+		return "REFUSED"
+	case 8:
+		return "NXRRSET"
+	case 9:
+		return "NOTAUTH"
+	case 10:
+		return "NOTZONE"
+	}
+	return rcode.String()
+}
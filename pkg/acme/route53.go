@@ -0,0 +1,127 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	route53API = "https://route53.amazonaws.com/2013-04-01"
+	// route53Region is the region Route 53 requests are signed for, as the
+	// service is global.
+	route53Region = "us-east-1"
+)
+
+// Route53 sets challenge records in an AWS Route 53 hosted zone, with keys
+// allowed route53:ChangeResourceRecordSets on it.
+type Route53 struct {
+	accessKey string
+	secretKey string
+	zoneID    string
+	client    *http.Client
+}
+
+func NewRoute53(accessKey, secretKey, zoneID string) *Route53 {
+	return &Route53{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		zoneID:    strings.TrimPrefix(zoneID, "/hostedzone/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type route53Change struct {
+	XMLName xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Action  string   `xml:"ChangeBatch>Changes>Change>Action"`
+	Name    string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Name"`
+	Type    string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Type"`
+	TTL     int      `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>TTL"`
+	Value   string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+// Present replaces the record set, which holds a single challenge as the
+// manager answers them one at a time.
+func (r *Route53) Present(ctx context.Context, name, value string) error {
+	return r.change(ctx, "UPSERT", name, value)
+}
+
+func (r *Route53) CleanUp(ctx context.Context, name, value string) error {
+	return r.change(ctx, "DELETE", name, value)
+}
+
+func (r *Route53) change(ctx context.Context, action, name, value string) error {
+	body, err := xml.Marshal(route53Change{
+		Action: action,
+		Name:   name + ".",
+		Type:   "TXT",
+		TTL:    60,
+		Value:  `"` + value + `"`,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, route53API+"/hostedzone/"+r.zoneID+"/rrset/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	r.sign(req, body, time.Now().UTC())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("route53 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign applies AWS Signature Version 4 to req.
+func (r *Route53) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "host;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" + "x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + route53Region + "/route53/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+r.secretKey), date)
+	key = hmacSHA256(key, route53Region)
+	key = hmacSHA256(key, "route53")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		r.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
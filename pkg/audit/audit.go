@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// Event is one audited API request.
+type Event struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	TokenID   string    `json:"token_id,omitempty"`
+	IP        string    `json:"ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Duration  int64     `json:"duration_ms"`
+}
+
+// Sink receives audit events. Its methods are only called from the Logger's
+// goroutine, so sinks need no locking of their own.
+type Sink interface {
+	Write(e Event) error
+	// Flush is called periodically so buffering sinks can ship events
+	// during quiet periods.
+	Flush() error
+	Close() error
+}
+
+const flushInterval = 10 * time.Second
+
+// Logger fans events out to its sinks without blocking the request path.
+// Events are dropped, with a warning, if the sinks fall behind.
+type Logger struct {
+	sinks  []Sink
+	events chan Event
+	done   chan struct{}
+}
+
+func NewLogger(sinks ...Sink) *Logger {
+	l := &Logger{
+		sinks:  sinks,
+		events: make(chan Event, 1024),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *Logger) Record(e Event) {
+	select {
+	case l.events <- e:
+	default:
+		logging.Audit.Warn().Str("method", "Logger.Record").Str("path", e.Path).Msg("Audit queue full, dropping event")
+	}
+}
+
+// Close flushes pending events and closes every sink.
+func (l *Logger) Close() {
+	close(l.events)
+	<-l.done
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-l.events:
+			if !ok {
+				l.close()
+				return
+			}
+			for _, sink := range l.sinks {
+				if err := sink.Write(e); err != nil {
+					logging.Audit.Error().Err(err).Str("method", "Logger.run").Msg("Failed to write audit event")
+				}
+			}
+		case <-ticker.C:
+			for _, sink := range l.sinks {
+				if err := sink.Flush(); err != nil {
+					logging.Audit.Error().Err(err).Str("method", "Logger.run").Msg("Failed to flush audit sink")
+				}
+			}
+		}
+	}
+}
+
+func (l *Logger) close() {
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			logging.Audit.Error().Err(err).Str("method", "Logger.run").Msg("Failed to close audit sink")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// FileSink appends events as JSON lines to a file, rotating it once it grows
+// past MaxSize. Rotated files are named <path>.<timestamp> and removed once
+// there are more than MaxFiles of them or they are older than MaxAge.
+type FileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	maxAge   time.Duration
+
+	file *os.File
+	size int64
+}
+
+func NewFileSink(path string, maxSize int64, maxFiles int, maxAge time.Duration) (*FileSink, error) {
+	s := &FileSink{path: path, maxSize: maxSize, maxFiles: maxFiles, maxAge: maxAge}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Write(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if s.maxSize > 0 && s.size+int64(len(line)) > s.maxSize && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileSink) Flush() error {
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	rotated := s.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(s.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.prune()
+	return nil
+}
+
+// prune applies retention to the rotated files.
+func (s *FileSink) prune() {
+	matches, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return
+	}
+	// Timestamps sort lexically, oldest first.
+	sort.Strings(matches)
+
+	for i, path := range matches {
+		expired := s.maxFiles > 0 && len(matches)-i > s.maxFiles
+		if !expired && s.maxAge > 0 {
+			ts := strings.TrimPrefix(path, s.path+".")
+			if rotatedAt, err := time.Parse("20060102T150405.000000000", ts); err == nil {
+				expired = time.Since(rotatedAt) > s.maxAge
+			}
+		}
+		if !expired {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logging.Audit.Warn().Err(err).Str("path", path).Msg("Failed to remove expired audit log")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/backup"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// ObjectSink batches events into JSONL objects in an object store. A batch
+// is uploaded once it reaches maxSize bytes or is older than interval.
+// Objects older than retention are deleted after each upload.
+type ObjectSink struct {
+	store     backup.ObjectStore
+	prefix    string
+	interval  time.Duration
+	maxSize   int
+	retention time.Duration
+
+	buf     bytes.Buffer
+	started time.Time
+}
+
+func NewObjectSink(store backup.ObjectStore, prefix string, interval time.Duration, maxSize int, retention time.Duration) *ObjectSink {
+	return &ObjectSink{store: store, prefix: prefix, interval: interval, maxSize: maxSize, retention: retention}
+}
+
+func (s *ObjectSink) Write(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if s.buf.Len() == 0 {
+		s.started = time.Now()
+	}
+	s.buf.Write(line)
+	s.buf.WriteByte('\n')
+
+	if s.maxSize > 0 && s.buf.Len() >= s.maxSize {
+		return s.upload()
+	}
+	return nil
+}
+
+func (s *ObjectSink) Flush() error {
+	if s.buf.Len() == 0 || time.Since(s.started) < s.interval {
+		return nil
+	}
+	return s.upload()
+}
+
+func (s *ObjectSink) Close() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	return s.upload()
+}
+
+func (s *ObjectSink) upload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	now := time.Now().UTC()
+	key := path.Join(s.prefix, now.Format("2006/01/02"), fmt.Sprintf("%020d.jsonl", now.UnixNano()))
+	if err := s.store.Put(ctx, key, s.buf.Bytes()); err != nil {
+		// Keep the batch and retry on the next flush.
+		return fmt.Errorf("failed to upload audit batch: %w", err)
+	}
+	logging.Audit.Debug().Str("key", key).Int("bytes", s.buf.Len()).Msg("Audit batch uploaded")
+	s.buf.Reset()
+
+	if s.retention > 0 {
+		if err := s.prune(ctx, now.Add(-s.retention)); err != nil {
+			logging.Audit.Warn().Err(err).Str("method", "ObjectSink.upload").Msg("Failed to apply audit retention")
+		}
+	}
+	return nil
+}
+
+func (s *ObjectSink) prune(ctx context.Context, cutoff time.Time) error {
+	objects, err := s.store.List(ctx, strings.TrimSuffix(s.prefix, "/")+"/")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if obj.LastModified.IsZero() || !obj.LastModified.Before(cutoff) {
+			continue
+		}
+		if err := s.store.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
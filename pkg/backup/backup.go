@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+const (
+	kindFull        = "full"
+	kindIncremental = "incr"
+)
+
+// Object is a stored backup file.
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ObjectStore is where backup files are kept.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// Source produces Badger backups; since is the version of the previous
+// backup, or zero for a full one.
+type Source interface {
+	Backup(w io.Writer, since uint64) (uint64, error)
+}
+
+type Config struct {
+	Interval time.Duration
+	// FullEvery starts a new chain with a full backup after this many
+	// incremental ones.
+	FullEvery int
+	// Retention is the number of full backup chains to keep.
+	Retention int
+	Prefix    string
+}
+
+// Scheduler periodically streams incremental backups of a Source into an
+// ObjectStore. Every chain starts with a full backup, and the hub always
+// starts a new chain after a restart.
+type Scheduler struct {
+	src   Source
+	store ObjectStore
+	cfg   Config
+
+	mu       sync.Mutex
+	since    uint64
+	sinceRun int
+	lastErr  error
+	lastRun  time.Time
+}
+
+func NewScheduler(src Source, store ObjectStore, cfg Config) *Scheduler {
+	return &Scheduler{src: src, store: store, cfg: cfg}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.BackupNow(ctx); err != nil {
+			logging.Backup.Error().Err(err).Str("method", "Scheduler.Run").Msg("Backup failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// BackupNow takes one backup and applies retention.
+func (s *Scheduler) BackupNow(ctx context.Context) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, err := s.backup(ctx)
+	s.lastRun = time.Now()
+	s.lastErr = err
+	if err != nil {
+		return Object{}, err
+	}
+
+	if err := s.prune(ctx); err != nil {
+		logging.Backup.Warn().Err(err).Str("method", "Scheduler.BackupNow").Msg("Failed to apply backup retention")
+	}
+	return obj, nil
+}
+
+func (s *Scheduler) List(ctx context.Context) ([]Object, error) {
+	return s.store.List(ctx, listPrefix(s.cfg.Prefix))
+}
+
+// LastRun reports when the last backup was attempted and how it ended.
+func (s *Scheduler) LastRun() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRun, s.lastErr
+}
+
+func (s *Scheduler) backup(ctx context.Context) (Object, error) {
+	since := s.since
+	kind := kindIncremental
+	if since == 0 || s.sinceRun >= s.cfg.FullEvery {
+		since = 0
+		kind = kindFull
+	}
+
+	var buf bytes.Buffer
+	version, err := s.src.Backup(&buf, since)
+	if err != nil {
+		return Object{}, fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	key := path.Join(s.cfg.Prefix, fmt.Sprintf("%020d-%s-%d.badger", time.Now().UnixNano(), kind, version))
+	if err := s.store.Put(ctx, key, buf.Bytes()); err != nil {
+		return Object{}, fmt.Errorf("failed to upload backup: %w", err)
+	}
+
+	if kind == kindFull {
+		s.sinceRun = 0
+	} else {
+		s.sinceRun++
+	}
+	s.since = version
+
+	logging.Backup.Info().Str("key", key).Str("kind", kind).Int("bytes", buf.Len()).Msg("Backup uploaded")
+	return Object{Key: key, Size: int64(buf.Len()), LastModified: time.Now()}, nil
+}
+
+// prune deletes every object older than the oldest full backup still kept.
+func (s *Scheduler) prune(ctx context.Context) error {
+	objects, err := s.store.List(ctx, listPrefix(s.cfg.Prefix))
+	if err != nil {
+		return err
+	}
+
+	var fulls []int
+	for i, obj := range objects {
+		if isFull(obj.Key) {
+			fulls = append(fulls, i)
+		}
+	}
+	if len(fulls) <= s.cfg.Retention {
+		return nil
+	}
+
+	cutoff := fulls[len(fulls)-s.cfg.Retention]
+	for _, obj := range objects[:cutoff] {
+		if err := s.store.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+		logging.Backup.Debug().Str("key", obj.Key).Msg("Deleted expired backup")
+	}
+	return nil
+}
+
+// Restore loads the most recent backup chain from store into the Badger
+// database at dbPath. The hub must not be running against dbPath.
+func Restore(ctx context.Context, store ObjectStore, prefix, dbPath string) ([]Object, error) {
+	objects, err := store.List(ctx, listPrefix(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	start := -1
+	for i, obj := range objects {
+		if isFull(obj.Key) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("no full backup found under %q", prefix)
+	}
+	chain := objects[start:]
+
+	db, err := badger.Open(badger.DefaultOptions(dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger db: %w", err)
+	}
+	defer db.Close()
+
+	for _, obj := range chain {
+		body, err := store.Get(ctx, obj.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", obj.Key, err)
+		}
+		err = db.Load(body, 256)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", obj.Key, err)
+		}
+		logging.Backup.Info().Str("key", obj.Key).Msg("Backup restored")
+	}
+	return chain, nil
+}
+
+func listPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+func isFull(key string) bool {
+	return strings.Contains(path.Base(key), "-"+kindFull+"-")
+}
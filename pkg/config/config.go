@@ -2,53 +2,690 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Config struct {
 	Server struct {
-		APIPort    int `mapstructure:"api_port"`
-		MarimoPort int `mapstructure:"marimo_port"`
-		ProxyPort  int `mapstructure:"proxy_port"`
+		APIHost    string `mapstructure:"api_host"`
+		APIPort    int    `mapstructure:"api_port"`
+		MarimoHost string `mapstructure:"marimo_host"`
+		MarimoPort int    `mapstructure:"marimo_port"`
+		ProxyHost  string `mapstructure:"proxy_host"`
+		ProxyPort  int    `mapstructure:"proxy_port"`
+		// ProxyMaxBodyMB caps request bodies forwarded to notebooks, which
+		// are streamed rather than buffered.
+		ProxyMaxBodyMB int `mapstructure:"proxy_max_body_mb"`
+		// ReadOnly starts the hub rejecting the API calls that change
+		// state. Admins switch it at runtime too.
+		ReadOnly bool `mapstructure:"read_only"`
+		// DrainTimeout is how long a hub that handed over to an upgraded
+		// process keeps serving the sessions it has open.
+		DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+		API          HTTPConfig    `mapstructure:"api"`
+		Proxy        HTTPConfig    `mapstructure:"proxy"`
 	} `mapstructure:"server"`
 	Notebooks struct {
-		Path      string `mapstructure:"path"`
+		Path string `mapstructure:"path"`
+		Host string `mapstructure:"host"`
+		// ReconcileInterval is how often running processes are checked
+		// against the desired state in the registry.
+		ReconcileInterval time.Duration `mapstructure:"reconcile_interval"`
+		// StartTimeout is how long a notebook process may take to accept
+		// connections before it is killed. Notebooks may set their own.
+		StartTimeout time.Duration `mapstructure:"start_timeout"`
+		// StartParallelism bounds how many notebooks start at once, and
+		// StartJitter spreads their starts by a random delay up to it.
+		StartParallelism int           `mapstructure:"start_parallelism"`
+		StartJitter      time.Duration `mapstructure:"start_jitter"`
+		// StateDir keeps pid files used to clean up orphaned processes.
+		StateDir string `mapstructure:"state_dir"`
+		// SecretsDir holds a file per secret, which notebook environments
+		// refer to as ${secret:name}. Every notebook may use every secret.
+		SecretsDir string `mapstructure:"secrets_dir"`
+		// IdleTimeout stops notebooks nobody has had a request or session
+		// open to for that long, until the next request wakes them. Pinned
+		// notebooks and dependencies keep running. Zero disables it.
+		IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+		// Subreaper makes the hub adopt the processes orphaned by its
+		// notebooks and reap them once they exit, as it always does when
+		// it runs as PID 1. Linux only.
+		Subreaper bool `mapstructure:"subreaper"`
+		// AssetsMaxAge is how long browsers may cache the static assets of
+		// a notebook.
+		AssetsMaxAge time.Duration `mapstructure:"assets_max_age"`
+		// Logs captures backend output to rotated files per notebook; an
+		// empty dir disables capture.
+		Logs struct {
+			Dir       string        `mapstructure:"dir"`
+			MaxSizeMB int           `mapstructure:"max_size_mb"`
+			MaxAge    time.Duration `mapstructure:"max_age"`
+			MaxFiles  int           `mapstructure:"max_files"`
+			Retention time.Duration `mapstructure:"retention"`
+			// TotalSizeMB caps the logs of all notebooks together.
+			TotalSizeMB int `mapstructure:"total_size_mb"`
+			// Mirror also writes every line of backend output to the hub's
+			// log, tagged with the notebook and the stream, whether or not
+			// it is captured to files.
+			Mirror bool `mapstructure:"mirror"`
+		} `mapstructure:"logs"`
+		// Upload limits project bundles uploaded through the API.
+		Upload struct {
+			MaxSizeMB int `mapstructure:"max_size_mb"`
+			// MaxDiskMB caps the size of a bundle once extracted.
+			MaxDiskMB int `mapstructure:"max_disk_mb"`
+			MaxFiles  int `mapstructure:"max_files"`
+		} `mapstructure:"upload"`
 		PortRange struct {
 			Start int `mapstructure:"start"`
 			End   int `mapstructure:"end"`
 		} `mapstructure:"port_range"`
 	} `mapstructure:"notebooks"`
 	Database struct {
-		Path string `mapstructure:"path"`
+		// Driver selects the registry backend: "badger" or "postgres".
+		Driver string `mapstructure:"driver"`
+		Path   string `mapstructure:"path"`
+		DSN    string `mapstructure:"dsn"`
+		// GCInterval is how often the Badger value log is garbage collected.
+		GCInterval time.Duration `mapstructure:"gc_interval"`
+		// ChangeRetention is how long the Badger registry keeps the changes
+		// served at /api/v1/changes. Zero keeps them for good.
+		ChangeRetention time.Duration `mapstructure:"change_retention"`
 	} `mapstructure:"database"`
+	Cluster struct {
+		Enabled bool   `mapstructure:"enabled"`
+		NodeID  string `mapstructure:"node_id"`
+		// AdvertiseAddress is the host:port other nodes use to reach this
+		// node. Its host is also published for the notebook backends it runs.
+		AdvertiseAddress string        `mapstructure:"advertise_address"`
+		LeaseTTL         time.Duration `mapstructure:"lease_ttl"`
+		PollInterval     time.Duration `mapstructure:"poll_interval"`
+	} `mapstructure:"cluster"`
+	Backup struct {
+		Enabled   bool          `mapstructure:"enabled"`
+		Interval  time.Duration `mapstructure:"interval"`
+		FullEvery int           `mapstructure:"full_every"`
+		Retention int           `mapstructure:"retention"`
+		S3        S3Config      `mapstructure:"s3"`
+	} `mapstructure:"backup"`
+	Audit struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Retention is how long rotated files and uploaded batches are kept.
+		Retention time.Duration `mapstructure:"retention"`
+		File      struct {
+			// Path of the JSONL audit log; empty disables the file sink.
+			Path      string `mapstructure:"path"`
+			MaxSizeMB int    `mapstructure:"max_size_mb"`
+			MaxFiles  int    `mapstructure:"max_files"`
+		} `mapstructure:"file"`
+		// S3 ships the audit log in batches; an empty bucket disables it.
+		S3            S3Config      `mapstructure:"s3"`
+		FlushInterval time.Duration `mapstructure:"flush_interval"`
+	} `mapstructure:"audit"`
+	Auth struct {
+		Enabled bool `mapstructure:"enabled"`
+		// AdminUsername and AdminPassword bootstrap an admin account on
+		// startup if no user with that name exists yet.
+		AdminUsername string `mapstructure:"admin_username"`
+		AdminPassword string `mapstructure:"admin_password" json:"-"`
+		// BasicUsername and BasicPasswordHash, a bcrypt hash, are a single
+		// account the API takes HTTP Basic credentials for, with or without
+		// user accounts. It is allowed everything, and is all small setups
+		// need to keep the API from being open.
+		BasicUsername     string `mapstructure:"basic_username"`
+		BasicPasswordHash string `mapstructure:"basic_password_hash" json:"-"`
+		// TrustedProxies lists the addresses and CIDR ranges, separated by
+		// commas, of an auth proxy in front of the hub whose UserHeader and
+		// GroupsHeader say who the caller is. With auth enabled, the API
+		// logs such callers in, creating their account the first time,
+		// and the proxy passes the headers on to notebooks only from it.
+		TrustedProxies string `mapstructure:"trusted_proxies"`
+		UserHeader     string `mapstructure:"user_header"`
+		GroupsHeader   string `mapstructure:"groups_header"`
+		// AdminGroups, separated by commas, makes the users the proxy puts
+		// in one of them admins and everyone else not.
+		AdminGroups string `mapstructure:"admin_groups"`
+	} `mapstructure:"auth"`
+	Log struct {
+		// RequestLevel is the zerolog level API requests are logged at;
+		// "disabled" turns request logging off.
+		RequestLevel string `mapstructure:"request_level"`
+		// Format is how the hub logs: "json", one object per line for log
+		// collectors, or "console", colored and abbreviated for people
+		// reading a terminal.
+		Format string `mapstructure:"format"`
+		// Level is the zerolog level the hub logs at, and Levels overrides
+		// it for modules as module=level pairs separated by commas, such
+		// as "registry=info,runner=debug".
+		Level  string `mapstructure:"level"`
+		Levels string `mapstructure:"levels"`
+		// Access logs the requests the proxy handles, and AccessSample is
+		// the fraction of them logged, from 0 to 1. Server errors are
+		// logged whatever the sample. Notebooks may override both.
+		Access       bool    `mapstructure:"access"`
+		AccessSample float64 `mapstructure:"access_sample"`
+	} `mapstructure:"log"`
+	Debug struct {
+		// Enabled serves pprof and expvar on the API server.
+		Enabled bool `mapstructure:"enabled"`
+	} `mapstructure:"debug"`
+	Metrics struct {
+		// Push sends the metrics served on /metrics to a collector every
+		// PushInterval, for hubs that can't be scraped: statsd, otlp, or
+		// empty for none.
+		Push         string        `mapstructure:"push"`
+		PushInterval time.Duration `mapstructure:"push_interval"`
+		// PushAddress is the host:port of a statsd server, or the URL of
+		// an OTLP/HTTP metrics endpoint such as
+		// http://collector:4318/v1/metrics.
+		PushAddress string `mapstructure:"push_address"`
+		// PushHeaders are added to OTLP requests, as "Name=value" pairs
+		// separated by commas, such as credentials the collector wants.
+		PushHeaders string `mapstructure:"push_headers" json:"-"`
+	} `mapstructure:"metrics"`
+	Hooks struct {
+		// File is a JSON file defining inbound webhooks; empty disables
+		// them.
+		File string `mapstructure:"file"`
+		// EventsURL receives every registry change as a JSON POST, signed
+		// with EventsSecret if set; empty disables it.
+		EventsURL    string `mapstructure:"events_url"`
+		EventsSecret string `mapstructure:"events_secret" json:"-"`
+	} `mapstructure:"hooks"`
+	Landing struct {
+		// Enabled makes the proxy answer hosts without a notebook with an
+		// HTML index of public notebooks instead of a bare 404.
+		Enabled bool `mapstructure:"enabled"`
+		// Domain is the hub's own base domain, where the index is the page
+		// visitors are meant to see.
+		Domain string `mapstructure:"domain"`
+		Title  string `mapstructure:"title"`
+	} `mapstructure:"landing"`
+	Maintenance struct {
+		// Enabled starts the proxy answering every domain with the
+		// maintenance page. Admins switch it at runtime too.
+		Enabled bool `mapstructure:"enabled"`
+		// Message is shown on the built-in page, and Page is an HTML file
+		// served instead of it.
+		Message string `mapstructure:"message"`
+		Page    string `mapstructure:"page"`
+		// AllowIPs lists the addresses and CIDR ranges, separated by
+		// commas, of operators who still reach the notebooks.
+		AllowIPs string `mapstructure:"allow_ips"`
+	} `mapstructure:"maintenance"`
+	Proxy struct {
+		// BaseDomain is the zone the hub serves notebooks under. Notebooks
+		// must then use a domain in it, and may give just a subdomain label.
+		BaseDomain string `mapstructure:"base_domain"`
+		// DNSCheck resolves the domain of a notebook being registered and
+		// warns about, or fails, domains that don't point at
+		// PublicAddress: one of off, warn or fail.
+		DNSCheck      string `mapstructure:"dns_check"`
+		PublicAddress string `mapstructure:"public_address"`
+		// SecurityHeaders adds HSTS, X-Content-Type-Options,
+		// Referrer-Policy and X-Frame-Options to proxied responses that
+		// lack them. Notebooks may override it and the headers.
+		SecurityHeaders bool `mapstructure:"security_headers"`
+		// CSP is the Content-Security-Policy of notebook pages, replacing
+		// their own: a template given the .Domain requested and the
+		// notebook's .FrameAncestors, 'self' unless it sets some. Empty
+		// leaves pages alone unless the notebook sets a policy.
+		CSP string `mapstructure:"csp"`
+		// ExternalPrefix is the path a proxy in front of the hub serves it
+		// under, stripping it from requests. Links and redirects in
+		// notebook pages are rewritten to keep it. An X-Forwarded-Prefix
+		// from a trusted proxy takes precedence.
+		ExternalPrefix string `mapstructure:"external_prefix"`
+		// ErrorPage is an HTML template the proxy renders when it can't
+		// serve a notebook, for notebooks without an error page of their
+		// own. Empty uses the built-in page.
+		ErrorPage string `mapstructure:"error_page"`
+		// ShareSecret signs the share links that let visitors into
+		// notebooks without other credentials. Empty uses a random secret,
+		// so links stop working when the hub restarts. ShareMaxTTL is the
+		// longest a link may last.
+		ShareSecret string        `mapstructure:"share_secret" json:"-"`
+		ShareMaxTTL time.Duration `mapstructure:"share_max_ttl"`
+	} `mapstructure:"proxy"`
+	ACME struct {
+		// Enabled has the proxy serve HTTPS with a certificate for Domains
+		// from an ACME CA, such as Let's Encrypt, renewed before it
+		// expires. Control of the domains is proven with DNS-01 challenges,
+		// so wildcards work and the proxy needn't be reachable by the CA.
+		Enabled   bool   `mapstructure:"enabled"`
+		Directory string `mapstructure:"directory"`
+		Email     string `mapstructure:"email"`
+		// Domains lists the names of the certificate, separated by commas.
+		// Empty uses the base domain and its wildcard.
+		Domains string `mapstructure:"domains"`
+		// Dir keeps the ACME account key and the certificate.
+		Dir         string        `mapstructure:"dir"`
+		RenewBefore time.Duration `mapstructure:"renew_before"`
+		// Propagation is how long challenge records are given to reach
+		// the DNS provider's name servers before the CA checks them.
+		Propagation time.Duration `mapstructure:"propagation"`
+		// Provider sets the challenge records: cloudflare, route53 or
+		// rfc2136, configured in the section of the same name.
+		Provider   string `mapstructure:"provider"`
+		Cloudflare struct {
+			// APIToken needs the Zone.DNS edit permission.
+			APIToken string `mapstructure:"api_token" json:"-"`
+			ZoneID   string `mapstructure:"zone_id"`
+		} `mapstructure:"cloudflare"`
+		Route53 struct {
+			AccessKey string `mapstructure:"access_key"`
+			SecretKey string `mapstructure:"secret_key" json:"-"`
+			ZoneID    string `mapstructure:"zone_id"`
+		} `mapstructure:"route53"`
+		RFC2136 struct {
+			// Server is the host[:port] of the zone's primary name server.
+			Server string `mapstructure:"server"`
+			Zone   string `mapstructure:"zone"`
+			// TSIGKey names the key updates are signed with, and
+			// TSIGSecret is the key in base64. Updates are unsigned
+			// without a key.
+			TSIGKey       string `mapstructure:"tsig_key"`
+			TSIGSecret    string `mapstructure:"tsig_secret" json:"-"`
+			TSIGAlgorithm string `mapstructure:"tsig_algorithm"`
+		} `mapstructure:"rfc2136"`
+	} `mapstructure:"acme"`
+	WebDAV struct {
+		// Enabled serves the notebooks directory over WebDAV under /dav on
+		// the API server.
+		Enabled bool `mapstructure:"enabled"`
+	} `mapstructure:"webdav"`
+	Health struct {
+		// MinPinnedRunning is the fraction of pinned notebooks that must be
+		// running for /readyz to report ready. Zero disables the check.
+		MinPinnedRunning float64 `mapstructure:"min_pinned_running"`
+	} `mapstructure:"health"`
+	// Strict fails loading on unknown or deprecated settings, which are
+	// otherwise only warned about.
+	Strict bool `mapstructure:"strict"`
+
+	sources  map[string]string
+	warnings []string
+}
+
+// HTTPConfig tunes one of the HTTP servers. Timeouts of zero are unlimited.
+// The timeouts stop applying once a connection is upgraded to a WebSocket.
+type HTTPConfig struct {
+	// Concurrency is the most connections served at once.
+	Concurrency  int           `mapstructure:"concurrency"`
+	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// IdleTimeout is how long a keep-alive connection may wait for its next
+	// request.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	// ReadBuffer and WriteBuffer are the per-connection buffer sizes in
+	// bytes. The read buffer also limits the size of request headers.
+	ReadBuffer  int `mapstructure:"read_buffer"`
+	WriteBuffer int `mapstructure:"write_buffer"`
+	// TLSCert and TLSKey are PEM files the server serves HTTPS with
+	// instead of HTTP.
+	TLSCert string `mapstructure:"tls_cert"`
+	TLSKey  string `mapstructure:"tls_key"`
+	// TLSCerts is a directory of certificates for particular domains, each
+	// a name.crt file next to its name.key. Clients asking for a domain one
+	// of them covers get it instead of TLSCert. Changes are picked up
+	// within a minute.
+	TLSCerts string `mapstructure:"tls_certs"`
+	// ClientCA is a PEM file of the CAs client certificates are verified
+	// against. On the API, such a certificate authenticates the user named
+	// by its common name, without a token or password. On the proxy, its
+	// subject is passed to notebooks.
+	ClientCA string `mapstructure:"client_ca"`
+	// ClientAuth is "require", refusing connections without a client
+	// certificate, or "optional", verifying the ones presented and leaving
+	// the API's other credentials, or notebooks that require one, to deal
+	// with the rest.
+	ClientAuth string `mapstructure:"client_auth"`
+}
+
+type S3Config struct {
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	Prefix    string `mapstructure:"prefix"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key" json:"-"`
+	PathStyle bool   `mapstructure:"path_style"`
 }
 
 var (
 	defaults = map[string]interface{}{
-		"server.api_port":            8081,
-		"server.marimo_port":         8080,
-		"server.proxy_port":          80,
-		"notebooks.path":             "/notebooks",
-		"notebooks.port_range.start": 3000,
-		"notebooks.port_range.end":   4000,
-		"database.path":              "/data/marimo-hub.db",
+		"server.api_host":              "0.0.0.0",
+		"server.api_port":              8081,
+		"server.marimo_host":           "0.0.0.0",
+		"server.marimo_port":           8080,
+		"server.proxy_host":            "0.0.0.0",
+		"server.proxy_port":            80,
+		"server.proxy_max_body_mb":     1024,
+		"server.drain_timeout":         "10m",
+		"server.read_only":             false,
+		"server.api.concurrency":       256 * 1024,
+		"server.api.read_timeout":      "0s",
+		"server.api.write_timeout":     "0s",
+		"server.api.idle_timeout":      "2m",
+		"server.api.read_buffer":       4096,
+		"server.api.write_buffer":      4096,
+		"server.api.tls_cert":          "",
+		"server.api.tls_key":           "",
+		"server.api.tls_certs":         "",
+		"server.api.client_ca":         "",
+		"server.api.client_auth":       "require",
+		"server.proxy.concurrency":     256 * 1024,
+		"server.proxy.read_timeout":    "0s",
+		"server.proxy.write_timeout":   "0s",
+		"server.proxy.idle_timeout":    "2m",
+		"server.proxy.read_buffer":     16384,
+		"server.proxy.write_buffer":    4096,
+		"server.proxy.tls_cert":        "",
+		"server.proxy.tls_key":         "",
+		"server.proxy.tls_certs":       "",
+		"server.proxy.client_ca":       "",
+		"server.proxy.client_auth":     "require",
+		"notebooks.path":               "/notebooks",
+		"notebooks.host":               "127.0.0.1",
+		"notebooks.start_timeout":      "2m",
+		"notebooks.start_parallelism":  4,
+		"notebooks.start_jitter":       "1s",
+		"notebooks.port_range.start":   3000,
+		"notebooks.port_range.end":     4000,
+		"notebooks.reconcile_interval": "30s",
+		"notebooks.state_dir":          "/data/run",
+		"notebooks.secrets_dir":        "",
+		"notebooks.subreaper":          false,
+		"notebooks.idle_timeout":       "0s",
+		"notebooks.assets_max_age":     "1h",
+		"notebooks.logs.dir":           "/data/logs",
+		"notebooks.logs.max_size_mb":   10,
+		"notebooks.logs.max_age":       "24h",
+		"notebooks.logs.max_files":     5,
+		"notebooks.logs.retention":     "168h",
+		"notebooks.logs.mirror":        false,
+		"notebooks.logs.total_size_mb": 1024,
+		"notebooks.upload.max_size_mb": 100,
+		"notebooks.upload.max_disk_mb": 500,
+		"notebooks.upload.max_files":   10000,
+		"database.driver":              "badger",
+		"database.path":                "/data/marimo-hub.db",
+		"database.dsn":                 "",
+		"database.gc_interval":         "10m",
+		"database.change_retention":    "168h",
+		"cluster.enabled":              false,
+		"cluster.node_id":              "",
+		"cluster.advertise_address":    "",
+		"cluster.lease_ttl":            "15s",
+		"cluster.poll_interval":        "5s",
+		"backup.enabled":               false,
+		"backup.interval":              "1h",
+		"backup.full_every":            24,
+		"backup.retention":             7,
+		"backup.s3.endpoint":           "",
+		"backup.s3.region":             "us-east-1",
+		"backup.s3.bucket":             "",
+		"backup.s3.prefix":             "marimo-hub",
+		"backup.s3.access_key":         "",
+		"backup.s3.secret_key":         "",
+		"backup.s3.path_style":         false,
+		"audit.enabled":                false,
+		"audit.retention":              "2160h",
+		"audit.file.path":              "/data/audit.jsonl",
+		"audit.file.max_size_mb":       100,
+		"audit.file.max_files":         10,
+		"audit.s3.endpoint":            "",
+		"audit.s3.region":              "us-east-1",
+		"audit.s3.bucket":              "",
+		"audit.s3.prefix":              "marimo-hub-audit",
+		"audit.s3.access_key":          "",
+		"audit.s3.secret_key":          "",
+		"audit.s3.path_style":          false,
+		"audit.flush_interval":         "1m",
+		"auth.enabled":                 false,
+		"auth.admin_username":          "admin",
+		"auth.admin_password":          "",
+		"auth.basic_username":          "",
+		"auth.basic_password_hash":     "",
+		"auth.trusted_proxies":         "",
+		"auth.user_header":             "X-Forwarded-User",
+		"auth.groups_header":           "X-Forwarded-Groups",
+		"auth.admin_groups":            "",
+		"log.request_level":            "info",
+		"log.format":                   "json",
+		"log.level":                    "debug",
+		"log.levels":                   "",
+		"log.access":                   false,
+		"log.access_sample":            1.0,
+		"debug.enabled":                false,
+		"metrics.push":                 "",
+		"metrics.push_interval":        "15s",
+		"metrics.push_address":         "",
+		"metrics.push_headers":         "",
+		"webdav.enabled":               false,
+		"hooks.file":                   "",
+		"hooks.events_url":             "",
+		"hooks.events_secret":          "",
+		"landing.enabled":              false,
+		"landing.domain":               "",
+		"landing.title":                "Notebooks",
+		"maintenance.enabled":          false,
+		"maintenance.message":          "This service is down for maintenance and will be back shortly.",
+		"maintenance.page":             "",
+		"maintenance.allow_ips":        "",
+		"proxy.base_domain":            "",
+		"proxy.dns_check":              "off",
+		"proxy.public_address":         "",
+		"proxy.security_headers":       false,
+		"proxy.csp":                    "",
+		"proxy.external_prefix":        "",
+		"proxy.error_page":             "",
+		"proxy.share_secret":           "",
+		"proxy.share_max_ttl":          "720h",
+		"acme.enabled":                 false,
+		"acme.directory":               "https://acme-v02.api.letsencrypt.org/directory",
+		"acme.email":                   "",
+		"acme.domains":                 "",
+		"acme.dir":                     "/data/acme",
+		"acme.renew_before":            "720h",
+		"acme.propagation":             "2m",
+		"acme.provider":                "",
+		"acme.cloudflare.api_token":    "",
+		"acme.cloudflare.zone_id":      "",
+		"acme.route53.access_key":      "",
+		"acme.route53.secret_key":      "",
+		"acme.route53.zone_id":         "",
+		"acme.rfc2136.server":          "",
+		"acme.rfc2136.zone":            "",
+		"acme.rfc2136.tsig_key":        "",
+		"acme.rfc2136.tsig_secret":     "",
+		"acme.rfc2136.tsig_algorithm":  "hmac-sha256",
+		"health.min_pinned_running":    0.0,
+		"strict":                       false,
 	}
 
 	envMappings = map[string]string{
-		"API_PORT":            "server.api_port",
-		"MARIMO_PORT":         "server.marimo_port",
-		"PROXY_PORT":          "server.proxy_port",
-		"NOTEBOOKS_PATH":      "notebooks.path",
-		"NOTEBOOK_PORT_RANGE": "notebooks.port_range",
-		"DB_PATH":             "database.path",
+		"API_HOST":               "server.api_host",
+		"API_PORT":               "server.api_port",
+		"MARIMO_HOST":            "server.marimo_host",
+		"MARIMO_PORT":            "server.marimo_port",
+		"PROXY_HOST":             "server.proxy_host",
+		"PROXY_PORT":             "server.proxy_port",
+		"PROXY_MAX_BODY":         "server.proxy_max_body_mb",
+		"DRAIN_TIMEOUT":          "server.drain_timeout",
+		"READ_ONLY":              "server.read_only",
+		"API_CONCURRENCY":        "server.api.concurrency",
+		"API_READ_TIMEOUT":       "server.api.read_timeout",
+		"API_WRITE_TIMEOUT":      "server.api.write_timeout",
+		"API_IDLE_TIMEOUT":       "server.api.idle_timeout",
+		"API_READ_BUFFER":        "server.api.read_buffer",
+		"API_WRITE_BUFFER":       "server.api.write_buffer",
+		"API_TLS_CERT":           "server.api.tls_cert",
+		"API_TLS_KEY":            "server.api.tls_key",
+		"API_TLS_CERTS":          "server.api.tls_certs",
+		"API_CLIENT_CA":          "server.api.client_ca",
+		"API_CLIENT_AUTH":        "server.api.client_auth",
+		"PROXY_CONCURRENCY":      "server.proxy.concurrency",
+		"PROXY_READ_TIMEOUT":     "server.proxy.read_timeout",
+		"PROXY_WRITE_TIMEOUT":    "server.proxy.write_timeout",
+		"PROXY_IDLE_TIMEOUT":     "server.proxy.idle_timeout",
+		"PROXY_READ_BUFFER":      "server.proxy.read_buffer",
+		"PROXY_WRITE_BUFFER":     "server.proxy.write_buffer",
+		"PROXY_TLS_CERT":         "server.proxy.tls_cert",
+		"PROXY_TLS_KEY":          "server.proxy.tls_key",
+		"PROXY_TLS_CERTS":        "server.proxy.tls_certs",
+		"PROXY_CLIENT_CA":        "server.proxy.client_ca",
+		"PROXY_CLIENT_AUTH":      "server.proxy.client_auth",
+		"NOTEBOOKS_PATH":         "notebooks.path",
+		"NOTEBOOK_HOST":          "notebooks.host",
+		"NOTEBOOK_START_TIMEOUT": "notebooks.start_timeout",
+		"NOTEBOOK_START_WORKERS": "notebooks.start_parallelism",
+		"NOTEBOOK_START_JITTER":  "notebooks.start_jitter",
+		"NOTEBOOK_PORT_RANGE":    "notebooks.port_range",
+		"NOTEBOOK_RECONCILE":     "notebooks.reconcile_interval",
+		"NOTEBOOK_STATE_DIR":     "notebooks.state_dir",
+		"SECRETS_DIR":            "notebooks.secrets_dir",
+		"NOTEBOOK_SUBREAPER":     "notebooks.subreaper",
+		"NOTEBOOK_IDLE_TIMEOUT":  "notebooks.idle_timeout",
+		"NOTEBOOK_ASSET_MAX_AGE": "notebooks.assets_max_age",
+		"NOTEBOOK_LOG_DIR":       "notebooks.logs.dir",
+		"NOTEBOOK_LOG_MAX_SIZE":  "notebooks.logs.max_size_mb",
+		"NOTEBOOK_LOG_MAX_AGE":   "notebooks.logs.max_age",
+		"NOTEBOOK_LOG_MAX_FILES": "notebooks.logs.max_files",
+		"NOTEBOOK_LOG_RETENTION": "notebooks.logs.retention",
+		"NOTEBOOK_LOG_BUDGET":    "notebooks.logs.total_size_mb",
+		"NOTEBOOK_LOG_MIRROR":    "notebooks.logs.mirror",
+		"UPLOAD_MAX_SIZE":        "notebooks.upload.max_size_mb",
+		"UPLOAD_MAX_DISK":        "notebooks.upload.max_disk_mb",
+		"UPLOAD_MAX_FILES":       "notebooks.upload.max_files",
+		"DB_DRIVER":              "database.driver",
+		"DB_PATH":                "database.path",
+		"DB_DSN":                 "database.dsn",
+		"DB_GC_INTERVAL":         "database.gc_interval",
+		"DB_CHANGE_RETENTION":    "database.change_retention",
+		"CLUSTER_ENABLED":        "cluster.enabled",
+		"CLUSTER_NODE_ID":        "cluster.node_id",
+		"CLUSTER_ADVERTISE":      "cluster.advertise_address",
+		"CLUSTER_LEASE_TTL":      "cluster.lease_ttl",
+		"CLUSTER_POLL":           "cluster.poll_interval",
+		"BACKUP_ENABLED":         "backup.enabled",
+		"BACKUP_INTERVAL":        "backup.interval",
+		"BACKUP_FULL_EVERY":      "backup.full_every",
+		"BACKUP_RETENTION":       "backup.retention",
+		"BACKUP_S3_ENDPOINT":     "backup.s3.endpoint",
+		"BACKUP_S3_REGION":       "backup.s3.region",
+		"BACKUP_S3_BUCKET":       "backup.s3.bucket",
+		"BACKUP_S3_PREFIX":       "backup.s3.prefix",
+		"BACKUP_S3_ACCESS_KEY":   "backup.s3.access_key",
+		"BACKUP_S3_SECRET_KEY":   "backup.s3.secret_key",
+		"BACKUP_S3_PATH_STYLE":   "backup.s3.path_style",
+		"AUDIT_ENABLED":          "audit.enabled",
+		"AUDIT_RETENTION":        "audit.retention",
+		"AUDIT_FILE":             "audit.file.path",
+		"AUDIT_FILE_MAX_SIZE":    "audit.file.max_size_mb",
+		"AUDIT_FILE_MAX_FILES":   "audit.file.max_files",
+		"AUDIT_S3_ENDPOINT":      "audit.s3.endpoint",
+		"AUDIT_S3_REGION":        "audit.s3.region",
+		"AUDIT_S3_BUCKET":        "audit.s3.bucket",
+		"AUDIT_S3_PREFIX":        "audit.s3.prefix",
+		"AUDIT_S3_ACCESS_KEY":    "audit.s3.access_key",
+		"AUDIT_S3_SECRET_KEY":    "audit.s3.secret_key",
+		"AUDIT_S3_PATH_STYLE":    "audit.s3.path_style",
+		"AUDIT_FLUSH":            "audit.flush_interval",
+		"AUTH_ENABLED":           "auth.enabled",
+		"AUTH_ADMIN_USERNAME":    "auth.admin_username",
+		"AUTH_ADMIN_PASSWORD":    "auth.admin_password",
+		"AUTH_BASIC_USERNAME":    "auth.basic_username",
+		"AUTH_BASIC_HASH":        "auth.basic_password_hash",
+		"AUTH_TRUSTED_PROXIES":   "auth.trusted_proxies",
+		"AUTH_USER_HEADER":       "auth.user_header",
+		"AUTH_GROUPS_HEADER":     "auth.groups_header",
+		"AUTH_ADMIN_GROUPS":      "auth.admin_groups",
+		"LOG_REQUEST_LEVEL":      "log.request_level",
+		"LOG_FORMAT":             "log.format",
+		"LOG_LEVEL":              "log.level",
+		"LOG_LEVELS":             "log.levels",
+		"LOG_ACCESS":             "log.access",
+		"LOG_ACCESS_SAMPLE":      "log.access_sample",
+		"DEBUG_ENABLED":          "debug.enabled",
+		"METRICS_PUSH":           "metrics.push",
+		"METRICS_PUSH_INTERVAL":  "metrics.push_interval",
+		"METRICS_PUSH_ADDRESS":   "metrics.push_address",
+		"METRICS_PUSH_HEADERS":   "metrics.push_headers",
+		"WEBDAV_ENABLED":         "webdav.enabled",
+		"HOOKS_FILE":             "hooks.file",
+		"EVENTS_WEBHOOK_URL":     "hooks.events_url",
+		"EVENTS_WEBHOOK_SECRET":  "hooks.events_secret",
+		"LANDING_ENABLED":        "landing.enabled",
+		"LANDING_DOMAIN":         "landing.domain",
+		"LANDING_TITLE":          "landing.title",
+		"MAINTENANCE_ENABLED":    "maintenance.enabled",
+		"MAINTENANCE_MESSAGE":    "maintenance.message",
+		"MAINTENANCE_PAGE":       "maintenance.page",
+		"MAINTENANCE_ALLOW_IPS":  "maintenance.allow_ips",
+		"PROXY_BASE_DOMAIN":      "proxy.base_domain",
+		"DNS_CHECK":              "proxy.dns_check",
+		"PUBLIC_ADDRESS":         "proxy.public_address",
+		"PROXY_SECURITY_HEADERS": "proxy.security_headers",
+		"PROXY_CSP":              "proxy.csp",
+		"PROXY_EXTERNAL_PREFIX":  "proxy.external_prefix",
+		"PROXY_ERROR_PAGE":       "proxy.error_page",
+		"PROXY_SHARE_SECRET":     "proxy.share_secret",
+		"PROXY_SHARE_MAX_TTL":    "proxy.share_max_ttl",
+		"ACME_ENABLED":           "acme.enabled",
+		"ACME_DIRECTORY":         "acme.directory",
+		"ACME_EMAIL":             "acme.email",
+		"ACME_DOMAINS":           "acme.domains",
+		"ACME_DIR":               "acme.dir",
+		"ACME_RENEW_BEFORE":      "acme.renew_before",
+		"ACME_PROPAGATION":       "acme.propagation",
+		"ACME_PROVIDER":          "acme.provider",
+		"CLOUDFLARE_API_TOKEN":   "acme.cloudflare.api_token",
+		"CLOUDFLARE_ZONE_ID":     "acme.cloudflare.zone_id",
+		"ROUTE53_ACCESS_KEY":     "acme.route53.access_key",
+		"ROUTE53_SECRET_KEY":     "acme.route53.secret_key",
+		"ROUTE53_ZONE_ID":        "acme.route53.zone_id",
+		"RFC2136_SERVER":         "acme.rfc2136.server",
+		"RFC2136_ZONE":           "acme.rfc2136.zone",
+		"RFC2136_TSIG_KEY":       "acme.rfc2136.tsig_key",
+		"RFC2136_TSIG_SECRET":    "acme.rfc2136.tsig_secret",
+		"RFC2136_TSIG_ALGORITHM": "acme.rfc2136.tsig_algorithm",
+		"READY_MIN_PINNED":       "health.min_pinned_running",
+		"STRICT_CONFIG":          "strict",
 	}
 )
 
 func Load() (*Config, error) {
+	return LoadFile("")
+}
+
+// LoadFile loads the configuration like Load, starting from the settings in
+// the YAML, JSON or TOML file at path. Environment variables still take
+// precedence over the file. An empty path reads no file.
+func LoadFile(path string) (*Config, error) {
 	v := viper.New()
 
+	var fileKeys []string
+	if path != "" {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		fileKeys = v.AllKeys()
+	}
+
 	for key, value := range defaults {
 		v.SetDefault(key, value)
 	}
@@ -76,6 +713,19 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if config.Cluster.NodeID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine node id: %w", err)
+		}
+		config.Cluster.NodeID = hostname
+	}
+	config.sources = sources(v)
+	config.warnings = checkSettings(fileKeys)
+	if config.Strict && len(config.warnings) > 0 {
+		return nil, fmt.Errorf("strict configuration: %s", strings.Join(config.warnings, "; "))
+	}
+
 	if err := validateConfig(&config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -112,6 +762,21 @@ func parseInt(s string) (int, error) {
 }
 
 func validateConfig(cfg *Config) error {
+	hosts := []struct {
+		name  string
+		value string
+	}{
+		{"API host", cfg.Server.APIHost},
+		{"marimo host", cfg.Server.MarimoHost},
+		{"proxy host", cfg.Server.ProxyHost},
+		{"notebook host", cfg.Notebooks.Host},
+	}
+	for _, h := range hosts {
+		if err := validateHost(h.value); err != nil {
+			return fmt.Errorf("invalid %s: %w", h.name, err)
+		}
+	}
+
 	if err := validatePort(cfg.Server.APIPort); err != nil {
 		return fmt.Errorf("invalid API port: %w", err)
 	}
@@ -126,11 +791,206 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("invalid notebook port range: %w", err)
 	}
 
-	if !strings.HasPrefix(cfg.Notebooks.Path, "/") {
+	if !isAbsPath(cfg.Notebooks.Path) {
 		return fmt.Errorf("notebooks path must be absolute")
 	}
-	if !strings.HasPrefix(cfg.Database.Path, "/") {
-		return fmt.Errorf("database path must be absolute")
+	if cfg.Notebooks.ReconcileInterval < time.Second {
+		return fmt.Errorf("notebooks reconcile interval must be at least 1s")
+	}
+	if up := cfg.Notebooks.Upload; up.MaxSizeMB <= 0 || up.MaxDiskMB <= 0 || up.MaxFiles <= 0 {
+		return fmt.Errorf("notebooks upload limits must be positive")
+	}
+	if cfg.Server.DrainTimeout < 0 {
+		return fmt.Errorf("drain timeout must not be negative")
+	}
+	if err := validateHTTP("api", cfg.Server.API); err != nil {
+		return err
+	}
+	if err := validateHTTP("proxy", cfg.Server.Proxy); err != nil {
+		return err
+	}
+	if cfg.Server.ProxyMaxBodyMB <= 0 {
+		return fmt.Errorf("proxy max body size must be positive")
+	}
+	if cfg.Hooks.EventsURL != "" && !strings.HasPrefix(cfg.Hooks.EventsURL, "http://") && !strings.HasPrefix(cfg.Hooks.EventsURL, "https://") {
+		return fmt.Errorf("events webhook URL must be an http or https URL")
+	}
+	if strings.ContainsAny(cfg.Proxy.BaseDomain, "/: ") {
+		return fmt.Errorf("proxy base domain must be a bare domain name")
+	}
+	if !ValidPathPrefix(cfg.Proxy.ExternalPrefix) {
+		return fmt.Errorf("proxy external prefix must be a path such as /notebooks")
+	}
+	if cfg.Proxy.ShareMaxTTL <= 0 {
+		return fmt.Errorf("proxy share max TTL must be positive")
+	}
+	switch cfg.Proxy.DNSCheck {
+	case "off":
+	case "warn", "fail":
+		if cfg.Proxy.PublicAddress == "" {
+			return fmt.Errorf("proxy public address is required to check DNS")
+		}
+	default:
+		return fmt.Errorf("proxy DNS check must be off, warn or fail")
+	}
+	if err := validateACME(cfg); err != nil {
+		return err
+	}
+	if err := validateMetrics(cfg); err != nil {
+		return err
+	}
+	if cfg.Notebooks.StartTimeout <= 0 {
+		return fmt.Errorf("notebooks start timeout must be positive")
+	}
+	if cfg.Notebooks.StartParallelism <= 0 {
+		return fmt.Errorf("notebooks start parallelism must be positive")
+	}
+	if cfg.Notebooks.StartJitter < 0 {
+		return fmt.Errorf("notebooks start jitter must not be negative")
+	}
+	if cfg.Notebooks.AssetsMaxAge < 0 {
+		return fmt.Errorf("notebooks assets max age must not be negative")
+	}
+	if cfg.Notebooks.SecretsDir != "" && !isAbsPath(cfg.Notebooks.SecretsDir) {
+		return fmt.Errorf("notebooks secrets dir must be absolute")
+	}
+	if cfg.Notebooks.IdleTimeout < 0 {
+		return fmt.Errorf("notebooks idle timeout must not be negative")
+	}
+	if cfg.Notebooks.IdleTimeout > 0 && cfg.Cluster.Enabled {
+		return fmt.Errorf("notebooks idle timeout is not supported in a cluster, where other hubs proxy to this one's notebooks")
+	}
+	if cfg.Notebooks.Subreaper && runtime.GOOS != "linux" {
+		return fmt.Errorf("notebooks subreaper is only supported on Linux")
+	}
+	if logs := cfg.Notebooks.Logs; logs.Dir != "" {
+		if !isAbsPath(logs.Dir) {
+			return fmt.Errorf("notebooks log dir must be absolute")
+		}
+		if logs.MaxSizeMB < 0 || logs.MaxAge < 0 || logs.MaxFiles < 0 || logs.Retention < 0 || logs.TotalSizeMB < 0 {
+			return fmt.Errorf("notebooks log rotation and retention settings must not be negative")
+		}
+	}
+	switch cfg.Database.Driver {
+	case "badger":
+		if !isAbsPath(cfg.Database.Path) {
+			return fmt.Errorf("database path must be absolute")
+		}
+		if cfg.Database.GCInterval < time.Minute {
+			return fmt.Errorf("database gc interval must be at least 1m")
+		}
+		if cfg.Database.ChangeRetention < 0 {
+			return fmt.Errorf("database change retention must not be negative")
+		}
+	case "postgres":
+		if cfg.Database.DSN == "" {
+			return fmt.Errorf("database dsn is required for the postgres driver")
+		}
+		if cfg.Cluster.PollInterval <= 0 {
+			return fmt.Errorf("cluster poll interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown database driver %q", cfg.Database.Driver)
+	}
+
+	if cfg.Cluster.Enabled {
+		if cfg.Database.Driver != "postgres" {
+			return fmt.Errorf("cluster mode requires the postgres database driver")
+		}
+		if _, _, err := net.SplitHostPort(cfg.Cluster.AdvertiseAddress); err != nil {
+			return fmt.Errorf("invalid cluster advertise address: %w", err)
+		}
+		if ip := net.ParseIP(cfg.Notebooks.Host); cfg.Notebooks.Host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return fmt.Errorf("notebooks host must be reachable from other nodes in cluster mode")
+		}
+		if cfg.Cluster.LeaseTTL < time.Second {
+			return fmt.Errorf("cluster lease ttl must be at least 1s")
+		}
+	}
+
+	if cfg.Backup.Enabled {
+		if cfg.Database.Driver != "badger" {
+			return fmt.Errorf("backups are only supported for the badger database driver")
+		}
+		if cfg.Backup.S3.Bucket == "" {
+			return fmt.Errorf("backup bucket is required")
+		}
+		if cfg.Backup.Interval < time.Minute {
+			return fmt.Errorf("backup interval must be at least 1m")
+		}
+		if cfg.Backup.FullEvery < 0 {
+			return fmt.Errorf("backup full_every must not be negative")
+		}
+		if cfg.Backup.Retention < 1 {
+			return fmt.Errorf("backup retention must be at least 1")
+		}
+	}
+
+	if cfg.Audit.Enabled {
+		if cfg.Audit.File.Path == "" && cfg.Audit.S3.Bucket == "" {
+			return fmt.Errorf("audit log needs a file path or an s3 bucket")
+		}
+		if cfg.Audit.File.Path != "" && !isAbsPath(cfg.Audit.File.Path) {
+			return fmt.Errorf("audit file path must be absolute")
+		}
+		if cfg.Audit.File.MaxSizeMB < 0 || cfg.Audit.File.MaxFiles < 0 || cfg.Audit.Retention < 0 {
+			return fmt.Errorf("audit rotation and retention settings must not be negative")
+		}
+		if cfg.Audit.S3.Bucket != "" && cfg.Audit.FlushInterval < time.Second {
+			return fmt.Errorf("audit flush interval must be at least 1s")
+		}
+	}
+
+	if cfg.Maintenance.Page != "" && !isAbsPath(cfg.Maintenance.Page) {
+		return fmt.Errorf("maintenance page must be absolute")
+	}
+	if _, err := ParseIPList(cfg.Maintenance.AllowIPs); err != nil {
+		return fmt.Errorf("invalid maintenance allow_ips: %w", err)
+	}
+
+	if cfg.Auth.AdminPassword != "" {
+		if cfg.Auth.AdminUsername == "" {
+			return fmt.Errorf("auth admin username is required when an admin password is set")
+		}
+		if len(cfg.Auth.AdminPassword) < 8 {
+			return fmt.Errorf("auth admin password must be at least 8 characters")
+		}
+	}
+
+	if (cfg.Auth.BasicUsername == "") != (cfg.Auth.BasicPasswordHash == "") {
+		return fmt.Errorf("auth basic username and password hash must be set together")
+	}
+	if cfg.Auth.BasicPasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(cfg.Auth.BasicPasswordHash)); err != nil {
+			return fmt.Errorf("auth basic password hash must be a bcrypt hash: %w", err)
+		}
+	}
+
+	if _, err := ParseIPList(cfg.Auth.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid auth trusted_proxies: %w", err)
+	}
+	if cfg.Auth.TrustedProxies != "" && (cfg.Auth.UserHeader == "" || cfg.Auth.GroupsHeader == "") {
+		return fmt.Errorf("auth user and groups headers are required with trusted proxies")
+	}
+
+	if _, err := zerolog.ParseLevel(cfg.Log.RequestLevel); err != nil || cfg.Log.RequestLevel == "" {
+		return fmt.Errorf("invalid log request level %q", cfg.Log.RequestLevel)
+	}
+	if _, err := zerolog.ParseLevel(cfg.Log.Level); err != nil || cfg.Log.Level == "" {
+		return fmt.Errorf("invalid log level %q", cfg.Log.Level)
+	}
+	if _, err := logging.ParseLevels(cfg.Log.Levels); err != nil {
+		return fmt.Errorf("invalid log levels: %w", err)
+	}
+	if cfg.Log.Format != "json" && cfg.Log.Format != "console" {
+		return fmt.Errorf("log format must be json or console")
+	}
+	if cfg.Log.AccessSample < 0 || cfg.Log.AccessSample > 1 {
+		return fmt.Errorf("log access sample must be between 0 and 1")
+	}
+
+	if cfg.Health.MinPinnedRunning < 0 || cfg.Health.MinPinnedRunning > 1 {
+		return fmt.Errorf("health.min_pinned_running must be between 0 and 1")
 	}
 
 	ports := map[int]string{
@@ -154,6 +1014,110 @@ func validatePort(port int) error {
 	return nil
 }
 
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("host must not be empty")
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return fmt.Errorf("host must be an IP address or hostname without a port")
+	}
+	return nil
+}
+
+func validateMetrics(cfg *Config) error {
+	m := cfg.Metrics
+	switch m.Push {
+	case "":
+		return nil
+	case "statsd":
+		if _, _, err := net.SplitHostPort(m.PushAddress); err != nil {
+			return fmt.Errorf("metrics push address must be the host:port of a statsd server")
+		}
+	case "otlp":
+		if !strings.HasPrefix(m.PushAddress, "http://") && !strings.HasPrefix(m.PushAddress, "https://") {
+			return fmt.Errorf("metrics push address must be the http or https URL of an OTLP endpoint")
+		}
+		if _, err := ParseHeaders(m.PushHeaders); err != nil {
+			return fmt.Errorf("invalid metrics push headers: %w", err)
+		}
+	default:
+		return fmt.Errorf("metrics push must be statsd, otlp or empty")
+	}
+	if m.PushInterval <= 0 {
+		return fmt.Errorf("metrics push interval must be positive")
+	}
+	return nil
+}
+
+// ParseHeaders parses "Name=value" pairs separated by commas, such as
+// "Authorization=Bearer abc, X-Scope-OrgID=team".
+func ParseHeaders(s string) (http.Header, error) {
+	header := http.Header{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " :") {
+			return nil, fmt.Errorf("%q is not a Name=value pair", item)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// ParseIPList parses addresses and CIDR ranges separated by commas, such as
+// "10.0.0.0/8, 192.168.1.7". An address is a range of its own.
+func ParseIPList(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a CIDR range", item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ValidPathPrefix reports whether prefix is empty or a path of segments of
+// letters, digits, '.', '_', '~' and '-', which is safe to write into
+// pages.
+func ValidPathPrefix(prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return false
+	}
+	for _, r := range strings.TrimSuffix(prefix, "/") {
+		if !(r == '/' || r == '.' || r == '_' || r == '~' || r == '-' ||
+			'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return !strings.Contains(prefix, "//")
+}
+
+// isAbsPath reports whether path is absolute. On Windows, paths rooted on
+// the current drive like the defaults count too, so they work there as is.
+func isAbsPath(path string) bool {
+	if filepath.IsAbs(path) {
+		return true
+	}
+	return runtime.GOOS == "windows" && (strings.HasPrefix(path, `\`) || strings.HasPrefix(path, "/"))
+}
+
 func validatePortRange(start, end int) error {
 	if err := validatePort(start); err != nil {
 		return err
@@ -166,3 +1130,69 @@ func validatePortRange(start, end int) error {
 	}
 	return nil
 }
+
+func validateACME(cfg *Config) error {
+	acme := cfg.ACME
+	if !acme.Enabled {
+		return nil
+	}
+	if acme.Domains == "" && cfg.Proxy.BaseDomain == "" {
+		return fmt.Errorf("ACME needs domains or a proxy base domain")
+	}
+	if !strings.HasPrefix(acme.Directory, "https://") {
+		return fmt.Errorf("ACME directory must be an https URL")
+	}
+	if !isAbsPath(acme.Dir) {
+		return fmt.Errorf("ACME dir must be absolute")
+	}
+	if acme.RenewBefore <= 0 || acme.Propagation < 0 {
+		return fmt.Errorf("ACME renew_before must be positive and propagation must not be negative")
+	}
+	switch acme.Provider {
+	case "cloudflare":
+		if acme.Cloudflare.APIToken == "" || acme.Cloudflare.ZoneID == "" {
+			return fmt.Errorf("cloudflare API token and zone ID are required")
+		}
+	case "route53":
+		if acme.Route53.AccessKey == "" || acme.Route53.SecretKey == "" || acme.Route53.ZoneID == "" {
+			return fmt.Errorf("route53 access key, secret key and zone ID are required")
+		}
+	case "rfc2136":
+		if acme.RFC2136.Server == "" || acme.RFC2136.Zone == "" {
+			return fmt.Errorf("rfc2136 server and zone are required")
+		}
+		if (acme.RFC2136.TSIGKey == "") != (acme.RFC2136.TSIGSecret == "") {
+			return fmt.Errorf("rfc2136 TSIG key and secret must be set together")
+		}
+	default:
+		return fmt.Errorf("ACME provider must be cloudflare, route53 or rfc2136")
+	}
+	return nil
+}
+
+func validateHTTP(name string, cfg HTTPConfig) error {
+	if cfg.Concurrency <= 0 || cfg.ReadBuffer <= 0 || cfg.WriteBuffer <= 0 {
+		return fmt.Errorf("%s concurrency and buffer sizes must be positive", name)
+	}
+	if cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 {
+		return fmt.Errorf("%s timeouts must not be negative", name)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("%s TLS certificate and key must be set together", name)
+	}
+	if cfg.ClientAuth != "require" && cfg.ClientAuth != "optional" {
+		return fmt.Errorf("%s client auth must be require or optional", name)
+	}
+	if cfg.ClientCA != "" && cfg.TLSCert == "" {
+		return fmt.Errorf("%s client CA needs a TLS certificate", name)
+	}
+	if cfg.TLSCerts != "" && cfg.TLSCert == "" {
+		return fmt.Errorf("%s TLS certificates directory needs a default TLS certificate", name)
+	}
+	for _, path := range []string{cfg.TLSCert, cfg.TLSKey, cfg.TLSCerts, cfg.ClientCA} {
+		if path != "" && !isAbsPath(path) {
+			return fmt.Errorf("%s TLS files must be absolute", name)
+		}
+	}
+	return nil
+}
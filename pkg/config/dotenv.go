@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/subosito/gotenv"
+)
+
+// EnvFileVariable names the environment variable holding the path of the
+// .env file to load, for when --env-file isn't given.
+const EnvFileVariable = "ENV_FILE"
+
+// LoadEnvFile sets the variables of the .env file at path in the process
+// environment, so that loading the configuration reads them like any other.
+// Variables already set win over the file, which only fills in what the
+// shell leaves out. An empty path falls back to ENV_FILE, and loads nothing
+// when that isn't set either: the file is a convenience for local
+// development that production deployments don't read unless asked to.
+func LoadEnvFile(path string) error {
+	if path == "" {
+		path = os.Getenv(EnvFileVariable)
+	}
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer f.Close()
+
+	env, err := gotenv.StrictParse(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse env file %s: %w", path, err)
+	}
+	for key, value := range env {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to set %s from env file: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// redacted stands in for the value of a secret that is set.
+const redacted = "[redacted]"
+
+var dsnPassword = regexp.MustCompile(`(password=)\S+`)
+
+// Settings returns the configuration keyed like a configuration file. Secrets,
+// the fields kept out of JSON, are redacted, and so is the password of the
+// database DSN.
+func (c *Config) Settings() map[string]any {
+	return settings(reflect.ValueOf(*c))
+}
+
+// Sources reports where each setting came from: "default", "file" or "env"
+// followed by the variable that set it.
+func (c *Config) Sources() map[string]string {
+	return c.sources
+}
+
+func settings(v reflect.Value) map[string]any {
+	out := make(map[string]any, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+		value := v.Field(i)
+
+		switch {
+		case value.Kind() == reflect.Struct:
+			out[key] = settings(value)
+		case field.Tag.Get("json") == "-":
+			if !value.IsZero() {
+				out[key] = redacted
+			} else {
+				out[key] = ""
+			}
+		case key == "dsn":
+			out[key] = redactDSN(value.String())
+		case value.Type() == reflect.TypeOf(time.Duration(0)):
+			out[key] = value.Interface().(time.Duration).String()
+		default:
+			out[key] = value.Interface()
+		}
+	}
+	return out
+}
+
+// redactDSN hides the password of a URL or key=value Postgres DSN.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// sources works out where every setting with a default was loaded from,
+// following viper's precedence.
+func sources(v *viper.Viper) map[string]string {
+	out := make(map[string]string, len(defaults))
+	for key := range defaults {
+		out[key] = source(v, key)
+	}
+	return out
+}
+
+func source(v *viper.Viper, key string) string {
+	if env := strings.ToUpper(strings.ReplaceAll(key, ".", "_")); os.Getenv(env) != "" {
+		return "env " + env
+	}
+	for env, mapped := range envMappings {
+		if (mapped == key || strings.HasPrefix(key, mapped+".")) && os.Getenv(env) != "" {
+			return "env " + env
+		}
+	}
+	if v.InConfig(key) {
+		return "file"
+	}
+	return "default"
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// envPrefixes start the names of the hub's environment variables. Variables
+// with one of them that the hub doesn't read are most likely misspelled.
+var envPrefixes = []string{"ACME_", "API_", "AUDIT_", "AUTH_", "BACKUP_", "CLOUDFLARE_", "CLUSTER_", "DB_", "EVENTS_", "HOOKS_", "LANDING_", "MAINTENANCE_", "METRICS_", "NOTEBOOK_", "NOTEBOOKS_", "PROXY_", "RFC2136_", "ROUTE53_", "UPLOAD_", "WEBDAV_"}
+
+// deprecated maps settings that are still read but will be removed, by key
+// or environment variable, to what replaces them.
+var deprecated = map[string]string{}
+
+// Warnings lists the problems with how the configuration was given that
+// didn't stop it from loading: unknown and deprecated settings. In strict
+// mode they fail loading instead.
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// checkSettings finds the settings in fileKeys, the keys set by the
+// configuration file, and in the environment that the hub doesn't know or
+// that are deprecated.
+func checkSettings(fileKeys []string) []string {
+	keys := make(map[string]bool)
+	for key := range defaults {
+		keys[key] = true
+	}
+	envs := make(map[string]bool)
+	for env, key := range envMappings {
+		envs[env] = true
+		keys[key] = true
+	}
+	// Viper also reads every key from the variable named after it.
+	for key := range keys {
+		envs[strings.ToUpper(strings.ReplaceAll(key, ".", "_"))] = true
+	}
+
+	var problems []string
+	for _, key := range slices.Sorted(slices.Values(fileKeys)) {
+		if replacement, ok := deprecated[key]; ok {
+			problems = append(problems, fmt.Sprintf("setting %s is deprecated, use %s", key, replacement))
+		} else if !keys[key] {
+			problems = append(problems, "unknown setting "+key+suggest(key, slices.Collect(maps.Keys(keys))))
+		}
+	}
+	var environ []string
+	for _, kv := range os.Environ() {
+		env, _, _ := strings.Cut(kv, "=")
+		environ = append(environ, env)
+	}
+	slices.Sort(environ)
+	for _, env := range environ {
+		if replacement, ok := deprecated[env]; ok {
+			problems = append(problems, fmt.Sprintf("environment variable %s is deprecated, use %s", env, replacement))
+			continue
+		}
+		if envs[env] || !slices.ContainsFunc(envPrefixes, func(prefix string) bool { return strings.HasPrefix(env, prefix) }) {
+			continue
+		}
+		problems = append(problems, "unknown environment variable "+env+suggest(env, slices.Collect(maps.Keys(envMappings))))
+	}
+	return problems
+}
+
+// suggest names the candidate closest to name, if one is close enough to
+// be what was meant.
+func suggest(name string, candidates []string) string {
+	// Short names are a few edits away from too many others.
+	best, bestDistance := "", min(3, len(name)/4)+1
+	for _, candidate := range slices.Sorted(slices.Values(candidates)) {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance is the Damerau-Levenshtein distance between a and b, which
+// counts swapped neighbors as one edit.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
@@ -0,0 +1,315 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"github.com/rekk30/marimo-hub/pkg/metrics"
+)
+
+// maxRestartDelay bounds how long a restart for changed files waits for the
+// sessions of a notebook to end.
+const maxRestartDelay = 10 * time.Minute
+
+// Connections is what the proxy has open to a notebook's backend.
+type Connections struct {
+	Requests int `json:"requests"`
+	Sessions int `json:"sessions"`
+	// LastActive is when a request or session last began or ended, or when
+	// the process was last seen running unused.
+	LastActive *time.Time `json:"last_active,omitempty"`
+}
+
+func (c Connections) inUse() bool {
+	return c.Requests > 0 || c.Sessions > 0
+}
+
+// connTracker counts the connections the proxy has open to each notebook.
+type connTracker struct {
+	mu        sync.Mutex
+	notebooks map[string]*tracked
+}
+
+type tracked struct {
+	requests, sessions int
+	lastActive         time.Time
+	// restart runs once the last session ends, or when timer fires.
+	restart func()
+	timer   *time.Timer
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{notebooks: make(map[string]*tracked)}
+}
+
+// getLocked returns the state of notebook id. Must hold t.mu.
+func (t *connTracker) getLocked(id string) *tracked {
+	n, ok := t.notebooks[id]
+	if !ok {
+		n = &tracked{}
+		t.notebooks[id] = n
+	}
+	return n
+}
+
+// begin counts a request or session to notebook id, and returns the function
+// ending it. With a limit, it refuses, returning false, once that many are
+// open.
+func (t *connTracker) begin(id string, session bool, limit int) (func(), bool) {
+	t.mu.Lock()
+	n := t.getLocked(id)
+	count := &n.requests
+	if session {
+		count = &n.sessions
+	}
+	if limit > 0 && *count >= limit {
+		t.mu.Unlock()
+		return nil, false
+	}
+	*count++
+	n.lastActive = time.Now()
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			*count--
+			n.lastActive = time.Now()
+			var restart func()
+			if n.sessions == 0 && n.restart != nil {
+				restart = n.takeRestart()
+			}
+			t.mu.Unlock()
+			if restart != nil {
+				go restart()
+			}
+		})
+	}, true
+}
+
+// takeRestart returns the pending restart and forgets it. Must hold the
+// tracker's lock.
+func (n *tracked) takeRestart() func() {
+	restart := n.restart
+	n.restart = nil
+	n.timer.Stop()
+	return restart
+}
+
+func (t *connTracker) get(id string) Connections {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.notebooks[id]
+	if !ok {
+		return Connections{}
+	}
+	c := Connections{Requests: n.requests, Sessions: n.sessions}
+	if !n.lastActive.IsZero() {
+		last := n.lastActive
+		c.LastActive = &last
+	}
+	return c
+}
+
+// touch marks notebook id active now, as when its process starts.
+func (t *connTracker) touch(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.getLocked(id).lastActive = time.Now()
+}
+
+// whenUnused runs restart once notebook id has no sessions, or after
+// maxRestartDelay. It reports whether restart was deferred, which it isn't
+// if there are no sessions now. A notebook has at most one pending restart.
+func (t *connTracker) whenUnused(id string, restart func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.getLocked(id)
+	if n.sessions == 0 {
+		return false
+	}
+	if n.restart != nil {
+		return true
+	}
+	n.restart = restart
+	n.timer = time.AfterFunc(maxRestartDelay, func() {
+		t.mu.Lock()
+		var restart func()
+		if n.restart != nil {
+			restart = n.takeRestart()
+		}
+		t.mu.Unlock()
+		if restart != nil {
+			restart()
+		}
+	})
+	return true
+}
+
+// forget drops the state of notebook id once it is removed, unless
+// connections to it are still open.
+func (t *connTracker) forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n, ok := t.notebooks[id]; ok && n.requests == 0 && n.sessions == 0 {
+		if n.restart != nil {
+			n.takeRestart()
+		}
+		delete(t.notebooks, id)
+	}
+}
+
+// BeginRequest counts a proxied HTTP request to notebook id until the
+// returned function is called.
+func (r *Runner) BeginRequest(id string) func() {
+	done, _ := r.conns.begin(id, false, 0)
+	return done
+}
+
+// BeginSession counts a proxied WebSocket session to notebook id until the
+// returned function is called.
+func (r *Runner) BeginSession(id string) func() {
+	done, _ := r.conns.begin(id, true, 0)
+	return done
+}
+
+// TryBeginRequest is BeginRequest for a notebook taking at most limit
+// requests at once, or any number with a limit of zero. It returns false,
+// counting nothing, once limit requests are being proxied.
+func (r *Runner) TryBeginRequest(id string, limit int) (func(), bool) {
+	return r.conns.begin(id, false, limit)
+}
+
+// TryBeginSession is BeginSession for a notebook taking at most limit
+// sessions at once, as TryBeginRequest is for requests.
+func (r *Runner) TryBeginSession(id string, limit int) (func(), bool) {
+	return r.conns.begin(id, true, limit)
+}
+
+// Connections returns what the proxy has open to notebook id.
+func (r *Runner) Connections(id string) Connections {
+	return r.conns.get(id)
+}
+
+// restartWhenUnused restarts notebook id for changed files once nobody has
+// a session open to it, so the change doesn't cut off people using it.
+func (r *Runner) restartWhenUnused(id string) error {
+	deferred := r.conns.whenUnused(id, func() {
+		if err := r.Restart(id); err != nil {
+			logging.Runner.Warn().Str("method", "Runner.restartWhenUnused").
+				Str("notebook", id).
+				Err(err).
+				Msg("Failed to restart notebook")
+		}
+	})
+	if !deferred {
+		return r.Restart(id)
+	}
+	logging.Runner.Info().Str("method", "Runner.restartWhenUnused").
+		Str("notebook", id).
+		Msg("Restart deferred until the open sessions end")
+	return nil
+}
+
+// Wake starts notebook id again if it was stopped for being idle, and
+// reports whether it did.
+func (r *Runner) Wake(id string) bool {
+	r.mu.RLock()
+	manager, exists := r.managers[id]
+	r.mu.RUnlock()
+	if !exists || !manager.wake() {
+		return false
+	}
+	r.conns.touch(id)
+	logging.Runner.Info().Str("method", "Runner.Wake").Str("notebook", id).Msg("Waking idle notebook")
+	r.enqueueStart(manager)
+	go r.processQueue()
+	return true
+}
+
+// runIdleCheck stops notebooks nobody has used for timeout until the runner
+// is stopped.
+func (r *Runner) runIdleCheck(timeout time.Duration) {
+	ticker := time.NewTicker(max(min(timeout/4, time.Minute), time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.stopIdle(timeout)
+		}
+	}
+}
+
+// stopIdle stops the running notebooks without connections for timeout.
+// Pinned notebooks and the dependencies of other notebooks keep running.
+func (r *Runner) stopIdle(timeout time.Duration) {
+	if !r.IsLeader() || r.released.Load() {
+		return
+	}
+	r.mu.RLock()
+	managers := make([]*NotebookManager, 0, len(r.managers))
+	needed := make(map[string]bool)
+	for _, manager := range r.managers {
+		managers = append(managers, manager)
+		nb, _, _ := manager.snapshot()
+		for _, dep := range nb.DependsOn {
+			needed[dep] = true
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, manager := range managers {
+		nb, status, _ := manager.snapshot()
+		if status != StatusRunning || nb.Pinned || needed[nb.ID] {
+			continue
+		}
+		conns := r.conns.get(nb.ID)
+		if conns.inUse() {
+			continue
+		}
+		if conns.LastActive == nil {
+			// First seen running; count from now.
+			r.conns.touch(nb.ID)
+			continue
+		}
+		if time.Since(*conns.LastActive) < timeout {
+			continue
+		}
+		if manager.idle() {
+			logging.Runner.Info().Str("method", "Runner.stopIdle").
+				Str("notebook", nb.ID).
+				Dur("idle", time.Since(*conns.LastActive)).
+				Msg("Stopped idle notebook")
+		}
+	}
+}
+
+// Collect implements metrics.Collector.
+func (r *Runner) Collect() []metrics.Metric {
+	r.mu.RLock()
+	ids := make([]string, 0, len(r.managers))
+	for id := range r.managers {
+		ids = append(ids, id)
+	}
+	r.mu.RUnlock()
+	sort.Strings(ids)
+
+	var m []metrics.Metric
+	for _, id := range ids {
+		conns := r.conns.get(id)
+		labels := map[string]string{"notebook": id}
+		m = append(m,
+			metrics.Metric{Name: "marimo_hub_notebook_active_requests", Help: "HTTP requests being proxied to each notebook.", Type: metrics.TypeGauge, Labels: labels, Value: float64(conns.Requests)},
+			metrics.Metric{Name: "marimo_hub_notebook_active_sessions", Help: "WebSocket sessions open to each notebook.", Type: metrics.TypeGauge, Labels: labels, Value: float64(conns.Sessions)},
+		)
+		if conns.LastActive != nil {
+			m = append(m, metrics.Metric{Name: "marimo_hub_notebook_last_active_timestamp_seconds", Help: "When each notebook was last used.", Type: metrics.TypeGauge, Labels: labels, Value: float64(conns.LastActive.Unix())})
+		}
+	}
+	return m
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BundleLimits bounds what an uploaded project bundle may extract to.
+type BundleLimits struct {
+	// MaxSize is the total uncompressed size of all files in bytes.
+	MaxSize int64
+	// MaxFiles is the number of entries in the archive.
+	MaxFiles int
+}
+
+// ExtractBundle unpacks a zip archive into dir, which must not exist yet.
+// Entries that would land outside dir and symlinks are rejected. On error
+// nothing is left behind.
+func ExtractBundle(r io.ReaderAt, size int64, dir string, limits BundleLimits) (err error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return &InvalidRequestError{Reason: "bundle is not a valid zip archive"}
+	}
+	if limits.MaxFiles > 0 && len(archive.File) > limits.MaxFiles {
+		return &InvalidRequestError{Reason: fmt.Sprintf("bundle has more than %d files", limits.MaxFiles)}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	var total int64
+	for _, f := range archive.File {
+		if f.Mode()&os.ModeSymlink != 0 {
+			return &InvalidRequestError{Reason: "bundle entry " + f.Name + " is a symlink"}
+		}
+		if !filepath.IsLocal(filepath.FromSlash(f.Name)) {
+			return &InvalidRequestError{Reason: "bundle entry " + f.Name + " is outside the bundle"}
+		}
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		n, err := extractFile(f, path, limits.MaxSize-total, limits.MaxSize > 0)
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+	return nil
+}
+
+// extractFile writes one archive entry to path, reading at most limit bytes
+// when limited. The header's size is not trusted.
+func extractFile(f *zip.File, path string, limit int64, limited bool) (int64, error) {
+	src, err := f.Open()
+	if err != nil {
+		return 0, &InvalidRequestError{Reason: "bundle entry " + f.Name + " is corrupt"}
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return 0, &InvalidRequestError{Reason: "bundle entry " + f.Name + " appears twice"}
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	var reader io.Reader = src
+	if limited {
+		reader = io.LimitReader(src, limit+1)
+	}
+	n, err := io.Copy(dst, reader)
+	if err != nil {
+		return n, &InvalidRequestError{Reason: "bundle entry " + f.Name + " is corrupt"}
+	}
+	if limited && n > limit {
+		return n, &InvalidRequestError{Reason: "bundle is too large once extracted"}
+	}
+	return n, dst.Close()
+}
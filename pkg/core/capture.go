@@ -0,0 +1,233 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// Streams of a backend's output. Its stdout and stderr share the output
+// pipe, unless they are mirrored into the hub's log, which tells them apart.
+const (
+	streamOutput = "output"
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// maxMirroredLine bounds the lines mirrored into the hub's log; longer ones
+// are split.
+const maxMirroredLine = 16 << 10
+
+// capture copies the output of a backend from the pipes it writes to into
+// its log. The pipes belong to the hub rather than the process, so they can
+// be handed to a hub taking over and the process never writes to a pipe
+// nobody reads.
+type capture struct {
+	pipes map[string]*os.File
+	log   io.WriteCloser
+	done  chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	detached bool
+}
+
+// newCapture copies pipes, by stream, into log. Unless mirror is empty, the
+// lines are also logged as output of the notebook with that id.
+func newCapture(pipes map[string]*os.File, log io.WriteCloser, mirror string) *capture {
+	c := &capture{pipes: pipes, log: log, done: make(chan struct{})}
+	shared := &lockedWriter{w: log}
+	var wg sync.WaitGroup
+	for stream, pipe := range pipes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if mirror == "" {
+				io.Copy(shared, pipe)
+				return
+			}
+			lines := &lineLogger{notebook: mirror, stream: stream}
+			io.Copy(io.MultiWriter(shared, lines), pipe)
+			lines.flush()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(c.done)
+	}()
+	return c
+}
+
+// close finishes copying once the process exited. The rest of the output is
+// drained for at most outputWaitDelay, in case a child outlived the process
+// and still holds the pipes open. The pipes are left open if they were
+// detached.
+func (c *capture) close() {
+	select {
+	case <-c.done:
+	case <-time.After(outputWaitDelay):
+		for _, pipe := range c.pipes {
+			pipe.SetReadDeadline(time.Now())
+		}
+		<-c.done
+	}
+	c.mu.Lock()
+	if !c.detached {
+		for _, pipe := range c.pipes {
+			pipe.Close()
+		}
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.log.Close()
+}
+
+// detach stops copying and returns the pipes by stream, still open, for
+// another hub to read. It returns nil where the copy can't be interrupted.
+func (c *capture) detach() map[string]*os.File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	for _, pipe := range c.pipes {
+		if pipe.SetReadDeadline(time.Now()) != nil {
+			return nil
+		}
+	}
+	c.detached = true
+	<-c.done
+	return c.pipes
+}
+
+// outputPipes connects the output of the backend spec describes to new
+// pipes, one per stream, and returns their read ends by stream along with
+// the write ends, which the caller closes once the backend started.
+func outputPipes(spec *LaunchSpec, split bool) (map[string]*os.File, []*os.File, error) {
+	streams := []string{streamOutput}
+	if split {
+		streams = []string{streamStdout, streamStderr}
+	}
+	pipes := make(map[string]*os.File, len(streams))
+	var writers []*os.File
+	for _, stream := range streams {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeFiles(pipes)
+			for _, w := range writers {
+				w.Close()
+			}
+			return nil, nil, err
+		}
+		pipes[stream] = r
+		writers = append(writers, w)
+		switch stream {
+		case streamStdout:
+			spec.Stdout = w
+		case streamStderr:
+			spec.Stderr = w
+		default:
+			spec.Stdout = w
+			spec.Stderr = w
+		}
+	}
+	return pipes, writers, nil
+}
+
+func closeFiles(files map[string]*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// outputKey is how the pipe of stream is named when handing it to a hub
+// taking over. The first stream is named after the notebook alone, as by
+// hubs that only had one.
+func outputKey(id, stream string) string {
+	if stream == streamOutput || stream == streamStdout {
+		return id
+	}
+	return id + "/" + stream
+}
+
+// takeOutputs removes the pipes of notebook id from outputs, as handed over
+// by the previous hub, and returns them by stream.
+func takeOutputs(outputs map[string]*os.File, id string) map[string]*os.File {
+	pipes := make(map[string]*os.File)
+	if pipe, ok := outputs[outputKey(id, streamStderr)]; ok {
+		pipes[streamStderr] = pipe
+		delete(outputs, outputKey(id, streamStderr))
+	}
+	if pipe, ok := outputs[id]; ok {
+		if len(pipes) > 0 {
+			pipes[streamStdout] = pipe
+		} else {
+			pipes[streamOutput] = pipe
+		}
+		delete(outputs, id)
+	}
+	return pipes
+}
+
+// lockedWriter serializes the writes of the streams sharing a log.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// lineLogger logs every line written to it as output of a notebook, so the
+// hub's log shows the output of all notebooks interleaved with its own.
+type lineLogger struct {
+	notebook string
+	stream   string
+	buf      []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	start := 0
+	for {
+		i := bytes.IndexByte(l.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		l.logLine(l.buf[start : start+i])
+		start += i + 1
+	}
+	l.buf = append(l.buf[:0], l.buf[start:]...)
+	if len(l.buf) >= maxMirroredLine {
+		l.flush()
+	}
+	return len(p), nil
+}
+
+// flush logs what is left of an unterminated line.
+func (l *lineLogger) flush() {
+	l.logLine(l.buf)
+	l.buf = nil
+}
+
+func (l *lineLogger) logLine(line []byte) {
+	text := strings.TrimRight(string(line), "\r")
+	if text == "" {
+		return
+	}
+	logging.Runner.Info().Str("notebook_id", l.notebook).
+		Str("stream", l.stream).
+		Msg(text)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	changePrefix = "change:"
+	// changeSeqKey holds the sequence number of the last change, which
+	// outlives the changes themselves.
+	changeSeqKey = "changelog:seq"
+	// DefaultChangeRetention is how long changes are kept unless the
+	// registry is told otherwise.
+	DefaultChangeRetention = 7 * 24 * time.Hour
+	// MaxChanges bounds how many changes are read at once.
+	MaxChanges = 1000
+)
+
+// ChangeLog is implemented by registries that keep their events, so that
+// subscribers that were down, sync tools and peers can catch up on the
+// changes they missed.
+type ChangeLog interface {
+	// Changes returns up to limit changes after the one numbered since, in
+	// order.
+	Changes(since uint64, limit int) (ChangesResponse, error)
+}
+
+// badgerChangelog keeps events in the registry's database, each expiring
+// after the retention.
+type badgerChangelog struct {
+	db        *badger.DB
+	retention atomic.Int64
+}
+
+func newBadgerChangelog(db *badger.DB) *badgerChangelog {
+	l := &badgerChangelog{db: db}
+	l.retention.Store(int64(DefaultChangeRetention))
+	return l
+}
+
+// changeKey sorts changes by their sequence number.
+func changeKey(seq uint64) []byte {
+	return fmt.Appendf(nil, "%s%020d", changePrefix, seq)
+}
+
+// last returns the sequence number of the last change appended, or 0.
+func (l *badgerChangelog) last() (uint64, error) {
+	var seq uint64
+	err := l.db.View(func(txn *badger.Txn) error {
+		var err error
+		seq, err = readChangeSeq(txn)
+		return err
+	})
+	return seq, err
+}
+
+func readChangeSeq(txn *badger.Txn) (uint64, error) {
+	item, err := txn.Get([]byte(changeSeqKey))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var seq uint64
+	err = item.Value(func(val []byte) error {
+		seq, err = strconv.ParseUint(string(val), 10, 64)
+		return err
+	})
+	return seq, err
+}
+
+func (l *badgerChangelog) append(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return l.db.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry(changeKey(e.Seq), data)
+		if retention := time.Duration(l.retention.Load()); retention > 0 {
+			entry = entry.WithTTL(retention)
+		}
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+		return txn.Set([]byte(changeSeqKey), strconv.AppendUint(nil, e.Seq, 10))
+	})
+}
+
+func (l *badgerChangelog) Changes(since uint64, limit int) (ChangesResponse, error) {
+	if limit <= 0 || limit > MaxChanges {
+		limit = MaxChanges
+	}
+	resp := ChangesResponse{Changes: []Event{}}
+	err := l.db.View(func(txn *badger.Txn) error {
+		last, err := readChangeSeq(txn)
+		if err != nil {
+			return err
+		}
+		resp.Seq = last
+		// A caller ahead of the log saw changes this database doesn't
+		// have, as after restoring a backup.
+		if since > last {
+			resp.Seq, resp.Truncated = since, true
+			return nil
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(changePrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(changeKey(since + 1)); it.Valid(); it.Next() {
+			if len(resp.Changes) == limit {
+				resp.More = true
+				resp.Seq = resp.Changes[limit-1].Seq
+				break
+			}
+			var e Event
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &e)
+			}); err != nil {
+				return err
+			}
+			resp.Changes = append(resp.Changes, e)
+		}
+		// Changes older than the retention are gone.
+		if since < last && (len(resp.Changes) == 0 || resp.Changes[0].Seq > since+1) {
+			resp.Truncated = true
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// Changes returns the changes after since that are still kept.
+func (r *BadgerRegistry) Changes(since uint64, limit int) (ChangesResponse, error) {
+	return r.changes.Changes(since, limit)
+}
+
+// SetChangeRetention sets how long changes appended from now on are kept.
+// Zero keeps them for good.
+func (r *BadgerRegistry) SetChangeRetention(retention time.Duration) {
+	r.changes.retention.Store(int64(retention))
+}
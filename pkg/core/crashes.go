@@ -0,0 +1,35 @@
+package core
+
+import (
+	"slices"
+	"time"
+)
+
+// maxCrashes is how many crashes the runner remembers.
+const maxCrashes = 50
+
+// Crash is a backend that exited with an error or never became ready.
+type Crash struct {
+	NotebookID string    `json:"notebook_id"`
+	Reason     string    `json:"reason"`
+	Time       time.Time `json:"time"`
+}
+
+func (r *Runner) recordCrash(id, reason string) {
+	r.crashMu.Lock()
+	defer r.crashMu.Unlock()
+	r.crashes = append(r.crashes, Crash{NotebookID: id, Reason: reason, Time: time.Now()})
+	if len(r.crashes) > maxCrashes {
+		r.crashes = slices.Delete(r.crashes, 0, len(r.crashes)-maxCrashes)
+	}
+}
+
+// RecentCrashes returns the last crashes of backends on this runner, newest
+// first.
+func (r *Runner) RecentCrashes() []Crash {
+	r.crashMu.Lock()
+	crashes := slices.Clone(r.crashes)
+	r.crashMu.Unlock()
+	slices.Reverse(crashes)
+	return crashes
+}
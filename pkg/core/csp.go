@@ -0,0 +1,82 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// ContentPolicy sets the Content-Security-Policy of a notebook's
+// HTML pages, such as to let other sites embed it.
+type ContentPolicy struct {
+	// Policy replaces the hub's policy template for the notebook.
+	Policy string `json:"policy,omitempty" validate:"omitempty,max=4096"`
+	// FrameAncestors lists the sources allowed to embed the notebook, such
+	// as https://intranet.example.com. They fill in {{.FrameAncestors}},
+	// or are added as a frame-ancestors directive to policies without it.
+	FrameAncestors []string `json:"frame_ancestors,omitempty" validate:"omitempty,max=50,dive,required,max=200"`
+}
+
+// orNil returns nil for a policy that sets nothing, which is how a request
+// clears it.
+func (p *ContentPolicy) orNil() *ContentPolicy {
+	if p == nil || (p.Policy == "" && len(p.FrameAncestors) == 0) {
+		return nil
+	}
+	return p
+}
+
+// CSPData is what policy templates are rendered with.
+type CSPData struct {
+	// Domain is the domain the page was requested on.
+	Domain string
+	// FrameAncestors are the notebook's frame ancestors separated by
+	// spaces, or 'self'.
+	FrameAncestors string
+}
+
+// cspTemplates caches the parsed policy templates, which are rendered for
+// every HTML response.
+var cspTemplates sync.Map
+
+// RenderCSP renders a policy template, a text/template such as
+// "default-src 'self'; frame-ancestors {{.FrameAncestors}}".
+func RenderCSP(policy string, data CSPData) (string, error) {
+	tmpl, ok := cspTemplates.Load(policy)
+	if !ok {
+		parsed, err := template.New("csp").Parse(policy)
+		if err != nil {
+			return "", err
+		}
+		tmpl, _ = cspTemplates.LoadOrStore(policy, parsed)
+	}
+	var b strings.Builder
+	if err := tmpl.(*template.Template).Execute(&b, data); err != nil {
+		return "", err
+	}
+	// Policies are a single header line.
+	return strings.Join(strings.Fields(b.String()), " "), nil
+}
+
+// CheckCSP reports whether a policy template renders.
+func CheckCSP(policy string) error {
+	_, err := RenderCSP(policy, CSPData{Domain: "example.com", FrameAncestors: "'self'"})
+	return err
+}
+
+// checkCSP rejects notebook policies that don't render and sources that
+// would spill into other directives.
+func checkCSP(p *ContentPolicy) error {
+	if p == nil {
+		return nil
+	}
+	if err := CheckCSP(p.Policy); err != nil {
+		return &InvalidRequestError{Reason: "invalid CSP policy: " + err.Error()}
+	}
+	for _, source := range p.FrameAncestors {
+		if strings.ContainsAny(source, " ;,") {
+			return &InvalidRequestError{Reason: "CSP frame ancestors must be single sources"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"github.com/rekk30/marimo-hub/pkg/metrics"
+)
+
+const gcDiscardRatio = 0.5
+
+type DBStats struct {
+	LSMSize  int64 `json:"lsm_size"`
+	VLogSize int64 `json:"vlog_size"`
+	// Keys counts entries per key prefix, e.g. "notebook".
+	Keys               map[string]int `json:"keys"`
+	Levels             []LevelStats   `json:"levels"`
+	PendingCompactions int            `json:"pending_compactions"`
+	LastGC             *GCResult      `json:"last_gc,omitempty"`
+}
+
+type LevelStats struct {
+	Level      int     `json:"level"`
+	Tables     int     `json:"tables"`
+	Size       int64   `json:"size"`
+	TargetSize int64   `json:"target_size"`
+	Score      float64 `json:"score"`
+}
+
+type GCResult struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	// Rewrites is the number of value log files rewritten.
+	Rewrites int    `json:"rewrites"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (r *BadgerRegistry) Stats() DBStats {
+	lsm, vlog := r.db.Size()
+	stats := DBStats{LSMSize: lsm, VLogSize: vlog, Keys: r.countKeys()}
+
+	for _, l := range r.db.Levels() {
+		stats.Levels = append(stats.Levels, LevelStats{
+			Level:      l.Level,
+			Tables:     l.NumTables,
+			Size:       l.Size,
+			TargetSize: l.TargetSize,
+			Score:      l.Adjusted,
+		})
+		// Badger compacts a level once its adjusted score exceeds 1.
+		if l.Adjusted > 1 {
+			stats.PendingCompactions++
+		}
+	}
+
+	r.gcMu.Lock()
+	if r.lastGC != nil {
+		gc := *r.lastGC
+		stats.LastGC = &gc
+	}
+	r.gcMu.Unlock()
+	return stats
+}
+
+func (r *BadgerRegistry) countKeys() map[string]int {
+	counts := make(map[string]int)
+	_ = r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			prefix, _, _ := strings.Cut(string(it.Item().Key()), ":")
+			counts[prefix]++
+		}
+		return nil
+	})
+	return counts
+}
+
+// RunGC periodically garbage collects the value log until ctx is done.
+func (r *BadgerRegistry) RunGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.runGC()
+		}
+	}
+}
+
+func (r *BadgerRegistry) runGC() {
+	result := GCResult{Time: time.Now()}
+	for {
+		err := r.db.RunValueLogGC(gcDiscardRatio)
+		if err == nil {
+			result.Rewrites++
+			continue
+		}
+		if !errors.Is(err, badger.ErrNoRewrite) {
+			result.Error = err.Error()
+			logging.Registry.Warn().Err(err).Str("method", "BadgerRegistry.runGC").Msg("Value log GC failed")
+		}
+		break
+	}
+	result.Duration = time.Since(result.Time)
+
+	logging.Registry.Debug().Int("rewrites", result.Rewrites).Dur("duration", result.Duration).Msg("Value log GC finished")
+
+	r.gcMu.Lock()
+	r.lastGC = &result
+	r.gcMu.Unlock()
+}
+
+// Collect implements metrics.Collector.
+func (r *BadgerRegistry) Collect() []metrics.Metric {
+	stats := r.Stats()
+	m := []metrics.Metric{
+		{Name: "marimo_hub_db_lsm_size_bytes", Help: "Size of the Badger LSM tree.", Type: metrics.TypeGauge, Value: float64(stats.LSMSize)},
+		{Name: "marimo_hub_db_vlog_size_bytes", Help: "Size of the Badger value log.", Type: metrics.TypeGauge, Value: float64(stats.VLogSize)},
+		{Name: "marimo_hub_db_pending_compactions", Help: "Levels due for compaction.", Type: metrics.TypeGauge, Value: float64(stats.PendingCompactions)},
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(stats.Keys)) {
+		m = append(m, metrics.Metric{Name: "marimo_hub_db_keys", Help: "Keys stored per prefix.", Type: metrics.TypeGauge,
+			Labels: map[string]string{"prefix": prefix}, Value: float64(stats.Keys[prefix])})
+	}
+	for _, l := range stats.Levels {
+		labels := map[string]string{"level": strconv.Itoa(l.Level)}
+		m = append(m,
+			metrics.Metric{Name: "marimo_hub_db_level_size_bytes", Help: "Size of each LSM level.", Type: metrics.TypeGauge, Labels: labels, Value: float64(l.Size)},
+			metrics.Metric{Name: "marimo_hub_db_level_tables", Help: "Tables in each LSM level.", Type: metrics.TypeGauge, Labels: labels, Value: float64(l.Tables)},
+		)
+	}
+	if gc := stats.LastGC; gc != nil {
+		failed := 0.0
+		if gc.Error != "" {
+			failed = 1
+		}
+		m = append(m,
+			metrics.Metric{Name: "marimo_hub_db_gc_last_run_timestamp_seconds", Help: "When value log GC last ran.", Type: metrics.TypeGauge, Value: float64(gc.Time.Unix())},
+			metrics.Metric{Name: "marimo_hub_db_gc_last_rewrites", Help: "Value log files rewritten by the last GC.", Type: metrics.TypeGauge, Value: float64(gc.Rewrites)},
+			metrics.Metric{Name: "marimo_hub_db_gc_last_failed", Help: "Whether the last value log GC failed.", Type: metrics.TypeGauge, Value: failed},
+		)
+	}
+	return m
+}
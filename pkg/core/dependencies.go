@@ -0,0 +1,71 @@
+package core
+
+import (
+	"slices"
+	"strings"
+)
+
+// checkDependencies rejects dependencies of the notebook id that don't exist
+// or would make a notebook wait for itself. id is empty for a notebook being
+// added, which nothing depends on yet.
+func checkDependencies(reg NotebookLister, id string, deps []string) error {
+	if len(deps) == 0 {
+		return nil
+	}
+
+	graph := make(map[string][]string)
+	for _, nb := range reg.List() {
+		graph[nb.ID] = nb.DependsOn
+	}
+	for _, dep := range deps {
+		if dep == id {
+			return &InvalidRequestError{Reason: "notebook cannot depend on itself"}
+		}
+		if _, exists := graph[dep]; !exists {
+			return &InvalidRequestError{Reason: "dependency " + dep + " does not exist"}
+		}
+	}
+	if id == "" {
+		return nil
+	}
+
+	// Any cycle goes through id, since the graph had none before.
+	graph[id] = deps
+	if path := dependencyPath(graph, deps, id, nil, make(map[string]bool)); path != nil {
+		cycle := append([]string{id}, path...)
+		return &InvalidRequestError{Reason: "dependency cycle: " + strings.Join(cycle, " -> ")}
+	}
+	return nil
+}
+
+// dependencyPath returns the chain of dependencies leading from one of from
+// to target, or nil if there is none.
+func dependencyPath(graph map[string][]string, from []string, target string, path []string, seen map[string]bool) []string {
+	for _, id := range from {
+		if id == target {
+			return append(path, id)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if found := dependencyPath(graph, graph[id], target, append(path, id), seen); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// checkNotDependedOn rejects deleting a notebook others still wait for.
+func checkNotDependedOn(reg NotebookLister, id string) error {
+	var dependents []string
+	for _, nb := range reg.List() {
+		if slices.Contains(nb.DependsOn, id) {
+			dependents = append(dependents, nb.ID)
+		}
+	}
+	if len(dependents) > 0 {
+		return &DependedOnError{ID: id, Dependents: dependents}
+	}
+	return nil
+}
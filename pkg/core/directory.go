@@ -0,0 +1,110 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const postgresDirectorySchema = `
+CREATE TABLE IF NOT EXISTS backends (
+	notebook_id TEXT PRIMARY KEY,
+	node_id     TEXT NOT NULL,
+	address     TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+// directoryTimeout bounds each query to the backend directory, so that a
+// stalled database delays publishing but never the notebooks themselves.
+const directoryTimeout = 2 * time.Second
+
+// BackendDirectory publishes where each notebook backend is running so that
+// any node in a cluster can proxy to it.
+type BackendDirectory interface {
+	Advertise(id, address string, status Status) error
+	Withdraw(id string) error
+	// Backends returns every published backend by notebook ID.
+	Backends() (map[string]Backend, error)
+}
+
+// Backend is where a notebook backend runs, as published in the directory.
+type Backend struct {
+	Address string
+	Status  Status
+}
+
+type backendState struct {
+	id      string
+	address string
+	status  Status
+}
+
+// PostgresDirectory stores backend addresses in the shared cluster database.
+type PostgresDirectory struct {
+	db     *sql.DB
+	nodeID string
+}
+
+func NewPostgresDirectory(ctx context.Context, dsn string, nodeID string) (*PostgresDirectory, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, postgresDirectorySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	return &PostgresDirectory{db: db, nodeID: nodeID}, nil
+}
+
+func (d *PostgresDirectory) Close() error {
+	return d.db.Close()
+}
+
+func (d *PostgresDirectory) Advertise(id, address string, status Status) error {
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO backends (notebook_id, node_id, address, status, updated_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (notebook_id) DO UPDATE
+		SET node_id = EXCLUDED.node_id, address = EXCLUDED.address,
+			status = EXCLUDED.status, updated_at = EXCLUDED.updated_at`,
+		id, d.nodeID, address, string(status))
+	return err
+}
+
+// Withdraw removes the entry only if this node still owns it, so a node
+// shutting down can't erase a backend another node has since taken over.
+func (d *PostgresDirectory) Withdraw(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `DELETE FROM backends WHERE notebook_id = $1 AND node_id = $2`, id, d.nodeID)
+	return err
+}
+
+func (d *PostgresDirectory) Backends() (map[string]Backend, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT notebook_id, address, status FROM backends`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backends := make(map[string]Backend)
+	for rows.Next() {
+		var id, address, status string
+		if err := rows.Scan(&id, &address, &status); err != nil {
+			return nil, err
+		}
+		backends[id] = Backend{Address: address, Status: Status(status)}
+	}
+	return backends, rows.Err()
+}
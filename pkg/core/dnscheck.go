@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// dnsCheckTimeout bounds the lookups made to check a domain.
+const dnsCheckTimeout = 5 * time.Second
+
+// DNSCheck checks that notebook domains resolve to the hub, catching
+// notebooks registered before their DNS record exists.
+type DNSCheck struct {
+	// PublicAddress is the IP address or host name clients reach the hub
+	// at. A domain passes if it resolves to any of its addresses.
+	PublicAddress string
+	// Fail rejects domains that don't pass; otherwise they are only
+	// logged.
+	Fail bool
+	// Resolver defaults to net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// Verify is a DomainPolicy check.
+func (d DNSCheck) Verify(domain string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsCheckTimeout)
+	defer cancel()
+
+	want, err := d.lookup(ctx, d.PublicAddress)
+	if err != nil {
+		// The hub's own address not resolving says nothing about the
+		// domain.
+		logging.Registry.Error().Str("method", "DNSCheck.Verify").
+			Str("address", d.PublicAddress).
+			Err(err).
+			Msg("Failed to resolve public address, domain not checked")
+		return nil
+	}
+
+	got, err := d.lookup(ctx, domain)
+	var problem string
+	switch {
+	case err != nil:
+		problem = "does not resolve: " + err.Error()
+	case !slices.ContainsFunc(got, func(addr netip.Addr) bool { return slices.Contains(want, addr) }):
+		problem = "resolves to " + joinAddrs(got) + ", not to the hub at " + d.PublicAddress
+	default:
+		return nil
+	}
+
+	if d.Fail {
+		return &InvalidRequestError{Reason: "domain " + domain + " " + problem}
+	}
+	logging.Registry.Warn().Str("method", "DNSCheck.Verify").
+		Str("domain", domain).
+		Str("problem", problem).
+		Msg("Domain does not point at the hub")
+	return nil
+}
+
+func (d DNSCheck) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	return addrs, nil
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	s := make([]string, len(addrs))
+	for i, addr := range addrs {
+		s[i] = addr.String()
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// NormalizeDomain returns the canonical form notebook domains are stored
+// and looked up under: lowercase ASCII without a trailing dot, with
+// internationalized labels converted to punycode.
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	if domain == "" {
+		return "", nil
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", &InvalidRequestError{Reason: "invalid domain " + domain + ": " + err.Error()}
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// normalizeHost is the lenient form of NormalizeDomain used for lookups,
+// where a host that cannot be converted simply won't match anything.
+func normalizeHost(host string) string {
+	if domain, err := NormalizeDomain(host); err == nil {
+		return domain
+	}
+	return strings.ToLower(host)
+}
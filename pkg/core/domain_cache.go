@@ -0,0 +1,144 @@
+package core
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// DomainResolver maps a request host and path to its notebook.
+type DomainResolver interface {
+	Route(host, path string) (Notebook, bool)
+	List() []Notebook
+}
+
+// DomainCache keeps every route→notebook mapping in memory so the proxy
+// never has to query the registry. It is kept current by registry events.
+// Events may be delivered out of order, so they are only used as a hint to
+// re-read the notebook from the registry.
+type DomainCache struct {
+	mu      sync.RWMutex
+	reg     Registry
+	byRoute map[string]Notebook
+	routes  map[string]string
+	// prefixes holds the path prefixes mounted on each domain, longest
+	// first.
+	prefixes map[string][]string
+}
+
+func NewDomainCache() *DomainCache {
+	return &DomainCache{
+		byRoute:  make(map[string]Notebook),
+		routes:   make(map[string]string),
+		prefixes: make(map[string][]string),
+	}
+}
+
+// Load fills the cache from reg and makes later events authoritative
+// against it.
+func (d *DomainCache) Load(reg Registry) {
+	nbs := reg.List()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reg = reg
+	for _, nb := range nbs {
+		d.setLocked(nb)
+	}
+	logging.Proxy.Debug().Str("method", "DomainCache.Load").Int("notebooks", len(nbs)).Msg("Domain cache loaded")
+}
+
+// GetByDomain returns the notebook mounted at the root of domain.
+func (d *DomainCache) GetByDomain(domain string) (Notebook, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	nb, ok := d.byRoute[routeKey(domain, "")]
+	return nb, ok
+}
+
+// Route returns the notebook serving path on host: the one mounted at the
+// longest prefix of path, or else at the root.
+func (d *DomainCache) Route(host, path string) (Notebook, bool) {
+	domain := normalizeHost(host)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, prefix := range d.prefixes[domain] {
+		if underPrefix(path, prefix) {
+			return d.byRoute[domain+prefix], true
+		}
+	}
+	nb, ok := d.byRoute[domain]
+	return nb, ok
+}
+
+// List returns every cached notebook ordered by name.
+func (d *DomainCache) List() []Notebook {
+	d.mu.RLock()
+	nbs := slices.Collect(maps.Values(d.byRoute))
+	d.mu.RUnlock()
+	slices.SortFunc(nbs, func(a, b Notebook) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.Domain, b.Domain))
+	})
+	return nbs
+}
+
+// HandleEvent makes the cache an event sink.
+func (d *DomainCache) HandleEvent(e Event) error {
+	d.HandleRegistryEvent(e.Notebook, e.Action)
+	return nil
+}
+
+func (d *DomainCache) HandleRegistryEvent(nb Notebook, action RegistryAction) {
+	d.mu.RLock()
+	reg := d.reg
+	d.mu.RUnlock()
+
+	current, exists := nb, action != ActionDelete
+	if reg != nil {
+		current, exists = reg.Get(nb.ID)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removeLocked(nb.ID)
+	if exists {
+		d.setLocked(current)
+	}
+}
+
+func (d *DomainCache) setLocked(nb Notebook) {
+	d.removeLocked(nb.ID)
+	domain := normalizeHost(nb.Domain)
+	key := domain + nb.PathPrefix
+	if _, taken := d.byRoute[key]; !taken && nb.PathPrefix != "" {
+		prefixes := append(d.prefixes[domain], nb.PathPrefix)
+		slices.SortFunc(prefixes, func(a, b string) int { return len(b) - len(a) })
+		d.prefixes[domain] = prefixes
+	}
+	d.byRoute[key] = nb
+	d.routes[nb.ID] = key
+}
+
+func (d *DomainCache) removeLocked(id string) {
+	key, ok := d.routes[id]
+	if !ok {
+		return
+	}
+	delete(d.routes, id)
+	nb := d.byRoute[key]
+	if nb.ID != id {
+		return
+	}
+	delete(d.byRoute, key)
+	if nb.PathPrefix != "" {
+		domain := normalizeHost(nb.Domain)
+		d.prefixes[domain] = slices.DeleteFunc(d.prefixes[domain], func(p string) bool { return p == nb.PathPrefix })
+		if len(d.prefixes[domain]) == 0 {
+			delete(d.prefixes, domain)
+		}
+	}
+}
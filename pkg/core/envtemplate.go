@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// envReference matches the references in a notebook's environment values:
+// ${name} is replaced by the variable name, and $$ by a literal $.
+var envReference = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// secretName is what may follow secret: in a reference, which names a file
+// of the secrets directory.
+var secretName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// envVariables are the variables besides secrets that environment values may
+// refer to.
+var envVariables = []string{"notebook.id", "notebook.name", "notebook.domain", "notebook.path_prefix", "notebook.workspace_id", "host", "port"}
+
+// expandEnvValue replaces the references in value with what lookup returns
+// for them.
+func expandEnvValue(value string, lookup func(name string) (string, error)) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		v, lookupErr := lookup(ref[2 : len(ref)-1])
+		if lookupErr != nil && err == nil {
+			err = lookupErr
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// checkEnvTemplates rejects environment values referring to variables that
+// don't exist. Secrets are only read when the notebook starts.
+func checkEnvTemplates(env map[string]string) error {
+	for key, value := range env {
+		_, err := expandEnvValue(value, func(name string) (string, error) {
+			if secret, ok := strings.CutPrefix(name, "secret:"); ok {
+				if !secretName.MatchString(secret) {
+					return "", fmt.Errorf("invalid secret name %q", secret)
+				}
+				return "", nil
+			}
+			if !slices.Contains(envVariables, name) {
+				return "", fmt.Errorf("unknown variable ${%s}", name)
+			}
+			return "", nil
+		})
+		if err != nil {
+			return &InvalidRequestError{Reason: "env " + key + ": " + err.Error()}
+		}
+	}
+	return nil
+}
+
+// resolveEnv returns the environment of nb with its references resolved,
+// as KEY=value pairs.
+func (m *NotebookManager) resolveEnv(nb Notebook) ([]string, error) {
+	lookup := func(name string) (string, error) {
+		if secret, ok := strings.CutPrefix(name, "secret:"); ok {
+			return m.readSecret(secret)
+		}
+		switch name {
+		case "notebook.id":
+			return nb.ID, nil
+		case "notebook.name":
+			return nb.Name, nil
+		case "notebook.domain":
+			return nb.Domain, nil
+		case "notebook.path_prefix":
+			return nb.PathPrefix, nil
+		case "notebook.workspace_id":
+			return nb.WorkspaceID, nil
+		case "host":
+			return m.host, nil
+		case "port":
+			return strconv.Itoa(m.port), nil
+		}
+		return "", fmt.Errorf("unknown variable ${%s}", name)
+	}
+
+	env := make([]string, 0, len(nb.Env))
+	for key, value := range nb.Env {
+		expanded, err := expandEnvValue(value, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", key, err)
+		}
+		env = append(env, key+"="+expanded)
+	}
+	return env, nil
+}
+
+// readSecret returns the secret stored in the file name of the secrets
+// directory, without a trailing newline.
+func (m *NotebookManager) readSecret(name string) (string, error) {
+	if m.secrets == "" {
+		return "", fmt.Errorf("secret %s: no secrets directory is configured", name)
+	}
+	if !secretName.MatchString(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(m.secrets, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
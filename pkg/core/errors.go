@@ -2,15 +2,59 @@ package core
 
 import (
 	"fmt"
+	"strings"
 )
 
 // Base error types
 type NotFoundError struct {
-	ID string
+	// Resource is the kind of thing that was not found; empty means a
+	// notebook.
+	Resource string
+	ID       string
 }
 
 func (e *NotFoundError) Error() string {
-	return fmt.Sprintf("notebook %s not found", e.ID)
+	resource := e.Resource
+	if resource == "" {
+		resource = "notebook"
+	}
+	return fmt.Sprintf("%s %s not found", resource, e.ID)
+}
+
+type InUseError struct {
+	Field string
+	Value string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("%s %s is already in use", e.Field, e.Value)
+}
+
+type WorkspaceNotEmptyError struct {
+	ID string
+}
+
+func (e *WorkspaceNotEmptyError) Error() string {
+	return fmt.Sprintf("workspace %s still contains notebooks", e.ID)
+}
+
+// DependedOnError is returned when deleting a notebook that others
+// depend on.
+type DependedOnError struct {
+	ID         string
+	Dependents []string
+}
+
+func (e *DependedOnError) Error() string {
+	return fmt.Sprintf("notebook %s is a dependency of %s", e.ID, strings.Join(e.Dependents, ", "))
+}
+
+type InvalidRequestError struct {
+	Reason string
+}
+
+func (e *InvalidRequestError) Error() string {
+	return e.Reason
 }
 
 type AlreadyRunningError struct {
@@ -108,3 +152,39 @@ func (e *ProcessKillError) Error() string {
 func (e *ProcessKillError) Unwrap() error {
 	return e.Err
 }
+
+type SignalError struct {
+	PID    int
+	Signal string
+	Err    error
+}
+
+func (e *SignalError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("failed to %s process %d: %v", e.Signal, e.PID, e.Err)
+	}
+	return fmt.Sprintf("failed to %s process %d", e.Signal, e.PID)
+}
+
+func (e *SignalError) Unwrap() error {
+	return e.Err
+}
+
+type PortsExhaustedError struct {
+	Start int
+	End   int
+}
+
+func (e *PortsExhaustedError) Error() string {
+	return fmt.Sprintf("no free ports left in range %d-%d", e.Start, e.End)
+}
+
+type QuotaExceededError struct {
+	WorkspaceID string
+	Resource    string
+	Limit       int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("workspace %s exceeded its %s quota of %d", e.WorkspaceID, e.Resource, e.Limit)
+}
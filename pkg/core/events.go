@@ -0,0 +1,281 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// eventShards is how many queues each sink's events are spread over. Events
+// of one notebook always go to the same queue, so they stay in order while
+// a slow event for one notebook doesn't hold up the others.
+const eventShards = 16
+
+// Event is a change to a notebook in the registry. Deleted notebooks may
+// only carry their ID. Events replaying the notebooks a registry loaded
+// when it opened aren't changes and carry no sequence number.
+type Event struct {
+	Seq      uint64         `json:"seq"`
+	Time     time.Time      `json:"time"`
+	Action   RegistryAction `json:"action"`
+	Notebook Notebook       `json:"notebook"`
+}
+
+// EventSink receives registry events. Events of one notebook are handled
+// one at a time in the order they happened; events of different notebooks
+// may be handled concurrently.
+type EventSink interface {
+	HandleEvent(e Event) error
+}
+
+// EventSinkFunc adapts a function to an EventSink.
+type EventSinkFunc func(e Event) error
+
+func (f EventSinkFunc) HandleEvent(e Event) error {
+	return f(e)
+}
+
+// EventSource is implemented by registries that components may subscribe
+// to after they opened, such as API clients streaming changes.
+type EventSource interface {
+	Subscribe(sink EventSink) *Subscription
+	Unsubscribe(sub *Subscription)
+}
+
+// EventBus delivers registry events to its sinks. Publishing never blocks:
+// every sink has its own queues, so a slow sink only delays itself. The
+// sinks it is created with stay for good; others come and go through
+// Subscribe and Unsubscribe.
+type EventBus struct {
+	seq    atomic.Uint64
+	mu     sync.Mutex
+	sinks  []*sinkQueues
+	log    eventLog
+	closed bool
+}
+
+// Subscription is a sink subscribed to an EventBus, the handle to
+// unsubscribe it with.
+type Subscription struct {
+	queues *sinkQueues
+	seq    uint64
+}
+
+// Seq is the sequence number of the last event published before the
+// subscription started. Every later event is delivered to it; subscribers
+// catch up on earlier ones from the registry's ChangeLog.
+func (s *Subscription) Seq() uint64 {
+	return s.seq
+}
+
+// eventLog keeps the events published, in order.
+type eventLog interface {
+	append(e Event) error
+}
+
+func NewEventBus(sinks ...EventSink) *EventBus {
+	b := &EventBus{}
+	for _, sink := range sinks {
+		b.sinks = append(b.sinks, newSinkQueues(sink, eventShards))
+	}
+	return b
+}
+
+// Subscribe delivers the events published from now on to sink. Unlike
+// the sinks the bus was created with, it gets them one at a time in the
+// order they were published, whichever notebook they are about.
+func (b *EventBus) Subscribe(sink EventSink) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &Subscription{queues: newSinkQueues(sink, 1), seq: b.seq.Load()}
+	if b.closed {
+		sub.queues.close()
+		return sub
+	}
+	b.sinks = append(b.sinks, sub.queues)
+	return sub
+}
+
+// Unsubscribe stops delivering events to the sink of sub, returning once it
+// has handled those already queued, so it must not be called from the sink
+// itself. Unsubscribing again does nothing.
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	i := slices.Index(b.sinks, sub.queues)
+	if i >= 0 {
+		b.sinks = slices.Delete(b.sinks, i, i+1)
+	}
+	b.mu.Unlock()
+	if i >= 0 {
+		sub.queues.close()
+	}
+}
+
+// record appends the events published from now on to log, numbering them
+// after seq, the last one it holds.
+func (b *EventBus) record(log eventLog, seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.log = log
+	b.seq.Store(seq)
+}
+
+// Publish queues an event for every sink.
+func (b *EventBus) Publish(nb Notebook, action RegistryAction) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := Event{Seq: b.seq.Add(1), Time: time.Now(), Action: action, Notebook: nb}
+	if b.log != nil {
+		if err := b.log.append(e); err != nil {
+			logging.Registry.Error().Str("method", "EventBus.Publish").
+				Uint64("seq", e.Seq).
+				Str("notebook", nb.ID).
+				Err(err).
+				Msg("Failed to record event")
+		}
+	}
+	b.publishLocked(e)
+}
+
+// replay queues an event for a notebook loaded when the registry opened,
+// which isn't recorded.
+func (b *EventBus) replay(nb Notebook) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publishLocked(Event{Time: time.Now(), Action: ActionAdd, Notebook: nb})
+}
+
+// publishLocked must be called with b.mu held.
+func (b *EventBus) publishLocked(e Event) {
+	logging.Registry.Debug().Str("method", "EventBus.Publish").
+		Uint64("seq", e.Seq).
+		Str("notebook", e.Notebook.ID).
+		Interface("action", e.Action).
+		Int("sinks", len(b.sinks)).
+		Msg("Publishing event")
+	for _, sink := range b.sinks {
+		sink.push(e)
+	}
+}
+
+// Close stops delivery once every queued event has been handled.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	sinks := b.sinks
+	b.sinks = nil
+	b.closed = true
+	b.mu.Unlock()
+	for _, sink := range sinks {
+		sink.close()
+	}
+}
+
+// sinkQueues feeds one sink from a queue per shard.
+type sinkQueues struct {
+	sink   EventSink
+	shards []*eventQueue
+	wg     sync.WaitGroup
+}
+
+func newSinkQueues(sink EventSink, shards int) *sinkQueues {
+	s := &sinkQueues{sink: sink, shards: make([]*eventQueue, shards)}
+	for i := range s.shards {
+		q := &eventQueue{ready: make(chan struct{}, 1)}
+		s.shards[i] = q
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			q.run(s.deliver)
+		}()
+	}
+	return s
+}
+
+func (s *sinkQueues) push(e Event) {
+	h := fnv.New32a()
+	h.Write([]byte(e.Notebook.ID))
+	s.shards[h.Sum32()%uint32(len(s.shards))].push(e)
+}
+
+func (s *sinkQueues) close() {
+	for _, q := range s.shards {
+		q.close()
+	}
+	s.wg.Wait()
+}
+
+// deliver hands e to the sink, which failing or panicking only loses that
+// event.
+func (s *sinkQueues) deliver(e Event) {
+	defer func() {
+		if v := recover(); v != nil {
+			logging.Registry.Error().Str("method", "EventBus.deliver").
+				Str("sink", fmt.Sprintf("%T", s.sink)).
+				Uint64("seq", e.Seq).
+				Str("notebook", e.Notebook.ID).
+				Interface("panic", v).
+				Msg("Event sink panicked")
+		}
+	}()
+	if err := s.sink.HandleEvent(e); err != nil {
+		logging.Registry.Error().Str("method", "EventBus.deliver").
+			Str("sink", fmt.Sprintf("%T", s.sink)).
+			Uint64("seq", e.Seq).
+			Str("notebook", e.Notebook.ID).
+			Err(err).
+			Msg("Event sink failed")
+	}
+}
+
+// eventQueue is an unbounded FIFO drained by one goroutine.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []Event
+	closed bool
+	ready  chan struct{}
+}
+
+func (q *eventQueue) push(e Event) {
+	q.mu.Lock()
+	q.events = append(q.events, e)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) run(deliver func(Event)) {
+	for {
+		q.mu.Lock()
+		events, closed := q.events, q.closed
+		q.events = nil
+		q.mu.Unlock()
+
+		for _, e := range events {
+			deliver(e)
+		}
+		if closed && len(events) == 0 {
+			return
+		}
+		if len(events) == 0 {
+			<-q.ready
+		}
+	}
+}
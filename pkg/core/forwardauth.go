@@ -0,0 +1,27 @@
+package core
+
+// ForwardAuth has the proxy ask an external service whether to let each
+// request to a notebook through, the way Traefik's forward-auth does. The
+// service gets a GET with the request's headers and X-Forwarded-Method,
+// -Proto, -Host, -Uri and -For describing it. A 2xx answer lets the request
+// through; any other answer, such as a redirect to a login page, is sent
+// back to the visitor instead.
+type ForwardAuth struct {
+	URL string `json:"url,omitempty" validate:"omitempty,http_url,max=2048"`
+	// ResponseHeaders are copied from the service's 2xx answer onto the
+	// request to the notebook, replacing whatever the visitor sent, such
+	// as the name of the user it logged in.
+	ResponseHeaders []string `json:"response_headers,omitempty" validate:"omitempty,max=20,dive,required,max=100"`
+	// Timeout is how many seconds the service may take to answer. Zero
+	// uses 10.
+	Timeout int `json:"timeout,omitempty" validate:"gte=0"`
+}
+
+// orNil returns nil for forward-auth without a URL, which is how a request
+// clears it.
+func (a *ForwardAuth) orNil() *ForwardAuth {
+	if a == nil || a.URL == "" {
+		return nil
+	}
+	return a
+}
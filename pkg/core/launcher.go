@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// errSuspendUnsupportedBackend is returned for backends whose launcher
+// can't pause them.
+var errSuspendUnsupportedBackend = errors.New("the backend of this notebook can't be suspended")
+
+// Launcher starts the backends serving notebooks. The runner decides when a
+// notebook runs and watches the Process its launcher returns, so backends
+// run as containers or by remote agents only need a launcher of their own.
+// ExecLauncher, the default, runs marimo as a child process.
+type Launcher interface {
+	// Start launches the backend described by spec. The backend is stopped
+	// once ctx is done.
+	Start(ctx context.Context, spec LaunchSpec) (Process, error)
+}
+
+// LaunchSpec describes the backend of a notebook.
+type LaunchSpec struct {
+	Notebook Notebook
+	// File is the notebook file to run, and Dir, for a directory-backed
+	// app, the directory to run it in.
+	File string
+	Dir  string
+	// Host and Port are where the backend listens.
+	Host string
+	Port int
+	// Env is added to the backend's environment, with secrets resolved.
+	Env []string
+	// Stdout and Stderr, if not nil, receive the backend's output. They
+	// are the same file unless the streams are captured apart.
+	Stdout *os.File
+	Stderr *os.File
+}
+
+// Process is a running backend.
+type Process interface {
+	// Address is the host:port the proxy dials to reach the backend.
+	Address() string
+	// Pid is the pid of a local process, or 0 for backends that aren't
+	// processes of this host. Only local processes have pid files, are
+	// adopted across upgrades and count towards memory quotas.
+	Pid() int
+	// Stop kills the backend along with everything it started.
+	Stop() error
+	// Wait blocks until the backend exits, returning an error if it
+	// failed. The runner learns that a backend stopped running from it.
+	Wait() error
+}
+
+// Suspender is implemented by processes that can be paused without losing
+// their state.
+type Suspender interface {
+	Suspend() error
+	Resume() error
+}
+
+// ExecLauncher runs each backend as a child process of the hub, in a
+// process group of its own, using the notebook's runtime or marimo.
+type ExecLauncher struct{}
+
+func (ExecLauncher) Start(ctx context.Context, spec LaunchSpec) (Process, error) {
+	runtime := spec.Notebook.Runtime
+	if runtime == "" {
+		runtime = "marimo"
+	}
+
+	cmd := exec.CommandContext(ctx, runtime, "run", spec.File,
+		"--port", fmt.Sprintf("%d", spec.Port),
+		"--host", spec.Host,
+		"--headless",
+		"--no-token")
+	// A directory-backed app imports its helpers and reads its data
+	// relative to its own directory.
+	cmd.Dir = spec.Dir
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return signalGroup(cmd.Process, syscall.SIGKILL)
+	}
+	if spec.Notebook.Watch {
+		cmd.Args = append(cmd.Args, "--watch")
+	}
+	if spec.Notebook.ShowCode {
+		cmd.Args = append(cmd.Args, "--include-code")
+	}
+	// The proxy forwards paths as they are, so a notebook under a prefix
+	// serves its routes and links below it.
+	if spec.Notebook.PathPrefix != "" {
+		cmd.Args = append(cmd.Args, "--base-url", spec.Notebook.PathPrefix)
+	}
+	if len(spec.Env) > 0 {
+		cmd.Env = append(os.Environ(), spec.Env...)
+	}
+	if spec.Stdout != nil {
+		cmd.Stdout = spec.Stdout
+	}
+	if spec.Stderr != nil {
+		cmd.Stderr = spec.Stderr
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, &ExecError{Command: runtime + " run", Err: err}
+	}
+	return &localProcess{proc: cmd.Process, addr: dialAddress(spec.Host, spec.Port), wait: cmd.Wait}, nil
+}
+
+// localProcess is a backend running as a process of this host, started by
+// ExecLauncher or adopted from the hub this one took over from.
+type localProcess struct {
+	proc *os.Process
+	addr string
+	wait func() error
+}
+
+// adoptedProcess wraps proc, which is not a child of this process, so that
+// exiting is noticed by polling.
+func adoptedProcess(proc *os.Process, addr string) *localProcess {
+	return &localProcess{proc: proc, addr: addr, wait: func() error {
+		ticker := time.NewTicker(adoptedPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !processAlive(proc) {
+				break
+			}
+		}
+		return nil
+	}}
+}
+
+func (p *localProcess) Address() string {
+	return p.addr
+}
+
+func (p *localProcess) Pid() int {
+	return p.proc.Pid
+}
+
+// Stop kills the whole group, the kernels included. This also works while
+// the group is suspended.
+func (p *localProcess) Stop() error {
+	return signalGroup(p.proc, syscall.SIGKILL)
+}
+
+func (p *localProcess) Wait() error {
+	return p.wait()
+}
+
+func (p *localProcess) Suspend() error {
+	return suspendProcess(p.proc)
+}
+
+func (p *localProcess) Resume() error {
+	return resumeProcess(p.proc)
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// LeaderElector decides which hub in a cluster schedules notebook processes.
+type LeaderElector interface {
+	// Run blocks until ctx is done, calling onChange whenever this node gains
+	// or loses leadership.
+	Run(ctx context.Context, onChange func(isLeader bool))
+	// Leader returns the advertised proxy address of the current leader.
+	Leader() (string, bool)
+}
+
+// StandaloneElector is used when clustering is disabled: the single node is
+// always the leader.
+type StandaloneElector struct{}
+
+func (StandaloneElector) Run(ctx context.Context, onChange func(isLeader bool)) {
+	onChange(true)
+	<-ctx.Done()
+}
+
+func (StandaloneElector) Leader() (string, bool) {
+	return "", false
+}
+
+// PostgresElector implements lease-based leader election on the hub_leader
+// table. The leader renews its lease every ttl/3; other nodes take over once
+// the lease has expired.
+type PostgresElector struct {
+	db      *sql.DB
+	nodeID  string
+	address string
+	ttl     time.Duration
+
+	mu       sync.RWMutex
+	isLeader bool
+	leader   string
+}
+
+func NewPostgresElector(db *sql.DB, nodeID, address string, ttl time.Duration) *PostgresElector {
+	return &PostgresElector{
+		db:      db,
+		nodeID:  nodeID,
+		address: address,
+		ttl:     ttl,
+	}
+}
+
+func (e *PostgresElector) Run(ctx context.Context, onChange func(isLeader bool)) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx, onChange)
+
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *PostgresElector) Leader() (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader, e.leader != ""
+}
+
+func (e *PostgresElector) campaign(ctx context.Context, onChange func(isLeader bool)) {
+	_, err := e.db.ExecContext(ctx, `
+		INSERT INTO hub_leader (id, node_id, address, expires_at)
+		VALUES (1, $1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (id) DO UPDATE
+		SET node_id = EXCLUDED.node_id, address = EXCLUDED.address, expires_at = EXCLUDED.expires_at
+		WHERE hub_leader.node_id = EXCLUDED.node_id OR hub_leader.expires_at < now()`,
+		e.nodeID, e.address, e.ttl.Seconds())
+	if err != nil {
+		logging.Runner.Warn().Err(err).Str("method", "PostgresElector.campaign").Msg("Failed to renew leader lease")
+		e.setState(false, "", onChange)
+		return
+	}
+
+	var nodeID, address string
+	err = e.db.QueryRowContext(ctx, `SELECT node_id, address FROM hub_leader WHERE id = 1`).Scan(&nodeID, &address)
+	if err != nil {
+		logging.Runner.Warn().Err(err).Str("method", "PostgresElector.campaign").Msg("Failed to read leader lease")
+		e.setState(false, "", onChange)
+		return
+	}
+
+	e.setState(nodeID == e.nodeID, address, onChange)
+}
+
+func (e *PostgresElector) setState(isLeader bool, leader string, onChange func(isLeader bool)) {
+	e.mu.Lock()
+	changed := e.isLeader != isLeader
+	e.isLeader = isLeader
+	e.leader = leader
+	e.mu.Unlock()
+
+	if changed {
+		logging.Runner.Info().Str("node", e.nodeID).Bool("leader", isLeader).Msg("Leadership changed")
+		onChange(isLeader)
+	}
+}
+
+func (e *PostgresElector) resign() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := e.db.ExecContext(ctx, `DELETE FROM hub_leader WHERE id = 1 AND node_id = $1`, e.nodeID); err != nil {
+		logging.Runner.Warn().Err(err).Str("method", "PostgresElector.resign").Msg("Failed to release leader lease")
+	}
+}
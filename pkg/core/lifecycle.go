@@ -0,0 +1,191 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// defaultHookTimeout bounds hooks that don't set their own timeout.
+const defaultHookTimeout = time.Minute
+
+// hookReasonOutput is how much of a failed hook's output ends up in the
+// notebook's error reason; the rest is in its process log.
+const hookReasonOutput = 200
+
+// LifecycleHooks are commands run around a notebook's process. They run in
+// the notebook's directory with its environment, plus MARIMO_HUB_NOTEBOOK_ID
+// and MARIMO_HUB_HOOK naming the notebook and the hook.
+type LifecycleHooks struct {
+	// PreStart runs before every start of the process, such as to refresh
+	// the data it reads.
+	PreStart *HookCommand `json:"pre_start,omitempty"`
+	// PostStop runs once the process has exited, whether it was stopped,
+	// restarted or crashed.
+	PostStop *HookCommand `json:"post_stop,omitempty"`
+}
+
+// HookCommand is a command run without a shell.
+type HookCommand struct {
+	Command []string `json:"command" validate:"required,min=1,dive,required"`
+	// Timeout is how many seconds the command may run before it is killed.
+	// Zero uses one minute.
+	Timeout int `json:"timeout,omitempty" validate:"gte=0"`
+	// Required pre-start hooks fail the start when they fail; otherwise
+	// failing hooks are only logged.
+	Required bool `json:"required,omitempty"`
+}
+
+// orNil returns nil for hooks without any command, which is how a request
+// clears them.
+func (h *LifecycleHooks) orNil() *LifecycleHooks {
+	if h == nil || (h.PreStart == nil && h.PostStop == nil) {
+		return nil
+	}
+	return h
+}
+
+// runHook runs hook for nb and writes its output to the notebook's process
+// log.
+func (m *NotebookManager) runHook(ctx context.Context, name string, hook *HookCommand, nb Notebook) error {
+	timeout := defaultHookTimeout
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	file, dir := notebookEntry(nb.LocalPath(m.dir))
+	if dir == "" {
+		dir = filepath.Dir(file)
+	}
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Dir = dir
+	env, err := m.resolveEnv(nb)
+	if err != nil {
+		return &ExecError{Command: name + " hook", Err: err}
+	}
+	cmd.Env = append(os.Environ(), "MARIMO_HUB_NOTEBOOK_ID="+nb.ID, "MARIMO_HUB_HOOK="+name)
+	cmd.Env = append(cmd.Env, env...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return signalGroup(cmd.Process, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = outputWaitDelay
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	started := time.Now()
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	m.logHookOutput(nb.ID, name, output.Bytes())
+
+	if err != nil && ctx.Err() == context.Canceled {
+		logging.Runner.Debug().Str("method", "NotebookManager.runHook").
+			Str("notebook", nb.ID).
+			Str("hook", name).
+			Msg("Hook cancelled")
+		return err
+	}
+	if err != nil {
+		out := strings.TrimSpace(output.String())
+		if len(out) > hookReasonOutput {
+			out = "..." + out[len(out)-hookReasonOutput:]
+		}
+		logging.Runner.Warn().Str("method", "NotebookManager.runHook").
+			Str("notebook", nb.ID).
+			Str("hook", name).
+			Str("output", out).
+			Err(err).
+			Msg("Hook failed")
+		if out != "" {
+			err = fmt.Errorf("%w: %s", err, out)
+		}
+		return &ExecError{Command: name + " hook", Err: err}
+	}
+	logging.Runner.Info().Str("method", "NotebookManager.runHook").
+		Str("notebook", nb.ID).
+		Str("hook", name).
+		Dur("duration", time.Since(started)).
+		Msg("Hook succeeded")
+	return nil
+}
+
+// logHookOutput appends the output of a hook to the notebook's process log,
+// and mirrors it into the hub's log as the stream named after the hook.
+func (m *NotebookManager) logHookOutput(id, name string, output []byte) {
+	if m.mirror {
+		lines := &lineLogger{notebook: id, stream: name}
+		lines.Write(output)
+		lines.flush()
+	}
+	if m.output == nil || len(output) == 0 {
+		return
+	}
+	w, err := m.output.Open(id)
+	if err != nil {
+		logging.Runner.Warn().Str("method", "NotebookManager.logHookOutput").
+			Str("notebook", id).
+			Err(err).
+			Msg("Failed to open process log, hook output is discarded")
+		return
+	}
+	defer w.Close()
+	w.Write(output)
+}
+
+// startAfterHook runs the pre-start hook of nb and then starts the process,
+// unless the notebook was stopped meanwhile. Only a failing required hook
+// keeps it from starting.
+func (m *NotebookManager) startAfterHook(ctx context.Context, nb Notebook) {
+	hook := nb.Hooks.PreStart
+	err := m.runHook(ctx, "pre_start", hook, nb)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	m.hookCancel()
+	m.hookCancel = nil
+
+	if err != nil && hook.Required {
+		m.reason = err.Error()
+		m.setStatus(StatusError)
+		if m.failed != nil {
+			m.failed(nb.ID, m.reason)
+		}
+		logging.Runner.Error().Str("method", "NotebookManager.startAfterHook").
+			Str("notebook", nb.ID).
+			Err(err).
+			Msg("Notebook failed to start")
+		return
+	}
+	if err := m.launchLocked(); err != nil {
+		logging.Runner.Error().Str("method", "NotebookManager.startAfterHook").
+			Str("notebook", nb.ID).
+			Err(err).
+			Msg("Failed to start notebook")
+	}
+}
+
+// postStop runs the post-stop hook of nb, if any, in the background. It
+// isn't tied to the runner's context, so it also runs when the hub shuts
+// notebooks down.
+func (m *NotebookManager) postStop(nb Notebook) {
+	if nb.Hooks == nil || nb.Hooks.PostStop == nil {
+		return
+	}
+	go m.runHook(context.Background(), "post_stop", nb.Hooks.PostStop, nb)
+}
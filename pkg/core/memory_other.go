@@ -0,0 +1,10 @@
+//go:build !linux
+
+package core
+
+import "errors"
+
+// processRSS is only implemented on Linux.
+func processRSS(pid int) (int64, error) {
+	return 0, errors.New("process memory is not available on this platform")
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+const orphanGracePeriod = 5 * time.Second
+
+// ReapOrphans terminates notebook processes left behind by a previous hub
+// that crashed, so they do not hold on to ports this runner hands out. It
+// must run before any notebook is started. Candidates come from the pid
+// files the runner writes and, where supported, from the process table; a
+// process is only killed if its command line still looks like one of our
+// backends on a port in our range.
+func (r *Runner) ReapOrphans() int {
+	return r.reapOrphans(nil)
+}
+
+// reapOrphans is ReapOrphans sparing the processes in keep and their pid
+// files.
+func (r *Runner) reapOrphans(keep map[int]bool) int {
+	candidates := make(map[int]bool)
+
+	if r.stateDir != "" {
+		files, _ := filepath.Glob(filepath.Join(r.stateDir, "*.pid"))
+		for _, file := range files {
+			state, err := readProcessState(file)
+			if err == nil && keep[state.PID] {
+				continue
+			}
+			if err == nil {
+				candidates[state.PID] = true
+			}
+			os.Remove(file)
+		}
+	}
+
+	pids, err := listPIDs()
+	if err != nil {
+		logging.Runner.Warn().Err(err).Str("method", "Runner.ReapOrphans").Msg("Failed to scan processes")
+	}
+	for _, pid := range pids {
+		candidates[pid] = true
+	}
+
+	reaped := 0
+	for pid := range candidates {
+		if pid == os.Getpid() || keep[pid] {
+			continue
+		}
+		args, err := processArgs(pid)
+		if err != nil || !r.isBackend(args) {
+			continue
+		}
+		logging.Runner.Warn().Str("method", "Runner.ReapOrphans").
+			Int("pid", pid).
+			Str("command", strings.Join(args, " ")).
+			Msg("Terminating orphaned notebook process")
+		if err := terminate(pid); err != nil {
+			logging.Runner.Error().Err(err).Int("pid", pid).Msg("Failed to terminate orphaned process")
+			continue
+		}
+		reaped++
+	}
+	return reaped
+}
+
+// isBackend reports whether args is a notebook process started by a runner
+// with this runner's port range.
+func (r *Runner) isBackend(args []string) bool {
+	if !slices.Contains(args, "run") || !slices.Contains(args, "--headless") {
+		return false
+	}
+	i := slices.Index(args, "--port")
+	if i < 0 || i+1 >= len(args) {
+		return false
+	}
+	port, err := strconv.Atoi(args[i+1])
+	return err == nil && port >= r.ports.start && port <= r.ports.end
+}
+
+// terminate stops pid and the kernels in its process group, giving them a
+// grace period to exit cleanly.
+func terminate(pid int) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := signalGroup(proc, syscall.SIGTERM); err != nil {
+		return signalGroup(proc, syscall.SIGKILL)
+	}
+	// A suspended orphan only acts on SIGTERM once it is continued.
+	resumeProcess(proc)
+
+	deadline := time.Now().Add(orphanGracePeriod)
+	for time.Now().Before(deadline) {
+		if !processAlive(proc) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return signalGroup(proc, syscall.SIGKILL)
+}
+
+// Adopt takes over the notebook processes recorded in the state directory
+// by a hub that handed its listeners to this one, instead of terminating
+// them as ReapOrphans does. It must run before any notebook is started.
+// outputs are the pipes returned by Release in the previous hub; the ones
+// of processes that can't be adopted are closed. Processes that can't be
+// adopted are reaped like orphans.
+func (r *Runner) Adopt(outputs map[string]*os.File) int {
+	keep := make(map[int]bool)
+	if r.stateDir != "" {
+		files, _ := filepath.Glob(filepath.Join(r.stateDir, "*.pid"))
+		for _, file := range files {
+			state, err := readProcessState(file)
+			if err != nil || state.Notebook.ID == "" {
+				continue
+			}
+			pipes := takeOutputs(outputs, state.Notebook.ID)
+			if r.adopt(state, pipes) {
+				keep[state.PID] = true
+			} else {
+				closeFiles(pipes)
+			}
+		}
+	}
+	for _, pipe := range outputs {
+		pipe.Close()
+	}
+	if n := r.reapOrphans(keep); n > 0 {
+		logging.Runner.Warn().Int("processes", n).Str("method", "Runner.Adopt").Msg("Terminated notebook processes that could not be adopted")
+	}
+	return len(keep)
+}
+
+func (r *Runner) adopt(state processState, pipes map[string]*os.File) bool {
+	proc, err := os.FindProcess(state.PID)
+	if err != nil || !processAlive(proc) {
+		return false
+	}
+	// Where the process table can be read, make sure the pid wasn't
+	// reused by something else.
+	if args, err := processArgs(state.PID); err == nil && !r.isBackend(args) {
+		return false
+	}
+	if !r.ports.reserve(state.Port) {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manager := r.newManagerLocked(state.Notebook, state.Port)
+	manager.adopt(proc, pipes)
+	logging.Runner.Info().Str("method", "Runner.Adopt").
+		Str("notebook", state.Notebook.ID).
+		Int("pid", state.PID).
+		Int("port", state.Port).
+		Msg("Adopted notebook process")
+	return true
+}
+
+// processState is what the pid file of a running notebook records, enough
+// for a hub taking over to adopt the process.
+type processState struct {
+	PID      int      `json:"pid"`
+	Port     int      `json:"port"`
+	Notebook Notebook `json:"notebook"`
+}
+
+// readProcessState reads a pid file, which older hubs wrote holding only
+// the pid.
+func readProcessState(file string) (processState, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return processState{}, err
+	}
+	var state processState
+	if err := json.Unmarshal(data, &state); err == nil {
+		return state, nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return processState{PID: pid}, err
+}
+
+func (m *NotebookManager) pidFile() string {
+	return filepath.Join(m.stateDir, m.notebook.ID+".pid")
+}
+
+// writePIDFile records the running process, if it is local. Must hold
+// m.mu.
+func (m *NotebookManager) writePIDFile() {
+	if m.stateDir == "" || m.proc.Pid() == 0 {
+		return
+	}
+	data, err := json.Marshal(processState{PID: m.proc.Pid(), Port: m.port, Notebook: m.notebook})
+	if err == nil {
+		err = os.WriteFile(m.pidFile(), data, 0o644)
+	}
+	if err != nil {
+		logging.Runner.Warn().Err(err).Str("notebook", m.notebook.ID).Msg("Failed to write pid file")
+	}
+}
+
+// removePIDFile must be called with m.mu held.
+func (m *NotebookManager) removePIDFile() {
+	if m.stateDir == "" {
+		return
+	}
+	os.Remove(m.pidFile())
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ResolvePath resolves a notebook path against the notebooks directory root
+// and returns both its absolute form and its form relative to root. path may
+// be absolute or relative to root; either way it must lie within root once
+// symlinks are resolved. Paths that don't exist yet are checked by their
+// closest existing parent.
+func ResolvePath(root, path string) (abs, rel string, err error) {
+	root = filepath.Clean(root)
+	abs = filepath.Clean(path)
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(root, abs)
+	}
+
+	resolvedRoot, err := resolveExisting(root)
+	if err != nil {
+		return "", "", err
+	}
+	resolved, err := resolveExisting(abs)
+	if err != nil {
+		return "", "", err
+	}
+	if !withinDir(resolvedRoot, resolved) {
+		return "", "", &InvalidRequestError{Reason: "path " + path + " is outside the notebooks directory"}
+	}
+
+	// A path may reach root through the directory it links to rather than
+	// through root itself.
+	if withinDir(root, abs) {
+		rel, err = filepath.Rel(root, abs)
+	} else {
+		rel, err = filepath.Rel(resolvedRoot, resolved)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return abs, rel, nil
+}
+
+// resolveExisting evaluates the symlinks of the longest existing prefix of
+// path and appends the rest unchanged.
+func resolveExisting(path string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path, nil
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) && !filepath.IsAbs(rel)
+}
+
+// appEntry is the file run for a notebook whose path is a directory.
+const appEntry = "app.py"
+
+// CheckNotebookPath rejects a directory that has no app entry point. Paths
+// that don't exist yet are accepted, as they may be deployed later.
+func CheckNotebookPath(path string) error {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(path, appEntry)); err != nil {
+		return &InvalidRequestError{Reason: "directory " + path + " has no " + appEntry}
+	}
+	return nil
+}
+
+// notebookEntry returns the file to run for a notebook at path and, for a
+// directory-backed app, the directory to run it in.
+func notebookEntry(path string) (file, dir string) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return filepath.Join(path, appEntry), path
+	}
+	return path, ""
+}
+
+// LocalPath is where the notebook is found on a node that mounts the
+// notebooks directory at notebooksDir. The relative path is preferred, so
+// hubs that mount the directory elsewhere still find it.
+func (nb Notebook) LocalPath(notebooksDir string) string {
+	if notebooksDir != "" && nb.RelativePath != "" {
+		return filepath.Join(notebooksDir, nb.RelativePath)
+	}
+	return nb.Path
+}
+
+// localDir is the notebook's directory: the one a directory-backed app runs
+// in, or the one holding the notebook file.
+func (nb Notebook) localDir(notebooksDir string) string {
+	path := nb.LocalPath(notebooksDir)
+	if _, dir := notebookEntry(path); dir == "" {
+		path = filepath.Dir(path)
+	}
+	return path
+}
+
+// ErrorPagePath returns the notebook's error page template, or false if it
+// has none. Like assets, it must not lead out of the notebook's directory.
+func (nb Notebook) ErrorPagePath(notebooksDir string) (string, bool) {
+	if nb.ErrorPage == "" {
+		return "", false
+	}
+	dir := nb.localDir(notebooksDir)
+	file, _, err := ResolvePath(dir, filepath.Join(dir, filepath.FromSlash(nb.ErrorPage)))
+	if err != nil {
+		return "", false
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return file, true
+}
+
+// AssetPath returns the file of the notebook's assets folder that name
+// refers to, or false if the notebook has no such asset. Symlinks must not
+// lead out of the folder.
+func (nb Notebook) AssetPath(notebooksDir, name string) (string, bool) {
+	if nb.Assets == "" {
+		return "", false
+	}
+	root := filepath.Join(nb.localDir(notebooksDir), nb.Assets)
+
+	file, _, err := ResolvePath(root, filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", false
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return file, true
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"slices"
+	"sync"
+)
+
+// portPool hands out ports from a fixed range and takes them back when a
+// notebook goes away, so churn doesn't walk the allocator off the end.
+type portPool struct {
+	mu    sync.Mutex
+	start int
+	end   int
+	next  int
+	free  []int
+	inUse map[int]bool
+}
+
+func newPortPool(start, end int) *portPool {
+	return &portPool{
+		start: start,
+		end:   end,
+		next:  start,
+		inUse: make(map[int]bool),
+	}
+}
+
+func (p *portPool) acquire() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n := len(p.free); n > 0 {
+		port := p.free[n-1]
+		p.free = p.free[:n-1]
+		p.inUse[port] = true
+		return port, nil
+	}
+
+	if p.next > p.end {
+		return 0, &PortsExhaustedError{Start: p.start, End: p.end}
+	}
+
+	port := p.next
+	p.next++
+	p.inUse[port] = true
+	return port, nil
+}
+
+// reserve marks a port taken by a process that was adopted rather than
+// started, reporting false if it is outside the range or already taken.
+func (p *portPool) reserve(port int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if port < p.start || port > p.end || p.inUse[port] {
+		return false
+	}
+	if i := slices.Index(p.free, port); i >= 0 {
+		p.free = slices.Delete(p.free, i, i+1)
+	}
+	for p.next <= port {
+		if p.next != port {
+			p.free = append(p.free, p.next)
+		}
+		p.next++
+	}
+	p.inUse[port] = true
+	return true
+}
+
+func (p *portPool) release(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.inUse[port] {
+		return
+	}
+	delete(p.inUse, port)
+	p.free = append(p.free, port)
+}
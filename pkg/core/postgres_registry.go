@@ -0,0 +1,715 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS notebooks (
+	id      TEXT PRIMARY KEY,
+	domain  TEXT NOT NULL UNIQUE,
+	data    JSONB NOT NULL,
+	version BIGINT NOT NULL DEFAULT 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS notebooks_domain_lower ON notebooks (lower(domain));
+CREATE TABLE IF NOT EXISTS workspaces (
+	id   TEXT PRIMARY KEY,
+	data JSONB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS users (
+	id       TEXT PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	data     JSONB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tokens (
+	id   TEXT PRIMARY KEY,
+	data JSONB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS hub_leader (
+	id         INT PRIMARY KEY,
+	node_id    TEXT NOT NULL,
+	address    TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+`
+
+// PostgresRegistry stores notebooks in a Postgres database shared by every
+// hub in a cluster. Changes made by other nodes are picked up by polling.
+type PostgresRegistry struct {
+	db     *sql.DB
+	events *EventBus
+	cancel context.CancelFunc
+	policy DomainPolicy
+
+	mu       sync.Mutex
+	versions map[string]int64
+}
+
+func NewPostgresRegistry(ctx context.Context, dsn string, pollInterval time.Duration, sinks ...EventSink) (*PostgresRegistry, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	reg := &PostgresRegistry{
+		db:       db,
+		events:   NewEventBus(sinks...),
+		cancel:   cancel,
+		versions: make(map[string]int64),
+	}
+
+	if err := reg.poll(ctx); err != nil {
+		cancel()
+		db.Close()
+		return nil, fmt.Errorf("failed to load existing notebooks: %w", err)
+	}
+	go reg.watch(ctx, pollInterval)
+
+	return reg, nil
+}
+
+// DB exposes the underlying connection pool so cluster components can share it.
+func (r *PostgresRegistry) DB() *sql.DB {
+	return r.db
+}
+
+// Verify checks that the database can be reached.
+func (r *PostgresRegistry) Verify(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	return nil
+}
+
+// Now reads the database's clock.
+func (r *PostgresRegistry) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	err := r.db.QueryRowContext(ctx, "SELECT now()").Scan(&now)
+	return now, err
+}
+
+// Subscribe delivers the registry's changes from now on to sink.
+func (r *PostgresRegistry) Subscribe(sink EventSink) *Subscription {
+	return r.events.Subscribe(sink)
+}
+
+func (r *PostgresRegistry) Unsubscribe(sub *Subscription) {
+	r.events.Unsubscribe(sub)
+}
+
+func (r *PostgresRegistry) Close() error {
+	r.cancel()
+	r.events.Close()
+	return r.db.Close()
+}
+
+func (r *PostgresRegistry) SetDomainPolicy(policy DomainPolicy) {
+	policy.BaseDomain = normalizeBaseDomain(policy.BaseDomain)
+	r.policy = policy
+}
+
+func (r *PostgresRegistry) Add(req CreateUpdateNotebookRequest) (Notebook, error) {
+	logging.Registry.Debug().Str("method", "PostgresRegistry.Add").
+		Interface("request", req).Msg("Starting Add operation")
+
+	if req.Name == "" || req.Path == "" || req.Domain == "" {
+		return Notebook{}, &InvalidRequestError{Reason: "name, path, and domain are required for creation"}
+	}
+
+	if err := resolveWorkspace(r, &req, true); err != nil {
+		return Notebook{}, err
+	}
+	if err := normalizeRequestRoute(&req, r.policy.BaseDomain); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkNotebookQuota(r, req.WorkspaceID); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkDependencies(r, "", req.DependsOn); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkEnvTemplates(req.Env); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkUpstream(req.Upstream); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkCSP(req.CSP); err != nil {
+		return Notebook{}, err
+	}
+	if err := r.policy.verify(req.Domain); err != nil {
+		return Notebook{}, err
+	}
+
+	nb := newNotebook(req)
+	data, err := json.Marshal(nb)
+	if err != nil {
+		return Notebook{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.db.Exec(`INSERT INTO notebooks (id, domain, data) VALUES ($1, $2, $3)`, nb.ID, routeKey(nb.Domain, nb.PathPrefix), data)
+	if isUniqueViolation(err) {
+		return Notebook{}, &InUseError{Field: "domain", Value: routeKey(nb.Domain, nb.PathPrefix)}
+	}
+	if err != nil {
+		logging.Registry.Error().Err(err).Str("id", nb.ID).Msg("Failed to store notebook")
+		return Notebook{}, err
+	}
+	r.versions[nb.ID] = 1
+
+	r.events.Publish(nb, ActionAdd)
+
+	logging.Registry.Info().Str("id", nb.ID).Str("domain", nb.Domain).
+		Str("method", "PostgresRegistry.Add").
+		Msg("Successfully added notebook")
+	return nb, nil
+}
+
+func (r *PostgresRegistry) Get(id string) (Notebook, bool) {
+	return r.queryOne(`SELECT data FROM notebooks WHERE id = $1`, id)
+}
+
+func (r *PostgresRegistry) GetByDomain(domain string) (Notebook, bool) {
+	return r.GetByRoute(domain, "")
+}
+
+func (r *PostgresRegistry) GetByRoute(domain, prefix string) (Notebook, bool) {
+	return r.queryOne(`SELECT data FROM notebooks WHERE lower(domain) = $1`, routeKey(domain, prefix))
+}
+
+func (r *PostgresRegistry) List() []Notebook {
+	rows, err := r.db.Query(`SELECT data FROM notebooks ORDER BY id`)
+	if err != nil {
+		logging.Registry.Error().Err(err).Str("method", "PostgresRegistry.List").Msg("Failed to list notebooks")
+		return nil
+	}
+	defer rows.Close()
+
+	var notebooks []Notebook
+	for rows.Next() {
+		var data []byte
+		var nb Notebook
+		if err := rows.Scan(&data); err != nil {
+			logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.List").Msg("Failed to scan notebook")
+			continue
+		}
+		if err := json.Unmarshal(data, &nb); err != nil {
+			logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.List").Msg("Failed to unmarshal notebook")
+			continue
+		}
+		notebooks = append(notebooks, nb)
+	}
+	return notebooks
+}
+
+func (r *PostgresRegistry) Update(id string, req CreateUpdateNotebookRequest) (Notebook, error) {
+	logging.Registry.Debug().Str("method", "PostgresRegistry.Update").
+		Str("id", id).
+		Interface("req", req).
+		Msg("Starting Update operation")
+
+	for attempt := 1; ; attempt++ {
+		nb, err := r.update(id, req)
+		if !errors.Is(err, errVersionConflict) || attempt == storeAttempts {
+			return nb, err
+		}
+		logging.Registry.Debug().Str("method", "PostgresRegistry.Update").
+			Int("attempt", attempt).Msg("Retrying conflicting write")
+	}
+}
+
+// errVersionConflict is returned by update when another node wrote the
+// notebook between reading and writing it.
+var errVersionConflict = errors.New("notebook was changed concurrently")
+
+// update applies req to the notebook as read, writing it only if the row
+// still has the version that was read.
+func (r *PostgresRegistry) update(id string, req CreateUpdateNotebookRequest) (Notebook, error) {
+	nb, read, exists := r.getVersioned(id)
+	if !exists {
+		return Notebook{}, &NotFoundError{ID: id}
+	}
+	if err := resolveUpdateWorkspace(r, nb, &req); err != nil {
+		return Notebook{}, err
+	}
+	if err := normalizeRequestRoute(&req, r.policy.BaseDomain); err != nil {
+		return Notebook{}, err
+	}
+	if req.WorkspaceID != "" && req.WorkspaceID != nb.WorkspaceID {
+		if err := checkNotebookQuota(r, req.WorkspaceID); err != nil {
+			return Notebook{}, err
+		}
+	}
+	if req.DependsOn != nil {
+		if err := checkDependencies(r, id, req.DependsOn); err != nil {
+			return Notebook{}, err
+		}
+	}
+	if err := checkEnvTemplates(req.Env); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkUpstream(req.Upstream); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkCSP(req.CSP); err != nil {
+		return Notebook{}, err
+	}
+	if req.Domain != nb.Domain {
+		if err := r.policy.verify(req.Domain); err != nil {
+			return Notebook{}, err
+		}
+	}
+	if !applyUpdate(&nb, req) {
+		return nb, nil
+	}
+
+	data, err := json.Marshal(nb)
+	if err != nil {
+		return Notebook{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var version int64
+	err = r.db.QueryRow(`UPDATE notebooks SET domain = $2, data = $3, version = version + 1 WHERE id = $1 AND version = $4 RETURNING version`,
+		id, routeKey(nb.Domain, nb.PathPrefix), data, read).Scan(&version)
+	if isUniqueViolation(err) {
+		return Notebook{}, &InUseError{Field: "domain", Value: routeKey(nb.Domain, nb.PathPrefix)}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted or written meanwhile; reading it again tells which.
+		return Notebook{}, errVersionConflict
+	}
+	if err != nil {
+		return Notebook{}, err
+	}
+	r.versions[id] = version
+
+	r.events.Publish(nb, ActionUpdate)
+
+	logging.Registry.Info().Str("id", id).Str("domain", nb.Domain).Msg("Successfully updated notebook")
+	return nb, nil
+}
+
+func (r *PostgresRegistry) Delete(id string) error {
+	logging.Registry.Debug().Str("method", "PostgresRegistry.Delete").Str("id", id).Msg("Starting Delete operation")
+
+	nb, exists := r.Get(id)
+	if !exists {
+		return &NotFoundError{ID: id}
+	}
+	if err := checkNotDependedOn(r, id); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res, err := r.db.Exec(`DELETE FROM notebooks WHERE id = $1`, id)
+	if err != nil {
+		logging.Registry.Error().Err(err).Str("id", id).Msg("Failed to delete notebook")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{ID: id}
+	}
+	delete(r.versions, id)
+
+	r.events.Publish(nb, ActionDelete)
+
+	logging.Registry.Info().Str("id", id).Str("domain", nb.Domain).Msg("Successfully deleted notebook")
+	return nil
+}
+
+func (r *PostgresRegistry) AddWorkspace(req CreateUpdateWorkspaceRequest) (Workspace, error) {
+	if req.Name == "" {
+		return Workspace{}, &InvalidRequestError{Reason: "name is required for creation"}
+	}
+
+	ws := newWorkspace(req)
+	data, err := json.Marshal(ws)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if _, err := r.db.Exec(`INSERT INTO workspaces (id, data) VALUES ($1, $2)`, ws.ID, data); err != nil {
+		return Workspace{}, err
+	}
+
+	logging.Registry.Info().Str("id", ws.ID).Str("name", ws.Name).Msg("Successfully added workspace")
+	return ws, nil
+}
+
+func (r *PostgresRegistry) GetWorkspace(id string) (Workspace, bool) {
+	var data []byte
+	if err := r.db.QueryRow(`SELECT data FROM workspaces WHERE id = $1`, id).Scan(&data); err != nil {
+		return Workspace{}, false
+	}
+	var ws Workspace
+	if err := json.Unmarshal(data, &ws); err != nil {
+		return Workspace{}, false
+	}
+	return ws, true
+}
+
+func (r *PostgresRegistry) ListWorkspaces() []Workspace {
+	rows, err := r.db.Query(`SELECT data FROM workspaces ORDER BY id`)
+	if err != nil {
+		logging.Registry.Error().Err(err).Str("method", "PostgresRegistry.ListWorkspaces").Msg("Failed to list workspaces")
+		return nil
+	}
+	defer rows.Close()
+
+	var workspaces []Workspace
+	for rows.Next() {
+		var data []byte
+		var ws Workspace
+		if err := rows.Scan(&data); err != nil || json.Unmarshal(data, &ws) != nil {
+			logging.Registry.Warn().Str("method", "PostgresRegistry.ListWorkspaces").Msg("Failed to read workspace")
+			continue
+		}
+		workspaces = append(workspaces, ws)
+	}
+	return workspaces
+}
+
+func (r *PostgresRegistry) UpdateWorkspace(id string, req CreateUpdateWorkspaceRequest) (Workspace, error) {
+	ws, exists := r.GetWorkspace(id)
+	if !exists {
+		return Workspace{}, &NotFoundError{Resource: "workspace", ID: id}
+	}
+	if !applyWorkspaceUpdate(&ws, req) {
+		return ws, nil
+	}
+
+	data, err := json.Marshal(ws)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if _, err := r.db.Exec(`UPDATE workspaces SET data = $2 WHERE id = $1`, id, data); err != nil {
+		return Workspace{}, err
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully updated workspace")
+	return ws, nil
+}
+
+func (r *PostgresRegistry) DeleteWorkspace(id string) error {
+	res, err := r.db.Exec(`
+		DELETE FROM workspaces WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM notebooks WHERE data->>'workspace_id' = $1)`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, exists := r.GetWorkspace(id); exists {
+			return &WorkspaceNotEmptyError{ID: id}
+		}
+		return &NotFoundError{Resource: "workspace", ID: id}
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully deleted workspace")
+	return nil
+}
+
+func (r *PostgresRegistry) AddUser(req CreateUpdateUserRequest) (User, error) {
+	if req.Username == "" {
+		return User{}, &InvalidRequestError{Reason: "username is required for creation"}
+	}
+
+	u, err := newUser(req)
+	if err != nil {
+		return User{}, err
+	}
+	data, err := json.Marshal(userRecord{User: u, PasswordHash: u.PasswordHash})
+	if err != nil {
+		return User{}, err
+	}
+	_, err = r.db.Exec(`INSERT INTO users (id, username, data) VALUES ($1, $2, $3)`, u.ID, u.Username, data)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return User{}, &InUseError{Field: "username", Value: req.Username}
+		}
+		return User{}, err
+	}
+
+	logging.Registry.Info().Str("id", u.ID).Str("username", u.Username).Msg("Successfully added user")
+	return u, nil
+}
+
+func (r *PostgresRegistry) GetUser(id string) (User, bool) {
+	return r.queryUser(`SELECT data FROM users WHERE id = $1`, id)
+}
+
+func (r *PostgresRegistry) GetUserByName(username string) (User, bool) {
+	return r.queryUser(`SELECT data FROM users WHERE username = $1`, username)
+}
+
+func (r *PostgresRegistry) ListUsers() []User {
+	rows, err := r.db.Query(`SELECT data FROM users ORDER BY username`)
+	if err != nil {
+		logging.Registry.Error().Err(err).Str("method", "PostgresRegistry.ListUsers").Msg("Failed to list users")
+		return nil
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var data []byte
+		var rec userRecord
+		if err := rows.Scan(&data); err != nil || json.Unmarshal(data, &rec) != nil {
+			logging.Registry.Warn().Str("method", "PostgresRegistry.ListUsers").Msg("Failed to read user")
+			continue
+		}
+		rec.User.PasswordHash = rec.PasswordHash
+		users = append(users, rec.User)
+	}
+	return users
+}
+
+func (r *PostgresRegistry) UpdateUser(id string, req CreateUpdateUserRequest) (User, error) {
+	u, exists := r.GetUser(id)
+	if !exists {
+		return User{}, &NotFoundError{Resource: "user", ID: id}
+	}
+	updated, err := applyUserUpdate(&u, req)
+	if err != nil || !updated {
+		return u, err
+	}
+
+	data, err := json.Marshal(userRecord{User: u, PasswordHash: u.PasswordHash})
+	if err != nil {
+		return User{}, err
+	}
+	_, err = r.db.Exec(`UPDATE users SET username = $2, data = $3 WHERE id = $1`, id, u.Username, data)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return User{}, &InUseError{Field: "username", Value: req.Username}
+		}
+		return User{}, err
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully updated user")
+	return u, nil
+}
+
+func (r *PostgresRegistry) DeleteUser(id string) error {
+	res, err := r.db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "user", ID: id}
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully deleted user")
+	return nil
+}
+
+func (r *PostgresRegistry) queryUser(query string, arg string) (User, bool) {
+	var data []byte
+	if err := r.db.QueryRow(query, arg).Scan(&data); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.queryUser").Msg("Failed to get user")
+		}
+		return User{}, false
+	}
+	var rec userRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return User{}, false
+	}
+	rec.User.PasswordHash = rec.PasswordHash
+	return rec.User, true
+}
+
+func (r *PostgresRegistry) AddToken(userID string, req CreateTokenRequest) (Token, string, error) {
+	t, secret, err := newToken(userID, req)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	data, err := json.Marshal(tokenRecord{Token: t, SecretHash: t.SecretHash})
+	if err != nil {
+		return Token{}, "", err
+	}
+	if _, err := r.db.Exec(`INSERT INTO tokens (id, data) VALUES ($1, $2)`, t.ID, data); err != nil {
+		return Token{}, "", err
+	}
+
+	logging.Registry.Info().Str("id", t.ID).Str("name", t.Name).Str("user", userID).Msg("Successfully added token")
+	return t, secret, nil
+}
+
+func (r *PostgresRegistry) GetToken(id string) (Token, bool) {
+	var data []byte
+	if err := r.db.QueryRow(`SELECT data FROM tokens WHERE id = $1`, id).Scan(&data); err != nil {
+		return Token{}, false
+	}
+	var rec tokenRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Token{}, false
+	}
+	rec.Token.SecretHash = rec.SecretHash
+	return rec.Token, true
+}
+
+func (r *PostgresRegistry) ListTokens() []Token {
+	rows, err := r.db.Query(`SELECT data FROM tokens ORDER BY id`)
+	if err != nil {
+		logging.Registry.Error().Err(err).Str("method", "PostgresRegistry.ListTokens").Msg("Failed to list tokens")
+		return nil
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var data []byte
+		var rec tokenRecord
+		if err := rows.Scan(&data); err != nil || json.Unmarshal(data, &rec) != nil {
+			logging.Registry.Warn().Str("method", "PostgresRegistry.ListTokens").Msg("Failed to read token")
+			continue
+		}
+		rec.Token.SecretHash = rec.SecretHash
+		tokens = append(tokens, rec.Token)
+	}
+	return tokens
+}
+
+func (r *PostgresRegistry) DeleteToken(id string) error {
+	res, err := r.db.Exec(`DELETE FROM tokens WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &NotFoundError{Resource: "token", ID: id}
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully deleted token")
+	return nil
+}
+
+func (r *PostgresRegistry) queryOne(query string, arg string) (Notebook, bool) {
+	var data []byte
+	if err := r.db.QueryRow(query, arg).Scan(&data); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.queryOne").Msg("Failed to get notebook")
+		}
+		return Notebook{}, false
+	}
+	var nb Notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.queryOne").Msg("Failed to unmarshal notebook")
+		return Notebook{}, false
+	}
+	return nb, true
+}
+
+// getVersioned reads the notebook with the version of its row.
+func (r *PostgresRegistry) getVersioned(id string) (Notebook, int64, bool) {
+	var data []byte
+	var version int64
+	err := r.db.QueryRow(`SELECT data, version FROM notebooks WHERE id = $1`, id).Scan(&data, &version)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.getVersioned").Msg("Failed to get notebook")
+		}
+		return Notebook{}, 0, false
+	}
+	var nb Notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.getVersioned").Msg("Failed to unmarshal notebook")
+		return Notebook{}, 0, false
+	}
+	return nb, version, true
+}
+
+func (r *PostgresRegistry) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.poll(ctx); err != nil {
+				logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.watch").Msg("Failed to poll for changes")
+			}
+		}
+	}
+}
+
+// poll compares row versions against what this node has already seen and
+// notifies subscribers about changes made by other nodes.
+func (r *PostgresRegistry) poll(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, `SELECT data, version FROM notebooks`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var data []byte
+		var version int64
+		if err := rows.Scan(&data, &version); err != nil {
+			return err
+		}
+		var nb Notebook
+		if err := json.Unmarshal(data, &nb); err != nil {
+			logging.Registry.Warn().Err(err).Str("method", "PostgresRegistry.poll").Msg("Failed to unmarshal notebook")
+			continue
+		}
+		seen[nb.ID] = true
+
+		known, exists := r.versions[nb.ID]
+		switch {
+		case !exists:
+			r.events.Publish(nb, ActionAdd)
+		case known != version:
+			r.events.Publish(nb, ActionUpdate)
+		}
+		r.versions[nb.ID] = version
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id := range r.versions {
+		if !seen[id] {
+			delete(r.versions, id)
+			r.events.Publish(Notebook{ID: id}, ActionDelete)
+		}
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
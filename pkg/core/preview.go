@@ -0,0 +1,222 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// previewsDir is the folder of the notebooks directory holding the
+// checkouts of previews.
+const previewsDir = "previews"
+
+// previewName is what a preview name must look like; it becomes part of a
+// domain label.
+var previewName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// previewMu serializes changes to previews, which share git checkouts.
+var previewMu sync.Mutex
+
+// PreviewDomain returns the domain of the preview called name of a notebook
+// served at domain. The name is prefixed to the first label rather than added
+// as another one, so a wildcard certificate for the parent domain covers it.
+func PreviewDomain(domain, name string) string {
+	first, rest, _ := strings.Cut(domain, ".")
+	if rest == "" {
+		return name + "--" + first
+	}
+	return name + "--" + first + "." + rest
+}
+
+// ListPreviews returns the previews of the notebook with the given ID.
+func ListPreviews(reg Registry, id string) []Notebook {
+	var previews []Notebook
+	for _, nb := range reg.List() {
+		if nb.Preview != nil && nb.Preview.Of == id {
+			previews = append(previews, nb)
+		}
+	}
+	return previews
+}
+
+func findPreview(reg Registry, id, name string) (Notebook, bool) {
+	for _, nb := range ListPreviews(reg, id) {
+		if nb.Preview.Name == name {
+			return nb, true
+		}
+	}
+	return Notebook{}, false
+}
+
+// DeployPreview checks out ref of the git repository holding base and runs
+// it as a preview notebook called name. The ref is fetched from the origin
+// remote. Deploying an existing preview again moves its checkout to the
+// fetched ref. The code at ref may come from anyone, so the env and
+// lifecycle hooks of base, which may hold secrets, are only copied into the
+// preview when secrets is set. changed reports whether the registry changed, which starts or
+// restarts the preview; otherwise the caller has to restart it to pick up
+// the new checkout.
+func DeployPreview(ctx context.Context, reg Registry, notebooksDir string, base Notebook, name, ref string, secrets bool) (nb Notebook, changed bool, err error) {
+	if !previewName.MatchString(name) {
+		return Notebook{}, false, &InvalidRequestError{Reason: "preview name must be a lowercase domain label of at most 32 characters"}
+	}
+	if base.Preview != nil {
+		return Notebook{}, false, &InvalidRequestError{Reason: "notebook " + base.ID + " is itself a preview"}
+	}
+
+	domain, err := NormalizeDomain(PreviewDomain(base.Domain, name))
+	if err != nil {
+		return Notebook{}, false, err
+	}
+
+	previewMu.Lock()
+	defer previewMu.Unlock()
+
+	repo, rel, err := gitCheckout(ctx, base.LocalPath(notebooksDir))
+	if err != nil {
+		return Notebook{}, false, err
+	}
+	dir := filepath.Join(notebooksDir, previewsDir, base.ID, name)
+	previewRef := "refs/previews/" + base.ID + "/" + name
+
+	if _, err := git(ctx, repo, "fetch", "--quiet", "origin", "+"+ref+":"+previewRef); err != nil {
+		return Notebook{}, false, err
+	}
+
+	existing, exists := findPreview(reg, base.ID, name)
+	if _, statErr := os.Stat(dir); statErr == nil {
+		_, err = git(ctx, dir, "checkout", "--quiet", "--force", "--detach", previewRef)
+	} else {
+		_, err = git(ctx, repo, "worktree", "add", "--quiet", "--force", "--detach", dir, previewRef)
+	}
+	if err != nil {
+		return Notebook{}, false, err
+	}
+
+	preview := &Preview{Of: base.ID, Name: name, Ref: ref}
+	if exists {
+		if existing.Preview.Ref == ref {
+			return existing, false, nil
+		}
+		nb, err := reg.Update(existing.ID, CreateUpdateNotebookRequest{Preview: preview})
+		return nb, err == nil, err
+	}
+
+	path := filepath.Join(dir, rel)
+	relPath, err := filepath.Rel(notebooksDir, path)
+	if err != nil {
+		return Notebook{}, false, err
+	}
+	watch, pinned, public := false, false, false
+	req := CreateUpdateNotebookRequest{
+		Name:          base.Name + " (" + name + ")",
+		Path:          path,
+		RelativePath:  relPath,
+		Domain:        domain,
+		PathPrefix:    base.PathPrefix,
+		WorkspaceID:   base.WorkspaceID,
+		Runtime:       base.Runtime,
+		ShowCode:      &base.ShowCode,
+		Watch:         &watch,
+		Pinned:        &pinned,
+		Public:        &public,
+		ClientCert:    &base.ClientCert,
+		Owner:         base.Owner,
+		Collaborators: base.Collaborators,
+		Assets:        base.Assets,
+		ErrorPage:     base.ErrorPage,
+		StartTimeout:  &base.StartTimeout,
+		DependsOn:     base.DependsOn,
+		Probe:         base.Probe,
+		Upstream:      base.Upstream,
+		ForwardAuth:   base.ForwardAuth,
+		AccessLog:     base.AccessLog,
+		Limits:        base.Limits,
+		Timeouts:      base.Timeouts,
+		Security:      base.Security,
+		CSP:           base.CSP,
+		Preview:       preview,
+	}
+	if secrets {
+		req.Env, req.Hooks = base.Env, base.Hooks
+	}
+	nb, err = reg.Add(req)
+	if err != nil {
+		removeWorktree(ctx, repo, dir, previewRef)
+		return Notebook{}, false, err
+	}
+
+	logging.Registry.Info().Str("id", nb.ID).Str("base", base.ID).Str("ref", ref).Msg("Deployed preview")
+	return nb, true, nil
+}
+
+// DeletePreview unregisters the preview called name of base and removes its
+// checkout.
+func DeletePreview(ctx context.Context, reg Registry, notebooksDir string, base Notebook, name string) error {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+
+	nb, exists := findPreview(reg, base.ID, name)
+	if !exists {
+		return &NotFoundError{Resource: "preview", ID: name}
+	}
+	if err := reg.Delete(nb.ID); err != nil {
+		return err
+	}
+
+	if repo, _, err := gitCheckout(ctx, base.LocalPath(notebooksDir)); err == nil {
+		removeWorktree(ctx, repo, filepath.Join(notebooksDir, previewsDir, base.ID, name), "refs/previews/"+base.ID+"/"+name)
+	} else {
+		logging.Registry.Warn().Err(err).Str("id", nb.ID).Msg("Failed to remove preview checkout")
+	}
+
+	logging.Registry.Info().Str("id", nb.ID).Str("base", base.ID).Msg("Deleted preview")
+	return nil
+}
+
+func removeWorktree(ctx context.Context, repo, dir, ref string) {
+	if _, err := git(ctx, repo, "worktree", "remove", "--force", dir); err != nil {
+		logging.Registry.Warn().Err(err).Str("dir", dir).Msg("Failed to remove preview checkout")
+	}
+	if _, err := git(ctx, repo, "update-ref", "-d", ref); err != nil {
+		logging.Registry.Warn().Err(err).Str("ref", ref).Msg("Failed to remove preview ref")
+	}
+}
+
+// gitCheckout returns the root of the git checkout holding path and path
+// relative to it.
+func gitCheckout(ctx context.Context, path string) (repo, rel string, err error) {
+	dir := path
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	repo, err = git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", "", &InvalidRequestError{Reason: path + " is not in a git checkout"}
+	}
+	repo = filepath.FromSlash(repo)
+	// git resolves symlinks in the root it reports.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if rel, err = filepath.Rel(repo, path); err != nil {
+		return "", "", err
+	}
+	return repo, rel, nil
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
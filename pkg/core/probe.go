@@ -0,0 +1,220 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// Defaults of the probe settings a notebook leaves at zero.
+const (
+	defaultProbeInterval  = 10 * time.Second
+	defaultProbeTimeout   = 5 * time.Second
+	defaultProbeThreshold = 3
+)
+
+// HealthProbe overrides how the runner checks that a notebook is up. Without
+// one, a notebook is ready once it accepts connections and isn't checked
+// afterwards. With one, it is ready once the probe passes, and is killed
+// and restarted like a crashed one once the probe fails FailureThreshold
+// times in a row while it runs.
+type HealthProbe struct {
+	// Path is requested below the notebook's path prefix, and passes with
+	// a 2xx or 3xx answer. Empty probes by connecting, for apps whose
+	// pages are too heavy to load every few seconds.
+	Path string `json:"path,omitempty" validate:"omitempty,startswith=/,max=200"`
+	// Interval and Timeout are in seconds. Zero uses 10 and 5.
+	Interval int `json:"interval,omitempty" validate:"gte=0"`
+	Timeout  int `json:"timeout,omitempty" validate:"gte=0"`
+	// FailureThreshold is how many probes in a row must fail for the
+	// notebook to be restarted. Zero uses 3.
+	FailureThreshold int `json:"failure_threshold,omitempty" validate:"gte=0"`
+}
+
+// orNil returns nil for a probe without any setting, which is how a request
+// clears it.
+func (p *HealthProbe) orNil() *HealthProbe {
+	if p == nil || *p == (HealthProbe{}) {
+		return nil
+	}
+	return p
+}
+
+func (p *HealthProbe) interval() time.Duration {
+	if p.Interval > 0 {
+		return time.Duration(p.Interval) * time.Second
+	}
+	return defaultProbeInterval
+}
+
+func (p *HealthProbe) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return time.Duration(p.Timeout) * time.Second
+	}
+	return defaultProbeTimeout
+}
+
+func (p *HealthProbe) threshold() int {
+	if p.FailureThreshold > 0 {
+		return p.FailureThreshold
+	}
+	return defaultProbeThreshold
+}
+
+// HealthState is what the probe of a running notebook last found.
+type HealthState struct {
+	Healthy bool `json:"healthy"`
+	// Failures counts the probes that failed since the last one passed.
+	Failures  int        `json:"failures"`
+	LastProbe *time.Time `json:"last_probe,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// probeClient doesn't follow redirects, which pass the probe as they are.
+var probeClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// probeClientFor returns the client to probe a backend reached through u.
+// Backends with TLS settings of their own get a client without keep-alives,
+// as it is used once.
+func probeClientFor(u *Upstream) (*http.Client, error) {
+	cfg, err := u.TLSConfig()
+	if err != nil || cfg == nil {
+		return probeClient, err
+	}
+	return &http.Client{
+		Transport:     &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true},
+		CheckRedirect: probeClient.CheckRedirect,
+	}, nil
+}
+
+// probe checks the notebook at addr once, as p says, or by connecting
+// within timeout without a probe path.
+func probe(ctx context.Context, addr, prefix string, p *HealthProbe, u *Upstream, timeout time.Duration) error {
+	if p == nil || p.Path == "" {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	url := u.HTTPScheme() + "://" + addr + strings.TrimSuffix(prefix, "/") + p.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	client, err := probeClientFor(u)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("%s answered %s", p.Path, resp.Status)
+	}
+	return nil
+}
+
+// watchHealth probes proc while it runs, and fails it once the probe
+// failed often enough. It returns once proc is no longer the notebook's
+// process.
+func (m *NotebookManager) watchHealth(proc Process) {
+	m.mu.RLock()
+	p, addr, prefix, upstream := m.notebook.Probe, m.address(), m.notebook.PathPrefix, m.notebook.Upstream
+	m.mu.RUnlock()
+	if p == nil {
+		return
+	}
+
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.RLock()
+		current, status := m.proc == proc, m.status
+		m.mu.RUnlock()
+		if !current {
+			return
+		}
+		// A suspended process can't answer; a starting one is probed by
+		// awaitReady.
+		if status != StatusRunning {
+			continue
+		}
+
+		err := probe(m.ctx, addr, prefix, p, upstream, p.timeout())
+
+		m.mu.Lock()
+		if m.proc != proc || m.status != StatusRunning {
+			m.mu.Unlock()
+			continue
+		}
+		failures := m.recordProbeLocked(err)
+		if failures >= p.threshold() {
+			m.failLocked(fmt.Sprintf("failed %d health checks in a row: %v", failures, err), "Notebook is unhealthy")
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+		if err != nil {
+			logging.Runner.Warn().Str("method", "NotebookManager.watchHealth").
+				Str("notebook", m.notebook.ID).
+				Int("failures", failures).
+				Err(err).
+				Msg("Health check failed")
+		}
+	}
+}
+
+// recordProbeLocked records the result of a probe and returns how many
+// failed in a row. Must hold m.mu.
+func (m *NotebookManager) recordProbeLocked(err error) int {
+	now := time.Now()
+	m.health.LastProbe = &now
+	if err == nil {
+		m.health.Healthy = true
+		m.health.Failures = 0
+		m.health.LastError = ""
+		return 0
+	}
+	m.health.Healthy = false
+	m.health.Failures++
+	m.health.LastError = err.Error()
+	return m.health.Failures
+}
+
+// Health returns what the probe of notebook id last found, or false if it
+// has no probe or isn't run here.
+func (r *Runner) Health(id string) (HealthState, bool) {
+	r.mu.RLock()
+	manager, exists := r.managers[id]
+	r.mu.RUnlock()
+	if !exists {
+		return HealthState{}, false
+	}
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	if manager.notebook.Probe == nil {
+		return HealthState{}, false
+	}
+	return manager.health, true
+}
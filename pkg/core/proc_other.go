@@ -0,0 +1,15 @@
+//go:build !linux
+
+package core
+
+import "errors"
+
+// processArgs is only implemented on Linux.
+func processArgs(pid int) ([]string, error) {
+	return nil, errors.New("process arguments are not available on this platform")
+}
+
+// listPIDs is only implemented on Linux.
+func listPIDs() ([]int, error) {
+	return nil, nil
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+const queueInterval = 5 * time.Second
+
+// WorkspaceGetter looks up workspaces for quota enforcement.
+type WorkspaceGetter interface {
+	GetWorkspace(id string) (Workspace, bool)
+}
+
+// SetWorkspaces enables per-workspace quota enforcement.
+func (r *Runner) SetWorkspaces(workspaces WorkspaceGetter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workspaces = workspaces
+}
+
+// WorkspaceUsage reports the runtime resources a workspace currently holds.
+func (r *Runner) WorkspaceUsage(workspaceID string) QuotaUsage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.usageLocked(workspaceID)
+}
+
+// Usage reports the runtime resources held by the notebooks on this runner
+// for which include returns true.
+func (r *Runner) Usage(include func(Notebook) bool) QuotaUsage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.usageWhereLocked(include)
+}
+
+func (r *Runner) usageLocked(workspaceID string) QuotaUsage {
+	return r.usageWhereLocked(func(nb Notebook) bool { return nb.WorkspaceID == workspaceID })
+}
+
+func (r *Runner) usageWhereLocked(include func(Notebook) bool) QuotaUsage {
+	var usage QuotaUsage
+	for _, manager := range r.managers {
+		nb, status, proc := manager.snapshot()
+		if !include(nb) {
+			continue
+		}
+		usage.Ports++
+		// Pending managers were admitted and wait for a start worker.
+		if status == StatusRunning || status == StatusStarting || status == StatusPending {
+			usage.Running++
+		}
+		if proc != nil && proc.Pid() > 0 {
+			if rss, err := processRSS(proc.Pid()); err == nil {
+				usage.MemoryMB += int(rss / (1024 * 1024))
+			}
+		}
+	}
+	for _, nb := range r.pending {
+		if include(nb) {
+			usage.Queued++
+		}
+	}
+	return usage
+}
+
+// admitLocked checks whether starting nb would exceed its workspace quota.
+// Must hold r.mu.
+func (r *Runner) admitLocked(nb Notebook) error {
+	if nb.WorkspaceID == "" || r.workspaces == nil {
+		return nil
+	}
+	ws, exists := r.workspaces.GetWorkspace(nb.WorkspaceID)
+	if !exists {
+		return nil
+	}
+
+	q := ws.Quota
+	usage := r.usageLocked(nb.WorkspaceID)
+	switch {
+	case q.MaxPorts > 0 && usage.Ports >= q.MaxPorts:
+		return &QuotaExceededError{WorkspaceID: ws.ID, Resource: "ports", Limit: q.MaxPorts}
+	case q.MaxRunning > 0 && usage.Running >= q.MaxRunning:
+		return &QuotaExceededError{WorkspaceID: ws.ID, Resource: "running", Limit: q.MaxRunning}
+	case q.MaxMemoryMB > 0 && usage.MemoryMB >= q.MaxMemoryMB:
+		return &QuotaExceededError{WorkspaceID: ws.ID, Resource: "memory", Limit: q.MaxMemoryMB}
+	}
+	return nil
+}
+
+func (r *Runner) queueLoop() {
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.processQueue()
+		}
+	}
+}
+
+// processQueue starts queued notebooks that now fit within their quota.
+func (r *Runner) processQueue() {
+	r.mu.Lock()
+	admitted := make(map[string]*NotebookManager)
+	for id, nb := range r.pending {
+		if r.admitLocked(nb) != nil {
+			continue
+		}
+		manager, err := r.createLocked(nb)
+		if err != nil {
+			logging.Registry.Error().Str("method", "Runner.processQueue").
+				Str("notebook", id).
+				Err(err).
+				Msg("Failed to allocate port")
+			continue
+		}
+		delete(r.pending, id)
+		admitted[id] = manager
+	}
+	r.mu.Unlock()
+
+	for _, manager := range admitted {
+		r.enqueueStart(manager)
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// prSetChildSubreaper is the prctl option making a process adopt the
+// orphans of its descendants instead of PID 1.
+const prSetChildSubreaper = 36
+
+// reapInterval is how often zombie children are looked for. A zombie is
+// only reaped once it has been seen twice, leaving os/exec time to wait for
+// the processes it started.
+const reapInterval = 2 * time.Second
+
+// ReapChildren waits for the children of the hub that exited without anyone
+// waiting for them, until ctx is done. Running as PID 1, or as a subreaper,
+// the hub inherits the processes orphaned by its notebooks, such as the
+// subprocesses of crashed kernels, and they would otherwise be left as
+// zombies filling the process table. With subreaper set, the hub is made a
+// subreaper first.
+func ReapChildren(ctx context.Context, subreaper bool) error {
+	if subreaper {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+			return fmt.Errorf("failed to become a subreaper: %w", errno)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(reapInterval)
+		defer ticker.Stop()
+		seen := make(map[int]bool)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			zombies := zombieChildren()
+			for pid := range zombies {
+				if !seen[pid] {
+					continue
+				}
+				var status syscall.WaitStatus
+				if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err == nil && wpid == pid {
+					logging.Runner.Debug().Str("method", "ReapChildren").Int("pid", pid).Int("exit_code", status.ExitStatus()).Msg("Reaped orphaned process")
+				}
+				delete(zombies, pid)
+			}
+			seen = zombies
+		}
+	}()
+	return nil
+}
+
+// zombieChildren returns the children of the hub that have exited but not
+// been waited for.
+func zombieChildren() map[int]bool {
+	zombies := make(map[int]bool)
+	pids, err := listPIDs()
+	if err != nil {
+		return zombies
+	}
+	self := os.Getpid()
+	for _, pid := range pids {
+		data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name may contain anything, so the state and the
+		// parent follow its last closing parenthesis.
+		i := strings.LastIndex(string(data), ") ")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(data)[i+2:])
+		if len(fields) < 2 || fields[0] != "Z" {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil && ppid == self {
+			zombies[pid] = true
+		}
+	}
+	return zombies
+}
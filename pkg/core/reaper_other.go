@@ -0,0 +1,13 @@
+//go:build !linux
+
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// ReapChildren is only implemented on Linux.
+func ReapChildren(ctx context.Context, subreaper bool) error {
+	return errors.New("reaping orphaned processes is not supported on this platform")
+}
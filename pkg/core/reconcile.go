@@ -0,0 +1,120 @@
+package core
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// NotebookLister is the source of desired state for the reconciler.
+type NotebookLister interface {
+	List() []Notebook
+}
+
+// SetNotebooks sets where the reconciler reads desired state from.
+func (r *Runner) SetNotebooks(src NotebookLister) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notebooks = src
+}
+
+// RunReconciler reconciles every interval until the runner is stopped.
+// Registry events remain the fast path; the reconciler repairs whatever they
+// missed.
+func (r *Runner) RunReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.Reconcile()
+		}
+	}
+}
+
+// Reconcile compares the desired state in the registry with the processes
+// this runner manages: missing notebooks are started, ones that should not
+// run are stopped, crashed ones are restarted and stale definitions are
+// applied.
+func (r *Runner) Reconcile() {
+	if !r.IsLeader() {
+		return
+	}
+	r.mu.RLock()
+	src := r.notebooks
+	r.mu.RUnlock()
+	if src == nil {
+		return
+	}
+
+	want := make(map[string]Notebook)
+	for _, nb := range src.List() {
+		if nb.WantsProcess() {
+			want[nb.ID] = nb
+		}
+	}
+
+	var apply []Notebook
+	var restart []*NotebookManager
+
+	r.mu.Lock()
+	for id := range r.managers {
+		if _, ok := want[id]; !ok {
+			r.removeLocked(id)
+			logging.Runner.Info().Str("method", "Runner.Reconcile").Str("notebook", id).Msg("Stopped notebook that should not run")
+		}
+	}
+	for id := range r.pending {
+		if _, ok := want[id]; !ok {
+			delete(r.pending, id)
+		}
+	}
+	for id, nb := range want {
+		manager, exists := r.managers[id]
+		if !exists {
+			if _, queued := r.pending[id]; queued {
+				r.pending[id] = nb
+			} else {
+				apply = append(apply, nb)
+			}
+			continue
+		}
+
+		current, status, proc := manager.snapshot()
+		switch {
+		case !sameNotebook(current, nb):
+			apply = append(apply, nb)
+		case proc == nil && status != StatusRestarting && status != StatusPending && status != StatusIdle:
+			restart = append(restart, manager)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, nb := range apply {
+		logging.Runner.Info().Str("method", "Runner.Reconcile").Str("notebook", nb.ID).Msg("Applying missed notebook state")
+		r.handleNotebook(nb)
+	}
+	for _, manager := range restart {
+		nb, _, _ := manager.snapshot()
+		logging.Runner.Info().Str("method", "Runner.Reconcile").Str("notebook", nb.ID).Msg("Restarting crashed notebook")
+		r.enqueueStart(manager)
+	}
+	if len(apply) > 0 || len(restart) > 0 {
+		go r.processQueue()
+	}
+}
+
+// sameNotebook compares two versions of a notebook. Timestamps are compared
+// as instants because only one side may have been through the store, which
+// drops the monotonic clock reading and location.
+func sameNotebook(a, b Notebook) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return false
+	}
+	b.CreatedAt = a.CreatedAt
+	return reflect.DeepEqual(a, b)
+}
@@ -1,23 +1,44 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/google/uuid"
-	"github.com/rs/zerolog/log"
+	"github.com/rekk30/marimo-hub/pkg/logging"
 )
 
-const notebookPrefix = "notebook:"
+const (
+	notebookPrefix = "notebook:"
+	// routePrefix keys the reservation of each route by the ID of the
+	// notebook mounted there, which keeps routes unique.
+	routePrefix = "route:"
+	// storeAttempts is how often a notebook write is tried when concurrent
+	// writes conflict with it. One of the writes commits each round, so
+	// this many writers at once all get through.
+	storeAttempts = 10
+)
 
 type BadgerRegistry struct {
-	db   *badger.DB
-	subs []func(Notebook, RegistryAction)
+	db      *badger.DB
+	events  *EventBus
+	changes *badgerChangelog
+	policy  DomainPolicy
+
+	gcMu   sync.Mutex
+	lastGC *GCResult
 }
 
-func NewBadgerRegistry(dbPath string, subscribers ...func(Notebook, RegistryAction)) (*BadgerRegistry, error) {
+func NewBadgerRegistry(dbPath string, sinks ...EventSink) (*BadgerRegistry, error) {
 	opts := badger.DefaultOptions(dbPath)
 	db, err := badger.Open(opts)
 	if err != nil {
@@ -25,8 +46,21 @@ func NewBadgerRegistry(dbPath string, subscribers ...func(Notebook, RegistryActi
 	}
 
 	reg := &BadgerRegistry{
-		db:   db,
-		subs: subscribers,
+		db:      db,
+		events:  NewEventBus(sinks...),
+		changes: newBadgerChangelog(db),
+	}
+
+	last, err := reg.changes.last()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read change log: %w", err)
+	}
+	reg.events.record(reg.changes, last)
+
+	if err := reg.reserveRoutes(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to reserve notebook routes: %w", err)
 	}
 
 	err = reg.loadExistingNotebooks()
@@ -38,45 +72,89 @@ func NewBadgerRegistry(dbPath string, subscribers ...func(Notebook, RegistryActi
 	return reg, nil
 }
 
+// Subscribe delivers the registry's changes from now on to sink.
+func (r *BadgerRegistry) Subscribe(sink EventSink) *Subscription {
+	return r.events.Subscribe(sink)
+}
+
+func (r *BadgerRegistry) Unsubscribe(sub *Subscription) {
+	r.events.Unsubscribe(sub)
+}
+
 func (r *BadgerRegistry) Close() error {
+	r.events.Close()
 	return r.db.Close()
 }
 
+// Verify checks the checksums of every table and value log file, finding
+// storage corrupted on disk.
+func (r *BadgerRegistry) Verify(ctx context.Context) error {
+	if err := r.db.VerifyChecksum(); err != nil {
+		return fmt.Errorf("badger db is corrupt: %w", err)
+	}
+	return nil
+}
+
+// Backup writes every entry newer than since to w and returns the version to
+// pass as since for the next incremental backup.
+func (r *BadgerRegistry) Backup(w io.Writer, since uint64) (uint64, error) {
+	return r.db.Backup(w, since)
+}
+
+func (r *BadgerRegistry) SetDomainPolicy(policy DomainPolicy) {
+	policy.BaseDomain = normalizeBaseDomain(policy.BaseDomain)
+	r.policy = policy
+}
+
 func (r *BadgerRegistry) Add(req CreateUpdateNotebookRequest) (Notebook, error) {
-	log.Debug().Str("method", "BadgerRegistry.Add").
+	logging.Registry.Debug().Str("method", "BadgerRegistry.Add").
 		Interface("request", req).Msg("Starting Add operation")
 
 	if req.Name == "" || req.Path == "" || req.Domain == "" {
-		return Notebook{}, fmt.Errorf("name, path, and domain are required for creation")
+		return Notebook{}, &InvalidRequestError{Reason: "name, path, and domain are required for creation"}
 	}
 
-	if _, exists := r.GetByDomain(req.Domain); exists {
-		return Notebook{}, fmt.Errorf("domain %s is already in use", req.Domain)
+	if err := resolveWorkspace(r, &req, true); err != nil {
+		return Notebook{}, err
 	}
-
-	nb := Notebook{
-		ID:        uuid.New().String(),
-		Name:      req.Name,
-		Path:      req.Path,
-		Domain:    req.Domain,
-		ShowCode:  req.ShowCode != nil && *req.ShowCode,
-		Watch:     req.Watch != nil && *req.Watch,
-		CreatedAt: time.Now(),
+	if err := normalizeRequestRoute(&req, r.policy.BaseDomain); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkNotebookQuota(r, req.WorkspaceID); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkDependencies(r, "", req.DependsOn); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkEnvTemplates(req.Env); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkUpstream(req.Upstream); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkCSP(req.CSP); err != nil {
+		return Notebook{}, err
 	}
 
-	if _, exists := r.getNotebookByDomain(req.Domain); exists {
-		return Notebook{}, fmt.Errorf("domain %s is already in use", req.Domain)
+	prefix := storedPrefix(req.PathPrefix)
+	if _, exists := r.GetByRoute(req.Domain, prefix); exists {
+		return Notebook{}, &InUseError{Field: "domain", Value: routeKey(req.Domain, prefix)}
 	}
+	if err := r.policy.verify(req.Domain); err != nil {
+		return Notebook{}, err
+	}
+
+	nb := newNotebook(req)
 
 	if err := r.storeNotebook(nb); err != nil {
-		log.Error().Err(err).Str("id", nb.ID).Msg("Failed to store notebook")
+		logging.Registry.Error().Err(err).Str("id", nb.ID).Msg("Failed to store notebook")
 		return Notebook{}, err
 	}
 
-	log.Debug().Str("id", nb.ID).Msg("Notifying subscribers")
-	r.notifySubscribers(nb, ActionAdd)
+	logging.Registry.Debug().Str("id", nb.ID).Msg("Publishing event")
+	r.events.Publish(nb, ActionAdd)
 
-	log.Info().Str("id", nb.ID).Str("domain", nb.Domain).
+	logging.Registry.Info().Str("id", nb.ID).Str("domain", nb.Domain).
 		Str("method", "BadgerRegistry.Add").
 		Msg("Successfully added notebook")
 	return nb, nil
@@ -87,46 +165,39 @@ func (r *BadgerRegistry) Get(id string) (Notebook, bool) {
 }
 
 func (r *BadgerRegistry) GetByDomain(domain string) (Notebook, bool) {
-	log.Debug().Str("method", "BadgerRegistry.GetByDomain").
-		Str("domain", domain).Msg("Starting GetByDomain operation")
-	var result Notebook
-	var found bool
+	return r.GetByRoute(domain, "")
+}
 
+func (r *BadgerRegistry) GetByRoute(domain, prefix string) (Notebook, bool) {
+	key := routeKey(domain, prefix)
+	logging.Registry.Debug().Str("method", "BadgerRegistry.GetByRoute").
+		Str("route", key).Msg("Starting GetByRoute operation")
+	var nb Notebook
 	err := r.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte(notebookPrefix)
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			var nb Notebook
-			if err := item.Value(func(val []byte) error {
-				return json.Unmarshal(val, &nb)
-			}); err != nil {
-				log.Warn().Err(err).
-					Str("method", "BadgerRegistry.GetByDomain").
-					Msg("Failed to unmarshal notebook")
-				continue
-			}
-
-			if nb.Domain == domain {
-				result = nb
-				found = true
-				return nil
-			}
+		id, err := routeOwner(txn, key)
+		if err != nil {
+			return err
 		}
-		log.Debug().Str("method", "BadgerRegistry.GetByDomain").
-			Str("domain", domain).Msg("No notebook found")
-		return nil
+		item, err := txn.Get([]byte(notebookPrefix + id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &nb)
+		})
 	})
 
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		logging.Registry.Debug().Str("method", "BadgerRegistry.GetByRoute").
+			Str("route", key).Msg("No notebook found")
+		return Notebook{}, false
+	}
 	if err != nil {
-		log.Error().Err(err).Str("method", "BadgerRegistry.GetByDomain").
-			Str("domain", domain).Msg("Failed to get notebook by domain")
+		logging.Registry.Error().Err(err).Str("method", "BadgerRegistry.GetByRoute").
+			Str("route", key).Msg("Failed to get notebook by route")
 		return Notebook{}, false
 	}
-	return result, found
+	return nb, true
 }
 
 func (r *BadgerRegistry) List() []Notebook {
@@ -146,106 +217,124 @@ func (r *BadgerRegistry) List() []Notebook {
 			if err == nil {
 				notebooks = append(notebooks, nb)
 			} else {
-				log.Warn().Err(err).Str("method", "BadgerRegistry.List").Msg("Failed to unmarshal notebook")
+				logging.Registry.Warn().Err(err).Str("method", "BadgerRegistry.List").Msg("Failed to unmarshal notebook")
 			}
 		}
 		return nil
 	})
-	log.Debug().Str("method", "BadgerRegistry.List").Int("count", len(notebooks)).Msg("Successfully listed notebooks")
+	logging.Registry.Debug().Str("method", "BadgerRegistry.List").Int("count", len(notebooks)).Msg("Successfully listed notebooks")
 	return notebooks
 }
 
 func (r *BadgerRegistry) Update(id string, req CreateUpdateNotebookRequest) (Notebook, error) {
-	log.Debug().Str("method", "BadgerRegistry.Update").
+	logging.Registry.Debug().Str("method", "BadgerRegistry.Update").
 		Str("id", id).
 		Interface("req", req).
 		Msg("Starting Update operation")
 
-	if req.Domain != "" {
-		if existing, exists := r.GetByDomain(req.Domain); exists && existing.ID != id {
-			return Notebook{}, fmt.Errorf("domain %s is already in use", req.Domain)
-		}
-	}
-
 	nb, exists := r.getNotebook(id)
 
 	if !exists {
-		log.Warn().Str("id", id).Msg("Notebook not found")
-		return Notebook{}, fmt.Errorf("notebook %s not found", id)
+		logging.Registry.Warn().Str("id", id).Msg("Notebook not found")
+		return Notebook{}, &NotFoundError{ID: id}
 	}
 
-	updated := false
-	if req.Name != "" && req.Name != nb.Name {
-		nb.Name = req.Name
-		updated = true
+	if err := resolveUpdateWorkspace(r, nb, &req); err != nil {
+		return Notebook{}, err
 	}
-	if req.Path != "" && req.Path != nb.Path {
-		nb.Path = req.Path
-		updated = true
+	if err := normalizeRequestRoute(&req, r.policy.BaseDomain); err != nil {
+		return Notebook{}, err
 	}
-	if req.Domain != "" && req.Domain != nb.Domain {
-		nb.Domain = req.Domain
-		updated = true
+	if req.WorkspaceID != "" && req.WorkspaceID != nb.WorkspaceID {
+		if err := checkNotebookQuota(r, req.WorkspaceID); err != nil {
+			return Notebook{}, err
+		}
 	}
-	if req.ShowCode != nil && *req.ShowCode != nb.ShowCode {
-		nb.ShowCode = *req.ShowCode
-		updated = true
+	if req.DependsOn != nil {
+		if err := checkDependencies(r, id, req.DependsOn); err != nil {
+			return Notebook{}, err
+		}
 	}
-	if req.Watch != nil && *req.Watch != nb.Watch {
-		nb.Watch = *req.Watch
-		updated = true
+	if err := checkEnvTemplates(req.Env); err != nil {
+		return Notebook{}, err
 	}
-
-	if !updated {
-		log.Debug().Str("method", "BadgerRegistry.Update").
-			Str("id", id).
-			Msg("No changes to update")
-		return nb, nil
+	if err := checkUpstream(req.Upstream); err != nil {
+		return Notebook{}, err
+	}
+	if err := checkCSP(req.CSP); err != nil {
+		return Notebook{}, err
 	}
 
-	if req.Domain != "" && req.Domain != nb.Domain {
-		if existing, exists := r.getNotebookByDomain(req.Domain); exists && existing.ID != id {
-			return Notebook{}, fmt.Errorf("domain %s is already in use", req.Domain)
+	domain, prefix := requestRoute(nb, req)
+	if req.Domain != "" || req.PathPrefix != "" {
+		if existing, exists := r.GetByRoute(domain, prefix); exists && existing.ID != id {
+			return Notebook{}, &InUseError{Field: "domain", Value: routeKey(domain, prefix)}
+		}
+	}
+	if req.Domain != nb.Domain {
+		if err := r.policy.verify(req.Domain); err != nil {
+			return Notebook{}, err
 		}
 	}
 
-	if err := r.storeNotebook(nb); err != nil {
+	nb, updated, err := r.updateNotebook(id, req)
+	if err != nil {
 		return Notebook{}, err
 	}
+	if !updated {
+		logging.Registry.Debug().Str("method", "BadgerRegistry.Update").
+			Str("id", id).
+			Msg("No changes to update")
+		return nb, nil
+	}
 
-	log.Debug().Str("method", "BadgerRegistry.Update").Str("id", id).Msg("Notifying subscribers")
-	r.notifySubscribers(nb, ActionUpdate)
+	logging.Registry.Debug().Str("method", "BadgerRegistry.Update").Str("id", id).Msg("Publishing event")
+	r.events.Publish(nb, ActionUpdate)
 
-	log.Info().Str("id", id).Str("domain", nb.Domain).Msg("Successfully updated notebook")
+	logging.Registry.Info().Str("id", id).Str("domain", nb.Domain).Msg("Successfully updated notebook")
 	return nb, nil
 }
 
 func (r *BadgerRegistry) Delete(id string) error {
-	log.Debug().Str("method", "BadgerRegistry.Delete").Str("id", id).Msg("Starting Delete operation")
+	logging.Registry.Debug().Str("method", "BadgerRegistry.Delete").Str("id", id).Msg("Starting Delete operation")
 
 	nb, exists := r.getNotebook(id)
 
 	if !exists {
-		return fmt.Errorf("notebook %s not found", id)
+		return &NotFoundError{ID: id}
+	}
+	if err := checkNotDependedOn(r, id); err != nil {
+		return err
 	}
 
 	if _, exists := r.getNotebook(id); !exists {
-		return fmt.Errorf("notebook %s was deleted concurrently", id)
+		return &NotFoundError{ID: id}
 	}
 
-	log.Debug().Str("id", id).Msg("Deleting notebook from storage")
-	err := r.db.Update(func(txn *badger.Txn) error {
+	logging.Registry.Debug().Str("id", id).Msg("Deleting notebook from storage")
+	err := r.retryConflicts(func(txn *badger.Txn) error {
+		// The route may have changed since nb was read.
+		current, err := readNotebook(txn, id)
+		if err != nil {
+			return err
+		}
+		if err := releaseRoute(txn, routeKey(current.Domain, current.PathPrefix), id); err != nil {
+			return err
+		}
 		return txn.Delete([]byte(notebookPrefix + id))
 	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return &NotFoundError{ID: id}
+	}
 	if err != nil {
-		log.Error().Err(err).Str("id", id).Msg("Failed to delete notebook")
+		logging.Registry.Error().Err(err).Str("id", id).Msg("Failed to delete notebook")
 		return err
 	}
 
-	log.Debug().Str("method", "BadgerRegistry.Delete").Str("id", id).Msg("Notifying subscribers")
-	r.notifySubscribers(nb, ActionDelete)
+	logging.Registry.Debug().Str("method", "BadgerRegistry.Delete").Str("id", id).Msg("Publishing event")
+	r.events.Publish(nb, ActionDelete)
 
-	log.Info().Str("id", id).Str("domain", nb.Domain).Msg("Successfully deleted notebook")
+	logging.Registry.Info().Str("id", id).Str("domain", nb.Domain).Msg("Successfully deleted notebook")
 	return nil
 }
 
@@ -263,13 +352,13 @@ func (r *BadgerRegistry) loadExistingNotebooks() error {
 				return json.Unmarshal(val, &nb)
 			})
 			if err != nil {
-				log.Warn().Err(err).
+				logging.Registry.Warn().Err(err).
 					Str("method", "BadgerRegistry.loadExistingNotebooks").
 					Msg("Failed to unmarshal notebook")
 				continue
 			}
-			log.Debug().Str("id", nb.ID).Msg("Notifying subscribers for loaded notebook")
-			r.notifySubscribers(nb, ActionAdd)
+			logging.Registry.Debug().Str("id", nb.ID).Msg("Publishing event for loaded notebook")
+			r.events.replay(nb)
 		}
 		return nil
 	})
@@ -277,18 +366,13 @@ func (r *BadgerRegistry) loadExistingNotebooks() error {
 
 func (r *BadgerRegistry) getNotebook(id string) (Notebook, bool) {
 	var nb Notebook
-	err := r.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(notebookPrefix + id))
-		if err != nil {
-			return err
-		}
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &nb)
-		})
+	err := r.db.View(func(txn *badger.Txn) (err error) {
+		nb, err = readNotebook(txn, id)
+		return err
 	})
 
 	if err != nil {
-		log.Warn().Err(err).
+		logging.Registry.Warn().Err(err).
 			Str("method", "BadgerRegistry.getNotebook").
 			Str("id", id).
 			Msg("Failed to get notebook")
@@ -297,73 +381,356 @@ func (r *BadgerRegistry) getNotebook(id string) (Notebook, bool) {
 	return nb, true
 }
 
+// readNotebook reads the notebook with id in txn.
+func readNotebook(txn *badger.Txn, id string) (Notebook, error) {
+	var nb Notebook
+	item, err := txn.Get([]byte(notebookPrefix + id))
+	if err != nil {
+		return Notebook{}, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &nb)
+	})
+	return nb, err
+}
+
+// storeNotebook writes the new notebook nb along with the reservation of its
+// route, in one transaction, so that of two notebooks claiming a route at
+// once only one gets it.
 func (r *BadgerRegistry) storeNotebook(nb Notebook) error {
-	log.Debug().Str("method", "BadgerRegistry.storeNotebook").
+	logging.Registry.Debug().Str("method", "BadgerRegistry.storeNotebook").
 		Interface("notebook", nb).Msg("Storing notebook")
+	return r.retryConflicts(func(txn *badger.Txn) error {
+		return putNotebook(txn, nb, "")
+	})
+}
+
+// updateNotebook applies req to the notebook with id as stored when the
+// transaction runs, moving the reservation of its route along, and reports
+// whether anything changed.
+func (r *BadgerRegistry) updateNotebook(id string, req CreateUpdateNotebookRequest) (Notebook, bool, error) {
+	var nb Notebook
+	var updated bool
+	err := r.retryConflicts(func(txn *badger.Txn) error {
+		current, err := readNotebook(txn, id)
+		if err != nil {
+			return err
+		}
+		oldRoute := routeKey(current.Domain, current.PathPrefix)
+		nb, updated = current, applyUpdate(&current, req)
+		if !updated {
+			return nil
+		}
+		nb = current
+		return putNotebook(txn, nb, oldRoute)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return Notebook{}, false, &NotFoundError{ID: id}
+	}
+	return nb, updated, err
+}
+
+// retryConflicts runs fn in a transaction. Badger refuses to commit one that
+// read a key another transaction wrote meanwhile; running fn again sees
+// what the other one wrote.
+func (r *BadgerRegistry) retryConflicts(fn func(txn *badger.Txn) error) error {
+	for attempt := 1; ; attempt++ {
+		err := r.db.Update(fn)
+		if !errors.Is(err, badger.ErrConflict) || attempt == storeAttempts {
+			return err
+		}
+		logging.Registry.Debug().Str("method", "BadgerRegistry.retryConflicts").
+			Int("attempt", attempt).Msg("Retrying conflicting write")
+	}
+}
+
+// putNotebook writes nb in txn and reserves its route, failing if another
+// notebook holds it. oldRoute, if not empty, is where nb was mounted
+// before, whose reservation is released.
+func putNotebook(txn *badger.Txn, nb Notebook, oldRoute string) error {
 	data, err := json.Marshal(nb)
 	if err != nil {
-		log.Warn().Err(err).
-			Str("method", "BadgerRegistry.storeNotebook").
+		logging.Registry.Warn().Err(err).
+			Str("method", "putNotebook").
 			Str("id", nb.ID).
 			Msg("Failed to marshal notebook")
 		return err
 	}
 
-	return r.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(notebookPrefix+nb.ID), data)
-	})
+	route := routeKey(nb.Domain, nb.PathPrefix)
+	owner, err := routeOwner(txn, route)
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+	if err == nil && owner != nb.ID {
+		return &InUseError{Field: "domain", Value: route}
+	}
+	if oldRoute != "" && oldRoute != route {
+		if err := releaseRoute(txn, oldRoute, nb.ID); err != nil {
+			return err
+		}
+	}
+	if err := txn.Set([]byte(routePrefix+route), []byte(nb.ID)); err != nil {
+		return err
+	}
+	return txn.Set([]byte(notebookPrefix+nb.ID), data)
 }
 
-func (r *BadgerRegistry) notifySubscribers(nb Notebook, action RegistryAction) {
-	log.Debug().Str("method", "BadgerRegistry.notifySubscribers").
-		Interface("notebook", nb).
-		Interface("action", action).
-		Int("subscribers", len(r.subs)).
-		Msg("Notifying subscribers")
-	for _, handler := range r.subs {
-		go handler(nb, action)
+// routeOwner returns the ID of the notebook that reserved route, or
+// badger.ErrKeyNotFound if none did.
+func routeOwner(txn *badger.Txn, route string) (string, error) {
+	item, err := txn.Get([]byte(routePrefix + route))
+	if err != nil {
+		return "", err
 	}
+	val, err := item.ValueCopy(nil)
+	return string(val), err
 }
 
-func (r *BadgerRegistry) getNotebookByDomain(domain string) (Notebook, bool) {
-	log.Debug().Str("method", "BadgerRegistry.getNotebookByDomain").
-		Str("domain", domain).
-		Msg("Starting getNotebookByDomain operation")
-	var result Notebook
-	var found bool
+// releaseRoute drops the reservation of route, if the notebook with id holds it.
+func releaseRoute(txn *badger.Txn, route, id string) error {
+	owner, err := routeOwner(txn, route)
+	if errors.Is(err, badger.ErrKeyNotFound) || (err == nil && owner != id) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return txn.Delete([]byte(routePrefix + route))
+}
 
-	err := r.db.View(func(txn *badger.Txn) error {
+// reserveRoutes rebuilds the route reservations from the stored notebooks,
+// covering databases written before routes were reserved and backups
+// restored over them. Where notebooks share a route, the first one found
+// keeps it and the others are reported.
+func (r *BadgerRegistry) reserveRoutes() error {
+	return r.db.Update(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte(notebookPrefix)
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(routePrefix)
 		it := txn.NewIterator(opts)
-		defer it.Close()
+		var stale [][]byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			stale = append(stale, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+		for _, key := range stale {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
 
+		opts = badger.DefaultIteratorOptions
+		opts.Prefix = []byte(notebookPrefix)
+		it = txn.NewIterator(opts)
+		defer it.Close()
+		owners := map[string]string{}
 		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
 			var nb Notebook
-			if err := item.Value(func(val []byte) error {
+			if err := it.Item().Value(func(val []byte) error {
 				return json.Unmarshal(val, &nb)
 			}); err != nil {
-				log.Warn().Err(err).
-					Str("method", "BadgerRegistry.getNotebookByDomain").
+				logging.Registry.Warn().Err(err).
+					Str("method", "BadgerRegistry.reserveRoutes").
 					Msg("Failed to unmarshal notebook")
 				continue
 			}
-
-			if nb.Domain == domain {
-				result = nb
-				found = true
-				return nil
+			route := routeKey(nb.Domain, nb.PathPrefix)
+			if owner, taken := owners[route]; taken {
+				logging.Registry.Warn().Str("id", nb.ID).Str("route", route).
+					Str("owner", owner).
+					Msg("Notebook shares its route with another; give it a new domain or prefix")
+				continue
+			}
+			owners[route] = nb.ID
+			if err := txn.Set([]byte(routePrefix+route), []byte(nb.ID)); err != nil {
+				return err
 			}
 		}
-		log.Debug().Str("method", "BadgerRegistry.getNotebookByDomain").
-			Str("domain", domain).
-			Msg("No notebook found")
 		return nil
 	})
+}
 
-	if err != nil {
-		return Notebook{}, false
+func newNotebook(req CreateUpdateNotebookRequest) Notebook {
+	desired := req.Desired
+	if desired == "" {
+		desired = DesiredRunning
+	}
+	nb := Notebook{
+		ID:            uuid.New().String(),
+		Name:          req.Name,
+		Path:          req.Path,
+		RelativePath:  req.RelativePath,
+		Assets:        req.Assets,
+		ErrorPage:     req.ErrorPage,
+		Domain:        req.Domain,
+		PathPrefix:    storedPrefix(req.PathPrefix),
+		WorkspaceID:   req.WorkspaceID,
+		Runtime:       req.Runtime,
+		Env:           req.Env,
+		Owner:         req.Owner,
+		Collaborators: req.Collaborators,
+		ShowCode:      req.ShowCode != nil && *req.ShowCode,
+		Watch:         req.Watch != nil && *req.Watch,
+		Pinned:        req.Pinned != nil && *req.Pinned,
+		Public:        req.Public != nil && *req.Public,
+		Disabled:      req.Disabled != nil && *req.Disabled,
+		ClientCert:    req.ClientCert != nil && *req.ClientCert,
+		DependsOn:     req.DependsOn,
+		Hooks:         req.Hooks.orNil(),
+		Probe:         req.Probe.orNil(),
+		Upstream:      req.Upstream.orNil(),
+		ForwardAuth:   req.ForwardAuth.orNil(),
+		AccessLog:     req.AccessLog.orNil(),
+		Limits:        req.Limits.orNil(),
+		Timeouts:      req.Timeouts.orNil(),
+		Security:      req.Security.orNil(),
+		CSP:           req.CSP.orNil(),
+		Preview:       req.Preview,
+		Desired:       desired,
+		CreatedAt:     time.Now(),
+	}
+	if req.StartTimeout != nil {
+		nb.StartTimeout = *req.StartTimeout
+	}
+	return nb
+}
+
+// WantsRunning reports whether the notebook's process should be running.
+func (nb Notebook) WantsRunning() bool {
+	return !nb.Disabled && (nb.Desired == "" || nb.Desired == DesiredRunning)
+}
+
+// WantsProcess reports whether the notebook should have a process at all. A
+// suspended notebook keeps its process, paused, so its kernel state survives.
+func (nb Notebook) WantsProcess() bool {
+	return nb.WantsRunning() || (!nb.Disabled && nb.Desired == DesiredSuspended)
+}
+
+// applyUpdate merges the non-empty fields of req into nb and reports whether
+// anything changed.
+func applyUpdate(nb *Notebook, req CreateUpdateNotebookRequest) bool {
+	updated := false
+	if req.Name != "" && req.Name != nb.Name {
+		nb.Name = req.Name
+		updated = true
+	}
+	if req.Path != "" && (req.Path != nb.Path || req.RelativePath != nb.RelativePath) {
+		nb.Path = req.Path
+		nb.RelativePath = req.RelativePath
+		updated = true
+	}
+	if req.Domain != "" && req.Domain != nb.Domain {
+		nb.Domain = req.Domain
+		updated = true
+	}
+	if req.PathPrefix != "" && storedPrefix(req.PathPrefix) != nb.PathPrefix {
+		nb.PathPrefix = storedPrefix(req.PathPrefix)
+		updated = true
+	}
+	if req.Assets != "" && req.Assets != nb.Assets {
+		nb.Assets = req.Assets
+		updated = true
+	}
+	if req.ErrorPage != "" && req.ErrorPage != nb.ErrorPage {
+		nb.ErrorPage = req.ErrorPage
+		updated = true
+	}
+	if req.WorkspaceID != "" && req.WorkspaceID != nb.WorkspaceID {
+		nb.WorkspaceID = req.WorkspaceID
+		updated = true
+	}
+	if req.Runtime != "" && req.Runtime != nb.Runtime {
+		nb.Runtime = req.Runtime
+		updated = true
+	}
+	if req.Env != nil && !maps.Equal(req.Env, nb.Env) {
+		nb.Env = req.Env
+		updated = true
+	}
+	if req.Owner != "" && req.Owner != nb.Owner {
+		nb.Owner = req.Owner
+		updated = true
+	}
+	if req.Collaborators != nil && !slices.Equal(req.Collaborators, nb.Collaborators) {
+		nb.Collaborators = req.Collaborators
+		updated = true
+	}
+	if req.ShowCode != nil && *req.ShowCode != nb.ShowCode {
+		nb.ShowCode = *req.ShowCode
+		updated = true
+	}
+	if req.Watch != nil && *req.Watch != nb.Watch {
+		nb.Watch = *req.Watch
+		updated = true
+	}
+	if req.Pinned != nil && *req.Pinned != nb.Pinned {
+		nb.Pinned = *req.Pinned
+		updated = true
+	}
+	if req.Public != nil && *req.Public != nb.Public {
+		nb.Public = *req.Public
+		updated = true
+	}
+	if req.ClientCert != nil && *req.ClientCert != nb.ClientCert {
+		nb.ClientCert = *req.ClientCert
+		updated = true
+	}
+	if req.Disabled != nil && *req.Disabled != nb.Disabled {
+		nb.Disabled = *req.Disabled
+		updated = true
+	}
+	if req.StartTimeout != nil && *req.StartTimeout != nb.StartTimeout {
+		nb.StartTimeout = *req.StartTimeout
+		updated = true
+	}
+	if req.DependsOn != nil && !slices.Equal(req.DependsOn, nb.DependsOn) {
+		nb.DependsOn = req.DependsOn
+		updated = true
+	}
+	if req.Hooks != nil && !reflect.DeepEqual(req.Hooks.orNil(), nb.Hooks) {
+		nb.Hooks = req.Hooks.orNil()
+		updated = true
+	}
+	if req.Probe != nil && !reflect.DeepEqual(req.Probe.orNil(), nb.Probe) {
+		nb.Probe = req.Probe.orNil()
+		updated = true
+	}
+	if req.Upstream != nil && !reflect.DeepEqual(req.Upstream.orNil(), nb.Upstream) {
+		nb.Upstream = req.Upstream.orNil()
+		updated = true
+	}
+	if req.ForwardAuth != nil && !reflect.DeepEqual(req.ForwardAuth.orNil(), nb.ForwardAuth) {
+		nb.ForwardAuth = req.ForwardAuth.orNil()
+		updated = true
+	}
+	if req.AccessLog != nil && !reflect.DeepEqual(req.AccessLog.orNil(), nb.AccessLog) {
+		nb.AccessLog = req.AccessLog.orNil()
+		updated = true
+	}
+	if req.Limits != nil && !reflect.DeepEqual(req.Limits.orNil(), nb.Limits) {
+		nb.Limits = req.Limits.orNil()
+		updated = true
+	}
+	if req.Timeouts != nil && !reflect.DeepEqual(req.Timeouts.orNil(), nb.Timeouts) {
+		nb.Timeouts = req.Timeouts.orNil()
+		updated = true
+	}
+	if req.Security != nil && !reflect.DeepEqual(req.Security.orNil(), nb.Security) {
+		nb.Security = req.Security.orNil()
+		updated = true
+	}
+	if req.CSP != nil && !reflect.DeepEqual(req.CSP.orNil(), nb.CSP) {
+		nb.CSP = req.CSP.orNil()
+		updated = true
+	}
+	if req.Desired != "" && req.Desired != nb.Desired {
+		nb.Desired = req.Desired
+		updated = true
+	}
+	if req.Preview != nil && (nb.Preview == nil || *req.Preview != *nb.Preview) {
+		nb.Preview = req.Preview
+		updated = true
 	}
-	return result, found
+	return updated
 }
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pathSegment is what a segment of a path prefix may contain. Prefixes are
+// lowercase so they can share the case-insensitive index of domains.
+var pathSegment = regexp.MustCompile(`^[a-z0-9][a-z0-9._~-]*$`)
+
+// NormalizePathPrefix returns the canonical form of a path prefix: "/" for
+// the root of a domain, otherwise a path starting with a slash and ending
+// without one.
+func NormalizePathPrefix(prefix string) (string, error) {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return "/", nil
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		if !pathSegment.MatchString(segment) {
+			return "", &InvalidRequestError{Reason: "invalid path prefix /" + prefix + ": segments must be lowercase letters, digits, '.', '_', '~' or '-'"}
+		}
+	}
+	return "/" + prefix, nil
+}
+
+// storedPrefix is how a normalized request prefix is stored on a notebook,
+// which leaves it empty for the root.
+func storedPrefix(prefix string) string {
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// routeKey identifies where a notebook is mounted. Postgres keeps it in the
+// domain column, so the unique index covers notebooks sharing a domain.
+func routeKey(domain, prefix string) string {
+	return normalizeHost(domain) + storedPrefix(prefix)
+}
+
+// requestRoute returns where nb is mounted once req is applied to it.
+func requestRoute(nb Notebook, req CreateUpdateNotebookRequest) (domain, prefix string) {
+	domain, prefix = nb.Domain, nb.PathPrefix
+	if req.Domain != "" {
+		domain = req.Domain
+	}
+	if req.PathPrefix != "" {
+		prefix = storedPrefix(req.PathPrefix)
+	}
+	return domain, prefix
+}
+
+// underPrefix reports whether path is prefix or lies below it.
+func underPrefix(path, prefix string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || rest[0] == '/')
+}
+
+// DomainPolicy restricts the domains notebooks may be registered under.
+type DomainPolicy struct {
+	// BaseDomain restricts notebooks to subdomains of it, which bare labels
+	// are expanded against. Empty allows any domain.
+	BaseDomain string
+	// Verify, when set, checks the domain of a notebook being added or
+	// moved to another domain, rejecting it by returning an error.
+	Verify func(domain string) error
+}
+
+func (p DomainPolicy) verify(domain string) error {
+	if p.Verify == nil || domain == "" {
+		return nil
+	}
+	return p.Verify(domain)
+}
+
+// normalizeBaseDomain returns the form a base domain is compared in.
+func normalizeBaseDomain(domain string) string {
+	return normalizeHost(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+}
+
+// normalizeRequestRoute canonicalizes the domain and path prefix of req in
+// place. With a base domain, a bare label is expanded to a subdomain of it
+// and domains outside it are rejected.
+func normalizeRequestRoute(req *CreateUpdateNotebookRequest, base string) error {
+	domain, err := NormalizeDomain(req.Domain)
+	if err != nil {
+		return err
+	}
+	if base != "" && domain != "" {
+		if !strings.Contains(domain, ".") {
+			domain += "." + base
+		}
+		if domain != base && !strings.HasSuffix(domain, "."+base) {
+			return &InvalidRequestError{Reason: "domain " + domain + " is not under " + base}
+		}
+	}
+	req.Domain = domain
+	if req.PathPrefix != "" {
+		if req.PathPrefix, err = NormalizePathPrefix(req.PathPrefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
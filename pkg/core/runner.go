@@ -2,60 +2,277 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
+	"io"
+	"net"
+	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rekk30/marimo-hub/pkg/logging"
 )
 
+type RunnerConfig struct {
+	Host           string
+	PortRangeStart int
+	PortRangeEnd   int
+	// Directory, when set, receives the address and status of every backend
+	// this runner manages so other cluster nodes can proxy to them.
+	Directory BackendDirectory
+	// AdvertiseHost is the host other nodes use to reach backends on this
+	// node. Defaults to the dial address of Host.
+	AdvertiseHost string
+	// StateDir holds a pid file per running notebook so processes orphaned
+	// by a crash can be found on the next start. Empty disables pid files.
+	StateDir string
+	// Output, when set, captures the stdout and stderr of every backend.
+	Output OutputSink
+	// MirrorOutput also logs every line of backend output in the hub's
+	// log, tagged with the notebook and the stream.
+	MirrorOutput bool
+	// NotebooksDir is where this node mounts the notebooks directory. The
+	// relative path of a notebook is resolved against it.
+	NotebooksDir string
+	// StartTimeout is how long a process may take to accept connections
+	// before it is killed, for notebooks that don't set their own. Zero
+	// waits as long as it takes.
+	StartTimeout time.Duration
+	// StartParallelism bounds how many notebooks start at once, queueing
+	// the rest. Zero starts every notebook right away.
+	StartParallelism int
+	// StartJitter spreads queued starts by delaying each by a random
+	// duration up to it.
+	StartJitter time.Duration
+	// SecretsDir holds a file per secret that notebook environments refer
+	// to as ${secret:name}. Empty leaves them unresolvable.
+	SecretsDir string
+	// IdleTimeout stops notebooks nobody has had a request or session open
+	// to for that long, until the next one wakes them. Zero keeps them
+	// running.
+	IdleTimeout time.Duration
+	// Launcher starts the backends of notebooks. Defaults to ExecLauncher.
+	Launcher Launcher
+}
+
+// OutputSink opens the writer a notebook's process output is captured to.
+type OutputSink interface {
+	Open(id string) (io.WriteCloser, error)
+}
+
+// outputWaitDelay bounds how long a stopped backend's output is drained, in
+// case a child outlived it and still holds the pipe open.
+const outputWaitDelay = 5 * time.Second
+
+// adoptedPollInterval is how often an adopted process is checked for having
+// exited.
+const adoptedPollInterval = time.Second
+
+// readyPollInterval is how often a starting process is checked for
+// accepting connections.
+const readyPollInterval = 250 * time.Millisecond
+
+// directoryRefresh is how often the backend directory is read for the
+// notebooks other nodes run, which the proxy finds there once it has been.
+const directoryRefresh = time.Second
+
 type Runner struct {
 	ctx      context.Context
 	cancel   context.CancelFunc
 	mu       sync.RWMutex
 	managers map[string]*NotebookManager
-	nextPort atomic.Int64
+	pending  map[string]Notebook
+	ports    *portPool
+	host     string
+	stateDir string
+	output   OutputSink
+	mirror   bool
+	dir      string
+	timeout  time.Duration
+	secrets  string
+	launcher Launcher
+	leader   atomic.Bool
+	released atomic.Bool
+
+	directory     BackendDirectory
+	advertiseHost string
+	// states holds the latest state of each backend not yet published,
+	// and statesReady wakes advertiseLoop to publish them.
+	statesMu    sync.Mutex
+	states      map[string]backendState
+	statesReady chan struct{}
+	publishMu   sync.Mutex
+	// remote is the directory as last read, for notebooks other nodes run.
+	remote atomic.Pointer[map[string]Backend]
+
+	workspaces WorkspaceGetter
+	notebooks  NotebookLister
+
+	startMu     sync.Mutex
+	startQueue  []*NotebookManager
+	startReady  chan struct{}
+	startJitter time.Duration
+
+	crashMu sync.Mutex
+	crashes []Crash
+
+	conns *connTracker
 }
 
-func NewRunner(ctx context.Context) *Runner {
+func NewRunner(ctx context.Context, cfg RunnerConfig) *Runner {
 	ctx, cancel := context.WithCancel(ctx)
 	r := &Runner{
 		ctx:      ctx,
 		cancel:   cancel,
 		managers: make(map[string]*NotebookManager),
+		pending:  make(map[string]Notebook),
+		ports:    newPortPool(cfg.PortRangeStart, cfg.PortRangeEnd),
+		host:     cfg.Host,
+		stateDir: cfg.StateDir,
+		output:   cfg.Output,
+		mirror:   cfg.MirrorOutput,
+		dir:      cfg.NotebooksDir,
+		timeout:  cfg.StartTimeout,
+		secrets:  cfg.SecretsDir,
+		launcher: cfg.Launcher,
+
+		directory:     cfg.Directory,
+		advertiseHost: cfg.AdvertiseHost,
+		startJitter:   cfg.StartJitter,
+		conns:         newConnTracker(),
+	}
+	if r.launcher == nil {
+		r.launcher = ExecLauncher{}
+	}
+	r.leader.Store(true)
+	if r.stateDir != "" {
+		if err := os.MkdirAll(r.stateDir, 0o755); err != nil {
+			logging.Runner.Warn().Err(err).Str("dir", r.stateDir).Msg("Failed to create state directory, pid files disabled")
+			r.stateDir = ""
+		}
+	}
+	if r.directory != nil {
+		r.states = make(map[string]backendState)
+		r.statesReady = make(chan struct{}, 1)
+		go r.advertiseLoop()
+	}
+	if cfg.StartParallelism > 0 {
+		r.startWorkers(cfg.StartParallelism)
+	}
+	go r.queueLoop()
+	if cfg.IdleTimeout > 0 {
+		go r.runIdleCheck(cfg.IdleTimeout)
 	}
-	r.nextPort.Store(3000)
 	return r
 }
 
+// SetLeader toggles whether this runner schedules notebook processes. When
+// leadership is lost every running notebook is stopped so the new leader can
+// take over.
+func (r *Runner) SetLeader(isLeader bool) {
+	if r.leader.Swap(isLeader) == isLeader || isLeader {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range r.managers {
+		r.removeLocked(id)
+	}
+	clear(r.pending)
+}
+
+func (r *Runner) IsLeader() bool {
+	return r.leader.Load()
+}
+
+// Release stops managing notebook processes without stopping them, so that
+// a hub taking over can adopt them. Addresses and statuses are still
+// answered, for the proxy to finish the sessions it is serving. It returns
+// the pipes the processes write their output to, named after their
+// notebook and stream, for the hub taking over to keep reading.
+func (r *Runner) Release() map[string]*os.File {
+	r.released.Store(true)
+	r.leader.Store(false)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	outputs := make(map[string]*os.File)
+	for id, manager := range r.managers {
+		for stream, pipe := range manager.release() {
+			outputs[outputKey(id, stream)] = pipe
+		}
+	}
+	clear(r.pending)
+	return outputs
+}
+
+// HandleEvent makes the runner an event sink.
+func (r *Runner) HandleEvent(e Event) error {
+	r.HandleRegistryEvent(e.Notebook, e.Action)
+	return nil
+}
+
 func (r *Runner) HandleRegistryEvent(nb Notebook, action RegistryAction) {
-	log.Debug().Str("method", "Runner.HandleRegistryEvent").
+	if r.released.Load() {
+		return
+	}
+	logging.Runner.Debug().Str("method", "Runner.HandleRegistryEvent").
 		Interface("notebook", nb).
 		Interface("action", action).
 		Msg("Handling registry event")
 	switch action {
 	case ActionAdd, ActionUpdate:
+		if !r.IsLeader() {
+			return
+		}
 		r.handleNotebook(nb)
 	case ActionDelete:
 		r.mu.Lock()
-		if manager, exists := r.managers[nb.ID]; exists {
-			manager.stop()
-			delete(r.managers, nb.ID)
-		}
+		r.removeLocked(nb.ID)
+		delete(r.pending, nb.ID)
 		r.mu.Unlock()
+		go r.processQueue()
 	}
 }
 
+// removeLocked stops a notebook and frees its port. Must hold r.mu.
+func (r *Runner) removeLocked(id string) {
+	manager, exists := r.managers[id]
+	if !exists {
+		return
+	}
+	manager.stop()
+	r.ports.release(manager.port)
+	delete(r.managers, id)
+	r.conns.forget(id)
+}
+
 func (r *Runner) handleNotebook(nb Notebook) {
+	if !nb.WantsProcess() {
+		r.mu.Lock()
+		_, running := r.managers[nb.ID]
+		r.removeLocked(nb.ID)
+		delete(r.pending, nb.ID)
+		r.mu.Unlock()
+		if running {
+			logging.Runner.Info().Str("method", "Runner.handleNotebook").
+				Str("notebook", nb.ID).
+				Str("desired", string(nb.Desired)).
+				Msg("Notebook stopped")
+			go r.processQueue()
+		}
+		return
+	}
+
 	r.mu.Lock()
 	if existingManager, exists := r.managers[nb.ID]; exists {
-		log.Debug().Str("method", "Runner.handleNotebook").
+		logging.Runner.Debug().Str("method", "Runner.handleNotebook").
 			Str("notebook", nb.ID).
 			Msg("Updating notebook")
 		if err := existingManager.update(nb); err != nil {
-			log.Error().Str("method", "Runner.handleNotebook").
+			logging.Runner.Error().Str("method", "Runner.handleNotebook").
 				Str("notebook", nb.ID).
 				Err(err).
 				Msg("Failed to update notebook")
@@ -64,17 +281,100 @@ func (r *Runner) handleNotebook(nb Notebook) {
 		return
 	}
 
-	port := int(r.nextPort.Add(1))
-	newManager := &NotebookManager{
-		notebook: nb,
-		port:     port,
-		ctx:      r.ctx,
+	if err := r.admitLocked(nb); err != nil {
+		r.pending[nb.ID] = nb
+		r.mu.Unlock()
+		logging.Runner.Info().Str("method", "Runner.handleNotebook").
+			Str("notebook", nb.ID).
+			Err(err).
+			Msg("Notebook queued")
+		return
 	}
-	r.managers[nb.ID] = newManager
+	delete(r.pending, nb.ID)
+
+	newManager, err := r.createLocked(nb)
 	r.mu.Unlock()
+	if err != nil {
+		logging.Runner.Error().Str("method", "Runner.handleNotebook").
+			Str("notebook", nb.ID).
+			Err(err).
+			Msg("Failed to allocate port")
+		return
+	}
+
+	r.enqueueStart(newManager)
+}
+
+// Restart restarts the process of a notebook, picking up changes outside its
+// definition such as edits to its files.
+func (r *Runner) Restart(id string) error {
+	if r.released.Load() {
+		return &NotRunningError{ID: id}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manager, exists := r.managers[id]
+	if !exists {
+		return &NotRunningError{ID: id}
+	}
+	return manager.restart()
+}
+
+// Kill sends SIGKILL to the whole process group of a notebook at once, for
+// a backend wedged in native code. Unlike stopping it, killing neither waits
+// for the notebook's lock nor changes its desired state, so the notebook is
+// restarted like after a crash if it should still run. It returns the pid of
+// the process killed.
+func (r *Runner) Kill(id string) (int, error) {
+	if r.released.Load() {
+		return 0, &NotRunningError{ID: id}
+	}
+	r.mu.RLock()
+	manager, exists := r.managers[id]
+	r.mu.RUnlock()
+	if !exists {
+		return 0, &NotRunningError{ID: id}
+	}
+	pid, err := manager.kill(id)
+	if err != nil {
+		return pid, err
+	}
+	logging.Runner.Warn().Str("method", "Runner.Kill").
+		Str("notebook", id).
+		Int("pid", pid).
+		Msg("Killed notebook process group")
+	return pid, nil
+}
 
-	if err := newManager.start(); err != nil {
+// createLocked allocates a port and registers a manager for nb. Must hold r.mu.
+func (r *Runner) createLocked(nb Notebook) (*NotebookManager, error) {
+	port, err := r.ports.acquire()
+	if err != nil {
+		return nil, err
 	}
+	return r.newManagerLocked(nb, port), nil
+}
+
+// newManagerLocked registers a manager for nb on port. Must hold r.mu.
+func (r *Runner) newManagerLocked(nb Notebook, port int) *NotebookManager {
+	manager := &NotebookManager{
+		notebook: nb,
+		host:     r.host,
+		port:     port,
+		stateDir: r.stateDir,
+		output:   r.output,
+		mirror:   r.mirror,
+		dir:      r.dir,
+		timeout:  r.timeout,
+		secrets:  r.secrets,
+		launcher: r.launcher,
+		ctx:      r.ctx,
+		report:   r.report,
+		changed:  r.restartWhenUnused,
+		failed:   r.recordCrash,
+	}
+	r.managers[nb.ID] = manager
+	return manager
 }
 
 func (r *Runner) Stop() {
@@ -82,26 +382,56 @@ func (r *Runner) Stop() {
 	r.cancel()
 
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	for id, manager := range r.managers {
-		_ = id
-		manager.stop()
+	for id := range r.managers {
+		r.removeLocked(id)
+	}
+	clear(r.pending)
+	r.mu.Unlock()
+
+	// Withdraw the backends now, as nothing publishes them any more.
+	if r.directory != nil {
+		r.publishStates()
 	}
 }
 
 func (r *Runner) GetStatus(id string) (Status, error) {
 	r.mu.RLock()
 	manager, exists := r.managers[id]
+	_, queued := r.pending[id]
 	r.mu.RUnlock()
 
+	if queued {
+		return StatusPending, nil
+	}
 	if !exists {
+		if backend, ok := r.remoteBackend(id); ok {
+			return backend.Status, nil
+		}
 		return StatusStopped, &NotRunningError{ID: id}
 	}
 
 	return manager.getStatus(), nil
 }
 
+// GetReason explains why a notebook this runner manages is in Error, if it
+// knows.
+func (r *Runner) GetReason(id string) string {
+	r.mu.RLock()
+	manager, exists := r.managers[id]
+	r.mu.RUnlock()
+	if !exists {
+		return ""
+	}
+
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	if manager.status != StatusError {
+		return ""
+	}
+	return manager.reason
+}
+
 func (r *Runner) GetPort(id string) (int, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -113,23 +443,194 @@ func (r *Runner) GetPort(id string) (int, bool) {
 	return manager.port, true
 }
 
+// GetAddress returns the host:port the proxy should dial to reach a notebook,
+// falling back to the backend directory for notebooks run by other nodes.
+func (r *Runner) GetAddress(id string) (string, bool) {
+	r.mu.RLock()
+	manager, exists := r.managers[id]
+	r.mu.RUnlock()
+
+	if !exists {
+		if backend, ok := r.remoteBackend(id); ok {
+			return backend.Address, true
+		}
+		return "", false
+	}
+	return manager.address(), true
+}
+
+func (r *Runner) report(id string, port int, status Status) {
+	if r.directory == nil {
+		return
+	}
+	host := r.advertiseHost
+	if host == "" {
+		host = r.host
+	}
+	// Managers report while holding their locks, so this must not wait on
+	// the directory. Only the latest state of each backend is worth
+	// publishing.
+	r.statesMu.Lock()
+	r.states[id] = backendState{id: id, address: dialAddress(host, port), status: status}
+	r.statesMu.Unlock()
+	select {
+	case r.statesReady <- struct{}{}:
+	default:
+	}
+}
+
+// dialAddress turns a bind host into something a client can connect to.
+func dialAddress(host string, port int) string {
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, fmt.Sprintf("%d", port))
+}
+
+// advertiseLoop publishes the states backends report, and reads the
+// directory every directoryRefresh for the backends of other nodes. States
+// that failed to publish are tried again then too.
+func (r *Runner) advertiseLoop() {
+	r.refreshBackends()
+	ticker := time.NewTicker(directoryRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.statesReady:
+			r.publishStates()
+		case <-ticker.C:
+			r.publishStates()
+			r.refreshBackends()
+		}
+	}
+}
+
+// publishStates publishes the states reported since it last ran. A state
+// that fails to publish is kept for the next run, unless the backend has
+// reported a newer one meanwhile.
+func (r *Runner) publishStates() {
+	r.publishMu.Lock()
+	defer r.publishMu.Unlock()
+
+	r.statesMu.Lock()
+	states := r.states
+	if len(states) == 0 {
+		r.statesMu.Unlock()
+		return
+	}
+	r.states = make(map[string]backendState)
+	r.statesMu.Unlock()
+
+	var failed []backendState
+	for _, st := range states {
+		var err error
+		if st.status == StatusStopped {
+			err = r.directory.Withdraw(st.id)
+		} else {
+			err = r.directory.Advertise(st.id, st.address, st.status)
+		}
+		if err != nil {
+			logging.Runner.Warn().Str("method", "Runner.publishStates").
+				Str("notebook", st.id).
+				Err(err).
+				Msg("Failed to publish backend state")
+			failed = append(failed, st)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	r.statesMu.Lock()
+	defer r.statesMu.Unlock()
+	for _, st := range failed {
+		if _, newer := r.states[st.id]; !newer {
+			r.states[st.id] = st
+		}
+	}
+}
+
+// refreshBackends reads the directory again. The backends last read are
+// kept when that fails.
+func (r *Runner) refreshBackends() {
+	backends, err := r.directory.Backends()
+	if err != nil {
+		logging.Runner.Warn().Str("method", "Runner.refreshBackends").
+			Err(err).
+			Msg("Failed to read backend directory")
+		return
+	}
+	r.remote.Store(&backends)
+}
+
+// remoteBackend returns the backend of the notebook with id as the directory
+// last listed it.
+func (r *Runner) remoteBackend(id string) (Backend, bool) {
+	backends := r.remote.Load()
+	if backends == nil {
+		return Backend{}, false
+	}
+	backend, ok := (*backends)[id]
+	return backend, ok
+}
+
 //--- NotebookManager ---//
 
 type NotebookManager struct {
 	notebook Notebook
+	host     string
 	port     int
+	stateDir string
+	output   OutputSink
+	mirror   bool
+	dir      string
+	timeout  time.Duration
+	secrets  string
+	launcher Launcher
 	ctx      context.Context
-	cmd      *exec.Cmd
+	proc     Process
+	capture  *capture
 	status   Status
+	reason   string
 	mu       sync.RWMutex
+	report   func(id string, port int, status Status)
+	// changed is called when the files of a watched directory-backed
+	// notebook change, and unwatch stops watching them.
+	changed func(id string) error
+	unwatch context.CancelFunc
+	// failed is called when the process exits with an error or never
+	// becomes ready.
+	failed func(id, reason string)
+	// hookCancel is set while the pre-start hook runs, and stopping the
+	// notebook cancels it.
+	hookCancel context.CancelFunc
+	// current mirrors proc, or is nil without a process, so that the
+	// process can be killed and reached without waiting for m.mu.
+	current atomic.Pointer[Process]
+	// health is what the probe found since the process started.
+	health HealthState
 }
 
 func (m *NotebookManager) update(nb Notebook) error {
 	m.mu.Lock()
-	needsRestart := m.cmd != nil
+	prev := m.notebook
+	needsRestart := m.proc != nil
 	m.notebook = nb
+	if needsRestart {
+		// A hub adopting the process compares against this definition.
+		m.writePIDFile()
+	}
 	m.mu.Unlock()
 
+	// Suspending and resuming keep the process, so only a change to the
+	// definition itself needs a restart.
+	prev.Desired = nb.Desired
+	if needsRestart && sameNotebook(prev, nb) {
+		return m.setSuspended(nb.Desired == DesiredSuspended)
+	}
+
 	if needsRestart {
 		if err := m.stop(); err != nil {
 			return err
@@ -142,86 +643,491 @@ func (m *NotebookManager) update(nb Notebook) error {
 func (m *NotebookManager) stop() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.stopLocked(StatusStopped)
+}
+
+// idle stops the process of a running notebook for being unused, leaving
+// the notebook to be woken. It reports whether the notebook was running.
+func (m *NotebookManager) idle() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.status != StatusRunning || m.proc == nil {
+		return false
+	}
+	return m.stopLocked(StatusIdle) == nil
+}
+
+// wake moves an idle notebook on to being started, and reports whether it
+// was idle.
+func (m *NotebookManager) wake() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.status != StatusIdle {
+		return false
+	}
+	m.setStatus(StatusPending)
+	return true
+}
 
-	if m.cmd == nil {
+// stopLocked kills the process, or cancels its pre-start hook, and leaves
+// the notebook in status. Must hold m.mu.
+func (m *NotebookManager) stopLocked(status Status) error {
+	if m.hookCancel != nil {
+		m.hookCancel()
+		m.hookCancel = nil
+		m.setStatus(status)
+		return nil
+	}
+	if m.proc == nil {
 		return &NotRunningError{ID: m.notebook.ID}
 	}
 
-	if err := m.cmd.Process.Kill(); err != nil {
-		return &ProcessKillError{PID: m.cmd.Process.Pid, Err: err}
+	if err := m.proc.Stop(); err != nil {
+		return &ProcessKillError{PID: m.proc.Pid(), Err: err}
 	}
 
-	m.cmd = nil
-	m.status = StatusStopped
-	log.Debug().Str("method", "NotebookManager.stop").
+	if m.unwatch != nil {
+		m.unwatch()
+		m.unwatch = nil
+	}
+	m.removePIDFile()
+	m.setProcLocked(nil)
+	m.setStatus(status)
+	logging.Runner.Debug().Str("method", "NotebookManager.stop").
 		Str("notebook", m.notebook.ID).
 		Msg("Notebook stopped")
 	return nil
 }
 
+// adopt takes over proc, a process started by another hub for this
+// notebook, and watches for it to exit. pipes, by stream, are where the
+// process writes its output.
+func (m *NotebookManager) adopt(proc *os.Process, pipes map[string]*os.File) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	process := adoptedProcess(proc, m.address())
+	m.setProcLocked(process)
+	m.capture = nil
+	if len(pipes) > 0 {
+		m.capture = newCapture(pipes, m.openOutput("NotebookManager.adopt", true), m.mirrorID())
+	}
+	m.writePIDFile()
+	if m.notebook.Desired == DesiredSuspended {
+		m.setStatus(StatusSuspended)
+	} else {
+		m.setStatus(StatusRunning)
+	}
+	if file, dir := notebookEntry(m.notebook.LocalPath(m.dir)); dir != "" && m.notebook.Watch {
+		m.watchLocked(dir, file)
+	}
+	m.health = HealthState{}
+	go m.watchHealth(process)
+	go m.monitor(process, m.capture)
+}
+
+// release forgets the process, leaving it running and its pid file in
+// place for the hub taking over, and returns the pipes of its output by
+// stream.
+func (m *NotebookManager) release() map[string]*os.File {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unwatch != nil {
+		m.unwatch()
+		m.unwatch = nil
+	}
+	if m.proc == nil || m.capture == nil {
+		return nil
+	}
+	return m.capture.detach()
+}
+
+// openOutput opens the log of the process output, or returns nil if it
+// isn't captured. With required, as for the output of an adopted process
+// that has to be read even if it can't be stored lest the process block on
+// a full pipe, or output mirrored into the hub's log, it never returns nil.
+// Must hold m.mu.
+func (m *NotebookManager) openOutput(method string, required bool) io.WriteCloser {
+	required = required || m.mirror
+	if m.output == nil {
+		if required {
+			return nopWriteCloser{io.Discard}
+		}
+		return nil
+	}
+	w, err := m.output.Open(m.notebook.ID)
+	if err != nil {
+		msg := "Failed to open process log, output is discarded"
+		if m.mirror {
+			msg = "Failed to open process log, output is only mirrored"
+		}
+		logging.Runner.Warn().Str("method", method).
+			Str("notebook", m.notebook.ID).
+			Err(err).
+			Msg(msg)
+		if required {
+			return nopWriteCloser{io.Discard}
+		}
+		return nil
+	}
+	return w
+}
+
+// mirrorID is the id the output of the process is mirrored as, or empty if
+// it isn't mirrored.
+func (m *NotebookManager) mirrorID() string {
+	if !m.mirror {
+		return ""
+	}
+	return m.notebook.ID
+}
+
+func (m *NotebookManager) restart() error {
+	var notRunning *NotRunningError
+	if err := m.stop(); err != nil && !errors.As(err, &notRunning) {
+		return err
+	}
+	return m.start()
+}
+
+// watchLocked restarts the notebook when anything in its directory other
+// than the entry file changes; marimo already reloads the entry file itself.
+// Must hold m.mu.
+func (m *NotebookManager) watchLocked(dir, entry string) {
+	// A watcher outlives a process that exited on its own, so that fixing
+	// the files brings it back.
+	if m.unwatch != nil {
+		m.unwatch()
+		m.unwatch = nil
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	id := m.notebook.ID
+	err := watchTree(ctx, dir, entry, func() {
+		logging.Runner.Info().Str("method", "NotebookManager.watch").
+			Str("notebook", id).
+			Msg("Notebook files changed, restarting")
+		if err := m.changed(id); err != nil {
+			logging.Runner.Error().Str("method", "NotebookManager.watch").
+				Str("notebook", id).
+				Err(err).
+				Msg("Failed to restart notebook")
+		}
+	})
+	if err != nil {
+		cancel()
+		logging.Runner.Warn().Str("method", "NotebookManager.watch").
+			Str("notebook", id).
+			Str("dir", dir).
+			Err(err).
+			Msg("Failed to watch notebook directory")
+		return
+	}
+	m.unwatch = cancel
+}
+
 func (m *NotebookManager) start() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.cmd != nil {
+	if m.proc != nil || m.hookCancel != nil {
 		return &AlreadyRunningError{ID: m.notebook.ID}
 	}
+	if m.notebook.Hooks != nil && m.notebook.Hooks.PreStart != nil {
+		// The hook may take a while, and callers may hold the runner's lock.
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.hookCancel = cancel
+		m.reason = ""
+		m.setStatus(StatusStarting)
+		go m.startAfterHook(ctx, m.notebook)
+		return nil
+	}
+	return m.launchLocked()
+}
 
-	cmd := exec.CommandContext(m.ctx, "marimo", "run", m.notebook.Path,
-		"--port", fmt.Sprintf("%d", m.port),
-		"--host", "0.0.0.0",
-		"--headless",
-		"--no-token")
-	if m.notebook.Watch {
-		cmd.Args = append(cmd.Args, "--watch")
+// launchLocked starts the process. Must hold m.mu.
+func (m *NotebookManager) launchLocked() error {
+	file, dir := notebookEntry(m.notebook.LocalPath(m.dir))
+	spec := LaunchSpec{Notebook: m.notebook, File: file, Dir: dir, Host: m.host, Port: m.port}
+	if len(m.notebook.Env) > 0 {
+		env, err := m.resolveEnv(m.notebook)
+		if err != nil {
+			m.reason = err.Error()
+			m.setStatus(StatusError)
+			return err
+		}
+		spec.Env = env
 	}
-	if m.notebook.ShowCode {
-		cmd.Args = append(cmd.Args, "--include-code")
+
+	var pipes map[string]*os.File
+	var writers []*os.File
+	output := m.openOutput("NotebookManager.start", false)
+	if output != nil {
+		var err error
+		pipes, writers, err = outputPipes(&spec, m.mirror)
+		if err != nil {
+			logging.Runner.Warn().Str("method", "NotebookManager.start").
+				Str("notebook", m.notebook.ID).
+				Err(err).
+				Msg("Failed to create output pipes, output is discarded")
+			output.Close()
+			output = nil
+		}
 	}
 
-	if err := cmd.Start(); err != nil {
-		m.status = StatusError
-		return &ExecError{Command: "marimo run", Err: err}
+	proc, err := m.launcher.Start(m.ctx, spec)
+	for _, w := range writers {
+		w.Close()
+	}
+	if err != nil {
+		if output != nil {
+			closeFiles(pipes)
+			output.Close()
+		}
+		m.reason = err.Error()
+		m.setStatus(StatusError)
+		return err
+	}
+	m.capture = nil
+	if output != nil {
+		m.capture = newCapture(pipes, output, m.mirrorID())
 	}
 
-	log.Debug().Str("method", "NotebookManager.start").
+	logging.Runner.Debug().Str("method", "NotebookManager.start").
 		Str("notebook", m.notebook.ID).
-		Str("command", strings.Join(cmd.Args, " ")).
+		Str("address", proc.Address()).
+		Int("pid", proc.Pid()).
 		Msg("Notebook started")
 
-	m.cmd = cmd
-	m.status = StatusRunning
+	m.setProcLocked(proc)
+	m.writePIDFile()
+	m.reason = ""
+	m.health = HealthState{}
+	m.setStatus(StatusStarting)
+	if dir != "" && m.notebook.Watch {
+		m.watchLocked(dir, file)
+	}
+
+	go m.monitor(proc, m.capture)
 
-	go m.monitor()
+	if m.notebook.Desired == DesiredSuspended {
+		return m.suspendLocked()
+	}
+	timeout := m.timeout
+	if m.notebook.StartTimeout > 0 {
+		timeout = time.Duration(m.notebook.StartTimeout) * time.Second
+	}
+	go m.awaitReady(proc, timeout)
 	return nil
 }
 
+// awaitReady marks proc running once it accepts connections, or passes its
+// probe, and then watches its health. One that isn't ready within timeout
+// is killed instead of being left half started.
+func (m *NotebookManager) awaitReady(proc Process, timeout time.Duration) {
+	addr := m.address()
+	m.mu.RLock()
+	p, prefix, upstream := m.notebook.Probe, m.notebook.PathPrefix, m.notebook.Upstream
+	m.mu.RUnlock()
+	probeTimeout := readyPollInterval
+	if p != nil && p.Path != "" {
+		probeTimeout = p.timeout()
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := probe(m.ctx, addr, prefix, p, upstream, probeTimeout)
+
+		m.mu.Lock()
+		if m.proc != proc || m.status != StatusStarting {
+			// Stopped, exited or suspended meanwhile.
+			m.mu.Unlock()
+			return
+		}
+		if err == nil {
+			m.recordProbeLocked(nil)
+			m.setStatus(StatusRunning)
+			m.mu.Unlock()
+			logging.Runner.Debug().Str("method", "NotebookManager.awaitReady").
+				Str("notebook", m.notebook.ID).
+				Msg("Notebook ready")
+			go m.watchHealth(proc)
+			return
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			reason := fmt.Sprintf("not accepting connections after %s", timeout)
+			if p != nil && p.Path != "" {
+				reason = fmt.Sprintf("not passing its probe after %s: %v", timeout, err)
+			}
+			m.failLocked(reason, "Notebook failed to start")
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+		time.Sleep(readyPollInterval)
+	}
+}
+
+// failLocked kills a process that never became ready, or stopped being
+// healthy, records why and logs msg. The watcher is kept, so fixing the
+// files starts it again. Must hold m.mu.
+func (m *NotebookManager) failLocked(reason, msg string) {
+	if err := m.proc.Stop(); err != nil {
+		logging.Runner.Warn().Str("method", "NotebookManager.failLocked").
+			Str("notebook", m.notebook.ID).
+			Err(err).
+			Msg("Failed to kill notebook")
+	}
+	m.removePIDFile()
+	m.setProcLocked(nil)
+	m.reason = reason
+	m.setStatus(StatusError)
+	if m.failed != nil {
+		m.failed(m.notebook.ID, reason)
+	}
+	logging.Runner.Error().Str("method", "NotebookManager.failLocked").
+		Str("notebook", m.notebook.ID).
+		Str("reason", reason).
+		Msg(msg)
+}
+
+// setSuspended pauses or resumes the process without losing its state.
+func (m *NotebookManager) setSuspended(suspend bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.proc == nil {
+		return &NotRunningError{ID: m.notebook.ID}
+	}
+	if suspend {
+		return m.suspendLocked()
+	}
+	if m.status != StatusSuspended {
+		return nil
+	}
+	suspender, ok := m.proc.(Suspender)
+	if !ok {
+		return &SignalError{PID: m.proc.Pid(), Signal: "resume", Err: errSuspendUnsupportedBackend}
+	}
+	if err := suspender.Resume(); err != nil {
+		return &SignalError{PID: m.proc.Pid(), Signal: "resume", Err: err}
+	}
+	m.setStatus(StatusRunning)
+	logging.Runner.Debug().Str("method", "NotebookManager.setSuspended").
+		Str("notebook", m.notebook.ID).
+		Msg("Notebook resumed")
+	return nil
+}
+
+// suspendLocked must be called with m.mu held and a running process.
+func (m *NotebookManager) suspendLocked() error {
+	if m.status == StatusSuspended {
+		return nil
+	}
+	suspender, ok := m.proc.(Suspender)
+	if !ok {
+		return &SignalError{PID: m.proc.Pid(), Signal: "suspend", Err: errSuspendUnsupportedBackend}
+	}
+	if err := suspender.Suspend(); err != nil {
+		return &SignalError{PID: m.proc.Pid(), Signal: "suspend", Err: err}
+	}
+	m.setStatus(StatusSuspended)
+	logging.Runner.Debug().Str("method", "NotebookManager.suspendLocked").
+		Str("notebook", m.notebook.ID).
+		Msg("Notebook suspended")
+	return nil
+}
+
+// setProcLocked records proc as the process of the notebook. Must hold m.mu.
+func (m *NotebookManager) setProcLocked(proc Process) {
+	m.proc = proc
+	if proc == nil {
+		m.current.Store(nil)
+		return
+	}
+	m.current.Store(&proc)
+}
+
+// kill kills the process of the notebook right away, without taking m.mu,
+// and returns its pid. Noticing the exit is left to whatever watches the
+// process, as when it crashes.
+func (m *NotebookManager) kill(id string) (int, error) {
+	current := m.current.Load()
+	if current == nil {
+		return 0, &NotRunningError{ID: id}
+	}
+	proc := *current
+	if err := proc.Stop(); err != nil {
+		return proc.Pid(), &ProcessKillError{PID: proc.Pid(), Err: err}
+	}
+	return proc.Pid(), nil
+}
+
+// address is where the process of the notebook is reached, or would be
+// once it starts.
+func (m *NotebookManager) address() string {
+	if current := m.current.Load(); current != nil {
+		return (*current).Address()
+	}
+	return dialAddress(m.host, m.port)
+}
+
+// snapshot returns the notebook, its status and its process, which is nil
+// if none runs.
+func (m *NotebookManager) snapshot() (Notebook, Status, Process) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.notebook, m.status, m.proc
+}
+
+// setStatus must be called with m.mu held.
+func (m *NotebookManager) setStatus(status Status) {
+	m.status = status
+	if m.report != nil {
+		m.report(m.notebook.ID, m.port, status)
+	}
+}
+
 func (m *NotebookManager) getStatus() Status {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	return m.status
 }
 
-func (m *NotebookManager) monitor() {
-	log.Debug().Str("method", "NotebookManager.monitor").
+// monitor waits for proc to exit and closes its output. A process that was
+// stopped or replaced by a restart in the meantime no longer owns the
+// manager's state.
+func (m *NotebookManager) monitor(proc Process, output *capture) {
+	logging.Runner.Debug().Str("method", "NotebookManager.monitor").
 		Str("notebook", m.notebook.ID).
 		Msg("Monitoring notebook")
-	err := m.cmd.Wait()
+	err := proc.Wait()
+	if output != nil {
+		output.close()
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.postStop(m.notebook)
+
+	if m.proc != proc {
+		return
+	}
 
 	if err != nil && err.Error() != "signal: killed" {
-		m.status = StatusError
-		log.Error().Str("method", "NotebookManager.monitor").
+		m.reason = err.Error()
+		m.setStatus(StatusError)
+		if m.failed != nil {
+			m.failed(m.notebook.ID, m.reason)
+		}
+		logging.Runner.Error().Str("method", "NotebookManager.monitor").
 			Str("notebook", m.notebook.ID).
 			Err(err).
 			Msg("Notebook failed")
 	} else {
-		m.status = StatusStopped
-		log.Debug().Str("method", "NotebookManager.monitor").
+		m.setStatus(StatusStopped)
+		logging.Runner.Debug().Str("method", "NotebookManager.monitor").
 			Str("notebook", m.notebook.ID).
 			Msg("Notebook stopped")
 	}
 
-	m.cmd = nil
+	m.removePIDFile()
+	m.setProcLocked(nil)
 }
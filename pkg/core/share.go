@@ -0,0 +1,131 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultShareTTL is how long a share link lasts unless its creator says
+// otherwise.
+const DefaultShareTTL = 48 * time.Hour
+
+var (
+	ErrShareLinkInvalid = errors.New("share link is invalid")
+	ErrShareLinkExpired = errors.New("share link has expired")
+	ErrShareLinkUsedUp  = errors.New("share link has been used up")
+)
+
+// ShareLink lets whoever holds it visit a notebook until it expires, past
+// the forward auth and client certificates the notebook otherwise requires.
+type ShareLink struct {
+	ID         string    `json:"id"`
+	NotebookID string    `json:"notebook_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// MaxUses, if set, is how many visitors may open the link. Each gets a
+	// cookie that keeps them in until the link expires.
+	MaxUses int `json:"max_uses,omitempty"`
+}
+
+// ShareLinks signs share links and checks the ones visitors bring. Links
+// aren't stored: a token carries its link along with an HMAC of it, so
+// every hub with the same secret honors it. Uses are counted by each hub
+// in memory, so a restart starts them over.
+type ShareLinks struct {
+	secret []byte
+
+	mu   sync.Mutex
+	uses map[string]int
+	// expiry is when each link in uses expires, after which it's dropped.
+	expiry map[string]time.Time
+}
+
+// NewShareLinks signs links with secret, or with a random one if it is
+// empty, which makes the links of a hub stop working once it stops.
+func NewShareLinks(secret string) (*ShareLinks, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate share secret: %w", err)
+		}
+	}
+	return &ShareLinks{secret: key, uses: map[string]int{}, expiry: map[string]time.Time{}}, nil
+}
+
+// Create makes a link to the notebook with id lasting ttl and returns it
+// with its token.
+func (s *ShareLinks) Create(notebookID string, ttl time.Duration, maxUses int) (ShareLink, string, error) {
+	link := ShareLink{
+		ID:         uuid.New().String(),
+		NotebookID: notebookID,
+		ExpiresAt:  time.Now().Add(ttl).Truncate(time.Second).UTC(),
+		MaxUses:    maxUses,
+	}
+	data, err := json.Marshal(link)
+	if err != nil {
+		return ShareLink{}, "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return link, payload + "." + s.sign(payload), nil
+}
+
+func (s *ShareLinks) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns the link token stands for if it is genuine, unexpired and
+// to the notebook with id.
+func (s *ShareLinks) Verify(token, notebookID string) (ShareLink, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return ShareLink{}, ErrShareLinkInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ShareLink{}, ErrShareLinkInvalid
+	}
+	var link ShareLink
+	if err := json.Unmarshal(data, &link); err != nil || link.NotebookID != notebookID {
+		return ShareLink{}, ErrShareLinkInvalid
+	}
+	if time.Now().After(link.ExpiresAt) {
+		return link, ErrShareLinkExpired
+	}
+	return link, nil
+}
+
+// Redeem verifies token like Verify, counting a use of its link.
+func (s *ShareLinks) Redeem(token, notebookID string) (ShareLink, error) {
+	link, err := s.Verify(token, notebookID)
+	if err != nil || link.MaxUses == 0 {
+		return link, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, expiry := range s.expiry {
+		if now.After(expiry) {
+			delete(s.uses, id)
+			delete(s.expiry, id)
+		}
+	}
+	if s.uses[link.ID] >= link.MaxUses {
+		return link, ErrShareLinkUsedUp
+	}
+	s.uses[link.ID]++
+	s.expiry[link.ID] = link.ExpiresAt
+	return link, nil
+}
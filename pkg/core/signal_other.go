@@ -0,0 +1,35 @@
+//go:build !unix && !windows
+
+package core
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var errSuspendUnsupported = errors.New("suspending processes is not supported on this platform")
+
+// setProcessGroup is only implemented on Unix.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup only signals proc itself outside Unix.
+func signalGroup(proc *os.Process, sig syscall.Signal) error {
+	return proc.Signal(sig)
+}
+
+// processAlive reports whether proc is still running.
+func processAlive(proc *os.Process) bool {
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// suspendProcess is only implemented on Unix.
+func suspendProcess(proc *os.Process) error {
+	return errSuspendUnsupported
+}
+
+// resumeProcess is only implemented on Unix.
+func resumeProcess(proc *os.Process) error {
+	return errSuspendUnsupported
+}
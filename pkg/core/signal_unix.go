@@ -0,0 +1,37 @@
+//go:build unix
+
+package core
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a process group of its own so the Python
+// kernels marimo spawns can be signalled along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalGroup signals the process group led by proc, falling back to proc
+// alone for processes that were started without a group of their own.
+func signalGroup(proc *os.Process, sig syscall.Signal) error {
+	if err := syscall.Kill(-proc.Pid, sig); err == nil {
+		return nil
+	}
+	return proc.Signal(sig)
+}
+
+// processAlive reports whether proc is still running.
+func processAlive(proc *os.Process) bool {
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+func suspendProcess(proc *os.Process) error {
+	return signalGroup(proc, syscall.SIGSTOP)
+}
+
+func resumeProcess(proc *os.Process) error {
+	return signalGroup(proc, syscall.SIGCONT)
+}
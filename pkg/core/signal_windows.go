@@ -0,0 +1,54 @@
+//go:build windows
+
+package core
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+var errSuspendUnsupported = errors.New("suspending processes is not supported on this platform")
+
+// stillActive is the exit code GetExitCodeProcess reports for a process
+// that hasn't exited.
+const stillActive = 259
+
+// setProcessGroup is a no-op on Windows, where the kernels are found
+// through the process tree instead.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup kills proc along with the processes it started. Windows has
+// no other signals, so the rest fail and callers fall back to killing.
+func signalGroup(proc *os.Process, sig syscall.Signal) error {
+	if sig != syscall.SIGKILL {
+		return errors.New("signal " + sig.String() + " is not supported on this platform")
+	}
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(proc.Pid)).Run(); err == nil {
+		return nil
+	}
+	return proc.Kill()
+}
+
+// processAlive reports whether proc is still running.
+func processAlive(proc *os.Process) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(proc.Pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	return syscall.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
+
+// suspendProcess is only implemented on Unix.
+func suspendProcess(proc *os.Process) error {
+	return errSuspendUnsupported
+}
+
+// resumeProcess is only implemented on Unix.
+func resumeProcess(proc *os.Process) error {
+	return errSuspendUnsupported
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// startWorkers runs the workers that start notebook processes. Starting
+// marimo is mostly Python imports, so a hub booting with many notebooks
+// would otherwise start all of them at once and starve the host.
+func (r *Runner) startWorkers(n int) {
+	r.startReady = make(chan struct{}, 1)
+	for range n {
+		go r.startWorker()
+	}
+}
+
+// enqueueStart starts the process of manager once a worker is free and the
+// notebooks it depends on are running. The manager is Pending until then.
+// Without workers it starts right away.
+func (r *Runner) enqueueStart(manager *NotebookManager) {
+	if r.startReady == nil {
+		startManager(manager)
+		return
+	}
+
+	manager.mu.Lock()
+	manager.setStatus(StatusPending)
+	manager.mu.Unlock()
+
+	r.startMu.Lock()
+	r.startQueue = append(r.startQueue, manager)
+	r.startMu.Unlock()
+	select {
+	case r.startReady <- struct{}{}:
+	default:
+	}
+}
+
+// StartQueueLength returns how many notebooks wait for a start worker.
+func (r *Runner) StartQueueLength() int {
+	r.startMu.Lock()
+	defer r.startMu.Unlock()
+	return len(r.startQueue)
+}
+
+func (r *Runner) requeueStart(manager *NotebookManager) {
+	r.startMu.Lock()
+	r.startQueue = append(r.startQueue, manager)
+	r.startMu.Unlock()
+}
+
+func (r *Runner) nextStart() *NotebookManager {
+	r.startMu.Lock()
+	defer r.startMu.Unlock()
+	if len(r.startQueue) == 0 {
+		return nil
+	}
+	manager := r.startQueue[0]
+	r.startQueue = r.startQueue[1:]
+	if len(r.startQueue) > 0 {
+		// Wake another worker for the rest.
+		select {
+		case r.startReady <- struct{}{}:
+		default:
+		}
+	}
+	return manager
+}
+
+// startWorker starts queued notebooks one at a time, each after a random
+// delay of up to the jitter, and waits for each to become ready or fail
+// before taking the next.
+func (r *Runner) startWorker() {
+	for {
+		manager := r.nextStart()
+		if manager == nil {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-r.startReady:
+				continue
+			}
+		}
+
+		if !r.queuedCurrent(manager) {
+			continue
+		}
+		if !r.dependenciesRunning(manager) {
+			// Let the others go first; the dependencies are queued too.
+			r.requeueStart(manager)
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(readyPollInterval):
+			}
+			continue
+		}
+
+		if r.startJitter > 0 {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(rand.N(r.startJitter)):
+			}
+			if !r.queuedCurrent(manager) {
+				continue
+			}
+		}
+
+		startManager(manager)
+		for manager.getStatus() == StatusStarting {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(readyPollInterval):
+			}
+		}
+	}
+}
+
+// queuedCurrent reports whether manager should still be started. The
+// notebook may have been removed while it waited.
+func (r *Runner) queuedCurrent(manager *NotebookManager) bool {
+	r.mu.RLock()
+	current := r.managers[manager.notebook.ID] == manager
+	r.mu.RUnlock()
+	return current && !r.released.Load()
+}
+
+// dependenciesRunning reports whether every notebook manager depends on is
+// running, here or, in a cluster, on another hub.
+func (r *Runner) dependenciesRunning(manager *NotebookManager) bool {
+	manager.mu.RLock()
+	deps := manager.notebook.DependsOn
+	manager.mu.RUnlock()
+	for _, dep := range deps {
+		if status, _ := r.GetStatus(dep); status != StatusRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// startManager starts the process of manager. It may have been started by
+// a restart in the meantime, which is fine.
+func startManager(manager *NotebookManager) {
+	var running *AlreadyRunningError
+	if err := manager.start(); err != nil && !errors.As(err, &running) {
+		logging.Runner.Error().Str("method", "Runner.startManager").
+			Str("notebook", manager.notebook.ID).
+			Err(err).
+			Msg("Failed to start notebook")
+	}
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+const (
+	tokenPrefix       = "token:"
+	tokenSecretPrefix = "mh_"
+)
+
+// tokenRecord is the stored form of a Token, see userRecord.
+type tokenRecord struct {
+	Token
+	SecretHash string `json:"secret_hash"`
+}
+
+// Allows reports whether the token grants scope. Write implies every other
+// scope.
+func (t Token) Allows(scope TokenScope) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeWrite)
+}
+
+// Covers reports whether nb is within the resources the token is limited to.
+func (t Token) Covers(nb Notebook) bool {
+	if len(t.NotebookIDs) > 0 {
+		return slices.Contains(t.NotebookIDs, nb.ID)
+	}
+	return t.WorkspaceID == "" || t.WorkspaceID == nb.WorkspaceID
+}
+
+func (t Token) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// newToken creates a token and its secret. The secret embeds the token ID so
+// it can be looked up without scanning, and only its hash is stored.
+func newToken(userID string, req CreateTokenRequest) (Token, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return Token{}, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	t := Token{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		UserID:      userID,
+		WorkspaceID: req.WorkspaceID,
+		NotebookIDs: req.NotebookIDs,
+		Scopes:      req.Scopes,
+		ExpiresAt:   req.ExpiresAt,
+		CreatedAt:   time.Now(),
+	}
+	secret := tokenSecretPrefix + t.ID + "_" + base64.RawURLEncoding.EncodeToString(buf)
+	t.SecretHash = hashSecret(secret)
+	return t, secret, nil
+}
+
+// VerifyToken resolves a token secret to its token, rejecting unknown,
+// mismatched and expired secrets.
+func VerifyToken(reg TokenRegistry, secret string) (Token, bool) {
+	rest, ok := strings.CutPrefix(secret, tokenSecretPrefix)
+	if !ok {
+		return Token{}, false
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return Token{}, false
+	}
+
+	t, exists := reg.GetToken(id)
+	if !exists || t.Expired() {
+		return Token{}, false
+	}
+	if subtle.ConstantTimeCompare([]byte(t.SecretHash), []byte(hashSecret(secret))) != 1 {
+		return Token{}, false
+	}
+	return t, true
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *BadgerRegistry) AddToken(userID string, req CreateTokenRequest) (Token, string, error) {
+	t, secret, err := newToken(userID, req)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	data, err := json.Marshal(tokenRecord{Token: t, SecretHash: t.SecretHash})
+	if err != nil {
+		return Token{}, "", err
+	}
+	err = r.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(tokenPrefix+t.ID), data)
+	})
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	logging.Registry.Info().Str("id", t.ID).Str("name", t.Name).Str("user", userID).Msg("Successfully added token")
+	return t, secret, nil
+}
+
+func (r *BadgerRegistry) GetToken(id string) (Token, bool) {
+	var rec tokenRecord
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(tokenPrefix + id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &rec)
+		})
+	})
+	if err != nil {
+		return Token{}, false
+	}
+	rec.Token.SecretHash = rec.SecretHash
+	return rec.Token, true
+}
+
+func (r *BadgerRegistry) ListTokens() []Token {
+	var tokens []Token
+	_ = r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(tokenPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var rec tokenRecord
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &rec)
+			})
+			if err != nil {
+				logging.Registry.Warn().Err(err).Str("method", "BadgerRegistry.ListTokens").Msg("Failed to unmarshal token")
+				continue
+			}
+			rec.Token.SecretHash = rec.SecretHash
+			tokens = append(tokens, rec.Token)
+		}
+		return nil
+	})
+	return tokens
+}
+
+func (r *BadgerRegistry) DeleteToken(id string) error {
+	if _, exists := r.GetToken(id); !exists {
+		return &NotFoundError{Resource: "token", ID: id}
+	}
+	err := r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(tokenPrefix + id))
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully deleted token")
+	return nil
+}
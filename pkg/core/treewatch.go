@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// treeSettle is how long a tree has to be quiet after a change before it is
+// reported, so saving or syncing many files causes a single restart.
+const treeSettle = time.Second
+
+// watchTree calls onChange whenever a file below dir changes, except for
+// skip, until ctx is done. Hidden directories and Python bytecode caches
+// are ignored, since running the app writes to them.
+func watchTree(ctx context.Context, dir, skip string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := addTree(watcher, dir); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		settle := time.NewTimer(treeSettle)
+		settle.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				settle.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Name == skip || ignoredPath(event.Name) {
+					continue
+				}
+				if event.Has(fsnotify.Create) {
+					// New directories have to be watched too.
+					addTree(watcher, event.Name)
+				}
+				settle.Reset(treeSettle)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logging.Runner.Warn().Err(err).Str("method", "watchTree").Str("dir", dir).Msg("File watcher error")
+			case <-settle.C:
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
+
+// addTree watches root and every directory below it that isn't ignored.
+func addTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && ignoredPath(path) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+func ignoredPath(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") || base == "__pycache__" || strings.HasSuffix(base, ".pyc")
+}
@@ -16,38 +16,411 @@ type Status string
 
 const (
 	StatusPending    Status = "Pending"
+	StatusStarting   Status = "Starting"
 	StatusRunning    Status = "Running"
 	StatusStopped    Status = "Stopped"
 	StatusError      Status = "Error"
 	StatusRestarting Status = "Restarting"
+	StatusSuspended  Status = "Suspended"
+	// StatusIdle is a notebook stopped for being unused, which the next
+	// request to it starts again.
+	StatusIdle Status = "Idle"
+	// StatusDisabled is reported for disabled notebooks, which the runner
+	// never sees.
+	StatusDisabled Status = "Disabled"
+)
+
+// DesiredState is what the hub should do with a notebook's process. It is
+// persisted so a restarted hub only starts the notebooks that should run.
+type DesiredState string
+
+const (
+	DesiredRunning   DesiredState = "running"
+	DesiredStopped   DesiredState = "stopped"
+	DesiredSuspended DesiredState = "suspended"
 )
 
 type Notebook struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Path      string    `json:"path"`
-	Domain    string    `json:"domain"`
-	ShowCode  bool      `json:"show_code"`
-	Watch     bool      `json:"watch"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	Domain      string            `json:"domain"`
+	WorkspaceID string            `json:"workspace_id,omitempty"`
+	Runtime     string            `json:"runtime,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	ShowCode    bool              `json:"show_code"`
+	Watch       bool              `json:"watch"`
+	Pinned      bool              `json:"pinned"`
+	// PathPrefix mounts the notebook under a path of its domain, such as
+	// /sales, so several notebooks can share one. Empty is the root.
+	PathPrefix string `json:"path_prefix,omitempty"`
+	// RelativePath is Path relative to the notebooks directory. Runners
+	// prefer it, so a notebook follows the directory wherever a hub mounts it.
+	RelativePath string `json:"relative_path,omitempty"`
+	// Assets names a folder, relative to the notebook's directory, whose
+	// files the proxy serves under /assets/ on the notebook's domain.
+	Assets string `json:"assets,omitempty"`
+	// ErrorPage names an HTML template, relative to the notebook's
+	// directory, that the proxy renders instead of its own pages when it
+	// can't serve the notebook, such as while it is stopped or starting.
+	ErrorPage string `json:"error_page,omitempty"`
+	// Public notebooks are listed on the proxy's landing page.
+	Public bool `json:"public,omitempty"`
+	// Disabled notebooks keep their entry and their domain but are never
+	// started, whatever their desired state, and the proxy says so to
+	// visitors. Seasonal notebooks are disabled rather than deleted.
+	Disabled bool `json:"disabled,omitempty"`
+	// ClientCert notebooks are only served to visitors presenting a client
+	// certificate the proxy verified against its client CA.
+	ClientCert bool `json:"client_cert,omitempty"`
+	// StartTimeout is how many seconds the process may take to accept
+	// connections before it is killed. Zero uses the hub's default.
+	StartTimeout int `json:"start_timeout,omitempty"`
+	// DependsOn holds the IDs of notebooks that must be running before the
+	// runner starts this one.
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Hooks are commands the runner runs around the notebook's process.
+	Hooks *LifecycleHooks `json:"hooks,omitempty"`
+	// Probe overrides how the runner checks that the notebook is up.
+	Probe *HealthProbe `json:"probe,omitempty"`
+	// Upstream says how the proxy reaches the notebook's backend.
+	Upstream *Upstream `json:"upstream,omitempty"`
+	// ForwardAuth has an external service decide who may visit the
+	// notebook.
+	ForwardAuth *ForwardAuth `json:"forward_auth,omitempty"`
+	// AccessLog overrides how the proxy logs requests to the notebook.
+	AccessLog *AccessLog `json:"access_log,omitempty"`
+	// Limits bound what the proxy lets through to the notebook, so one
+	// busy notebook can't starve the others.
+	Limits *ProxyLimits `json:"limits,omitempty"`
+	// Timeouts override the proxy's read and write timeouts for requests
+	// to the notebook, such as slow reports streaming their results.
+	Timeouts *ProxyTimeouts `json:"timeouts,omitempty"`
+	// Security overrides the security headers the proxy adds to
+	// the notebook's responses.
+	Security *SecurityHeaders `json:"security_headers,omitempty"`
+	// CSP sets the Content-Security-Policy of the notebook's pages.
+	CSP *ContentPolicy `json:"csp,omitempty"`
+	// Preview is set on previews of another notebook.
+	Preview *Preview `json:"preview,omitempty"`
+	// Desired is empty for notebooks stored before desired state existed,
+	// which are treated as running.
+	Desired DesiredState `json:"desired_state,omitempty"`
+	// Owner is the ID of the user who may delete the notebook and change
+	// who else can edit it. Collaborators hold user IDs that may edit it.
+	Owner         string    `json:"owner,omitempty"`
+	Collaborators []string  `json:"collaborators,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AccessLog overrides the hub's access log settings for a notebook, such as
+// to sample a busy public dashboard or to log every request to a sensitive
+// one.
+type AccessLog struct {
+	// Enabled logs requests to the notebook, or not, whatever the hub does.
+	Enabled *bool `json:"enabled,omitempty"`
+	// SampleRate is the fraction of requests logged, from 0 to 1. Zero uses
+	// the hub's.
+	SampleRate float64 `json:"sample_rate,omitempty" validate:"gte=0,lte=1"`
+}
+
+// orNil returns nil for access log settings without any override, which is
+// how a request clears them.
+func (a *AccessLog) orNil() *AccessLog {
+	if a == nil || (a.Enabled == nil && a.SampleRate == 0) {
+		return nil
+	}
+	return a
+}
+
+// SecurityHeaders overrides, for a notebook, the security headers the proxy
+// adds to responses that lack them.
+type SecurityHeaders struct {
+	// Enabled adds the headers, or not, whatever the hub does.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Headers replace the hub's headers of the same name or add others,
+	// such as Permissions-Policy. An empty value drops the hub's.
+	Headers map[string]string `json:"headers,omitempty" validate:"omitempty,max=20,dive,keys,required,max=100,endkeys,max=4096"`
+}
+
+// orNil returns nil for security headers without any override, which is
+// how a request clears them.
+func (s *SecurityHeaders) orNil() *SecurityHeaders {
+	if s == nil || (s.Enabled == nil && len(s.Headers) == 0) {
+		return nil
+	}
+	return s
+}
+
+// ProxyLimits bound the load the proxy puts on a notebook. Zero is
+// unlimited.
+type ProxyLimits struct {
+	// MaxRequests is how many HTTP requests are proxied to the notebook at
+	// once; more are answered 429.
+	MaxRequests int `json:"max_requests,omitempty" validate:"gte=0"`
+	// MaxSessions is how many WebSocket sessions may be open to the
+	// notebook; more are closed as soon as they open, asking the browser to
+	// try again later.
+	MaxSessions int `json:"max_sessions,omitempty" validate:"gte=0"`
+	// BandwidthKB caps what the proxy sends visitors from the notebook,
+	// across every response and session, in KiB per second.
+	BandwidthKB int `json:"bandwidth_kb,omitempty" validate:"gte=0"`
+}
+
+// orNil returns nil for limits without any limit, which is how a request
+// clears them.
+func (l *ProxyLimits) orNil() *ProxyLimits {
+	if l == nil || *l == (ProxyLimits{}) {
+		return nil
+	}
+	return l
+}
+
+// ProxyTimeouts override the proxy's read_timeout and write_timeout, in
+// seconds. Zero keeps the proxy's own.
+type ProxyTimeouts struct {
+	// Read is how long the proxy may take to read a request.
+	Read int `json:"read,omitempty" validate:"gte=0"`
+	// Write is how long the proxy may take to send a response once the
+	// notebook has started answering.
+	Write int `json:"write,omitempty" validate:"gte=0"`
+}
+
+// orNil returns nil for timeouts that override nothing, which is how a
+// request clears them.
+func (t *ProxyTimeouts) orNil() *ProxyTimeouts {
+	if t == nil || *t == (ProxyTimeouts{}) {
+		return nil
+	}
+	return t
+}
+
+// Workspace groups notebooks and supplies defaults for the notebooks created
+// in it. Changing a workspace does not rewrite existing notebooks.
+type Workspace struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	DomainSuffix string            `json:"domain_suffix,omitempty"`
+	Runtime      string            `json:"runtime,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Quota        WorkspaceQuota    `json:"quota"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// WorkspaceQuota limits what a workspace may consume. Zero means unlimited.
+type WorkspaceQuota struct {
+	MaxNotebooks int `json:"max_notebooks" validate:"gte=0"`
+	MaxRunning   int `json:"max_running" validate:"gte=0"`
+	MaxMemoryMB  int `json:"max_memory_mb" validate:"gte=0"`
+	MaxPorts     int `json:"max_ports" validate:"gte=0"`
+}
+
+type QuotaUsage struct {
+	Notebooks int `json:"notebooks"`
+	Running   int `json:"running"`
+	Queued    int `json:"queued"`
+	MemoryMB  int `json:"memory_mb"`
+	Ports     int `json:"ports"`
 }
 
 type Registry interface {
 	Add(nb CreateUpdateNotebookRequest) (Notebook, error)
 	Get(id string) (Notebook, bool)
 	GetByDomain(domain string) (Notebook, bool)
+	// GetByRoute returns the notebook mounted at prefix on domain; an empty
+	// prefix or "/" is the root.
+	GetByRoute(domain, prefix string) (Notebook, bool)
 	List() []Notebook
 	Update(id string, req CreateUpdateNotebookRequest) (Notebook, error)
 	Delete(id string) error
+	// SetDomainPolicy restricts the domains notebooks may use.
+	SetDomainPolicy(policy DomainPolicy)
+
+	WorkspaceRegistry
+	UserRegistry
+	TokenRegistry
+}
+
+type WorkspaceRegistry interface {
+	AddWorkspace(req CreateUpdateWorkspaceRequest) (Workspace, error)
+	GetWorkspace(id string) (Workspace, bool)
+	ListWorkspaces() []Workspace
+	UpdateWorkspace(id string, req CreateUpdateWorkspaceRequest) (Workspace, error)
+	DeleteWorkspace(id string) error
+}
+
+type UserRegistry interface {
+	AddUser(req CreateUpdateUserRequest) (User, error)
+	GetUser(id string) (User, bool)
+	GetUserByName(username string) (User, bool)
+	ListUsers() []User
+	UpdateUser(id string, req CreateUpdateUserRequest) (User, error)
+	DeleteUser(id string) error
+}
+
+type TokenRegistry interface {
+	AddToken(userID string, req CreateTokenRequest) (Token, string, error)
+	GetToken(id string) (Token, bool)
+	ListTokens() []Token
+	DeleteToken(id string) error
+}
+
+type User struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Admin       bool   `json:"admin"`
+	// OIDCSubject maps an external identity provider subject to this user.
+	OIDCSubject  string    `json:"oidc_subject,omitempty"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type TokenScope string
+
+const (
+	ScopeRead   TokenScope = "read"
+	ScopeDeploy TokenScope = "deploy"
+	ScopeWrite  TokenScope = "write"
+)
+
+// Token is an API credential acting on behalf of UserID. It is limited to
+// the verbs in Scopes and, when set, to one workspace or a list of notebooks.
+type Token struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	UserID      string       `json:"user_id,omitempty"`
+	WorkspaceID string       `json:"workspace_id,omitempty"`
+	NotebookIDs []string     `json:"notebook_ids,omitempty"`
+	Scopes      []TokenScope `json:"scopes"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	SecretHash  string       `json:"-"`
+	CreatedAt   time.Time    `json:"created_at"`
 }
 
 // TODO: Think about separating create and update requests
 type CreateUpdateNotebookRequest struct {
-	Name     string `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
-	Path     string `json:"path,omitempty" validate:"omitempty,filepath"`
-	Domain   string `json:"domain,omitempty" validate:"omitempty,hostname"`
-	ShowCode *bool  `json:"show_code,omitempty"`
-	Watch    *bool  `json:"watch,omitempty"`
+	Name          string            `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	Path          string            `json:"path,omitempty" validate:"omitempty,filepath"`
+	Domain        string            `json:"domain,omitempty" validate:"omitempty,hostname"`
+	PathPrefix    string            `json:"path_prefix,omitempty" validate:"omitempty,max=200"`
+	WorkspaceID   string            `json:"workspace_id,omitempty" validate:"omitempty,uuid"`
+	Runtime       string            `json:"runtime,omitempty" validate:"omitempty,min=1"`
+	Env           map[string]string `json:"env,omitempty" validate:"omitempty,dive,keys,envname,endkeys"`
+	ShowCode      *bool             `json:"show_code,omitempty"`
+	Watch         *bool             `json:"watch,omitempty"`
+	Pinned        *bool             `json:"pinned,omitempty"`
+	Desired       DesiredState      `json:"desired_state,omitempty" validate:"omitempty,oneof=running stopped suspended"`
+	Owner         string            `json:"owner,omitempty" validate:"omitempty,uuid"`
+	Collaborators []string          `json:"collaborators,omitempty" validate:"omitempty,dive,uuid"`
+	Assets        string            `json:"assets,omitempty" validate:"omitempty,filepath"`
+	ErrorPage     string            `json:"error_page,omitempty" validate:"omitempty,filepath"`
+	Public        *bool             `json:"public,omitempty"`
+	Disabled      *bool             `json:"disabled,omitempty"`
+	ClientCert    *bool             `json:"client_cert,omitempty"`
+	StartTimeout  *int              `json:"start_timeout,omitempty" validate:"omitempty,gte=0"`
+	DependsOn     []string          `json:"depends_on,omitempty" validate:"omitempty,dive,uuid"`
+	Hooks         *LifecycleHooks   `json:"hooks,omitempty"`
+	Probe         *HealthProbe      `json:"probe,omitempty"`
+	Upstream      *Upstream         `json:"upstream,omitempty"`
+	ForwardAuth   *ForwardAuth      `json:"forward_auth,omitempty"`
+	AccessLog     *AccessLog        `json:"access_log,omitempty"`
+	Limits        *ProxyLimits      `json:"limits,omitempty"`
+	Timeouts      *ProxyTimeouts    `json:"timeouts,omitempty"`
+	Security      *SecurityHeaders  `json:"security_headers,omitempty"`
+	CSP           *ContentPolicy    `json:"csp,omitempty"`
+	// RelativePath is filled in by the API when it resolves Path.
+	RelativePath string `json:"-"`
+	// Preview is set by DeployPreview only.
+	Preview *Preview `json:"-"`
+}
+
+// Preview marks a notebook as an ephemeral preview of the notebook Of,
+// running a checkout of the git ref Ref, such as the head of a pull request.
+type Preview struct {
+	Of   string `json:"of"`
+	Name string `json:"name"`
+	Ref  string `json:"ref"`
+}
+
+// DeployPreviewRequest deploys the git ref Ref as a preview.
+type DeployPreviewRequest struct {
+	Ref string `json:"ref" validate:"required"`
+	// Secrets gives the preview the env and lifecycle hooks of the
+	// notebook, which then run along with the code at Ref.
+	Secrets bool `json:"secrets,omitempty"`
+}
+
+// GlobNotebooksRequest registers every file matching Pattern, which is
+// relative to the notebooks directory. Name, Domain and PathPrefix are
+// templates rendered for each file; the other fields apply to every notebook.
+type GlobNotebooksRequest struct {
+	Pattern       string            `json:"pattern" validate:"required,filepath"`
+	Name          string            `json:"name,omitempty"`
+	Domain        string            `json:"domain" validate:"required"`
+	PathPrefix    string            `json:"path_prefix,omitempty"`
+	WorkspaceID   string            `json:"workspace_id,omitempty" validate:"omitempty,uuid"`
+	Runtime       string            `json:"runtime,omitempty" validate:"omitempty,min=1"`
+	Env           map[string]string `json:"env,omitempty" validate:"omitempty,dive,keys,envname,endkeys"`
+	ShowCode      *bool             `json:"show_code,omitempty"`
+	Watch         *bool             `json:"watch,omitempty"`
+	Pinned        *bool             `json:"pinned,omitempty"`
+	Desired       DesiredState      `json:"desired_state,omitempty" validate:"omitempty,oneof=running stopped suspended"`
+	Owner         string            `json:"owner,omitempty" validate:"omitempty,uuid"`
+	Collaborators []string          `json:"collaborators,omitempty" validate:"omitempty,dive,uuid"`
+	Assets        string            `json:"assets,omitempty" validate:"omitempty,filepath"`
+	ErrorPage     string            `json:"error_page,omitempty" validate:"omitempty,filepath"`
+	Public        *bool             `json:"public,omitempty"`
+	Disabled      *bool             `json:"disabled,omitempty"`
+	ClientCert    *bool             `json:"client_cert,omitempty"`
+	StartTimeout  *int              `json:"start_timeout,omitempty" validate:"omitempty,gte=0"`
+	DependsOn     []string          `json:"depends_on,omitempty" validate:"omitempty,dive,uuid"`
+	Hooks         *LifecycleHooks   `json:"hooks,omitempty"`
+	Probe         *HealthProbe      `json:"probe,omitempty"`
+	Upstream      *Upstream         `json:"upstream,omitempty"`
+	ForwardAuth   *ForwardAuth      `json:"forward_auth,omitempty"`
+	AccessLog     *AccessLog        `json:"access_log,omitempty"`
+	Limits        *ProxyLimits      `json:"limits,omitempty"`
+	Timeouts      *ProxyTimeouts    `json:"timeouts,omitempty"`
+	Security      *SecurityHeaders  `json:"security_headers,omitempty"`
+	CSP           *ContentPolicy    `json:"csp,omitempty"`
+}
+
+// SetDesiredStateRequest declares whether a notebook should be running,
+// leaving the runner to converge on it.
+type SetDesiredStateRequest struct {
+	Desired DesiredState `json:"desired" validate:"required,oneof=running stopped suspended"`
+}
+
+type CreateUpdateUserRequest struct {
+	Username    string `json:"username,omitempty" validate:"omitempty,max=64,username"`
+	DisplayName string `json:"display_name,omitempty" validate:"omitempty,max=100"`
+	Password    string `json:"password,omitempty" validate:"omitempty,min=8,max=72"`
+	Admin       *bool  `json:"admin,omitempty"`
+	OIDCSubject string `json:"oidc_subject,omitempty" validate:"omitempty,max=255"`
+}
+
+type CreateTokenRequest struct {
+	Name        string       `json:"name" validate:"required,max=100"`
+	WorkspaceID string       `json:"workspace_id,omitempty" validate:"omitempty,uuid"`
+	NotebookIDs []string     `json:"notebook_ids,omitempty" validate:"omitempty,dive,uuid"`
+	Scopes      []TokenScope `json:"scopes" validate:"required,min=1,dive,oneof=read deploy write"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+}
+
+// CreateShareLinkRequest asks for a link lasting until ExpiresAt, or for
+// DefaultShareTTL.
+type CreateShareLinkRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty" validate:"min=0"`
+}
+
+type CreateUpdateWorkspaceRequest struct {
+	Name         string            `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	DomainSuffix string            `json:"domain_suffix,omitempty" validate:"omitempty,fqdn"`
+	Runtime      string            `json:"runtime,omitempty" validate:"omitempty,min=1"`
+	Env          map[string]string `json:"env,omitempty" validate:"omitempty,dive,keys,envname,endkeys"`
+	Quota        *WorkspaceQuota   `json:"quota,omitempty"`
 }
 
 type NotebookResponse struct {
@@ -58,8 +431,155 @@ type NotebooksResponse struct {
 	Notebooks []Notebook `json:"notebooks"`
 }
 
+// GlobResult reports what happened to one file of a glob registration.
+// Exactly one of Notebook and Error is set.
+type GlobResult struct {
+	Path     string    `json:"path"`
+	Notebook *Notebook `json:"notebook,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type GlobNotebooksResponse struct {
+	Results []GlobResult `json:"results"`
+}
+
+// FileEntry describes a file or directory in the notebooks directory. Path
+// is relative to the notebooks directory and can be used as a notebook path.
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type FilesResponse struct {
+	Path  string      `json:"path"`
+	Files []FileEntry `json:"files"`
+}
+
+type WorkspaceResponse struct {
+	Workspace Workspace `json:"workspace"`
+}
+
+type WorkspacesResponse struct {
+	Workspaces []Workspace `json:"workspaces"`
+}
+
+type QuotaResponse struct {
+	Quota WorkspaceQuota `json:"quota"`
+	Usage QuotaUsage     `json:"usage"`
+}
+
+type UserResponse struct {
+	User User `json:"user"`
+}
+
+type UsersResponse struct {
+	Users []User `json:"users"`
+}
+
+type TokenResponse struct {
+	Token Token `json:"token"`
+	// Secret is only returned when the token is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+type ShareLinkResponse struct {
+	Link ShareLink `json:"link"`
+	// URL opens the notebook through the link. Token is the part that
+	// grants access, for clients building links of their own.
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+type TokensResponse struct {
+	Tokens []Token `json:"tokens"`
+}
+
 type StatusResponse struct {
 	Status Status `json:"status"`
+	// Reason explains an Error status when the runner knows why.
+	Reason string `json:"reason,omitempty"`
+	// Connections is what the proxy has open to the notebook.
+	Connections *Connections `json:"connections,omitempty"`
+	// Health is what the notebook's probe last found, if it has one.
+	Health *HealthState `json:"health,omitempty"`
+}
+
+type NotebookStateResponse struct {
+	Desired DesiredState `json:"desired"`
+	Current Status       `json:"current"`
+}
+
+// OverviewResponse sums up the notebooks a caller can see, for a status
+// wallboard. Usage and the start queue cover the notebooks on this hub.
+type OverviewResponse struct {
+	Notebooks     int               `json:"notebooks"`
+	Statuses      map[Status]int    `json:"statuses"`
+	Usage         QuotaUsage        `json:"usage"`
+	StartQueue    int               `json:"start_queue"`
+	RecentCrashes []Crash           `json:"recent_crashes"`
+	TopTraffic    []NotebookTraffic `json:"top_traffic"`
+}
+
+// NotebookTraffic counts the requests proxied to a notebook since the hub
+// started, WebSocket sessions included.
+type NotebookTraffic struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Requests uint64 `json:"requests"`
+}
+
+// ChangesResponse lists registry changes in order. Seq is the since to
+// pass for the changes that follow, and More says whether there are more
+// already. Truncated means changes after since were pruned or never seen
+// here, so the caller missed some and has to list the notebooks again.
+type ChangesResponse struct {
+	Changes   []Event `json:"changes"`
+	Seq       uint64  `json:"seq"`
+	More      bool    `json:"more,omitempty"`
+	Truncated bool    `json:"truncated,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Ready         bool `json:"ready"`
+	PinnedTotal   int  `json:"pinned_total"`
+	PinnedRunning int  `json:"pinned_running"`
+}
+
+// SystemConfigResponse is the effective configuration, keyed like a
+// configuration file, and where each setting came from.
+type SystemConfigResponse struct {
+	Settings map[string]any    `json:"settings"`
+	Sources  map[string]string `json:"sources"`
+}
+
+// ReadOnlyState is whether the API rejects changes, and since when and why.
+type ReadOnlyState struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+type SetReadOnlyRequest struct {
+	Enabled *bool  `json:"enabled" validate:"required"`
+	Reason  string `json:"reason,omitempty" validate:"max=256"`
+}
+
+// MaintenanceState is whether the proxy serves the maintenance page instead
+// of notebooks, and since when.
+type MaintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// SetMaintenanceRequest switches maintenance mode. An empty message keeps
+// the configured one.
+type SetMaintenanceRequest struct {
+	Enabled *bool  `json:"enabled" validate:"required"`
+	Message string `json:"message,omitempty" validate:"max=1024"`
 }
 
 type ErrorResponse struct {
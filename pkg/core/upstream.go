@@ -0,0 +1,79 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// Upstream says how the proxy reaches a notebook's backend. Without one it
+// speaks plain HTTP, which is what the runner's own processes serve; remote
+// runners and marimo instances hosted elsewhere may need HTTPS.
+type Upstream struct {
+	Scheme string `json:"scheme,omitempty" validate:"omitempty,oneof=http https"`
+	// CA is a PEM bundle of the certificates the backend's certificate is
+	// checked against, instead of the system's.
+	CA string `json:"ca,omitempty" validate:"max=65536"`
+	// InsecureSkipVerify accepts any certificate, for backends with
+	// self-signed ones on a trusted network.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// orNil returns nil for an upstream without any setting, which is how a
+// request clears it.
+func (u *Upstream) orNil() *Upstream {
+	if u == nil || *u == (Upstream{}) {
+		return nil
+	}
+	return u
+}
+
+// Secure reports whether the backend is reached over TLS.
+func (u *Upstream) Secure() bool {
+	return u != nil && u.Scheme == "https"
+}
+
+// HTTPScheme returns the scheme of the backend's URLs.
+func (u *Upstream) HTTPScheme() string {
+	if u.Secure() {
+		return "https"
+	}
+	return "http"
+}
+
+// WebSocketScheme returns the scheme of the backend's WebSocket URLs.
+func (u *Upstream) WebSocketScheme() string {
+	if u.Secure() {
+		return "wss"
+	}
+	return "ws"
+}
+
+// TLSConfig returns the TLS settings to dial the backend with, or nil for
+// a backend without TLS or with the defaults.
+func (u *Upstream) TLSConfig() (*tls.Config, error) {
+	if !u.Secure() || (u.CA == "" && !u.InsecureSkipVerify) {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: u.InsecureSkipVerify}
+	if u.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(u.CA)) {
+			return nil, &InvalidRequestError{Reason: "upstream CA holds no PEM certificate"}
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// checkUpstream rejects TLS settings on a plain HTTP upstream, which would
+// be ignored, and a CA that can't be parsed.
+func checkUpstream(u *Upstream) error {
+	if u == nil {
+		return nil
+	}
+	if !u.Secure() && (u.CA != "" || u.InsecureSkipVerify) {
+		return &InvalidRequestError{Reason: "upstream CA and insecure_skip_verify need the https scheme"}
+	}
+	_, err := u.TLSConfig()
+	return err
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const userPrefix = "user:"
+
+// userRecord is the stored form of a User; the password hash is kept out of
+// the User JSON so it never leaks through the API.
+type userRecord struct {
+	User
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+func (u User) CheckPassword(password string) bool {
+	if u.PasswordHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// CanEdit reports whether u may change nb. A nil user means authentication is
+// disabled and everything is allowed.
+func (nb Notebook) CanEdit(u *User) bool {
+	return u == nil || u.Admin || nb.Owner == "" || nb.Owner == u.ID || slices.Contains(nb.Collaborators, u.ID)
+}
+
+// CanManage reports whether u may delete nb or change its ownership.
+func (nb Notebook) CanManage(u *User) bool {
+	return u == nil || u.Admin || nb.Owner == "" || nb.Owner == u.ID
+}
+
+// IsMember reports whether u owns or collaborates on nb.
+func (nb Notebook) IsMember(u *User) bool {
+	return u != nil && (nb.Owner == u.ID || slices.Contains(nb.Collaborators, u.ID))
+}
+
+func newUser(req CreateUpdateUserRequest) (User, error) {
+	u := User{
+		ID:          uuid.New().String(),
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+		Admin:       req.Admin != nil && *req.Admin,
+		OIDCSubject: req.OIDCSubject,
+		CreatedAt:   time.Now(),
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, fmt.Errorf("failed to hash password: %w", err)
+		}
+		u.PasswordHash = string(hash)
+	}
+	return u, nil
+}
+
+func applyUserUpdate(u *User, req CreateUpdateUserRequest) (bool, error) {
+	updated := false
+	if req.Username != "" && req.Username != u.Username {
+		u.Username = req.Username
+		updated = true
+	}
+	if req.DisplayName != "" && req.DisplayName != u.DisplayName {
+		u.DisplayName = req.DisplayName
+		updated = true
+	}
+	if req.Admin != nil && *req.Admin != u.Admin {
+		u.Admin = *req.Admin
+		updated = true
+	}
+	if req.OIDCSubject != "" && req.OIDCSubject != u.OIDCSubject {
+		u.OIDCSubject = req.OIDCSubject
+		updated = true
+	}
+	if req.Password != "" && !u.CheckPassword(req.Password) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return false, fmt.Errorf("failed to hash password: %w", err)
+		}
+		u.PasswordHash = string(hash)
+		updated = true
+	}
+	return updated, nil
+}
+
+func (r *BadgerRegistry) AddUser(req CreateUpdateUserRequest) (User, error) {
+	if req.Username == "" {
+		return User{}, &InvalidRequestError{Reason: "username is required for creation"}
+	}
+	if _, exists := r.GetUserByName(req.Username); exists {
+		return User{}, &InUseError{Field: "username", Value: req.Username}
+	}
+
+	u, err := newUser(req)
+	if err != nil {
+		return User{}, err
+	}
+	if err := r.storeUser(u); err != nil {
+		return User{}, err
+	}
+
+	logging.Registry.Info().Str("id", u.ID).Str("username", u.Username).Msg("Successfully added user")
+	return u, nil
+}
+
+func (r *BadgerRegistry) GetUser(id string) (User, bool) {
+	var rec userRecord
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(userPrefix + id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &rec)
+		})
+	})
+	if err != nil {
+		return User{}, false
+	}
+	rec.User.PasswordHash = rec.PasswordHash
+	return rec.User, true
+}
+
+func (r *BadgerRegistry) GetUserByName(username string) (User, bool) {
+	for _, u := range r.ListUsers() {
+		if u.Username == username {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (r *BadgerRegistry) ListUsers() []User {
+	var users []User
+	_ = r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(userPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var rec userRecord
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &rec)
+			})
+			if err != nil {
+				logging.Registry.Warn().Err(err).Str("method", "BadgerRegistry.ListUsers").Msg("Failed to unmarshal user")
+				continue
+			}
+			rec.User.PasswordHash = rec.PasswordHash
+			users = append(users, rec.User)
+		}
+		return nil
+	})
+	return users
+}
+
+func (r *BadgerRegistry) UpdateUser(id string, req CreateUpdateUserRequest) (User, error) {
+	u, exists := r.GetUser(id)
+	if !exists {
+		return User{}, &NotFoundError{Resource: "user", ID: id}
+	}
+	if req.Username != "" && req.Username != u.Username {
+		if _, taken := r.GetUserByName(req.Username); taken {
+			return User{}, &InUseError{Field: "username", Value: req.Username}
+		}
+	}
+
+	updated, err := applyUserUpdate(&u, req)
+	if err != nil || !updated {
+		return u, err
+	}
+	if err := r.storeUser(u); err != nil {
+		return User{}, err
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully updated user")
+	return u, nil
+}
+
+func (r *BadgerRegistry) DeleteUser(id string) error {
+	if _, exists := r.GetUser(id); !exists {
+		return &NotFoundError{Resource: "user", ID: id}
+	}
+	err := r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(userPrefix + id))
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully deleted user")
+	return nil
+}
+
+func (r *BadgerRegistry) storeUser(u User) error {
+	data, err := json.Marshal(userRecord{User: u, PasswordHash: u.PasswordHash})
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(userPrefix+u.ID), data)
+	})
+}
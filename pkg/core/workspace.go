@@ -0,0 +1,231 @@
+package core
+
+import (
+	"encoding/json"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+const workspacePrefix = "workspace:"
+
+func newWorkspace(req CreateUpdateWorkspaceRequest) Workspace {
+	ws := Workspace{
+		ID:           uuid.New().String(),
+		Name:         req.Name,
+		DomainSuffix: req.DomainSuffix,
+		Runtime:      req.Runtime,
+		Env:          req.Env,
+		CreatedAt:    time.Now(),
+	}
+	if req.Quota != nil {
+		ws.Quota = *req.Quota
+	}
+	return ws
+}
+
+func applyWorkspaceUpdate(ws *Workspace, req CreateUpdateWorkspaceRequest) bool {
+	updated := false
+	if req.Name != "" && req.Name != ws.Name {
+		ws.Name = req.Name
+		updated = true
+	}
+	if req.DomainSuffix != "" && req.DomainSuffix != ws.DomainSuffix {
+		ws.DomainSuffix = req.DomainSuffix
+		updated = true
+	}
+	if req.Runtime != "" && req.Runtime != ws.Runtime {
+		ws.Runtime = req.Runtime
+		updated = true
+	}
+	if req.Env != nil && !maps.Equal(req.Env, ws.Env) {
+		ws.Env = req.Env
+		updated = true
+	}
+	if req.Quota != nil && *req.Quota != ws.Quota {
+		ws.Quota = *req.Quota
+		updated = true
+	}
+	return updated
+}
+
+// checkNotebookQuota rejects adding another notebook to a full workspace.
+func checkNotebookQuota(reg Registry, workspaceID string) error {
+	if workspaceID == "" {
+		return nil
+	}
+	ws, exists := reg.GetWorkspace(workspaceID)
+	if !exists || ws.Quota.MaxNotebooks == 0 {
+		return nil
+	}
+
+	count := 0
+	for _, nb := range reg.List() {
+		if nb.WorkspaceID == workspaceID {
+			count++
+		}
+	}
+	if count >= ws.Quota.MaxNotebooks {
+		return &QuotaExceededError{WorkspaceID: workspaceID, Resource: "notebooks", Limit: ws.Quota.MaxNotebooks}
+	}
+	return nil
+}
+
+// resolveWorkspace checks that the workspace referenced by req exists and
+// fills in the defaults it provides.
+func resolveWorkspace(reg WorkspaceRegistry, req *CreateUpdateNotebookRequest, create bool) error {
+	if req.WorkspaceID == "" {
+		return nil
+	}
+	ws, exists := reg.GetWorkspace(req.WorkspaceID)
+	if !exists {
+		return &InvalidRequestError{Reason: "workspace " + req.WorkspaceID + " does not exist"}
+	}
+
+	req.Domain = expandDomain(req.Domain, ws)
+	if !create {
+		return nil
+	}
+
+	if req.Runtime == "" {
+		req.Runtime = ws.Runtime
+	}
+	if len(ws.Env) > 0 {
+		env := maps.Clone(ws.Env)
+		maps.Copy(env, req.Env)
+		req.Env = env
+	}
+	return nil
+}
+
+// resolveUpdateWorkspace validates a workspace change and expands a bare
+// domain label against the workspace the notebook will end up in.
+func resolveUpdateWorkspace(reg WorkspaceRegistry, nb Notebook, req *CreateUpdateNotebookRequest) error {
+	if req.WorkspaceID != "" {
+		return resolveWorkspace(reg, req, false)
+	}
+	if nb.WorkspaceID == "" || req.Domain == "" {
+		return nil
+	}
+	if ws, exists := reg.GetWorkspace(nb.WorkspaceID); exists {
+		req.Domain = expandDomain(req.Domain, ws)
+	}
+	return nil
+}
+
+// expandDomain appends the workspace domain suffix to a bare label.
+func expandDomain(domain string, ws Workspace) string {
+	if domain == "" || ws.DomainSuffix == "" || strings.Contains(domain, ".") {
+		return domain
+	}
+	return domain + "." + strings.TrimPrefix(ws.DomainSuffix, ".")
+}
+
+func (r *BadgerRegistry) AddWorkspace(req CreateUpdateWorkspaceRequest) (Workspace, error) {
+	logging.Registry.Debug().Str("method", "BadgerRegistry.AddWorkspace").
+		Interface("request", req).Msg("Starting AddWorkspace operation")
+
+	if req.Name == "" {
+		return Workspace{}, &InvalidRequestError{Reason: "name is required for creation"}
+	}
+
+	ws := newWorkspace(req)
+	if err := r.storeWorkspace(ws); err != nil {
+		return Workspace{}, err
+	}
+
+	logging.Registry.Info().Str("id", ws.ID).Str("name", ws.Name).Msg("Successfully added workspace")
+	return ws, nil
+}
+
+func (r *BadgerRegistry) GetWorkspace(id string) (Workspace, bool) {
+	var ws Workspace
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(workspacePrefix + id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &ws)
+		})
+	})
+	if err != nil {
+		return Workspace{}, false
+	}
+	return ws, true
+}
+
+func (r *BadgerRegistry) ListWorkspaces() []Workspace {
+	var workspaces []Workspace
+	_ = r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(workspacePrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var ws Workspace
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &ws)
+			})
+			if err != nil {
+				logging.Registry.Warn().Err(err).Str("method", "BadgerRegistry.ListWorkspaces").Msg("Failed to unmarshal workspace")
+				continue
+			}
+			workspaces = append(workspaces, ws)
+		}
+		return nil
+	})
+	return workspaces
+}
+
+func (r *BadgerRegistry) UpdateWorkspace(id string, req CreateUpdateWorkspaceRequest) (Workspace, error) {
+	ws, exists := r.GetWorkspace(id)
+	if !exists {
+		return Workspace{}, &NotFoundError{Resource: "workspace", ID: id}
+	}
+	if !applyWorkspaceUpdate(&ws, req) {
+		return ws, nil
+	}
+	if err := r.storeWorkspace(ws); err != nil {
+		return Workspace{}, err
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully updated workspace")
+	return ws, nil
+}
+
+func (r *BadgerRegistry) DeleteWorkspace(id string) error {
+	if _, exists := r.GetWorkspace(id); !exists {
+		return &NotFoundError{Resource: "workspace", ID: id}
+	}
+	for _, nb := range r.List() {
+		if nb.WorkspaceID == id {
+			return &WorkspaceNotEmptyError{ID: id}
+		}
+	}
+
+	err := r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(workspacePrefix + id))
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Registry.Info().Str("id", id).Msg("Successfully deleted workspace")
+	return nil
+}
+
+func (r *BadgerRegistry) storeWorkspace(ws Workspace) error {
+	data, err := json.Marshal(ws)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(workspacePrefix+ws.ID), data)
+	})
+}
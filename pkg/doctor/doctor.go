@@ -0,0 +1,307 @@
+// Package doctor diagnoses the setup of a hub: whether what it needs to run
+// notebooks is there and usable. It backs GET /api/v1/system/doctor and the
+// doctor command, so problems can be found before, or without, digging
+// through logs.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+type Status string
+
+// Statuses of a check, from best to worst. A report has the worst status of
+// its checks.
+const (
+	StatusOK      Status = "ok"
+	StatusSkipped Status = "skipped"
+	StatusWarn    Status = "warn"
+	StatusFail    Status = "fail"
+)
+
+var severity = map[Status]int{StatusOK: 0, StatusSkipped: 1, StatusWarn: 2, StatusFail: 3}
+
+const (
+	// runtimeTimeout bounds asking a runtime for its version.
+	runtimeTimeout = 10 * time.Second
+	// maxClockSkew is how far the hub's clock may be off the database's
+	// before leases and timestamps go wrong.
+	maxClockSkew = 2 * time.Second
+	// maxDetails bounds the items listed by a check.
+	maxDetails = 20
+)
+
+type Check struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	// Details lists the items behind the message, such as the notebooks
+	// whose files are missing.
+	Details []string `json:"details,omitempty"`
+}
+
+type Report struct {
+	Time   time.Time `json:"time"`
+	Status Status    `json:"status"`
+	Checks []Check   `json:"checks"`
+}
+
+// Options is what the checks look at.
+type Options struct {
+	Config *config.Config
+	// Registry is nil if it couldn't be opened, as Badger can't be while
+	// the hub runs, and RegistryError says why.
+	Registry      core.Registry
+	RegistryError error
+	// Runner is nil outside the hub. With it, the ports held by the hub's
+	// own notebooks don't count as taken.
+	Runner *core.Runner
+}
+
+// verifier is a registry able to check its own storage.
+type verifier interface {
+	Verify(ctx context.Context) error
+}
+
+// clock is a registry whose storage has a clock of its own.
+type clock interface {
+	Now(ctx context.Context) (time.Time, error)
+}
+
+// Run runs every check and reports the results, in a fixed order.
+func Run(ctx context.Context, opts Options) Report {
+	checks := []Check{
+		checkRuntimes(ctx, opts),
+		checkPorts(opts),
+		checkNotebooksPath(opts.Config.Notebooks.Path),
+		checkDatabase(ctx, opts),
+		checkNotebookFiles(opts),
+		checkClock(ctx, opts),
+	}
+	report := Report{Time: time.Now(), Status: StatusOK, Checks: checks}
+	for _, c := range checks {
+		if severity[c.Status] > severity[report.Status] {
+			report.Status = c.Status
+		}
+	}
+	return report
+}
+
+// checkRuntimes checks that marimo, and every other runtime a notebook
+// names, can be found and run.
+func checkRuntimes(ctx context.Context, opts Options) Check {
+	c := Check{Name: "runtime", Status: StatusOK}
+	runtimes := []string{"marimo"}
+	if opts.Registry != nil {
+		for _, nb := range opts.Registry.List() {
+			if nb.Runtime != "" && !slices.Contains(runtimes, nb.Runtime) {
+				runtimes = append(runtimes, nb.Runtime)
+			}
+		}
+	}
+
+	var failed []string
+	for _, runtime := range runtimes {
+		version, err := runtimeVersion(ctx, runtime)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", runtime, err))
+			continue
+		}
+		c.Details = append(c.Details, runtime+" "+version)
+	}
+	if len(failed) > 0 {
+		c.Status = StatusFail
+		c.Message = fmt.Sprintf("%d of %d runtimes can't be run", len(failed), len(runtimes))
+		c.Details = append(failed, c.Details...)
+		return c
+	}
+	c.Message = strings.Join(c.Details, ", ")
+	c.Details = nil
+	return c
+}
+
+func runtimeVersion(ctx context.Context, runtime string) (string, error) {
+	path, err := exec.LookPath(runtime)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, runtimeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, "--version")
+	// Don't wait on children that outlive it holding the output open.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s --version failed: %w", path, err)
+	}
+	return strings.TrimSpace(string(out)) + " at " + path, nil
+}
+
+// checkPorts tries to listen on every port of the range notebooks are
+// given, except those the hub's notebooks hold. A port another process
+// took makes the notebook given it fail to start.
+func checkPorts(opts Options) Check {
+	cfg := opts.Config
+	start, end := cfg.Notebooks.PortRange.Start, cfg.Notebooks.PortRange.End
+	c := Check{Name: "ports", Status: StatusOK}
+
+	held := make(map[int]bool)
+	if opts.Runner != nil && opts.Registry != nil {
+		for _, nb := range opts.Registry.List() {
+			if port, ok := opts.Runner.GetPort(nb.ID); ok {
+				held[port] = true
+			}
+		}
+	}
+
+	var taken []int
+	for port := start; port <= end; port++ {
+		if held[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(cfg.Notebooks.Host, strconv.Itoa(port)))
+		if err != nil {
+			taken = append(taken, port)
+			continue
+		}
+		l.Close()
+	}
+
+	total := end - start + 1
+	free := total - len(held) - len(taken)
+	c.Message = fmt.Sprintf("%d of %d ports from %d to %d are free, %d used by notebooks", free, total, start, end, len(held))
+	switch {
+	case free == 0:
+		c.Status = StatusFail
+	case len(taken) > 0:
+		c.Status = StatusWarn
+		c.Message += fmt.Sprintf(", %d taken by other processes", len(taken))
+	}
+	for _, port := range taken[:min(len(taken), maxDetails)] {
+		c.Details = append(c.Details, strconv.Itoa(port))
+	}
+	return c
+}
+
+// checkNotebooksPath checks that the notebooks directory can be read, and
+// written to for uploads and new notebooks.
+func checkNotebooksPath(dir string) Check {
+	c := Check{Name: "notebooks_path", Status: StatusOK}
+	info, err := os.Stat(dir)
+	switch {
+	case err != nil:
+		c.Status = StatusFail
+		c.Message = err.Error()
+		return c
+	case !info.IsDir():
+		c.Status = StatusFail
+		c.Message = dir + " is not a directory"
+		return c
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		c.Status = StatusFail
+		c.Message = "not readable: " + err.Error()
+		return c
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		c.Status = StatusWarn
+		c.Message = "not writable, uploads will fail: " + err.Error()
+		return c
+	}
+	f.Close()
+	os.Remove(f.Name())
+	c.Message = dir + " is readable and writable"
+	return c
+}
+
+// checkDatabase checks that the registry opened and that its storage is
+// intact.
+func checkDatabase(ctx context.Context, opts Options) Check {
+	c := Check{Name: "database", Status: StatusOK}
+	driver := opts.Config.Database.Driver
+	if opts.Registry == nil {
+		c.Status = StatusFail
+		c.Message = fmt.Sprintf("%s registry can't be opened: %v", driver, opts.RegistryError)
+		return c
+	}
+	v, ok := opts.Registry.(verifier)
+	if !ok {
+		c.Status = StatusSkipped
+		c.Message = driver + " registry can't be verified"
+		return c
+	}
+	if err := v.Verify(ctx); err != nil {
+		c.Status = StatusFail
+		c.Message = err.Error()
+		return c
+	}
+	c.Message = driver + " registry is intact"
+	return c
+}
+
+// checkNotebookFiles lists the registered notebooks whose files are gone,
+// which can't be started.
+func checkNotebookFiles(opts Options) Check {
+	c := Check{Name: "notebook_files", Status: StatusOK}
+	if opts.Registry == nil {
+		c.Status = StatusSkipped
+		c.Message = "registry not opened"
+		return c
+	}
+	notebooks := opts.Registry.List()
+	var missing int
+	for _, nb := range notebooks {
+		path := nb.LocalPath(opts.Config.Notebooks.Path)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		missing++
+		if len(c.Details) < maxDetails {
+			c.Details = append(c.Details, fmt.Sprintf("%s (%s): %s", nb.Name, nb.ID, path))
+		}
+	}
+	c.Message = fmt.Sprintf("%d of %d notebooks have their files", len(notebooks)-missing, len(notebooks))
+	if missing > 0 {
+		c.Status = StatusWarn
+	}
+	return c
+}
+
+// checkClock compares the hub's clock with the database's. Cluster leases
+// and the times stored in the registry assume they agree.
+func checkClock(ctx context.Context, opts Options) Check {
+	c := Check{Name: "clock", Status: StatusOK}
+	db, ok := opts.Registry.(clock)
+	if !ok {
+		c.Status = StatusSkipped
+		c.Message = "no database clock to compare with"
+		return c
+	}
+	before := time.Now()
+	now, err := db.Now(ctx)
+	if err != nil {
+		c.Status = StatusFail
+		c.Message = "failed to read the database clock: " + err.Error()
+		return c
+	}
+	// Assume the database read its clock halfway through the round trip.
+	rtt := time.Since(before)
+	skew := before.Add(rtt / 2).Sub(now)
+	c.Message = fmt.Sprintf("%s off the database clock", skew.Abs().Round(time.Millisecond))
+	if skew.Abs() > maxClockSkew+rtt/2 {
+		c.Status = StatusWarn
+	}
+	return c
+}
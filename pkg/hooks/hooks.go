@@ -0,0 +1,215 @@
+// Package hooks defines inbound webhooks: named endpoints that, when called
+// with the right secret, run a list of deployment actions. It also posts
+// registry events to an outbound webhook.
+package hooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// Action types.
+const (
+	// ActionGitPull fast-forwards the git checkout in Dir.
+	ActionGitPull = "git_pull"
+	// ActionReload restarts Notebook.
+	ActionReload = "reload"
+	// ActionDiscover registers the files matching Discover that aren't
+	// registered yet.
+	ActionDiscover = "discover"
+	// ActionPreview deploys the pull request a delivery is about as a
+	// preview of Notebook, and deletes the preview once it is closed.
+	ActionPreview = "preview"
+)
+
+type Action struct {
+	Type string `json:"type"`
+	// Dir is relative to the notebooks directory.
+	Dir      string                     `json:"dir,omitempty"`
+	Notebook string                     `json:"notebook,omitempty"`
+	Discover *core.GlobNotebooksRequest `json:"discover,omitempty"`
+	// Forks lets a preview action deploy pull requests from forks, whose
+	// code anyone may have written.
+	Forks bool `json:"forks,omitempty"`
+	// Secrets gives previews the env and lifecycle hooks of Notebook,
+	// which then run along with the code of the pull request.
+	Secrets bool `json:"secrets,omitempty"`
+}
+
+type Hook struct {
+	Name    string   `json:"name"`
+	Secret  string   `json:"secret"`
+	Actions []Action `json:"actions"`
+}
+
+// Load reads the hooks defined in a JSON file holding a list of hooks.
+func Load(path string) (map[string]Hook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks: %w", err)
+	}
+	var list []Hook
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks: %w", err)
+	}
+
+	hooks := make(map[string]Hook, len(list))
+	for _, h := range list {
+		if h.Name == "" || h.Secret == "" {
+			return nil, fmt.Errorf("hook %q needs a name and a secret", h.Name)
+		}
+		if _, exists := hooks[h.Name]; exists {
+			return nil, fmt.Errorf("hook %q is defined twice", h.Name)
+		}
+		for _, a := range h.Actions {
+			if err := a.validate(); err != nil {
+				return nil, fmt.Errorf("hook %q: %w", h.Name, err)
+			}
+		}
+		hooks[h.Name] = h
+	}
+	return hooks, nil
+}
+
+func (a Action) validate() error {
+	switch a.Type {
+	case ActionGitPull:
+		if a.Dir == "" {
+			return fmt.Errorf("%s action needs a dir", a.Type)
+		}
+	case ActionReload, ActionPreview:
+		if a.Notebook == "" {
+			return fmt.Errorf("%s action needs a notebook", a.Type)
+		}
+	case ActionDiscover:
+		if a.Discover == nil || a.Discover.Pattern == "" || a.Discover.Domain == "" {
+			return fmt.Errorf("%s action needs a pattern and a domain", a.Type)
+		}
+	default:
+		return fmt.Errorf("unknown action type %q", a.Type)
+	}
+	return nil
+}
+
+// Verify checks that a delivery was sent by someone who knows the secret.
+// GitHub signs the body in X-Hub-Signature-256; GitLab and other senders
+// pass the secret itself in X-Gitlab-Token or X-Hook-Token.
+func (h Hook) Verify(body []byte, header func(key string) string) bool {
+	if sig, ok := strings.CutPrefix(header("X-Hub-Signature-256"), "sha256="); ok {
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	for _, key := range []string{"X-Gitlab-Token", "X-Hook-Token"} {
+		if token := header(key); token != "" {
+			return subtle.ConstantTimeCompare([]byte(token), []byte(h.Secret)) == 1
+		}
+	}
+	return false
+}
+
+// GitPull fast-forwards the git checkout in dir to its upstream.
+func GitPull(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "pull", "--ff-only")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git pull in %s failed: %w: %s", dir, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// PullRequest is what a preview needs to know about a pull request event.
+type PullRequest struct {
+	Number int
+	// Ref is the git ref the head of the pull request can be fetched from.
+	Ref string
+	// Closed is set once the pull request has been closed or merged.
+	Closed bool
+	// Fork is set when the head of the pull request is in another
+	// repository than its base, or in one that was deleted.
+	Fork bool
+}
+
+// PreviewName is the name of the preview deployed for the pull request.
+func (pr PullRequest) PreviewName() string {
+	return "pr-" + strconv.Itoa(pr.Number)
+}
+
+// ParsePullRequest reads a GitHub pull_request or GitLab merge request
+// delivery. It returns false for other deliveries and for events that don't
+// change the code of an open pull request, such as labeling it.
+func ParsePullRequest(body []byte) (PullRequest, bool) {
+	var event struct {
+		// GitHub
+		Action      string `json:"action"`
+		Number      int    `json:"number"`
+		PullRequest *struct {
+			Number int `json:"number"`
+			Head   struct {
+				Repo *struct {
+					FullName string `json:"full_name"`
+				} `json:"repo"`
+			} `json:"head"`
+		} `json:"pull_request"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		// GitLab
+		ObjectKind string `json:"object_kind"`
+		Attributes struct {
+			IID             int    `json:"iid"`
+			Action          string `json:"action"`
+			SourceProjectID int    `json:"source_project_id"`
+			TargetProjectID int    `json:"target_project_id"`
+		} `json:"object_attributes"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return PullRequest{}, false
+	}
+
+	switch {
+	case event.PullRequest != nil && event.Number > 0:
+		head := event.PullRequest.Head.Repo
+		pr := PullRequest{
+			Number: event.Number,
+			Ref:    "refs/pull/" + strconv.Itoa(event.Number) + "/head",
+			Fork:   head == nil || head.FullName != event.Repository.FullName,
+		}
+		switch event.Action {
+		case "opened", "reopened", "synchronize":
+			return pr, true
+		case "closed":
+			pr.Closed = true
+			return pr, true
+		}
+	case event.ObjectKind == "merge_request" && event.Attributes.IID > 0:
+		iid := event.Attributes.IID
+		pr := PullRequest{
+			Number: iid,
+			Ref:    "refs/merge-requests/" + strconv.Itoa(iid) + "/head",
+			Fork:   event.Attributes.SourceProjectID != event.Attributes.TargetProjectID,
+		}
+		switch event.Attributes.Action {
+		case "open", "reopen", "update":
+			return pr, true
+		case "close", "merge":
+			pr.Closed = true
+			return pr, true
+		}
+	}
+	return PullRequest{}, false
+}
@@ -0,0 +1,71 @@
+package hooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+)
+
+// Webhook is an event sink that POSTs every registry event as JSON to URL.
+// With a secret, the body is signed in X-Hub-Signature-256 the way inbound
+// hooks expect it.
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{URL: url, Secret: secret, Client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// HandleEvent delivers e, retrying failed deliveries a few times. Later
+// events of the same notebook wait until it is done.
+func (w *Webhook) HandleEvent(e core.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	for attempt := 1; ; attempt++ {
+		err = w.post(body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(webhookBackoff << (attempt - 1))
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver event: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", w.URL, resp.Status)
+	}
+	return nil
+}
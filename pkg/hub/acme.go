@@ -0,0 +1,81 @@
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rekk30/marimo-hub/pkg/acme"
+	"github.com/rekk30/marimo-hub/pkg/config"
+)
+
+// withACME has tlsCfg serve the certificate ACME keeps for the configured
+// domains, serving TLS even without a certificate of its own, and keeps
+// the certificate renewed until ctx is done. Certificates of the
+// certificates directory still take precedence.
+func withACME(ctx context.Context, cfg *config.Config, tlsCfg *tls.Config) (*tls.Config, error) {
+	provider, err := acmeProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.ACME.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	certs, err := loadCertDir(cfg.ACME.Dir)
+	if err != nil {
+		return nil, err
+	}
+	manager := acme.NewManager(acme.Config{
+		Directory:   cfg.ACME.Directory,
+		Email:       cfg.ACME.Email,
+		Domains:     acmeDomains(cfg),
+		Dir:         cfg.ACME.Dir,
+		RenewBefore: cfg.ACME.RenewBefore,
+		Propagation: cfg.ACME.Propagation,
+	}, provider)
+	go manager.Run(ctx, certs.reload)
+
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	configured := tlsCfg.GetCertificate
+	tlsCfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if configured != nil {
+			if cert, err := configured(hello); cert != nil || err != nil {
+				return cert, err
+			}
+		}
+		return certs.get(hello.ServerName), nil
+	}
+	return tlsCfg, nil
+}
+
+// acmeDomains are the configured domains, or the base domain and its
+// wildcard.
+func acmeDomains(cfg *config.Config) []string {
+	if cfg.ACME.Domains == "" {
+		base := strings.TrimSuffix(cfg.Proxy.BaseDomain, ".")
+		return []string{base, "*." + base}
+	}
+	var domains []string
+	for _, domain := range strings.Split(cfg.ACME.Domains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func acmeProvider(cfg *config.Config) (acme.Provider, error) {
+	switch c := cfg.ACME; c.Provider {
+	case "cloudflare":
+		return acme.NewCloudflare(c.Cloudflare.APIToken, c.Cloudflare.ZoneID), nil
+	case "route53":
+		return acme.NewRoute53(c.Route53.AccessKey, c.Route53.SecretKey, c.Route53.ZoneID), nil
+	case "rfc2136":
+		return acme.NewRFC2136(c.RFC2136.Server, c.RFC2136.Zone, c.RFC2136.TSIGKey, c.RFC2136.TSIGSecret, c.RFC2136.TSIGAlgorithm)
+	}
+	return nil, fmt.Errorf("unknown ACME provider %q", cfg.ACME.Provider)
+}
@@ -0,0 +1,149 @@
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// certDirPoll is how often a certificate directory is checked for changes,
+// such as renewed certificates.
+const certDirPoll = time.Minute
+
+// certDir serves the certificates in a directory to the clients asking for
+// the domains they cover. Each is a name.crt file next to its name.key.
+type certDir struct {
+	dir string
+
+	mu     sync.RWMutex
+	byName map[string]*tls.Certificate
+	// stamp tells whether the directory changed since it was loaded.
+	stamp string
+}
+
+// loadCertDir loads the certificates in dir.
+func loadCertDir(dir string) (*certDir, error) {
+	d := &certDir{dir: dir}
+	stamp, err := d.stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS certificates directory: %w", err)
+	}
+	d.load(stamp)
+	return d, nil
+}
+
+// Run reloads the directory whenever it changed, checking every interval,
+// until ctx is done.
+func (d *certDir) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.reload()
+		}
+	}
+}
+
+// reload loads the directory again if it changed since it was last loaded.
+func (d *certDir) reload() {
+	stamp, err := d.stat()
+	if err != nil {
+		log.Warn().Err(err).Str("dir", d.dir).Msg("Failed to read TLS certificates directory")
+		return
+	}
+	d.mu.RLock()
+	changed := stamp != d.stamp
+	d.mu.RUnlock()
+	if changed {
+		d.load(stamp)
+	}
+}
+
+// stat sums up the names, sizes and modification times of the files in the
+// directory.
+func (d *certDir) stat() (string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// load reads every certificate in the directory. Pairs that fail to load
+// are skipped, so one bad file doesn't take the others down.
+func (d *certDir) load(stamp string) {
+	crts, _ := filepath.Glob(filepath.Join(d.dir, "*.crt"))
+	slices.Sort(crts)
+	byName := map[string]*tls.Certificate{}
+	for _, crt := range crts {
+		key := strings.TrimSuffix(crt, ".crt") + ".key"
+		cert, err := tls.LoadX509KeyPair(crt, key)
+		if err != nil {
+			log.Warn().Err(err).Str("file", crt).Msg("Skipping TLS certificate")
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			log.Warn().Err(err).Str("file", crt).Msg("Skipping TLS certificate")
+			continue
+		}
+		cert.Leaf = leaf
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			// Of two certificates for a domain, the one valid for longer is
+			// the renewed one.
+			if other, ok := byName[name]; ok && !leaf.NotAfter.After(other.Leaf.NotAfter) {
+				continue
+			}
+			byName[name] = &cert
+		}
+	}
+
+	d.mu.Lock()
+	d.byName = byName
+	d.stamp = stamp
+	d.mu.Unlock()
+	log.Info().Str("dir", d.dir).Int("domains", len(byName)).Msg("Loaded TLS certificates")
+}
+
+// get returns the certificate for the domain a client asked for, or nil if
+// none covers it. A wildcard certificate covers a single label.
+func (d *certDir) get(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if cert, ok := d.byName[name]; ok {
+		return cert
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		return d.byName["*."+parent]
+	}
+	return nil
+}
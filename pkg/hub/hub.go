@@ -0,0 +1,567 @@
+// Package hub wires the registry, runner, API and proxy into a marimo hub,
+// so other programs can embed one.
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/api"
+	"github.com/rekk30/marimo-hub/pkg/audit"
+	"github.com/rekk30/marimo-hub/pkg/backup"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/hooks"
+	"github.com/rekk30/marimo-hub/pkg/logging"
+	"github.com/rekk30/marimo-hub/pkg/metrics"
+	"github.com/rekk30/marimo-hub/pkg/proclog"
+	"github.com/rekk30/marimo-hub/pkg/upgrade"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Hub is a marimo hub: notebooks registered through its API are run by its
+// runner and served through its proxy.
+type Hub struct {
+	cfg             *config.Config
+	openRegistry    RegistryOpener
+	launcher        core.Launcher
+	sinks           []core.EventSink
+	middleware      []fiber.Handler
+	routes          []RouteFunc
+	proxyMiddleware []fiber.Handler
+	proxyRoutes     []RouteFunc
+	withEditor      bool
+
+	// Background jobs are stopped when handing over to an upgraded
+	// process. The runner has a context of its own, as cancelling it kills
+	// the notebook processes the new process adopts.
+	ctx        context.Context
+	stopJobs   context.CancelFunc
+	reg        core.Registry
+	runner     *core.Runner
+	domains    *core.DomainCache
+	auditLog   *audit.Logger
+	readOnly   *api.ReadOnly
+	maint      *api.Maintenance
+	apiApp     *fiber.App
+	proxyApp   *fiber.App
+	apiTLS     *tls.Config
+	proxyTLS   *tls.Config
+	editor     *exec.Cmd
+	handedOver atomic.Bool
+}
+
+// New builds a hub and opens its registry, which starts the notebooks that
+// should be running. Serve it with Run.
+func New(opts ...Option) (*Hub, error) {
+	h := &Hub{openRegistry: OpenRegistry, withEditor: true}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.cfg == nil {
+		cfg, err := config.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration: %w", err)
+		}
+		h.cfg = cfg
+	}
+
+	h.ctx, h.stopJobs = context.WithCancel(context.Background())
+	if err := h.setup(upgrade.Inherited(), upgrade.Outputs()); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Config is the configuration the hub runs with.
+func (h *Hub) Config() *config.Config {
+	return h.cfg
+}
+
+func (h *Hub) Registry() core.Registry {
+	return h.reg
+}
+
+func (h *Hub) Runner() *core.Runner {
+	return h.runner
+}
+
+// API is the app serving the API, for registering routes once the hub is
+// built.
+func (h *Hub) API() *fiber.App {
+	return h.apiApp
+}
+
+// Proxy is the app proxying notebooks.
+func (h *Hub) Proxy() *fiber.App {
+	return h.proxyApp
+}
+
+// setup builds the hub. With adopt, it takes over the notebook processes a
+// hub handed over, whose output pipes are outputs, instead of terminating
+// them.
+func (h *Hub) setup(adopt bool, outputs map[string]*os.File) error {
+	cfg := h.cfg
+
+	apiConfig := httpConfig(cfg.Server.API)
+	// Bundle uploads are the largest requests the API takes.
+	apiConfig.BodyLimit = cfg.Notebooks.Upload.MaxSizeMB << 20
+	if cfg.WebDAV.Enabled {
+		apiConfig.RequestMethods = append(slices.Clone(fiber.DefaultMethods), api.WebDAVMethods...)
+	}
+	h.apiApp = fiber.New(apiConfig)
+	var err error
+	if h.apiTLS, err = serverTLS(h.ctx, cfg.Server.API); err != nil {
+		return fmt.Errorf("API server: %w", err)
+	}
+	if h.proxyTLS, err = serverTLS(h.ctx, cfg.Server.Proxy); err != nil {
+		return fmt.Errorf("proxy server: %w", err)
+	}
+	if cfg.ACME.Enabled {
+		if h.proxyTLS, err = withACME(h.ctx, cfg, h.proxyTLS); err != nil {
+			return fmt.Errorf("ACME: %w", err)
+		}
+	}
+	proxyConfig := httpConfig(cfg.Server.Proxy)
+	// Uploads are forwarded to notebooks as they arrive, and multipart
+	// forms are left for the notebook to parse.
+	proxyConfig.StreamRequestBody = true
+	proxyConfig.DisablePreParseMultipartForm = true
+	h.proxyApp = fiber.New(proxyConfig)
+
+	runnerCfg := core.RunnerConfig{
+		Host:             cfg.Notebooks.Host,
+		PortRangeStart:   cfg.Notebooks.PortRange.Start,
+		PortRangeEnd:     cfg.Notebooks.PortRange.End,
+		StateDir:         cfg.Notebooks.StateDir,
+		NotebooksDir:     cfg.Notebooks.Path,
+		StartTimeout:     cfg.Notebooks.StartTimeout,
+		StartParallelism: cfg.Notebooks.StartParallelism,
+		StartJitter:      cfg.Notebooks.StartJitter,
+		SecretsDir:       cfg.Notebooks.SecretsDir,
+		MirrorOutput:     cfg.Notebooks.Logs.Mirror,
+		IdleTimeout:      cfg.Notebooks.IdleTimeout,
+		Launcher:         h.launcher,
+	}
+	var logs *proclog.Store
+	if cfg.Notebooks.Logs.Dir != "" {
+		var err error
+		logs, err = proclog.NewStore(proclog.Config{
+			Dir:       cfg.Notebooks.Logs.Dir,
+			MaxSize:   int64(cfg.Notebooks.Logs.MaxSizeMB) << 20,
+			MaxAge:    cfg.Notebooks.Logs.MaxAge,
+			MaxFiles:  cfg.Notebooks.Logs.MaxFiles,
+			Retention: cfg.Notebooks.Logs.Retention,
+			TotalSize: int64(cfg.Notebooks.Logs.TotalSizeMB) << 20,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create process log store: %w", err)
+		}
+		runnerCfg.Output = logs
+		go logs.Run(h.ctx, time.Minute)
+	}
+	if cfg.Cluster.Enabled {
+		directory, err := core.NewPostgresDirectory(h.ctx, cfg.Database.DSN, cfg.Cluster.NodeID)
+		if err != nil {
+			return fmt.Errorf("failed to create backend directory: %w", err)
+		}
+		advertiseHost, _, _ := net.SplitHostPort(cfg.Cluster.AdvertiseAddress)
+		runnerCfg.Directory = directory
+		runnerCfg.AdvertiseHost = advertiseHost
+	}
+	// Set up before any notebook starts, so none of their orphans are
+	// missed.
+	if cfg.Notebooks.Subreaper || os.Getpid() == 1 {
+		if err := core.ReapChildren(h.ctx, cfg.Notebooks.Subreaper); err != nil {
+			return err
+		}
+	}
+	h.runner = core.NewRunner(context.Background(), runnerCfg)
+	metrics.Register(h.runner)
+	if adopt {
+		log.Info().Int("processes", h.runner.Adopt(outputs)).Msg("Adopted notebook processes from the previous hub")
+	} else if n := h.runner.ReapOrphans(); n > 0 {
+		log.Warn().Int("processes", n).Msg("Terminated notebook processes left over from a previous run")
+	}
+	if cfg.Cluster.Enabled {
+		// Followers never schedule processes; wait for the election.
+		h.runner.SetLeader(false)
+	}
+
+	h.domains = core.NewDomainCache()
+	sinks := append([]core.EventSink{h.runner, h.domains}, h.sinks...)
+	if cfg.Hooks.EventsURL != "" {
+		sinks = append(sinks, hooks.NewWebhook(cfg.Hooks.EventsURL, cfg.Hooks.EventsSecret))
+	}
+	reg, err := h.openRegistry(h.ctx, cfg, sinks...)
+	if err != nil {
+		return fmt.Errorf("failed to create registry: %w", err)
+	}
+	h.reg = reg
+
+	var scheduler *backup.Scheduler
+	var elector core.LeaderElector = core.StandaloneElector{}
+	badgerReg, _ := reg.(*core.BadgerRegistry)
+	if badgerReg != nil {
+		metrics.Register(badgerReg)
+		go badgerReg.RunGC(h.ctx, cfg.Database.GCInterval)
+		badgerReg.SetChangeRetention(cfg.Database.ChangeRetention)
+
+		if cfg.Backup.Enabled {
+			scheduler = backup.NewScheduler(badgerReg, S3Store(cfg.Backup.S3), backup.Config{
+				Interval:  cfg.Backup.Interval,
+				FullEvery: cfg.Backup.FullEvery,
+				Retention: cfg.Backup.Retention,
+				Prefix:    cfg.Backup.S3.Prefix,
+			})
+			go scheduler.Run(h.ctx)
+		}
+	}
+	if pgReg, ok := reg.(*core.PostgresRegistry); ok && cfg.Cluster.Enabled {
+		elector = core.NewPostgresElector(pgReg.DB(), cfg.Cluster.NodeID, cfg.Cluster.AdvertiseAddress, cfg.Cluster.LeaseTTL)
+	}
+
+	reg.SetDomainPolicy(DomainPolicy(cfg))
+	h.runner.SetWorkspaces(reg)
+	h.runner.SetNotebooks(reg)
+	go h.runner.RunReconciler(cfg.Notebooks.ReconcileInterval)
+	h.domains.Load(reg)
+	if err := bootstrapAdmin(cfg, reg); err != nil {
+		return err
+	}
+
+	if cfg.Cluster.Enabled {
+		go elector.Run(h.ctx, func(isLeader bool) {
+			h.runner.SetLeader(isLeader)
+			if isLeader {
+				h.runner.Reconcile()
+			}
+		})
+	}
+
+	api.SetupRecover(h.apiApp)
+	api.SetupRecover(h.proxyApp)
+	api.SetupRequestID(h.apiApp, logging.API)
+	api.SetupRequestID(h.proxyApp, logging.Proxy)
+	requestLevel, _ := zerolog.ParseLevel(cfg.Log.RequestLevel)
+	api.SetupRequestLog(h.apiApp, requestLevel)
+	api.SetupAccessLog(h.proxyApp, cfg, h.domains)
+	api.SetupProxyTimeouts(h.proxyApp, h.domains)
+	if cfg.Audit.Enabled {
+		if h.auditLog, err = newAuditLogger(cfg); err != nil {
+			return err
+		}
+		api.SetupAuditLog(h.apiApp, h.auditLog)
+	}
+	for _, handler := range h.middleware {
+		h.apiApp.Use(handler)
+	}
+	h.readOnly = api.NewReadOnly(cfg.Server.ReadOnly)
+	if h.maint, err = api.NewMaintenance(cfg); err != nil {
+		return err
+	}
+	api.SetupReadOnly(h.apiApp, h.readOnly)
+
+	if cfg.Hooks.File != "" {
+		defs, err := hooks.Load(cfg.Hooks.File)
+		if err != nil {
+			return fmt.Errorf("failed to load webhooks from %s: %w", cfg.Hooks.File, err)
+		}
+		api.SetupHookRoutes(h.apiApp, cfg, reg, h.runner, defs)
+	}
+	api.SetupAPIRoutes(h.apiApp, cfg, reg, h.runner)
+	if cfg.Proxy.ShareSecret == "" {
+		log.Warn().Msg("No proxy share secret is set; share links stop working when the hub restarts")
+	}
+	links, err := core.NewShareLinks(cfg.Proxy.ShareSecret)
+	if err != nil {
+		return err
+	}
+	api.SetupShareRoutes(h.apiApp, cfg, reg, links)
+	api.SetupReadOnlyRoutes(h.apiApp, h.readOnly)
+	api.SetupMaintenanceRoutes(h.apiApp, h.maint)
+	if scheduler != nil {
+		api.SetupBackupRoutes(h.apiApp, scheduler)
+	}
+	if badgerReg != nil {
+		api.SetupDBRoutes(h.apiApp, badgerReg)
+	}
+	changes, _ := reg.(core.ChangeLog)
+	if changes != nil {
+		api.SetupChangeRoutes(h.apiApp, changes)
+	}
+	if source, ok := reg.(core.EventSource); ok {
+		api.SetupEventRoutes(h.apiApp, source, changes)
+	}
+	if logs != nil {
+		api.SetupLogRoutes(h.apiApp, reg, h.runner, logs)
+	}
+	api.SetupMetricsRoutes(h.apiApp)
+	if err := h.pushMetrics(cfg); err != nil {
+		return err
+	}
+	if cfg.Debug.Enabled {
+		api.SetupDebugRoutes(h.apiApp, cfg, reg)
+	}
+	if cfg.WebDAV.Enabled {
+		api.SetupWebDAVRoutes(h.apiApp, cfg, reg)
+	}
+	for _, fn := range h.routes {
+		fn(h, h.apiApp)
+	}
+
+	if err := core.CheckCSP(cfg.Proxy.CSP); err != nil {
+		return fmt.Errorf("invalid proxy CSP: %w", err)
+	}
+	pages, err := api.NewErrorPages(cfg)
+	if err != nil {
+		return err
+	}
+	api.SetupMaintenance(h.proxyApp, h.maint, pages, h.domains)
+	for _, handler := range h.proxyMiddleware {
+		h.proxyApp.Use(handler)
+	}
+	// The proxy answers every path, so its own routes go first.
+	for _, fn := range h.proxyRoutes {
+		fn(h, h.proxyApp)
+	}
+	api.SetupProxyRoutes(h.proxyApp, cfg, h.domains, h.runner, links, pages)
+	return nil
+}
+
+// Run starts the marimo editor and serves the API and the proxy until both
+// servers are shut down. Sent upgrade.Signal, it hands over to a new
+// process running the current executable and returns once its own sessions
+// have drained.
+func (h *Hub) Run() error {
+	cfg := h.cfg
+	if h.withEditor {
+		if err := h.startEditor(); err != nil {
+			return err
+		}
+		// A failed upgrade starts the editor again.
+		defer func() { h.editor.Process.Kill() }()
+	}
+
+	apiAddr := net.JoinHostPort(cfg.Server.APIHost, fmt.Sprintf("%d", cfg.Server.APIPort))
+	proxyAddr := net.JoinHostPort(cfg.Server.ProxyHost, fmt.Sprintf("%d", cfg.Server.ProxyPort))
+	lns, err := upgrade.Listen(apiAddr, proxyAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	log.Info().Msgf("Starting API server on %s and proxy server on %s", apiAddr, proxyAddr)
+
+	var wg sync.WaitGroup
+	serve := func(lns []net.Listener) {
+		apiApp, proxyApp := h.apiApp, h.proxyApp
+		apiLn, proxyLn := withTLS(lns[0], h.apiTLS), withTLS(lns[1], h.proxyTLS)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := apiApp.Listener(apiLn); err != nil {
+				log.Error().Stack().Err(err).Msg("API server error")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := proxyApp.Listener(proxyLn); err != nil {
+				log.Error().Stack().Err(err).Msg("Proxy server error")
+			}
+		}()
+	}
+	serve(lns)
+
+	if err := upgrade.Ready(); err != nil {
+		log.Error().Err(err).Msg("Failed to tell the previous hub that this one took over")
+	}
+	if upgrade.Signal != nil {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, upgrade.Signal)
+		defer signal.Stop(signals)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-signals:
+				case <-done:
+					return
+				}
+				// Keep Run from returning until the sessions have drained.
+				wg.Add(1)
+				next, tookOver := h.handOver(lns, serve)
+				wg.Done()
+				if tookOver {
+					return
+				}
+				lns = next
+			}
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// startEditor starts the marimo editor.
+func (h *Hub) startEditor() error {
+	cfg := h.cfg
+	h.editor = exec.Command("marimo", "edit", "--headless", "--host", cfg.Server.MarimoHost, "-p", fmt.Sprintf("%d", cfg.Server.MarimoPort), "--skip-update-check", "--watch", "--allow-origins", "*", "--no-token")
+	if err := h.editor.Start(); err != nil {
+		return fmt.Errorf("failed to start marimo: %w", err)
+	}
+	log.Info().Msgf("Marimo started on port %d", cfg.Server.MarimoPort)
+	return nil
+}
+
+// Shutdown stops both servers, which makes Run return.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	return errors.Join(h.apiApp.ShutdownWithContext(ctx), h.proxyApp.ShutdownWithContext(ctx))
+}
+
+// Close stops the notebooks and background jobs and closes the registry.
+// After a handover, the notebooks are left to the hub that took over.
+func (h *Hub) Close() error {
+	h.stopJobs()
+	if h.runner != nil && !h.handedOver.Load() {
+		h.runner.Stop()
+	}
+	if h.auditLog != nil {
+		h.auditLog.Close()
+	}
+	if closer, ok := h.reg.(io.Closer); ok && !h.handedOver.Load() {
+		return closer.Close()
+	}
+	return nil
+}
+
+// pushMetrics sends the metrics to the collector the configuration names,
+// if any, until the hub closes.
+func (h *Hub) pushMetrics(cfg *config.Config) error {
+	var exporter metrics.Exporter
+	switch cfg.Metrics.Push {
+	case "statsd":
+		statsd, err := metrics.NewStatsd(cfg.Metrics.PushAddress)
+		if err != nil {
+			return err
+		}
+		exporter = statsd
+	case "otlp":
+		header, err := config.ParseHeaders(cfg.Metrics.PushHeaders)
+		if err != nil {
+			return err
+		}
+		exporter = metrics.NewOTLP(cfg.Metrics.PushAddress, header, "marimo-hub")
+	default:
+		return nil
+	}
+	go metrics.Push(h.ctx, metrics.Default, exporter, cfg.Metrics.PushInterval)
+	return nil
+}
+
+// OpenRegistry opens the registry the configuration selects.
+func OpenRegistry(ctx context.Context, cfg *config.Config, sinks ...core.EventSink) (core.Registry, error) {
+	if cfg.Database.Driver == "postgres" {
+		reg, err := core.NewPostgresRegistry(ctx, cfg.Database.DSN, cfg.Cluster.PollInterval, sinks...)
+		if err != nil {
+			return nil, err
+		}
+		return reg, nil
+	}
+	reg, err := core.NewBadgerRegistry(cfg.Database.Path, sinks...)
+	if err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// httpConfig is the configuration of a fiber app tuned as cfg says.
+func httpConfig(cfg config.HTTPConfig) fiber.Config {
+	return fiber.Config{
+		ErrorHandler:    api.ErrorHandler,
+		Concurrency:     cfg.Concurrency,
+		ReadTimeout:     cfg.ReadTimeout,
+		WriteTimeout:    cfg.WriteTimeout,
+		IdleTimeout:     cfg.IdleTimeout,
+		ReadBufferSize:  cfg.ReadBuffer,
+		WriteBufferSize: cfg.WriteBuffer,
+	}
+}
+
+// bootstrapAdmin creates the configured admin account so a fresh hub with auth
+// enabled can be logged into.
+func bootstrapAdmin(cfg *config.Config, reg core.UserRegistry) error {
+	if cfg.Auth.AdminPassword == "" {
+		if cfg.Auth.Enabled && len(reg.ListUsers()) == 0 {
+			log.Warn().Msg("Auth is enabled but no users exist; set AUTH_ADMIN_PASSWORD to create an admin")
+		}
+		return nil
+	}
+	if _, exists := reg.GetUserByName(cfg.Auth.AdminUsername); exists {
+		return nil
+	}
+
+	admin := true
+	_, err := reg.AddUser(core.CreateUpdateUserRequest{
+		Username: cfg.Auth.AdminUsername,
+		Password: cfg.Auth.AdminPassword,
+		Admin:    &admin,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create admin user: %w", err)
+	}
+	return nil
+}
+
+// S3Store is the object store cfg configures.
+func S3Store(cfg config.S3Config) *backup.S3Store {
+	return backup.NewS3Store(backup.S3Config{
+		Endpoint:     cfg.Endpoint,
+		Region:       cfg.Region,
+		Bucket:       cfg.Bucket,
+		AccessKey:    cfg.AccessKey,
+		SecretKey:    cfg.SecretKey,
+		UsePathStyle: cfg.PathStyle,
+	})
+}
+
+func newAuditLogger(cfg *config.Config) (*audit.Logger, error) {
+	var sinks []audit.Sink
+	if cfg.Audit.File.Path != "" {
+		file, err := audit.NewFileSink(cfg.Audit.File.Path, int64(cfg.Audit.File.MaxSizeMB)<<20, cfg.Audit.File.MaxFiles, cfg.Audit.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		sinks = append(sinks, file)
+	}
+	if cfg.Audit.S3.Bucket != "" {
+		sinks = append(sinks, audit.NewObjectSink(S3Store(cfg.Audit.S3), cfg.Audit.S3.Prefix, cfg.Audit.FlushInterval, 8<<20, cfg.Audit.Retention))
+	}
+	return audit.NewLogger(sinks...), nil
+}
+
+// DomainPolicy restricts notebook domains as the proxy configuration says.
+func DomainPolicy(cfg *config.Config) core.DomainPolicy {
+	policy := core.DomainPolicy{BaseDomain: cfg.Proxy.BaseDomain}
+	if cfg.Proxy.DNSCheck != "off" {
+		policy.Verify = core.DNSCheck{
+			PublicAddress: cfg.Proxy.PublicAddress,
+			Fail:          cfg.Proxy.DNSCheck == "fail",
+		}.Verify
+	}
+	return policy
+}
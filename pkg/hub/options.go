@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// Option customizes a hub built by New.
+type Option func(*Hub)
+
+// RegistryOpener opens the registry of a hub. The registry must deliver its
+// changes to sinks, which keep the runner and the proxy in step with it.
+type RegistryOpener func(ctx context.Context, cfg *config.Config, sinks ...core.EventSink) (core.Registry, error)
+
+// RouteFunc registers routes on one of the hub's servers.
+type RouteFunc func(h *Hub, app *fiber.App)
+
+// WithConfig uses cfg instead of loading the configuration from the
+// environment.
+func WithConfig(cfg *config.Config) Option {
+	return func(h *Hub) {
+		h.cfg = cfg
+	}
+}
+
+// WithRegistry opens the registry with open instead of the configured
+// database. Backups, database routes and cluster leadership need the
+// registries of this module and are left out for other ones.
+func WithRegistry(open RegistryOpener) Option {
+	return func(h *Hub) {
+		h.openRegistry = open
+	}
+}
+
+// WithLauncher starts the backends of notebooks with launcher instead of
+// running marimo as child processes.
+func WithLauncher(launcher core.Launcher) Option {
+	return func(h *Hub) {
+		h.launcher = launcher
+	}
+}
+
+// WithEventSinks delivers registry changes to sinks as well.
+func WithEventSinks(sinks ...core.EventSink) Option {
+	return func(h *Hub) {
+		h.sinks = append(h.sinks, sinks...)
+	}
+}
+
+// WithMiddleware runs handlers on every API request, after request IDs,
+// logging and auditing and before the routes.
+func WithMiddleware(handlers ...fiber.Handler) Option {
+	return func(h *Hub) {
+		h.middleware = append(h.middleware, handlers...)
+	}
+}
+
+// WithRoutes registers extra API routes after the hub's own. Routes under
+// /api/v1 are authenticated like the hub's.
+func WithRoutes(fn RouteFunc) Option {
+	return func(h *Hub) {
+		h.routes = append(h.routes, fn)
+	}
+}
+
+// WithProxyMiddleware runs handlers on every proxied request before it is
+// forwarded to its notebook.
+func WithProxyMiddleware(handlers ...fiber.Handler) Option {
+	return func(h *Hub) {
+		h.proxyMiddleware = append(h.proxyMiddleware, handlers...)
+	}
+}
+
+// WithProxyRoutes registers routes on the proxy, which take precedence over
+// notebooks.
+func WithProxyRoutes(fn RouteFunc) Option {
+	return func(h *Hub) {
+		h.proxyRoutes = append(h.proxyRoutes, fn)
+	}
+}
+
+// WithoutEditor doesn't run the marimo editor alongside the hub.
+func WithoutEditor() Option {
+	return func(h *Hub) {
+		h.withEditor = false
+	}
+}
@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/rekk30/marimo-hub/pkg/config"
+)
+
+// serverTLS returns the TLS settings a server is served with as cfg says,
+// or nil to serve plain HTTP. A certificates directory is watched until ctx
+// is done.
+func serverTLS(ctx context.Context, cfg config.HTTPConfig) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLSCerts != "" {
+		certs, err := loadCertDir(cfg.TLSCerts)
+		if err != nil {
+			return nil, err
+		}
+		go certs.Run(ctx, certDirPoll)
+		// Clients asking for no domain, or one no certificate in the
+		// directory covers, get the default one.
+		tlsCfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certs.get(hello.ServerName), nil
+		}
+	}
+	if cfg.ClientCA != "" {
+		pem, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA %s holds no PEM certificate", cfg.ClientCA)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.ClientAuth == "optional" {
+			tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsCfg, nil
+}
+
+// withTLS wraps ln to serve TLS as tlsCfg says, if set. The listener handed
+// over on upgrade stays the plain one.
+func withTLS(ln net.Listener, tlsCfg *tls.Config) net.Listener {
+	if tlsCfg == nil {
+		return ln
+	}
+	return tls.NewListener(ln, tlsCfg)
+}
@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/metrics"
+	"github.com/rekk30/marimo-hub/pkg/proxy"
+	"github.com/rekk30/marimo-hub/pkg/upgrade"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// apiDrainTimeout bounds how long API requests in flight may take to
+	// finish before the registry is handed over.
+	apiDrainTimeout = 30 * time.Second
+	// upgradeReadyTimeout bounds how long the new process may take to
+	// open the registry, adopt the notebooks and start serving.
+	upgradeReadyTimeout = 2 * time.Minute
+)
+
+// handOver replaces this hub with a new process running the current
+// executable, which is how the binary is upgraded in place: install the new
+// binary, then send the hub upgrade.Signal. The new process inherits the
+// listeners, so no connection is refused, and adopts the notebook processes.
+// This one stops the API first, since Badger can only be opened by one
+// process, and keeps proxying the sessions it has open until they end or
+// the drain timeout passes. It reports whether the new process took over;
+// if not, this hub is back to serving, through serve, on the listeners it
+// returns.
+func (h *Hub) handOver(listeners []net.Listener, serve func([]net.Listener)) ([]net.Listener, bool) {
+	files, err := upgrade.Files(listeners)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot hand over the listeners, not upgrading")
+		return listeners, false
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	log.Info().Msg("Handing over to a new process")
+	h.handedOver.Store(true)
+
+	outputs := h.runner.Release()
+	defer func() {
+		for _, pipe := range outputs {
+			pipe.Close()
+		}
+	}()
+	if err := h.apiApp.ShutdownWithTimeout(apiDrainTimeout); err != nil {
+		log.Warn().Err(err).Msg("API requests were still running")
+	}
+	h.stopJobs()
+	if closer, ok := h.reg.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to close the registry")
+		}
+	}
+	// The editor's port can't be shared.
+	if h.editor != nil {
+		h.editor.Process.Kill()
+		h.editor.Wait()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), upgradeReadyTimeout)
+	defer cancel()
+	proxyApp := h.proxyApp
+	if err := upgrade.Start(ctx, files, outputs); err != nil {
+		log.Error().Stack().Err(err).Msg("Upgrade failed, serving again")
+		lns, err := h.resume(files, outputs, serve)
+		if err != nil {
+			log.Fatal().Stack().Err(err).Msg("Cannot serve again after the failed upgrade, the hub has to be restarted")
+		}
+		// Adopting the processes took over their pipes.
+		outputs = nil
+		// The sessions the old proxy has open carry on, as this process
+		// does.
+		ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Server.DrainTimeout)
+		defer cancel()
+		if err := proxyApp.ShutdownWithContext(ctx); err != nil {
+			log.Warn().Err(err).Msg("Proxy requests were still running")
+		}
+		return lns, false
+	}
+	log.Info().Dur("timeout", h.cfg.Server.DrainTimeout).Msg("New process took over, draining")
+
+	ctx, cancel = context.WithTimeout(context.Background(), h.cfg.Server.DrainTimeout)
+	defer cancel()
+	if err := proxyApp.ShutdownWithContext(ctx); err != nil {
+		log.Warn().Err(err).Msg("Proxy requests were still running")
+	}
+	if err := proxy.WaitForSessions(ctx); err != nil {
+		log.Warn().Err(err).Msg("Closing notebook sessions that are still open")
+	}
+	return nil, true
+}
+
+// resume takes the hub back after a failed upgrade: the registry is opened
+// again, the notebook processes released for the new process are adopted
+// back and the API and proxy serve again on files, next to the proxy that
+// is still draining.
+func (h *Hub) resume(files []*os.File, outputs map[string]*os.File, serve func([]net.Listener)) ([]net.Listener, error) {
+	// The released runner and closed registry are replaced.
+	metrics.Unregister(h.runner)
+	if collector, ok := h.reg.(metrics.Collector); ok {
+		metrics.Unregister(collector)
+	}
+	h.ctx, h.stopJobs = context.WithCancel(context.Background())
+	if err := h.setup(true, outputs); err != nil {
+		return nil, err
+	}
+	h.handedOver.Store(false)
+
+	lns := make([]net.Listener, 0, len(files))
+	for _, file := range files {
+		ln, err := net.FileListener(file)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	serve(lns)
+	if h.withEditor {
+		if err := h.startEditor(); err != nil {
+			log.Error().Err(err).Msg("Failed to start marimo again")
+		}
+	}
+	log.Info().Msg("Serving again after the failed upgrade")
+	return lns, nil
+}
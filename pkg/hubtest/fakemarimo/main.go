@@ -0,0 +1,136 @@
+//go:build fakemarimo
+
+// Command fakemarimo stands in for marimo in tests. It takes the arguments
+// the runner passes to "marimo run" and serves, below --base-url:
+//
+//   - /ws, a WebSocket echoing every message back;
+//   - any other path, the Page it was started for and the request, as JSON.
+//
+// Lines of the notebook file change how it behaves:
+//
+//	# hubtest: delay <duration>   wait before listening
+//	# hubtest: exit <code>        exit with code instead of listening
+//
+// The hubtest package builds it with the fakemarimo build tag.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Page is what every path other than /ws answers with. hubtest decodes the
+// same fields.
+type Page struct {
+	File    string   `json:"file"`
+	Args    []string `json:"args"`
+	BaseURL string   `json:"base_url"`
+	Path    string   `json:"path"`
+	Query   string   `json:"query"`
+	Host    string   `json:"host"`
+	Cookie  string   `json:"cookie"`
+}
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "run" {
+		fmt.Fprintln(os.Stderr, "usage: marimo run <file> --port N --host H [flags]")
+		os.Exit(2)
+	}
+	file := os.Args[2]
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	port := flags.Int("port", 2718, "port to listen on")
+	host := flags.String("host", "127.0.0.1", "host to listen on")
+	baseURL := flags.String("base-url", "", "path prefix to serve below")
+	flags.Bool("headless", false, "")
+	flags.Bool("no-token", false, "")
+	flags.Bool("watch", false, "")
+	flags.Bool("include-code", false, "")
+	flags.Parse(os.Args[3:])
+
+	if err := follow(file); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	page := Page{File: file, Args: os.Args[1:], BaseURL: *baseURL}
+	mux := http.NewServeMux()
+	mux.HandleFunc(*baseURL+"/ws", echo)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		p := page
+		p.Path, p.Query, p.Host = r.URL.Path, r.URL.RawQuery, r.Host
+		p.Cookie = r.Header.Get("Cookie")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	})
+
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", file, addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// follow carries out the directives in the notebook file.
+func follow(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		directive, ok := strings.CutPrefix(scanner.Text(), "# hubtest:")
+		if !ok {
+			continue
+		}
+		verb, arg, _ := strings.Cut(strings.TrimSpace(directive), " ")
+		switch verb {
+		case "delay":
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return fmt.Errorf("invalid delay: %w", err)
+			}
+			time.Sleep(d)
+		case "exit":
+			code, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid exit code: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "exiting with %d\n", code)
+			os.Exit(code)
+		default:
+			return fmt.Errorf("unknown directive %q", verb)
+		}
+	}
+	return scanner.Err()
+}
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(typ, msg); err != nil {
+			return
+		}
+	}
+}
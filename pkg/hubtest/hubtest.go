@@ -0,0 +1,340 @@
+// Package hubtest runs a whole hub in-process for tests: its registry,
+// runner, API and proxy, with notebooks served by a fake marimo built from
+// ./fakemarimo, so that no Python or marimo installation is needed.
+package hubtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gorilla/websocket"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/hub"
+)
+
+const (
+	// WaitTimeout bounds how long WaitForStatus waits.
+	WaitTimeout = 15 * time.Second
+	// notebookPorts is how many ports the runner may give notebooks.
+	notebookPorts = 32
+)
+
+// Directives for notebook files, which the fake marimo carries out before
+// serving the notebook.
+const (
+	// Crash makes the notebook exit with status 3 instead of serving.
+	Crash = "# hubtest: exit 3\n"
+)
+
+// Delay makes the notebook wait for d before it serves.
+func Delay(d time.Duration) string {
+	return fmt.Sprintf("# hubtest: delay %s\n", d)
+}
+
+// Page is what the fake marimo answers requests with, other than to its
+// WebSocket.
+type Page struct {
+	File    string   `json:"file"`
+	Args    []string `json:"args"`
+	BaseURL string   `json:"base_url"`
+	Path    string   `json:"path"`
+	Query   string   `json:"query"`
+	Host    string   `json:"host"`
+	Cookie  string   `json:"cookie"`
+}
+
+// Hub is a hub serving its API and proxy on loopback ports, stopped along
+// with its notebooks when the test ends.
+type Hub struct {
+	*hub.Hub
+	t testing.TB
+	// Marimo is the fake marimo notebooks are run with.
+	Marimo string
+	// APIURL is the base URL of the API, such as http://127.0.0.1:1234.
+	APIURL string
+	// ProxyAddr is the address of the proxy.
+	ProxyAddr string
+}
+
+var (
+	buildOnce sync.Once
+	marimo    string
+	buildErr  error
+)
+
+// FakeMarimo builds the fake marimo once per test binary and returns its
+// path.
+func FakeMarimo(t testing.TB) string {
+	t.Helper()
+	buildOnce.Do(func() {
+		// The binary outlives the test that happened to build it.
+		dir, err := os.MkdirTemp("", "hubtest")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		name := "marimo"
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		marimo = filepath.Join(dir, name)
+		cmd := exec.Command("go", "build", "-tags", "fakemarimo", "-o", marimo, "github.com/rekk30/marimo-hub/pkg/hubtest/fakemarimo")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			buildErr = fmt.Errorf("failed to build the fake marimo: %w\n%s", err, out)
+		}
+	})
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	return marimo
+}
+
+// New starts a hub for the test, without the marimo editor. Its state is
+// kept in temporary directories; cfg, if not nil, adjusts the configuration
+// loaded from the environment before the hub is built.
+func New(t testing.TB, cfg func(*config.Config), opts ...hub.Option) *Hub {
+	t.Helper()
+	fake := FakeMarimo(t)
+
+	c, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	c.Server.APIHost = "127.0.0.1"
+	c.Server.ProxyHost = "127.0.0.1"
+	c.Notebooks.Path = filepath.Join(dir, "notebooks")
+	c.Notebooks.Host = "127.0.0.1"
+	c.Notebooks.StateDir = filepath.Join(dir, "run")
+	c.Notebooks.Logs.Dir = filepath.Join(dir, "logs")
+	c.Notebooks.StartJitter = 0
+	c.Database.Driver = "badger"
+	c.Database.Path = filepath.Join(dir, "marimo-hub.db")
+	c.Notebooks.PortRange.Start = freePort(t)
+	c.Notebooks.PortRange.End = min(c.Notebooks.PortRange.Start+notebookPorts-1, 65535)
+	for _, d := range []string{c.Notebooks.Path, c.Notebooks.StateDir, c.Notebooks.Logs.Dir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apiLn, proxyLn := listen(t), listen(t)
+	c.Server.APIPort = apiLn.Addr().(*net.TCPAddr).Port
+	c.Server.ProxyPort = proxyLn.Addr().(*net.TCPAddr).Port
+	if cfg != nil {
+		cfg(c)
+	}
+
+	h, err := hub.New(append([]hub.Option{hub.WithConfig(c), hub.WithoutEditor()}, opts...)...)
+	if err != nil {
+		apiLn.Close()
+		proxyLn.Close()
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	listenConfig := fiber.ListenConfig{DisableStartupMessage: true}
+	go func() {
+		defer wg.Done()
+		h.API().Listener(apiLn, listenConfig)
+	}()
+	go func() {
+		defer wg.Done()
+		h.Proxy().Listener(proxyLn, listenConfig)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		h.Shutdown(ctx)
+		wg.Wait()
+		h.Close()
+	})
+
+	return &Hub{
+		Hub:       h,
+		t:         t,
+		Marimo:    fake,
+		APIURL:    "http://" + apiLn.Addr().String(),
+		ProxyAddr: proxyLn.Addr().String(),
+	}
+}
+
+func listen(t testing.TB) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+// freePort returns a port nothing listens on, from which the runner hands
+// out notebook ports. The ones after it are likely free too, as the system
+// picks ports at random.
+func freePort(t testing.TB) int {
+	ln := listen(t)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// WriteNotebook writes a notebook file of the given directives to the
+// notebooks directory and returns its path.
+func (h *Hub) WriteNotebook(name string, directives ...string) string {
+	h.t.Helper()
+	var content bytes.Buffer
+	for _, d := range directives {
+		content.WriteString(d)
+	}
+	content.WriteString("import marimo\n")
+	path := filepath.Join(h.Config().Notebooks.Path, name)
+	if err := os.WriteFile(path, content.Bytes(), 0o644); err != nil {
+		h.t.Fatal(err)
+	}
+	return path
+}
+
+// AddNotebook registers a notebook through the API, run by the fake marimo
+// unless req names a runtime, and returns it.
+func (h *Hub) AddNotebook(req core.CreateUpdateNotebookRequest) core.Notebook {
+	h.t.Helper()
+	if req.Runtime == "" {
+		req.Runtime = h.Marimo
+	}
+	var resp core.NotebookResponse
+	if status := h.Call(http.MethodPost, "/api/v1/notebooks", req, &resp); status != http.StatusCreated {
+		h.t.Fatalf("creating notebook %q: status %d", req.Name, status)
+	}
+	return resp.Notebook
+}
+
+// SetDesired sets whether the notebook with id should be running through
+// the API.
+func (h *Hub) SetDesired(id string, desired core.DesiredState) {
+	h.t.Helper()
+	req := core.SetDesiredStateRequest{Desired: desired}
+	if status := h.Call(http.MethodPut, "/api/v1/notebooks/"+id+"/state", req, nil); status >= 300 {
+		h.t.Fatalf("setting notebook %s %s: status %d", id, desired, status)
+	}
+}
+
+// Call sends body, if not nil, as JSON to the API and decodes the response
+// into out, if not nil and the call succeeded. It returns the status code.
+func (h *Hub) Call(method, path string, body, out any) int {
+	h.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, h.APIURL+path, reader)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			h.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// Status returns the status the API reports for the notebook with id.
+func (h *Hub) Status(id string) core.StatusResponse {
+	h.t.Helper()
+	var resp core.StatusResponse
+	if status := h.Call(http.MethodGet, "/api/v1/notebooks/"+id+"/status", nil, &resp); status != http.StatusOK {
+		h.t.Fatalf("status of notebook %s: status %d", id, status)
+	}
+	return resp
+}
+
+// WaitForStatus waits for the notebook with id to reach want, failing the
+// test after WaitTimeout.
+func (h *Hub) WaitForStatus(id string, want core.Status) core.StatusResponse {
+	h.t.Helper()
+	deadline := time.Now().Add(WaitTimeout)
+	for {
+		resp := h.Status(id)
+		if resp.Status == want {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("notebook %s is %s (%s), want %s", id, resp.Status, resp.Reason, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Get requests path of the notebook at host through the proxy.
+func (h *Hub) Get(host, path string) *http.Response {
+	h.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://"+h.ProxyAddr+path, nil)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Host = host
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		h.t.Fatalf("GET %s%s: %v", host, path, err)
+	}
+	h.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// GetPage requests path of the notebook at host through the proxy and
+// decodes what the fake marimo answered.
+func (h *Hub) GetPage(host, path string) Page {
+	h.t.Helper()
+	resp := h.Get(host, path)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		h.t.Fatalf("GET %s%s: status %d: %s", host, path, resp.StatusCode, body)
+	}
+	var page Page
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		h.t.Fatalf("GET %s%s: %v", host, path, err)
+	}
+	return page
+}
+
+// DialWebSocket opens the WebSocket at path of the notebook at host through
+// the proxy. The connection is closed when the test ends.
+func (h *Hub) DialWebSocket(host, path string) *websocket.Conn {
+	h.t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws://"+h.ProxyAddr+path, http.Header{"Host": {host}})
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		h.t.Fatalf("dialing %s%s: %v (status %d)", host, path, err, status)
+	}
+	h.t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(WaitTimeout))
+	return conn
+}
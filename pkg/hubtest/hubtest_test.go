@@ -0,0 +1,532 @@
+package hubtest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rekk30/marimo-hub/pkg/config"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"github.com/rekk30/marimo-hub/pkg/hub"
+)
+
+// waitForExit waits for nothing to listen at addr any more.
+func waitForExit(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(WaitTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("a process still listens at %s", addr)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestProxiesNotebook(t *testing.T) {
+	h := New(t, nil)
+	path := h.WriteNotebook("app.py")
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "app", Path: path, Domain: "app.example.com"})
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+
+	page := h.GetPage("app.example.com", "/search?q=a&b=1")
+	if page.File != path {
+		t.Errorf("served %q, want %q", page.File, path)
+	}
+	if page.Path != "/search" || page.Query != "q=a&b=1" {
+		t.Errorf("notebook got %s?%s, want /search?q=a&b=1", page.Path, page.Query)
+	}
+	if resp := h.Get("missing.example.com", "/"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown domain: status %d, want 404", resp.StatusCode)
+	}
+
+	conn := h.DialWebSocket("app.example.com", "/ws")
+	for _, msg := range []string{"hello", "world"} {
+		conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		_, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Errorf("echo = %q, want %q", got, msg)
+		}
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	h := New(t, nil)
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{
+		Name:       "prefixed",
+		Path:       h.WriteNotebook("prefixed.py"),
+		Domain:     "apps.example.com",
+		PathPrefix: "/sales",
+	})
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+
+	page := h.GetPage("apps.example.com", "/sales/")
+	if page.BaseURL != "/sales" || page.Path != "/sales/" {
+		t.Errorf("notebook got %q with base URL %q, want /sales/ below /sales", page.Path, page.BaseURL)
+	}
+	conn := h.DialWebSocket("apps.example.com", "/sales/ws")
+	conn.WriteMessage(websocket.TextMessage, []byte("hi"))
+	if _, got, err := conn.ReadMessage(); err != nil || string(got) != "hi" {
+		t.Errorf("echo = %q, %v", got, err)
+	}
+}
+
+func TestStopAndStart(t *testing.T) {
+	h := New(t, nil)
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "cycle", Path: h.WriteNotebook("cycle.py"), Domain: "cycle.example.com"})
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+	addr, ok := h.Runner().GetAddress(nb.ID)
+	if !ok {
+		t.Fatal("running notebook has no address")
+	}
+
+	h.SetDesired(nb.ID, core.DesiredStopped)
+	h.WaitForStatus(nb.ID, core.StatusStopped)
+	waitForExit(t, addr)
+	if resp := h.Get("cycle.example.com", "/"); resp.StatusCode < 500 {
+		t.Errorf("stopped notebook: status %d, want an error", resp.StatusCode)
+	}
+
+	h.SetDesired(nb.ID, core.DesiredRunning)
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+	h.GetPage("cycle.example.com", "/")
+}
+
+func TestSlowStart(t *testing.T) {
+	h := New(t, nil)
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "slow", Path: h.WriteNotebook("slow.py", Delay(500*time.Millisecond)), Domain: "slow.example.com"})
+	if status := h.Status(nb.ID).Status; status == core.StatusRunning {
+		t.Errorf("status = %s before the notebook listens", status)
+	}
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+	h.GetPage("slow.example.com", "/")
+}
+
+func TestCrash(t *testing.T) {
+	h := New(t, nil)
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "crash", Path: h.WriteNotebook("crash.py", Crash), Domain: "crash.example.com"})
+	resp := h.WaitForStatus(nb.ID, core.StatusError)
+	if resp.Reason != "exit status 3" {
+		t.Errorf("reason = %q, want exit status 3", resp.Reason)
+	}
+}
+
+func TestDeleteStopsNotebook(t *testing.T) {
+	h := New(t, nil)
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "gone", Path: h.WriteNotebook("gone.py"), Domain: "gone.example.com"})
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+	addr, _ := h.Runner().GetAddress(nb.ID)
+
+	if status := h.Call(http.MethodDelete, "/api/v1/notebooks/"+nb.ID, nil, nil); status >= 300 {
+		t.Fatalf("delete: status %d", status)
+	}
+	waitForExit(t, addr)
+	if resp := h.Get("gone.example.com", "/"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleted notebook: status %d, want 404", resp.StatusCode)
+	}
+}
+
+// serverLauncher serves notebooks from http.Servers in the test process.
+type serverLauncher struct {
+	mu      sync.Mutex
+	started []string
+}
+
+func (l *serverLauncher) Start(ctx context.Context, spec core.LaunchSpec) (core.Process, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(spec.Host, strconv.Itoa(spec.Port)))
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.started = append(l.started, spec.Notebook.ID)
+	l.mu.Unlock()
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "served in-process")
+	})}
+	done := make(chan struct{})
+	go func() {
+		server.Serve(ln)
+		close(done)
+	}()
+	context.AfterFunc(ctx, func() { server.Close() })
+	return &serverProcess{server: server, addr: ln.Addr().String(), done: done}, nil
+}
+
+type serverProcess struct {
+	server *http.Server
+	addr   string
+	done   chan struct{}
+}
+
+func (p *serverProcess) Address() string { return p.addr }
+func (p *serverProcess) Pid() int        { return 0 }
+func (p *serverProcess) Stop() error     { return p.server.Close() }
+
+func (p *serverProcess) Wait() error {
+	<-p.done
+	return nil
+}
+
+func TestLauncher(t *testing.T) {
+	launcher := &serverLauncher{}
+	h := New(t, nil, hub.WithLauncher(launcher))
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "inproc", Path: h.WriteNotebook("inproc.py"), Domain: "inproc.example.com"})
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+
+	resp := h.Get("inproc.example.com", "/")
+	if body, _ := io.ReadAll(resp.Body); string(body) != "served in-process" {
+		t.Errorf("body = %q, want the launcher's server", body)
+	}
+
+	h.SetDesired(nb.ID, core.DesiredStopped)
+	h.WaitForStatus(nb.ID, core.StatusStopped)
+	h.SetDesired(nb.ID, core.DesiredRunning)
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+	launcher.mu.Lock()
+	defer launcher.mu.Unlock()
+	if len(launcher.started) != 2 {
+		t.Errorf("launched %d times, want 2", len(launcher.started))
+	}
+}
+
+func TestShareLink(t *testing.T) {
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer denied.Close()
+	h := New(t, nil)
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{
+		Name:        "shared",
+		Path:        h.WriteNotebook("shared.py"),
+		Domain:      "shared.example.com",
+		ForwardAuth: &core.ForwardAuth{URL: denied.URL},
+	})
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+	if resp := h.Get("shared.example.com", "/"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without a link: status %d, want 401", resp.StatusCode)
+	}
+
+	var share core.ShareLinkResponse
+	if status := h.Call(http.MethodPost, "/api/v1/notebooks/"+nb.ID+"/share", core.CreateShareLinkRequest{MaxUses: 1}, &share); status != http.StatusCreated {
+		t.Fatalf("creating share link: status %d", status)
+	}
+	link, err := url.Parse(share.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := h.Get("shared.example.com", link.RequestURI()+"&x=1")
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "./?x=1" {
+		t.Fatalf("opening the link: status %d to %q, want 303 to ./?x=1", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+h.ProxyAddr+"/?x=1", nil)
+	req.Host = "shared.example.com"
+	req.AddCookie(cookies[0])
+	req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("with the cookie: status %d, want 200", resp.StatusCode)
+	}
+	var page Page
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Cookie != "theme=dark" {
+		t.Errorf("notebook got cookies %q, want only theme=dark", page.Cookie)
+	}
+
+	if resp := h.Get("shared.example.com", link.RequestURI()); resp.StatusCode != http.StatusGone {
+		t.Errorf("opening a used up link: status %d, want 410", resp.StatusCode)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://"+h.ProxyAddr+"/", nil)
+	req.Host = "shared.example.com"
+	req.Header.Set("X-Share-Link", share.Link.ID)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("with a forged header: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	h := New(t, nil)
+	page := "<p>{{.Name}} is {{.Status}}: {{.Reason}}</p>"
+	if err := os.WriteFile(filepath.Join(h.Config().Notebooks.Path, "offline.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nb := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "branded", Path: h.WriteNotebook("branded.py"), Domain: "branded.example.com", ErrorPage: "offline.html"})
+	h.WaitForStatus(nb.ID, core.StatusRunning)
+	h.SetDesired(nb.ID, core.DesiredStopped)
+	h.WaitForStatus(nb.ID, core.StatusStopped)
+
+	get := func(accept string) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+h.ProxyAddr+"/", nil)
+		req.Host = "branded.example.com"
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if body := get("text/html"); body != "<p>branded is Stopped: stopped</p>" {
+		t.Errorf("stopped page = %q", body)
+	}
+	if body := get("application/json"); !strings.Contains(body, `"error"`) {
+		t.Errorf("API clients got %q, want a JSON error", body)
+	}
+
+	status := h.Call(http.MethodPut, "/api/v1/system/maintenance", core.SetMaintenanceRequest{Enabled: ptr(true)}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("enabling maintenance: status %d", status)
+	}
+	if body := get("text/html"); body != "<p>branded is : maintenance</p>" {
+		t.Errorf("maintenance page = %q", body)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// readEvent reads the next server-sent event that carries data.
+func readEvent(t *testing.T, r *bufio.Reader) (id string, e core.Event) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimSpace(strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+			return id, e
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	h := New(t, nil)
+	stream := func(lastID string) *bufio.Reader {
+		req, _ := http.NewRequest(http.MethodGet, h.APIURL+"/api/v1/events", nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("streaming events: status %d", resp.StatusCode)
+		}
+		return bufio.NewReader(resp.Body)
+	}
+
+	live := stream("")
+	first := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "one", Path: h.WriteNotebook("one.py"), Domain: "one.example.com", Desired: core.DesiredStopped})
+	second := h.AddNotebook(core.CreateUpdateNotebookRequest{Name: "two", Path: h.WriteNotebook("two.py"), Domain: "two.example.com", Desired: core.DesiredStopped})
+	firstID, e := readEvent(t, live)
+	if e.Action != core.ActionAdd || e.Notebook.ID != first.ID {
+		t.Fatalf("first event = %s of %s, want add of %s", e.Action, e.Notebook.ID, first.ID)
+	}
+	if _, e := readEvent(t, live); e.Notebook.ID != second.ID {
+		t.Fatalf("second event is of %s, want %s", e.Notebook.ID, second.ID)
+	}
+
+	// A client that reconnects gets what it missed, then what follows.
+	resumed := stream(firstID)
+	if _, e := readEvent(t, resumed); e.Notebook.ID != second.ID {
+		t.Errorf("caught up on %s, want %s", e.Notebook.ID, second.ID)
+	}
+	h.SetDesired(first.ID, core.DesiredRunning)
+	if _, e := readEvent(t, resumed); e.Action != core.ActionUpdate || e.Notebook.ID != first.ID {
+		t.Errorf("after catching up got %s of %s, want update of %s", e.Action, e.Notebook.ID, first.ID)
+	}
+}
+
+func TestConcurrentDomains(t *testing.T) {
+	h := New(t, nil)
+	reg := h.Registry()
+	const racers = 8
+
+	// claim has every racer run add at once and returns how many succeeded,
+	// failing the test on any error but the route being taken.
+	claim := func(add func(i int) error) int {
+		var wg sync.WaitGroup
+		errs := make(chan error, racers)
+		for i := range racers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- add(i)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		won := 0
+		for err := range errs {
+			var inUse *core.InUseError
+			switch {
+			case err == nil:
+				won++
+			case !errors.As(err, &inUse):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		return won
+	}
+
+	path := h.WriteNotebook("race.py")
+	added := claim(func(i int) error {
+		_, err := reg.Add(core.CreateUpdateNotebookRequest{Name: "race" + strconv.Itoa(i), Path: path, Domain: "race.example.com", Desired: core.DesiredStopped})
+		return err
+	})
+	if added != 1 {
+		t.Errorf("%d notebooks added on one domain, want 1", added)
+	}
+
+	var ids []string
+	for i := range racers {
+		nb, err := reg.Add(core.CreateUpdateNotebookRequest{Name: "move" + strconv.Itoa(i), Path: path, Domain: "move" + strconv.Itoa(i) + ".example.com", Desired: core.DesiredStopped})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, nb.ID)
+	}
+	moved := claim(func(i int) error {
+		_, err := reg.Update(ids[i], core.CreateUpdateNotebookRequest{Domain: "moved.example.com"})
+		return err
+	})
+	if moved != 1 {
+		t.Errorf("%d notebooks moved to one domain, want 1", moved)
+	}
+
+	// The domain the winner moved away from is free again.
+	for i, id := range ids {
+		if nb, _ := reg.Get(id); nb.Domain != "moved.example.com" {
+			continue
+		}
+		if _, err := reg.Add(core.CreateUpdateNotebookRequest{Name: "again", Path: path, Domain: "move" + strconv.Itoa(i) + ".example.com", Desired: core.DesiredStopped}); err != nil {
+			t.Errorf("adding a notebook on the domain left behind: %v", err)
+		}
+	}
+}
+
+func TestConcurrentMoves(t *testing.T) {
+	h := New(t, nil)
+	reg := h.Registry()
+	const moves = 8
+
+	nb, err := reg.Add(core.CreateUpdateNotebookRequest{Name: "mover", Path: h.WriteNotebook("mover.py"), Domain: "start.example.com", Desired: core.DesiredStopped})
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain := func(i int) string { return "to" + strconv.Itoa(i) + ".example.com" }
+	var wg sync.WaitGroup
+	for i := range moves {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := reg.Update(nb.ID, core.CreateUpdateNotebookRequest{Domain: domain(i)}); err != nil {
+				t.Errorf("moving to %s: %v", domain(i), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Only the domain the notebook ended up on may still be reserved.
+	nb, _ = reg.Get(nb.ID)
+	for i := -1; i < moves; i++ {
+		d := "start.example.com"
+		if i >= 0 {
+			d = domain(i)
+		}
+		found, taken := reg.GetByRoute(d, "")
+		if want := d == nb.Domain; taken != want || (taken && found.ID != nb.ID) {
+			t.Errorf("%s: reserved = %t, want %t (notebook is on %s)", d, taken, want, nb.Domain)
+		}
+	}
+}
+
+func TestWebDAVWrites(t *testing.T) {
+	h := New(t, func(c *config.Config) {
+		c.Auth.Enabled = true
+		c.WebDAV.Enabled = true
+	})
+	for _, req := range []core.CreateUpdateUserRequest{
+		{Username: "admin", Password: "admin-password", Admin: ptr(true)},
+		{Username: "user", Password: "user-password"},
+	} {
+		if _, err := h.Registry().AddUser(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(h.Config().Notebooks.Path, "app.py"), []byte("print(1)\n"), 0o644)
+
+	dav := func(method, username, password, body string) int {
+		req, err := http.NewRequest(method, h.APIURL+"/dav/app.py", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(username, password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := dav(http.MethodGet, "user", "user-password", ""); status != http.StatusOK {
+		t.Errorf("user reading: status %d, want 200", status)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete, "MOVE"} {
+		if status := dav(method, "user", "user-password", "print(2)\n"); status != http.StatusForbidden {
+			t.Errorf("user %s: status %d, want 403", method, status)
+		}
+	}
+	if status := dav(http.MethodPut, "admin", "admin-password", "print(3)\n"); status >= 300 {
+		t.Errorf("admin writing: status %d", status)
+	}
+	if data, _ := os.ReadFile(filepath.Join(h.Config().Notebooks.Path, "app.py")); string(data) != "print(3)\n" {
+		t.Errorf("app.py = %q, want what the admin wrote", data)
+	}
+}
@@ -0,0 +1,109 @@
+// Package logging splits the hub's logs into modules whose levels are set
+// separately, so that a noisy subsystem can be quieted while another one is
+// debugged.
+package logging
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Module is the logger of a subsystem. Until Setup is called it logs
+// through the global logger.
+type Module struct {
+	name   string
+	logger atomic.Pointer[zerolog.Logger]
+}
+
+var (
+	Registry  = &Module{name: "registry"}
+	Runner    = &Module{name: "runner"}
+	Proxy     = &Module{name: "proxy"}
+	WebSocket = &Module{name: "websocket"}
+	API       = &Module{name: "api"}
+	Backup    = &Module{name: "backup"}
+	Audit     = &Module{name: "audit"}
+	ACME      = &Module{name: "acme"}
+	Metrics   = &Module{name: "metrics"}
+)
+
+var modules = []*Module{Registry, Runner, Proxy, WebSocket, API, Backup, Audit, ACME, Metrics}
+
+// Names lists the modules levels can be set for.
+func Names() []string {
+	names := make([]string, len(modules))
+	for i, m := range modules {
+		names[i] = m.name
+	}
+	return names
+}
+
+// Logger returns the logger of the module.
+func (m *Module) Logger() *zerolog.Logger {
+	if l := m.logger.Load(); l != nil {
+		return l
+	}
+	return &log.Logger
+}
+
+func (m *Module) Debug() *zerolog.Event {
+	return m.Logger().Debug()
+}
+
+func (m *Module) Info() *zerolog.Event {
+	return m.Logger().Info()
+}
+
+func (m *Module) Warn() *zerolog.Event {
+	return m.Logger().Warn()
+}
+
+func (m *Module) Error() *zerolog.Event {
+	return m.Logger().Error()
+}
+
+// Setup sets the global logger to level, and derives the logger of every
+// module from it, at its level in levels or at level otherwise. Call it
+// again after replacing the global logger.
+func Setup(level zerolog.Level, levels map[string]zerolog.Level) {
+	log.Logger = log.Logger.Level(level)
+	for _, m := range modules {
+		l, ok := levels[m.name]
+		if !ok {
+			l = level
+		}
+		logger := log.Logger.Level(l).With().Str("module", m.name).Logger()
+		m.logger.Store(&logger)
+	}
+}
+
+// ParseLevels parses module levels given as module=level pairs separated
+// by commas, such as "registry=info,proxy=warn".
+func ParseLevels(s string) (map[string]zerolog.Level, error) {
+	levels := make(map[string]zerolog.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not module=level", pair)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !slices.Contains(Names(), name) {
+			return nil, fmt.Errorf("unknown module %q, expected one of %s", name, strings.Join(Names(), ", "))
+		}
+		level, err := zerolog.ParseLevel(value)
+		if err != nil || value == "" {
+			return nil, fmt.Errorf("invalid level %q for module %s", value, name)
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
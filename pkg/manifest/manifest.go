@@ -0,0 +1,123 @@
+// Package manifest reads manifests: files declaring the notebooks a hub
+// should serve, so they can be kept in version control.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rekk30/marimo-hub/pkg/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest lists notebooks with the same fields the API takes to create
+// them.
+type Manifest struct {
+	Notebooks []core.CreateUpdateNotebookRequest `json:"notebooks"`
+}
+
+// Load reads a YAML or JSON manifest. Unknown fields are rejected, so typos
+// don't silently drop settings.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	// YAML is a superset of JSON; going through JSON applies the field names
+	// and types the API uses.
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	var m Manifest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// FromNotebooks returns a manifest recreating notebooks. Previews are left
+// out, since they are deployed from pull requests rather than declared.
+func FromNotebooks(notebooks []core.Notebook) *Manifest {
+	m := &Manifest{Notebooks: []core.CreateUpdateNotebookRequest{}}
+	for _, nb := range notebooks {
+		if nb.Preview != nil {
+			continue
+		}
+		path := nb.Path
+		if nb.RelativePath != "" {
+			path = nb.RelativePath
+		}
+		m.Notebooks = append(m.Notebooks, core.CreateUpdateNotebookRequest{
+			Name:          nb.Name,
+			Path:          path,
+			Domain:        nb.Domain,
+			PathPrefix:    nb.PathPrefix,
+			WorkspaceID:   nb.WorkspaceID,
+			Runtime:       nb.Runtime,
+			Env:           nb.Env,
+			ShowCode:      &nb.ShowCode,
+			Watch:         &nb.Watch,
+			Pinned:        &nb.Pinned,
+			Desired:       nb.Desired,
+			Owner:         nb.Owner,
+			Collaborators: nb.Collaborators,
+			Assets:        nb.Assets,
+			ErrorPage:     nb.ErrorPage,
+			Public:        &nb.Public,
+			Disabled:      &nb.Disabled,
+			ClientCert:    &nb.ClientCert,
+			StartTimeout:  &nb.StartTimeout,
+			DependsOn:     nb.DependsOn,
+			Hooks:         nb.Hooks,
+			Probe:         nb.Probe,
+			Upstream:      nb.Upstream,
+			ForwardAuth:   nb.ForwardAuth,
+			AccessLog:     nb.AccessLog,
+			Limits:        nb.Limits,
+			Timeouts:      nb.Timeouts,
+			Security:      nb.Security,
+			CSP:           nb.CSP,
+		})
+	}
+	return m
+}
+
+// Write writes m as YAML, with fields in the order the API documents them.
+func (m *Manifest) Write(w io.Writer) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// Decoding JSON into a node keeps the field order, but also its flow
+	// style, which is cleared to get block YAML.
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	clearStyle(&node)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
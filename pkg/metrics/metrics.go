@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+)
+
+// Metric is a single sample.
+type Metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Collector produces samples when metrics are gathered.
+type Collector interface {
+	Collect() []Metric
+}
+
+type CollectorFunc func() []Metric
+
+func (f CollectorFunc) Collect() []Metric {
+	return f()
+}
+
+// Registry holds the collectors exposed by the hub.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// Default is the registry served on /metrics.
+var Default = &Registry{}
+
+func Register(c Collector) {
+	Default.Register(c)
+}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func Unregister(c Collector) {
+	Default.Unregister(c)
+}
+
+// Unregister removes c, for collectors that are replaced.
+func (r *Registry) Unregister(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = slices.DeleteFunc(r.collectors, func(other Collector) bool { return other == c })
+}
+
+// Gather collects every sample, sorted by name.
+func (r *Registry) Gather() []Metric {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var all []Metric
+	for _, c := range collectors {
+		all = append(all, c.Collect()...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// WriteText writes the samples in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	last := ""
+	for _, m := range r.Gather() {
+		if m.Name != last {
+			if m.Help != "" {
+				if _, err := fmt.Fprintf(w, "# HELP %s %s\n", m.Name, m.Help); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", m.Name, m.Type); err != nil {
+				return err
+			}
+			last = m.Name
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %g\n", m.Name, formatLabels(m.Labels), m.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, k, labelEscaper.Replace(labels[k])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Counter is a monotonically increasing value registered on Default.
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	Register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Collect() []Metric {
+	return []Metric{{Name: c.name, Help: c.help, Type: TypeCounter, Value: float64(c.value.Load())}}
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// OTLP aggregation temporality of counters, which are cumulative.
+const otlpCumulative = 2
+
+// OTLP sends samples to an OpenTelemetry collector over OTLP/HTTP, encoded
+// as JSON.
+type OTLP struct {
+	URL string
+	// Header is added to every request, as for credentials.
+	Header http.Header
+	Client *http.Client
+	// resource describes the hub the samples come from, and start is when
+	// its counters started counting.
+	resource otlpResource
+	start    time.Time
+}
+
+// NewOTLP sends to url, the metrics endpoint of a collector such as
+// http://collector:4318/v1/metrics. service names the hub.
+func NewOTLP(url string, header http.Header, service string) *OTLP {
+	attrs := []otlpAttribute{stringAttribute("service.name", service)}
+	if host, err := os.Hostname(); err == nil {
+		attrs = append(attrs, stringAttribute("host.name", host))
+	}
+	return &OTLP{
+		URL:      url,
+		Header:   header,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		resource: otlpResource{Attributes: attrs},
+		start:    time.Now(),
+	}
+}
+
+// The OTLP JSON encoding of ExportMetricsServiceRequest, as far as the
+// hub's samples need it.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpMetric struct {
+		Name        string     `json:"name"`
+		Description string     `json:"description,omitempty"`
+		Gauge       *otlpGauge `json:"gauge,omitempty"`
+		Sum         *otlpSum   `json:"sum,omitempty"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality"`
+		IsMonotonic            bool            `json:"isMonotonic"`
+	}
+	otlpDataPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano uint64          `json:"startTimeUnixNano,omitempty,string"`
+		TimeUnixNano      uint64          `json:"timeUnixNano,string"`
+		AsDouble          float64         `json:"asDouble"`
+	}
+	otlpAttribute struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+func (o *OTLP) Export(ctx context.Context, samples []Metric) error {
+	body, err := json.Marshal(o.request(samples, time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, values := range o.Header {
+		req.Header[k] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %w", o.URL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector %s answered %s", o.URL, resp.Status)
+	}
+	return nil
+}
+
+// request groups samples, which Gather sorts by name, into a metric each.
+func (o *OTLP) request(samples []Metric, now time.Time) otlpRequest {
+	var metrics []otlpMetric
+	for _, m := range samples {
+		if len(metrics) == 0 || metrics[len(metrics)-1].Name != m.Name {
+			metric := otlpMetric{Name: m.Name, Description: m.Help}
+			if m.Type == TypeCounter {
+				metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			} else {
+				metric.Gauge = &otlpGauge{}
+			}
+			metrics = append(metrics, metric)
+		}
+		point := otlpDataPoint{TimeUnixNano: uint64(now.UnixNano()), AsDouble: m.Value}
+		keys := make([]string, 0, len(m.Labels))
+		for k := range m.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			point.Attributes = append(point.Attributes, stringAttribute(k, m.Labels[k]))
+		}
+		metric := &metrics[len(metrics)-1]
+		if metric.Sum != nil {
+			point.StartTimeUnixNano = uint64(o.start.UnixNano())
+			metric.Sum.DataPoints = append(metric.Sum.DataPoints, point)
+		} else {
+			metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, point)
+		}
+	}
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     o.resource,
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "marimo-hub"}, Metrics: metrics}},
+	}}}
+}
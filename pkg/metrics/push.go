@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+// Exporter sends samples to a collector, for hubs that can't be scraped.
+type Exporter interface {
+	Export(ctx context.Context, samples []Metric) error
+}
+
+// Push gathers r and hands the samples to e every interval until ctx is
+// done. Failures are logged and the samples dropped; the next push sends
+// current values anyway. An exporter that is an io.Closer is closed once
+// ctx is done.
+func Push(ctx context.Context, r *Registry, e Exporter, interval time.Duration) {
+	if closer, ok := e.(io.Closer); ok {
+		defer closer.Close()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// A push cut short by the hub closing isn't worth a warning.
+			if err := e.Export(ctx, r.Gather()); err != nil && ctx.Err() == nil {
+				logging.Metrics.Warn().Err(err).Msg("Failed to push metrics")
+			}
+		}
+	}
+}
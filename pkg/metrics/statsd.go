@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxStatsdPacket keeps datagrams within the MTU of most networks.
+const maxStatsdPacket = 1432
+
+// Statsd sends samples to a statsd server over UDP, gauges as gauges and
+// counters as the increase since the previous push. Labels become
+// DogStatsD tags, which Datadog, Telegraf and Vector understand.
+type Statsd struct {
+	conn net.Conn
+
+	mu sync.Mutex
+	// last holds the value of each counter at the previous push.
+	last map[string]float64
+}
+
+// NewStatsd sends to the statsd server at addr, a host:port.
+func NewStatsd(addr string) (*Statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach statsd at %s: %w", addr, err)
+	}
+	return &Statsd{conn: conn, last: map[string]float64{}}, nil
+}
+
+// Close closes the connection to the statsd server.
+func (s *Statsd) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Statsd) Export(ctx context.Context, samples []Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var packet []byte
+	for _, m := range samples {
+		line := m.Name + ":"
+		switch m.Type {
+		case TypeCounter:
+			key := m.Name + formatLabels(m.Labels)
+			prev, seen := s.last[key]
+			s.last[key] = m.Value
+			// A counter that went down was reset, as by a restart.
+			delta := m.Value - prev
+			if !seen || delta < 0 {
+				delta = m.Value
+			}
+			if delta == 0 {
+				continue
+			}
+			line += strconv.FormatFloat(delta, 'f', -1, 64) + "|c"
+		default:
+			line += strconv.FormatFloat(m.Value, 'f', -1, 64) + "|g"
+		}
+		line += statsdTags(m.Labels)
+
+		if len(packet) > 0 && len(packet)+1+len(line) > maxStatsdPacket {
+			if _, err := s.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		_, err := s.conn.Write(packet)
+		return err
+	}
+	return nil
+}
+
+var statsdEscaper = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+
+func statsdTags(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, k+":"+statsdEscaper.Replace(v))
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
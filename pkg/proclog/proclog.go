@@ -0,0 +1,292 @@
+// Package proclog captures the output of notebook processes into one log
+// file per notebook. Files are rotated by size and age, and rotated files are
+// removed by count, by age and once all logs together exceed a size budget.
+package proclog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/logging"
+)
+
+const timestampFormat = "20060102T150405.000000000"
+
+type Config struct {
+	// Dir holds the log files of every notebook.
+	Dir string
+	// MaxSize and MaxAge rotate a notebook's active file once it grows past
+	// MaxSize bytes or was opened longer than MaxAge ago. Zero disables either.
+	MaxSize int64
+	MaxAge  time.Duration
+	// MaxFiles is how many rotated files are kept per notebook.
+	MaxFiles int
+	// Retention removes rotated files older than this.
+	Retention time.Duration
+	// TotalSize is the budget in bytes for all log files together. The
+	// oldest rotated files are removed first; active files are never removed.
+	TotalSize int64
+}
+
+// Store hands out the writers processes log to and applies retention.
+type Store struct {
+	cfg     Config
+	mu      sync.Mutex
+	writers map[string]*Writer
+}
+
+func NewStore(cfg Config) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return &Store{cfg: cfg, writers: make(map[string]*Writer)}, nil
+}
+
+// Open returns the writer for notebook id. A process that is restarted while
+// the previous one is still draining shares its writer; each Open must be
+// paired with a Close.
+func (s *Store) Open(id string) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.writers[id]; ok {
+		w.refs++
+		return w, nil
+	}
+
+	w := &Writer{store: s, id: id, path: s.path(id), refs: 1}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	s.writers[id] = w
+	return w, nil
+}
+
+// Files lists the log files of notebook id, oldest first. The active file,
+// if any, is last.
+func (s *Store) Files(id string) ([]string, error) {
+	rotated, err := filepath.Glob(s.path(id) + ".*")
+	if err != nil {
+		return nil, err
+	}
+	// Timestamps sort lexically, oldest first.
+	sort.Strings(rotated)
+	if _, err := os.Stat(s.path(id)); err == nil {
+		rotated = append(rotated, s.path(id))
+	}
+	return rotated, nil
+}
+
+// Run rotates idle files that outgrew MaxAge and enforces retention every
+// interval until ctx is cancelled.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+func (s *Store) sweep() {
+	s.mu.Lock()
+	writers := make([]*Writer, 0, len(s.writers))
+	for _, w := range s.writers {
+		writers = append(writers, w)
+	}
+	s.mu.Unlock()
+
+	for _, w := range writers {
+		w.mu.Lock()
+		if w.file != nil && w.size > 0 && w.expired() {
+			if err := w.rotate(); err != nil {
+				logging.Runner.Warn().Err(err).Str("notebook", w.id).Msg("Failed to rotate process log")
+			}
+		}
+		w.mu.Unlock()
+	}
+
+	s.enforceBudget()
+}
+
+// enforceBudget removes rotated files past Retention and then the oldest
+// rotated files until all logs fit in TotalSize.
+func (s *Store) enforceBudget() {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		logging.Runner.Warn().Err(err).Str("dir", s.cfg.Dir).Msg("Failed to list process logs")
+		return
+	}
+
+	type logFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var rotated []logFile
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		total += info.Size()
+		if strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		rotated = append(rotated, logFile{
+			path:    filepath.Join(s.cfg.Dir, entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(rotated, func(i, j int) bool { return rotated[i].modTime.Before(rotated[j].modTime) })
+
+	for _, f := range rotated {
+		expired := s.cfg.Retention > 0 && time.Since(f.modTime) > s.cfg.Retention
+		overBudget := s.cfg.TotalSize > 0 && total > s.cfg.TotalSize
+		if !expired && !overBudget {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			logging.Runner.Warn().Err(err).Str("path", f.path).Msg("Failed to remove process log")
+			continue
+		}
+		total -= f.size
+	}
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.cfg.Dir, id+".log")
+}
+
+// Writer appends a notebook's output to its active log file, rotating it as
+// configured. It is safe for concurrent use.
+type Writer struct {
+	store *Store
+	id    string
+	path  string
+	refs  int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// Write never fails: output that cannot be stored is dropped, because an
+// error would stop the copy from the process and eventually block it on a
+// full pipe.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil && w.size > 0 && (w.expired() || (w.store.cfg.MaxSize > 0 && w.size+int64(len(p)) > w.store.cfg.MaxSize)) {
+		if err := w.rotate(); err != nil {
+			logging.Runner.Warn().Err(err).Str("notebook", w.id).Msg("Failed to rotate process log")
+		}
+	}
+	if w.file == nil {
+		return len(p), nil
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		logging.Runner.Warn().Err(err).Str("notebook", w.id).Msg("Failed to write process log")
+	}
+	return len(p), nil
+}
+
+func (w *Writer) Close() error {
+	w.store.mu.Lock()
+	w.refs--
+	last := w.refs == 0
+	if last {
+		delete(w.store.writers, w.id)
+	}
+	w.store.mu.Unlock()
+	if !last {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// expired must be called with w.mu held.
+func (w *Writer) expired() bool {
+	return w.store.cfg.MaxAge > 0 && time.Since(w.openedAt) > w.store.cfg.MaxAge
+}
+
+// open must be called with w.mu held or before w is shared.
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open process log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+// rotate must be called with w.mu held.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	rotated := w.path + "." + time.Now().UTC().Format(timestampFormat)
+	if err := os.Rename(w.path, rotated); err != nil {
+		// Keep appending to the current file rather than dropping output.
+		w.open()
+		return fmt.Errorf("failed to rotate process log: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.prune()
+	return nil
+}
+
+// prune keeps the newest MaxFiles rotated files of the notebook.
+func (w *Writer) prune() {
+	if w.store.cfg.MaxFiles <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+	sort.Strings(matches)
+
+	for i := 0; i < len(matches)-w.store.cfg.MaxFiles; i++ {
+		if err := os.Remove(matches[i]); err != nil {
+			logging.Runner.Warn().Err(err).Str("path", matches[i]).Msg("Failed to remove process log")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// Backend is a running notebook requests are forwarded to.
+type Backend struct {
+	Notebook core.Notebook
+	// Addr is the host:port the notebook is reached at.
+	Addr    string
+	runtime Runtime
+}
+
+// URL returns the URL of path on the notebook.
+func (b *Backend) URL(path, rawQuery string) string {
+	return b.url(b.Notebook.Upstream.HTTPScheme(), path, rawQuery)
+}
+
+// WebSocketURL returns the URL of the WebSocket at path on the notebook.
+func (b *Backend) WebSocketURL(path, rawQuery string) string {
+	return b.url(b.Notebook.Upstream.WebSocketScheme(), path, rawQuery)
+}
+
+func (b *Backend) url(scheme, path, rawQuery string) string {
+	url := fmt.Sprintf("%s://%s%s", scheme, b.Addr, path)
+	if rawQuery != "" {
+		url += "?" + rawQuery
+	}
+	return url
+}
+
+// Forward sends req to the notebook, without following redirects, which
+// go back to the visitor. The request counts against the notebook's
+// request limit until the body of its response is closed, and the body is
+// paced to the notebook's bandwidth limit. Hop-by-hop headers are removed
+// from both.
+func (b *Backend) Forward(req *http.Request) (*http.Response, error) {
+	transport, err := upstreamTransport(b.Notebook.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUpstream, err)
+	}
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	done, admitted := b.runtime.TryBeginRequest(b.Notebook.ID, limits(b.Notebook).MaxRequests)
+	if !admitted {
+		return nil, ErrTooManyRequests
+	}
+	RemoveHopHeaders(req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	RemoveHopHeaders(resp.Header)
+	// The server streams the body after the handler returned.
+	resp.Body = trackedBody{resp.Body, done}
+	if throttle := b.Throttle(); throttle != nil {
+		resp.Body = throttledBody{resp.Body, throttle}
+	}
+	return resp, nil
+}
+
+// BeginSession counts a WebSocket session against the notebook's session
+// limit, and as one WaitForSessions waits for, until the returned function
+// is called.
+func (b *Backend) BeginSession() (func(), error) {
+	done, admitted := b.runtime.TryBeginSession(b.Notebook.ID, limits(b.Notebook).MaxSessions)
+	if !admitted {
+		return nil, ErrTooManySessions
+	}
+	sessions.Add(1)
+	return func() {
+		done()
+		sessions.Done()
+	}, nil
+}
+
+// Throttle returns the throttle of the notebook, or nil if its bandwidth
+// is unlimited.
+func (b *Backend) Throttle() *Throttle {
+	return notebookThrottle(b.Notebook)
+}
+
+// limits returns the limits of nb, zero meaning none.
+func limits(nb core.Notebook) core.ProxyLimits {
+	if nb.Limits == nil {
+		return core.ProxyLimits{}
+	}
+	return *nb.Limits
+}
+
+// upstreamTransports holds a transport for each upstream with TLS settings
+// of its own, so its connections are reused across requests.
+var upstreamTransports sync.Map
+
+// upstreamTransport returns the transport to reach a backend through u.
+func upstreamTransport(u *core.Upstream) (http.RoundTripper, error) {
+	cfg, err := u.TLSConfig()
+	if err != nil || cfg == nil {
+		return http.DefaultTransport, err
+	}
+	if t, ok := upstreamTransports.Load(*u); ok {
+		return t.(http.RoundTripper), nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	actual, _ := upstreamTransports.LoadOrStore(*u, t)
+	return actual.(http.RoundTripper), nil
+}
+
+// upstreamDialer returns the dialer for WebSockets of a backend reached
+// through u.
+func upstreamDialer(u *core.Upstream) (*websocket.Dialer, error) {
+	cfg, err := u.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = handshakeTimeout
+	dialer.TLSClientConfig = cfg
+	return &dialer, nil
+}
+
+// trackedBody ends the count of a proxied request once its response body
+// is closed.
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b trackedBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+func newTestBackend(t *testing.T, handler http.Handler, limits *core.ProxyLimits) (*Backend, *fakeRuntime) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	runtime := &fakeRuntime{addr: strings.TrimPrefix(server.URL, "http://"), status: core.StatusRunning}
+	router := NewRouter(fakeDomains{}, runtime)
+	backend, err := router.Backend(core.Notebook{ID: t.Name(), Limits: limits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, runtime
+}
+
+func TestForward(t *testing.T) {
+	backend, runtime := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Hop") != "" || r.Header.Get("Keep-Alive") != "" {
+			t.Errorf("hop-by-hop headers were forwarded: %v", r.Header)
+		}
+		w.Header().Set("Connection", "X-Secret")
+		w.Header().Set("X-Secret", "hop")
+		w.Header().Set("X-Kept", "yes")
+		io.WriteString(w, r.URL.RawQuery)
+	}), nil)
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL("/", "a=1"), nil)
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	resp, err := backend.Forward(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests, _ := runtime.open(); requests != 1 {
+		t.Errorf("%d requests open while the body is unread, want 1", requests)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "a=1" {
+		t.Errorf("body = %q, want the query a=1", body)
+	}
+	if resp.Header.Get("X-Secret") != "" || resp.Header.Get("Connection") != "" {
+		t.Errorf("hop-by-hop response headers were kept: %v", resp.Header)
+	}
+	if resp.Header.Get("X-Kept") != "yes" {
+		t.Errorf("X-Kept = %q, want yes", resp.Header.Get("X-Kept"))
+	}
+	if requests, _ := runtime.open(); requests != 0 {
+		t.Errorf("%d requests open once the body is closed, want 0", requests)
+	}
+}
+
+func TestForwardKeepsRedirects(t *testing.T) {
+	backend, _ := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}), nil)
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL("/", ""), nil)
+	resp, err := backend.Forward(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Errorf("got %d to %q, want the redirect to /login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestForwardLimitsRequests(t *testing.T) {
+	backend, runtime := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), &core.ProxyLimits{MaxRequests: 1})
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL("/", ""), nil)
+	first, err := backend.Forward(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, backend.URL("/", ""), nil)
+	if _, err := backend.Forward(req); !errors.Is(err, ErrTooManyRequests) {
+		t.Errorf("second request error = %v, want %v", err, ErrTooManyRequests)
+	}
+	first.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodGet, backend.URL("/", ""), nil)
+	third, err := backend.Forward(req)
+	if err != nil {
+		t.Fatalf("request after the first ended: %v", err)
+	}
+	third.Body.Close()
+	if requests, _ := runtime.open(); requests != 0 {
+		t.Errorf("%d requests open, want 0", requests)
+	}
+}
+
+func TestForwardFailureEndsRequest(t *testing.T) {
+	runtime := &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusRunning}
+	backend, err := NewRouter(fakeDomains{}, runtime).Backend(core.Notebook{ID: "unreachable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, backend.URL("/", ""), nil)
+	if _, err := backend.Forward(req); err == nil {
+		t.Fatal("Forward to a closed port succeeded")
+	}
+	if requests, _ := runtime.open(); requests != 0 {
+		t.Errorf("%d requests open after a failure, want 0", requests)
+	}
+}
+
+func TestForwardInvalidUpstream(t *testing.T) {
+	backend := &Backend{
+		Notebook: core.Notebook{ID: "nb", Upstream: &core.Upstream{Scheme: "https", CA: "not a certificate"}},
+		Addr:     "127.0.0.1:1",
+		runtime:  &fakeRuntime{},
+	}
+	req, _ := http.NewRequest(http.MethodGet, backend.URL("/", ""), nil)
+	if _, err := backend.Forward(req); !errors.Is(err, ErrInvalidUpstream) {
+		t.Errorf("Forward() error = %v, want %v", err, ErrInvalidUpstream)
+	}
+}
+
+func TestBeginSession(t *testing.T) {
+	runtime := &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusRunning}
+	backend, err := NewRouter(fakeDomains{}, runtime).Backend(core.Notebook{
+		ID:     "nb",
+		Limits: &core.ProxyLimits{MaxSessions: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := backend.BeginSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.BeginSession(); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("second session error = %v, want %v", err, ErrTooManySessions)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := WaitForSessions(ctx); err == nil {
+		t.Error("WaitForSessions returned with a session open")
+	}
+	done()
+	if err := WaitForSessions(context.Background()); err != nil {
+		t.Errorf("WaitForSessions() = %v once the session ended", err)
+	}
+	if _, sessions := runtime.open(); sessions != 0 {
+		t.Errorf("%d sessions open, want 0", sessions)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	payload := strings.Repeat("x", 64<<10)
+	backend, _ := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, payload)
+	}), &core.ProxyLimits{BandwidthKB: 256})
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL("/", ""), nil)
+	resp, err := backend.Forward(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	start := time.Now()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || len(body) != len(payload) {
+		t.Fatalf("read %d bytes, %v", len(body), err)
+	}
+	// 64KiB at 256KiB/s take a quarter of a second.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("body read in %v, want about 250ms", elapsed)
+	}
+}
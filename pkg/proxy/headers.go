@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopHeaders are the hop-by-hop headers of RFC 7230, section 6.1. They
+// describe a single connection and are never forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveCookies deletes the cookies whose names start with prefix from the
+// Cookie headers of h, keeping the others as they were sent.
+func RemoveCookies(h http.Header, prefix string) {
+	values := h.Values("Cookie")
+	h.Del("Cookie")
+	for _, v := range values {
+		var kept []string
+		for _, pair := range strings.Split(v, ";") {
+			pair = textproto.TrimString(pair)
+			if pair != "" && !strings.HasPrefix(pair, prefix) {
+				kept = append(kept, pair)
+			}
+		}
+		if len(kept) > 0 {
+			h.Add("Cookie", strings.Join(kept, "; "))
+		}
+	}
+}
+
+// RemoveHopHeaders deletes the hop-by-hop headers from h, along with the
+// ones its Connection header names.
+func RemoveHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRemoveCookies(t *testing.T) {
+	h := http.Header{}
+	h.Add("Cookie", "session=abc; hub_share_1=token; theme=dark")
+	h.Add("Cookie", "hub_share_2=token")
+
+	RemoveCookies(h, "hub_share_")
+
+	if got := h.Values("Cookie"); len(got) != 1 || got[0] != "session=abc; theme=dark" {
+		t.Errorf("Cookie = %q, want the other cookies only", got)
+	}
+}
+
+func TestRemoveHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Named")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Upgrade", "websocket")
+	h.Set("X-Named", "hop")
+	h.Set("Content-Type", "text/plain")
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+
+	RemoveHopHeaders(h)
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade", "X-Named"} {
+		if _, ok := h[name]; ok {
+			t.Errorf("%s was kept", name)
+		}
+	}
+	if h.Get("Content-Type") != "text/plain" {
+		t.Errorf("Content-Type = %q, want text/plain", h.Get("Content-Type"))
+	}
+	if got := h.Values("Set-Cookie"); len(got) != 2 {
+		t.Errorf("Set-Cookie = %v, want both cookies", got)
+	}
+}
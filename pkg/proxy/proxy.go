@@ -0,0 +1,133 @@
+// Package proxy routes the requests and WebSocket sessions the hub proxies
+// to the notebooks serving them, and forwards them there. HTTP requests and
+// WebSocket sessions share the lookup, readiness checks, limits, traffic
+// counts and draining; the API turns the errors into responses.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+var (
+	ErrNotFound   = errors.New("no such notebook")
+	ErrDisabled   = errors.New("notebook disabled")
+	ErrClientCert = errors.New("client certificate required")
+	ErrNoAddress  = errors.New("port unavailable")
+	ErrSuspended  = errors.New("notebook suspended")
+	ErrStarting   = errors.New("notebook starting")
+	ErrNotRunning = errors.New("notebook not running")
+	// ErrInvalidUpstream wraps the error of an upstream whose TLS settings
+	// don't load.
+	ErrInvalidUpstream = errors.New("invalid upstream")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrTooManySessions = errors.New("too many sessions")
+)
+
+// Runtime runs the notebooks requests are forwarded to, as core.Runner
+// does.
+type Runtime interface {
+	GetAddress(id string) (string, bool)
+	GetStatus(id string) (core.Status, error)
+	Wake(id string) bool
+	TryBeginRequest(id string, limit int) (func(), bool)
+	TryBeginSession(id string, limit int) (func(), bool)
+}
+
+// Router resolves the notebook a request is for and the backend serving
+// it.
+type Router struct {
+	domains core.DomainResolver
+	runtime Runtime
+}
+
+func NewRouter(domains core.DomainResolver, runtime Runtime) *Router {
+	return &Router{domains: domains, runtime: runtime}
+}
+
+// Notebook returns the notebook serving path on host to a visitor who
+// presented a verified client certificate if clientCert is set, and counts
+// the request in its traffic. A disabled notebook or one requiring a
+// certificate is returned along with the error.
+func (r *Router) Notebook(host, path string, clientCert bool) (core.Notebook, error) {
+	nb, ok := r.domains.Route(host, path)
+	if !ok {
+		return core.Notebook{}, ErrNotFound
+	}
+	if nb.Disabled {
+		return nb, ErrDisabled
+	}
+	if nb.ClientCert && !clientCert {
+		return nb, ErrClientCert
+	}
+	countRequest(nb.ID)
+	return nb, nil
+}
+
+// Backend returns the backend of nb if the notebook is running, waking it
+// if it was stopped for being idle.
+func (r *Router) Backend(nb core.Notebook) (*Backend, error) {
+	addr, ok := r.runtime.GetAddress(nb.ID)
+	if !ok {
+		return nil, ErrNoAddress
+	}
+	status, err := r.runtime.GetStatus(nb.ID)
+	if status == core.StatusIdle && r.runtime.Wake(nb.ID) {
+		status = core.StatusStarting
+	}
+	switch {
+	case status == core.StatusSuspended:
+		return nil, ErrSuspended
+	case status == core.StatusStarting || status == core.StatusPending:
+		return nil, ErrStarting
+	case err != nil || status != core.StatusRunning:
+		return nil, ErrNotRunning
+	}
+	return &Backend{Notebook: nb, Addr: addr, runtime: r.runtime}, nil
+}
+
+// traffic counts the requests proxied to each notebook since the hub
+// started, keyed by notebook ID.
+var traffic sync.Map
+
+func countRequest(id string) {
+	counter, ok := traffic.Load(id)
+	if !ok {
+		counter, _ = traffic.LoadOrStore(id, new(atomic.Uint64))
+	}
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// RequestCount returns how many requests and sessions were routed to
+// notebook id since the hub started.
+func RequestCount(id string) uint64 {
+	if counter, ok := traffic.Load(id); ok {
+		return counter.(*atomic.Uint64).Load()
+	}
+	return 0
+}
+
+// sessions counts the WebSocket sessions being proxied. The server stops
+// tracking a connection once it is upgraded, so shutting it down doesn't
+// wait for them.
+var sessions sync.WaitGroup
+
+// WaitForSessions waits until every proxied WebSocket session has ended or
+// ctx is done.
+func WaitForSessions(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
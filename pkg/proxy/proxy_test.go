@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// fakeDomains routes hosts to notebooks, whatever the path.
+type fakeDomains map[string]core.Notebook
+
+func (d fakeDomains) Route(host, path string) (core.Notebook, bool) {
+	nb, ok := d[host]
+	return nb, ok
+}
+
+func (d fakeDomains) List() []core.Notebook {
+	var nbs []core.Notebook
+	for _, nb := range d {
+		nbs = append(nbs, nb)
+	}
+	return nbs
+}
+
+// fakeRuntime runs a single notebook at addr.
+type fakeRuntime struct {
+	addr   string
+	status core.Status
+	// wakes sets whether an idle notebook can be woken.
+	wakes bool
+
+	mu       sync.Mutex
+	woken    int
+	requests int
+	sessions int
+}
+
+func (r *fakeRuntime) GetAddress(id string) (string, bool) {
+	return r.addr, r.addr != ""
+}
+
+func (r *fakeRuntime) GetStatus(id string) (core.Status, error) {
+	if r.status == core.StatusStopped {
+		return r.status, &core.NotRunningError{ID: id}
+	}
+	return r.status, nil
+}
+
+func (r *fakeRuntime) Wake(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.wakes {
+		r.woken++
+	}
+	return r.wakes
+}
+
+func (r *fakeRuntime) TryBeginRequest(id string, limit int) (func(), bool) {
+	return r.begin(&r.requests, limit)
+}
+
+func (r *fakeRuntime) TryBeginSession(id string, limit int) (func(), bool) {
+	return r.begin(&r.sessions, limit)
+}
+
+func (r *fakeRuntime) begin(count *int, limit int) (func(), bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if limit > 0 && *count >= limit {
+		return nil, false
+	}
+	*count++
+	return func() {
+		r.mu.Lock()
+		*count--
+		r.mu.Unlock()
+	}, true
+}
+
+func (r *fakeRuntime) open() (requests, sessions int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests, r.sessions
+}
+
+func TestRouterNotebook(t *testing.T) {
+	domains := fakeDomains{
+		"open.example.com":     {ID: "open", Name: "open"},
+		"disabled.example.com": {ID: "disabled", Disabled: true},
+		"mtls.example.com":     {ID: "mtls", ClientCert: true},
+	}
+	router := NewRouter(domains, &fakeRuntime{})
+
+	tests := []struct {
+		host       string
+		clientCert bool
+		wantErr    error
+	}{
+		{"open.example.com", false, nil},
+		{"missing.example.com", false, ErrNotFound},
+		{"disabled.example.com", false, ErrDisabled},
+		{"mtls.example.com", false, ErrClientCert},
+		{"mtls.example.com", true, nil},
+	}
+	for _, tt := range tests {
+		nb, err := router.Notebook(tt.host, "/", tt.clientCert)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Notebook(%q, clientCert=%v) error = %v, want %v", tt.host, tt.clientCert, err, tt.wantErr)
+		}
+		if want := domains[tt.host].ID; nb.ID != want {
+			t.Errorf("Notebook(%q) = %q, want %q", tt.host, nb.ID, want)
+		}
+	}
+}
+
+func TestRouterNotebookCountsTraffic(t *testing.T) {
+	router := NewRouter(fakeDomains{
+		"counted.example.com":  {ID: "counted"},
+		"disabled.example.com": {ID: "uncounted", Disabled: true},
+	}, &fakeRuntime{})
+
+	before := RequestCount("counted")
+	for range 3 {
+		router.Notebook("counted.example.com", "/", false)
+	}
+	router.Notebook("disabled.example.com", "/", false)
+
+	if got := RequestCount("counted") - before; got != 3 {
+		t.Errorf("RequestCount grew by %d, want 3", got)
+	}
+	if got := RequestCount("uncounted"); got != 0 {
+		t.Errorf("RequestCount of a disabled notebook = %d, want 0", got)
+	}
+}
+
+func TestRouterBackend(t *testing.T) {
+	tests := []struct {
+		name      string
+		runtime   *fakeRuntime
+		wantErr   error
+		wantWoken int
+	}{
+		{"running", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusRunning}, nil, 0},
+		{"no address", &fakeRuntime{status: core.StatusRunning}, ErrNoAddress, 0},
+		{"suspended", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusSuspended}, ErrSuspended, 0},
+		{"starting", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusStarting}, ErrStarting, 0},
+		{"pending", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusPending}, ErrStarting, 0},
+		{"idle", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusIdle, wakes: true}, ErrStarting, 1},
+		{"idle without waking", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusIdle}, ErrNotRunning, 0},
+		{"stopped", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusStopped}, ErrNotRunning, 0},
+		{"error", &fakeRuntime{addr: "127.0.0.1:1", status: core.StatusError}, ErrNotRunning, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(fakeDomains{}, tt.runtime)
+			backend, err := router.Backend(core.Notebook{ID: "nb"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Backend() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && backend.Addr != tt.runtime.addr {
+				t.Errorf("Backend().Addr = %q, want %q", backend.Addr, tt.runtime.addr)
+			}
+			if tt.runtime.woken != tt.wantWoken {
+				t.Errorf("woken %d times, want %d", tt.runtime.woken, tt.wantWoken)
+			}
+		})
+	}
+}
+
+func TestBackendURL(t *testing.T) {
+	plain := &Backend{Notebook: core.Notebook{}, Addr: "127.0.0.1:2718"}
+	secure := &Backend{Notebook: core.Notebook{Upstream: &core.Upstream{Scheme: "https"}}, Addr: "nb.internal:443"}
+
+	tests := []struct {
+		got, want string
+	}{
+		{plain.URL("/app/", ""), "http://127.0.0.1:2718/app/"},
+		{plain.URL("/search", "q=a&b=1"), "http://127.0.0.1:2718/search?q=a&b=1"},
+		{plain.WebSocketURL("/ws", "session_id=1"), "ws://127.0.0.1:2718/ws?session_id=1"},
+		{secure.URL("/", ""), "https://nb.internal:443/"},
+		{secure.WebSocketURL("/ws", ""), "wss://nb.internal:443/ws"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rekk30/marimo-hub/pkg/core"
+)
+
+// throttleChunk is the most sent between two waits of a throttle, which
+// keeps its pace smooth.
+const throttleChunk = 16 << 10
+
+// Throttle paces what the proxy sends from a notebook to a rate shared by
+// all its responses and sessions.
+type Throttle struct {
+	mu   sync.Mutex
+	rate int // bytes per second
+	// next is when the bytes sent so far will have been paid for.
+	next time.Time
+}
+
+// throttles holds the throttle of each notebook with a bandwidth limit.
+var throttles sync.Map
+
+// notebookThrottle returns the throttle of nb, or nil if its bandwidth is
+// unlimited.
+func notebookThrottle(nb core.Notebook) *Throttle {
+	if nb.Limits == nil || nb.Limits.BandwidthKB == 0 {
+		throttles.Delete(nb.ID)
+		return nil
+	}
+	rate := nb.Limits.BandwidthKB << 10
+	v, _ := throttles.LoadOrStore(nb.ID, &Throttle{rate: rate})
+	t := v.(*Throttle)
+	t.mu.Lock()
+	t.rate = rate
+	t.mu.Unlock()
+	return t
+}
+
+// Wait blocks until sending n more bytes keeps to the rate.
+func (t *Throttle) Wait(n int) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(n) * time.Second / time.Duration(t.rate))
+	delay := t.next.Sub(now)
+	t.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledBody paces a response body read by the server as it sends it.
+type throttledBody struct {
+	io.ReadCloser
+	t *Throttle
+}
+
+func (b throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.t.Wait(n)
+	return n, err
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gorilla/websocket"
+	wsproxy "github.com/rekk30/marimo-hub/pkg/websocket"
+)
+
+const (
+	// handshakeTimeout bounds each attempt to open a notebook's WebSocket.
+	handshakeTimeout = 10 * time.Second
+	// dialAttempts and dialBackoff ride out a notebook that is restarting;
+	// the backoff doubles after each failed attempt.
+	dialAttempts = 4
+	dialBackoff  = 250 * time.Millisecond
+	// controlWriteTimeout bounds forwarding a ping or pong.
+	controlWriteTimeout = time.Second
+)
+
+// Dial opens the notebook's WebSocket at path, retrying while the notebook
+// refuses connections or answers with a server error, as it does while
+// restarting. It gives up once ctx is done.
+func (b *Backend) Dial(ctx context.Context, path, rawQuery string, header http.Header) (*websocket.Conn, error) {
+	dialer, err := upstreamDialer(b.Notebook.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUpstream, err)
+	}
+	// The dialer only heeds ctx while connecting, so the connection is
+	// closed to abort the handshake once it is done.
+	netDial := dialer.NetDialContext
+	if netDial == nil {
+		netDial = (&net.Dialer{}).DialContext
+	}
+	var stop func() bool
+	dialer.NetDialContext = func(dialCtx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := netDial(dialCtx, network, addr)
+		if err == nil {
+			stop = context.AfterFunc(ctx, func() { conn.Close() })
+		}
+		return conn, err
+	}
+
+	url := b.WebSocketURL(path, rawQuery)
+	backoff := dialBackoff
+	for attempt := 1; ; attempt++ {
+		stop = nil
+		conn, resp, err := dialer.DialContext(ctx, url, header)
+		if err == nil && (stop == nil || stop()) {
+			return conn, nil
+		}
+		if err == nil {
+			conn.Close()
+			return nil, ctx.Err()
+		}
+		if stop != nil {
+			stop()
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		// A notebook that doesn't answer in time isn't restarting.
+		var netErr net.Error
+		timedOut := errors.As(err, &netErr) && netErr.Timeout()
+		if attempt == dialAttempts || ctx.Err() != nil || timedOut || (resp != nil && resp.StatusCode < 500) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// clientMessage is a message read from a visitor's WebSocket.
+type clientMessage struct {
+	typ  int
+	data []byte
+	err  error
+}
+
+// Session is a visitor's WebSocket session proxied to a notebook.
+type Session struct {
+	conn    *wsproxy.Conn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	first   chan clientMessage
+	backend atomic.Pointer[websocket.Conn]
+}
+
+// NewSession starts the session of the visitor on conn. The visitor's
+// first message is read in the background, so that the visitor leaving,
+// such as while the notebook's WebSocket is being opened, cancels Context.
+// Until the session is relayed, the hub answers the visitor's pings.
+func NewSession(conn *wsproxy.Conn) *Session {
+	s := &Session{conn: conn, first: make(chan clientMessage, 1)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	// The handlers are set before the connection is read.
+	conn.SetPingHandler(func(data string) error {
+		if backend := s.backend.Load(); backend != nil {
+			backend.WriteControl(websocket.PingMessage, []byte(data), time.Now().Add(controlWriteTimeout))
+		} else {
+			conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(controlWriteTimeout))
+		}
+		return nil
+	})
+	conn.SetPongHandler(func(data string) error {
+		if backend := s.backend.Load(); backend != nil {
+			backend.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(controlWriteTimeout))
+		}
+		return nil
+	})
+	go func() {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			s.cancel()
+		}
+		s.first <- clientMessage{typ, data, err}
+	}()
+	return s
+}
+
+// Context is done once the visitor left or the session is closed.
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Session) Close() {
+	s.cancel()
+}
+
+// Relay forwards messages, pings and pongs between the visitor and the
+// notebook's connection until either side ends the session, then tells the
+// other side how it ended. throttle, if not nil, paces what the notebook
+// sends.
+func (s *Session) Relay(backend *websocket.Conn, throttle *Throttle) {
+	backend.SetPingHandler(s.toClient(websocket.PingMessage))
+	backend.SetPongHandler(s.toClient(websocket.PongMessage))
+	s.backend.Store(backend)
+
+	go func() {
+		for {
+			t, msg, err := backend.ReadMessage()
+			if err != nil {
+				if closing, ok := closeMessage(err); ok {
+					s.conn.WriteMessage(websocket.CloseMessage, closing)
+				} else {
+					// The visitor's connection can't be closed while the
+					// session runs; ending it drops the connection without
+					// a close frame.
+					s.conn.SetReadDeadline(time.Now())
+				}
+				return
+			}
+			if throttle != nil {
+				throttle.Wait(len(msg))
+			}
+			if err := s.conn.WriteMessage(t, msg); err != nil {
+				return
+			}
+		}
+	}()
+	msg := <-s.first
+	for {
+		if msg.err != nil {
+			if closing, ok := closeMessage(msg.err); ok {
+				backend.WriteMessage(websocket.CloseMessage, closing)
+			}
+			return
+		}
+		if err := backend.WriteMessage(msg.typ, msg.data); err != nil {
+			return
+		}
+		msg.typ, msg.data, msg.err = s.conn.ReadMessage()
+	}
+}
+
+func (s *Session) toClient(typ int) func(string) error {
+	return func(data string) error {
+		s.conn.WriteControl(typ, []byte(data), time.Now().Add(controlWriteTimeout))
+		return nil
+	}
+}
+
+// closeMessage returns the close frame passing on how one side of a
+// session ended to the other, so that marimo tells a notebook shutting
+// down from a dropped connection. It returns false if that side ended
+// without a close frame, which the other side is then closed without too.
+func closeMessage(err error) ([]byte, bool) {
+	var code int
+	var text string
+	// Visitors' connections and notebooks' come from different packages.
+	var backendErr *websocket.CloseError
+	var clientErr *fastws.CloseError
+	switch {
+	case errors.As(err, &backendErr):
+		code, text = backendErr.Code, backendErr.Text
+	case errors.As(err, &clientErr):
+		code, text = clientErr.Code, clientErr.Text
+	default:
+		return nil, false
+	}
+	switch code {
+	case websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+		// These are never sent, only reported for connections that broke.
+		return nil, false
+	}
+	return websocket.FormatCloseMessage(code, text), true
+}
@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gorilla/websocket"
+	"github.com/rekk30/marimo-hub/pkg/core"
+	wsproxy "github.com/rekk30/marimo-hub/pkg/websocket"
+)
+
+// echoNotebook echoes messages back, closing the session with 1001 on
+// "close", dropping it on "drop" and pinging the visitor on "ping".
+func echoNotebook(t *testing.T) http.HandlerFunc {
+	var upgrader websocket.Upgrader
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.SetPongHandler(func(data string) error {
+			return conn.WriteMessage(websocket.TextMessage, []byte("pong "+data))
+		})
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch string(msg) {
+			case "close":
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "restarting"))
+			case "drop":
+				return
+			case "ping":
+				conn.WriteControl(websocket.PingMessage, []byte("from notebook"), time.Now().Add(time.Second))
+			default:
+				conn.WriteMessage(typ, msg)
+			}
+		}
+	}
+}
+
+// serveSessions proxies the WebSocket sessions of a hub to backend, and
+// returns the URL visitors connect to.
+func serveSessions(t *testing.T, backend *Backend) string {
+	t.Helper()
+	app := fiber.New()
+	app.Use(wsproxy.New(func(conn *wsproxy.Conn) {
+		session := NewSession(conn)
+		defer session.Close()
+		notebook, err := backend.Dial(session.Context(), conn.Path, conn.RawQuery, nil)
+		if err != nil {
+			t.Errorf("Dial() = %v", err)
+			return
+		}
+		defer notebook.Close()
+		session.Relay(notebook, nil)
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln, fiber.ListenConfig{DisableStartupMessage: true})
+	t.Cleanup(func() { app.Shutdown() })
+	return "ws://" + ln.Addr().String() + "/ws"
+}
+
+func dialSession(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readText(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(msg)
+}
+
+func TestSessionRelaysMessages(t *testing.T) {
+	backend, _ := newTestBackend(t, echoNotebook(t), nil)
+	conn := dialSession(t, serveSessions(t, backend))
+
+	for _, msg := range []string{"hello", "world"} {
+		conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		if got := readText(t, conn); got != msg {
+			t.Errorf("echo = %q, want %q", got, msg)
+		}
+	}
+}
+
+func TestSessionRelaysPings(t *testing.T) {
+	backend, _ := newTestBackend(t, echoNotebook(t), nil)
+	conn := dialSession(t, serveSessions(t, backend))
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	readText(t, conn)
+
+	// The notebook's ping reaches the visitor, whose pong reaches the
+	// notebook.
+	pinged := make(chan string, 1)
+	conn.SetPingHandler(func(data string) error {
+		pinged <- data
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	if got := readText(t, conn); got != "pong from notebook" {
+		t.Errorf("notebook got %q, want its pong", got)
+	}
+	if got := <-pinged; got != "from notebook" {
+		t.Errorf("visitor was pinged with %q", got)
+	}
+
+	// The visitor's ping reaches the notebook, whose pong reaches the
+	// visitor.
+	ponged := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		ponged <- data
+		return nil
+	})
+	conn.WriteControl(websocket.PingMessage, []byte("from visitor"), time.Now().Add(time.Second))
+	conn.WriteMessage(websocket.TextMessage, []byte("after ping"))
+	readText(t, conn)
+	select {
+	case got := <-ponged:
+		if got != "from visitor" {
+			t.Errorf("visitor's pong = %q", got)
+		}
+	default:
+		t.Error("the visitor's ping wasn't answered")
+	}
+}
+
+func TestSessionRelaysCloseCodes(t *testing.T) {
+	backend, _ := newTestBackend(t, echoNotebook(t), nil)
+	url := serveSessions(t, backend)
+
+	conn := dialSession(t, url)
+	conn.WriteMessage(websocket.TextMessage, []byte("close"))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) || !strings.Contains(err.Error(), "restarting") {
+		t.Errorf("closing notebook: read error = %v, want 1001 restarting", err)
+	}
+
+	conn = dialSession(t, url)
+	conn.WriteMessage(websocket.TextMessage, []byte("drop"))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+		t.Errorf("dropped notebook: read error = %v, want an abnormal closure", err)
+	}
+}
+
+func TestDialRetriesRestartingNotebook(t *testing.T) {
+	var attempts atomic.Int32
+	echo := echoNotebook(t)
+	backend, _ := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			http.Error(w, "restarting", http.StatusBadGateway)
+			return
+		}
+		echo(w, r)
+	}), nil)
+
+	conn, err := backend.Dial(context.Background(), "/ws", "", nil)
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	conn.Close()
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("%d attempts, want 3", got)
+	}
+}
+
+func TestDialGivesUp(t *testing.T) {
+	var attempts atomic.Int32
+	backend, _ := newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.NotFound(w, r)
+	}), nil)
+	if _, err := backend.Dial(context.Background(), "/ws", "", nil); err == nil {
+		t.Fatal("Dial() succeeded against a 404")
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("%d attempts at a 404, want 1", got)
+	}
+
+	attempts.Store(0)
+	backend, _ = newTestBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}), nil)
+	if _, err := backend.Dial(context.Background(), "/ws", "", nil); err == nil {
+		t.Fatal("Dial() succeeded against a 503")
+	}
+	if got := attempts.Load(); got != dialAttempts {
+		t.Errorf("%d attempts at a 503, want %d", got, dialAttempts)
+	}
+}
+
+func TestDialCancelledDuringHandshake(t *testing.T) {
+	// The listener accepts connections but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	backend := &Backend{Notebook: core.Notebook{ID: "hung"}, Addr: ln.Addr().String(), runtime: &fakeRuntime{}}
+
+	// Without a deadline, only cancelling ctx ends the handshake.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := backend.Dial(ctx, "/ws", "", nil); err == nil {
+		t.Fatal("Dial() succeeded without a handshake")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Dial() took %v to give up after ctx was done", elapsed)
+	}
+}
+
+func TestCloseMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []byte
+		ok   bool
+	}{
+		{"notebook", &websocket.CloseError{Code: websocket.CloseGoingAway, Text: "bye"}, websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"), true},
+		{"visitor", &fastws.CloseError{Code: 4000, Text: "custom"}, websocket.FormatCloseMessage(4000, "custom"), true},
+		{"no status", &websocket.CloseError{Code: websocket.CloseNoStatusReceived}, []byte{}, true},
+		{"abnormal", &websocket.CloseError{Code: websocket.CloseAbnormalClosure}, nil, false},
+		{"network", errors.New("connection reset"), nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := closeMessage(tt.err)
+		if ok != tt.ok || string(got) != string(tt.want) {
+			t.Errorf("%s: closeMessage() = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
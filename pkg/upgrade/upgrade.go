@@ -0,0 +1,89 @@
+// Package upgrade replaces a running hub with a new process without
+// refusing connections: the old process hands its listening sockets to the
+// new one, waits for it to report that it is ready and then drains. The new
+// process is a child of the old one, so the hub must not be the process a
+// container or supervisor waits on.
+package upgrade
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// listenersEnv holds the number of listeners a new process inherits,
+	// starting at file descriptor 3. They are followed by the output pipes
+	// of the notebooks listed in outputsEnv and then the ready pipe.
+	listenersEnv = "MARIMO_HUB_LISTENERS"
+	outputsEnv   = "MARIMO_HUB_OUTPUTS"
+	firstFD      = 3
+)
+
+var errUnsupported = errors.New("upgrades are not supported on this platform")
+
+// Inherited reports whether this process was started by an upgrade.
+func Inherited() bool {
+	return os.Getenv(listenersEnv) != ""
+}
+
+// Listen returns a TCP listener for each address, inherited from the process
+// that started this one during an upgrade or opened otherwise.
+func Listen(addrs ...string) ([]net.Listener, error) {
+	if !Inherited() {
+		lns := make([]net.Listener, 0, len(addrs))
+		for _, addr := range addrs {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				for _, ln := range lns {
+					ln.Close()
+				}
+				return nil, err
+			}
+			lns = append(lns, ln)
+		}
+		return lns, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv(listenersEnv))
+	if err != nil || n != len(addrs) {
+		return nil, errors.New("inherited listeners don't match the configured addresses")
+	}
+	lns := make([]net.Listener, n)
+	for i := range lns {
+		file := os.NewFile(uintptr(firstFD+i), "listener")
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		lns[i] = ln
+	}
+	return lns, nil
+}
+
+func inheritedOutputs() []string {
+	if env := os.Getenv(outputsEnv); env != "" {
+		return strings.Split(env, ",")
+	}
+	return nil
+}
+
+// Ready tells the process that started this one that it has taken over.
+// It does nothing if this process wasn't started by an upgrade.
+func Ready() error {
+	if !Inherited() {
+		return nil
+	}
+	n, _ := strconv.Atoi(os.Getenv(listenersEnv))
+	pipe := os.NewFile(uintptr(firstFD+n+len(inheritedOutputs())), "ready")
+	defer pipe.Close()
+	_, err := pipe.Write([]byte{1})
+	// The notebook processes started from now on mustn't take the
+	// descriptors for inherited ones.
+	os.Unsetenv(listenersEnv)
+	os.Unsetenv(outputsEnv)
+	return err
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package upgrade
+
+import (
+	"context"
+	"net"
+	"os"
+)
+
+// Signal is nil where upgrades are not supported.
+var Signal os.Signal
+
+// Files is only implemented on Unix.
+func Files(lns []net.Listener) ([]*os.File, error) {
+	return nil, errUnsupported
+}
+
+// Outputs is only implemented on Unix.
+func Outputs() map[string]*os.File {
+	return nil
+}
+
+// Start is only implemented on Unix.
+func Start(ctx context.Context, files []*os.File, outputs map[string]*os.File) error {
+	return errUnsupported
+}
@@ -0,0 +1,112 @@
+//go:build unix
+
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Signal asks a running hub to upgrade itself.
+var Signal os.Signal = syscall.SIGUSR2
+
+// Files duplicates the sockets of lns so they survive the listeners being
+// closed while the process drains.
+func Files(lns []net.Listener) ([]*os.File, error) {
+	files := make([]*os.File, 0, len(lns))
+	for _, ln := range lns {
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("cannot hand over a %T", ln)
+		}
+		file, err := tcp.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Outputs returns the output pipes of the notebook processes inherited from
+// the process that started this one, by notebook. It returns nil if this
+// process wasn't started by an upgrade.
+func Outputs() map[string]*os.File {
+	ids := inheritedOutputs()
+	if len(ids) == 0 {
+		return nil
+	}
+	n, _ := strconv.Atoi(os.Getenv(listenersEnv))
+	outputs := make(map[string]*os.File, len(ids))
+	for i, id := range ids {
+		fd := firstFD + n + i
+		// Only a non-blocking file supports the deadline that stops
+		// reading it when handing it over again.
+		syscall.SetNonblock(fd, true)
+		outputs[id] = os.NewFile(uintptr(fd), "output")
+	}
+	return outputs
+}
+
+// Start runs the current executable again with the same arguments, handing
+// it the listener files and the output pipes of the notebook processes, and
+// waits until it reports that it is ready. The new process is stopped if it
+// doesn't become ready before ctx is done.
+func Start(ctx context.Context, files []*os.File, outputs map[string]*os.File) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	ids := make([]string, 0, len(outputs))
+	extra := slices.Clone(files)
+	for id, pipe := range outputs {
+		ids = append(ids, id)
+		extra = append(extra, pipe)
+	}
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%d", listenersEnv, len(files)),
+		outputsEnv+"="+strings.Join(ids, ","))
+	cmd.ExtraFiles = append(extra, readyW)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	// The read fails once the pipe is closed without a word, which means
+	// the new process exited.
+	done := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return errors.Join(errors.New("new process did not become ready"), err)
+	}
+	// The new process outlives this one; nothing waits for it.
+	return cmd.Process.Release()
+}
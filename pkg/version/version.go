@@ -0,0 +1,74 @@
+// Package version describes the running build. The variables are set at
+// build time, e.g.
+//
+//	go build -ldflags "-X github.com/rekk30/marimo-hub/pkg/version.Version=v1.2.3" ./cmd
+package version
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Marimo is the version of the marimo found on PATH, empty if it
+	// couldn't be run.
+	Marimo string `json:"marimo_version,omitempty"`
+}
+
+// Get describes the running build. The commit falls back to the one the Go
+// toolchain stamped into the binary.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Marimo:    marimoVersion(),
+	}
+	if info.Commit == "" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range build.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+var (
+	marimoOnce sync.Once
+	marimo     string
+)
+
+// marimoVersion asks marimo for its version once; it doesn't change while
+// the hub runs.
+func marimoVersion() string {
+	marimoOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "marimo", "--version")
+		// Don't wait on children that outlive it holding the output open.
+		cmd.WaitDelay = time.Second
+		if out, err := cmd.Output(); err == nil {
+			marimo = strings.TrimSpace(string(out))
+		}
+	})
+	return marimo
+}
@@ -3,12 +3,12 @@ package websocket
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"runtime/debug"
 	"time"
 
 	"github.com/fasthttp/websocket"
 	"github.com/gofiber/fiber/v3"
+	"github.com/rekk30/marimo-hub/pkg/logging"
 	"github.com/valyala/fasthttp"
 )
 
@@ -34,7 +34,9 @@ type Config struct {
 
 func defaultRecover(c *Conn) {
 	if err := recover(); err != nil {
-		fmt.Fprintf(os.Stderr, "panic: %v\n%s\n", err, debug.Stack())
+		logging.WebSocket.Error().Interface("panic", err).
+			Bytes("stack", debug.Stack()).
+			Msg("WebSocket handler panicked")
 		_ = c.writeJSON(map[string]interface{}{"error": fmt.Sprint(err)})
 	}
 }